@@ -1,19 +1,50 @@
 package main
 
 import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	_ "github.com/go-sql-driver/mysql"
 	"github.com/jyangorch/hello-go/exercise-ddd-structure/internal/dataaccess/personrepo"
 	"github.com/jyangorch/hello-go/exercise-ddd-structure/pkg/domain/greet"
 	"github.com/jyangorch/hello-go/exercise-ddd-structure/pkg/domain/person"
 )
 
 func main() {
-	var repo person.PersonRepository = personrepo.NewInMemoryImpl()
+	repoKind := flag.String("repo", "inmem", "person repository: inmem or mysql")
+	dsn := flag.String("dsn", "", "MySQL data source name for -repo=mysql, e.g. user:password@tcp(localhost:3306)/people")
+	flag.Parse()
+
+	var repo person.PersonRepository
+	switch *repoKind {
+	case "inmem":
+		repo = personrepo.NewInMemoryImpl()
+	case "mysql":
+		db, err := sql.Open("mysql", *dsn)
+		if err != nil {
+			exitWithError(err)
+		}
+		defer db.Close()
+		mysqlRepo := personrepo.NewPersonRepositoryMySqlImpl(db)
+		if err := mysqlRepo.CreateTable(); err != nil {
+			exitWithError(err)
+		}
+		repo = mysqlRepo
+	default:
+		exitWithError(fmt.Errorf("unknown repository %q, expected inmem or mysql", *repoKind))
+	}
 
 	// save to repo
 	alice := person.NewPerson("001", "Alice")
 	bob := person.NewPerson("002", "Bob")
-	repo.SavePerson(alice)
-	repo.SavePerson(bob)
+	if err := repo.SavePerson(alice); err != nil {
+		exitWithError(err)
+	}
+	if err := repo.SavePerson(bob); err != nil {
+		exitWithError(err)
+	}
 
 	// get from repo
 	p1, _ := repo.GetPerson("001")
@@ -24,3 +55,8 @@ func main() {
 	var greeter greet.Greeter = greet.Greeter{}
 	greeter.MoveAndGreet(persons, 100, 200)
 }
+
+func exitWithError(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -6,6 +6,9 @@ import (
 	"github.com/jyangorch/hello-go/exercise-ddd-structure/pkg/domain/person"
 )
 
+// returned by every implementation when no person has the requested id
+var ErrNotFound = errors.New("not found")
+
 type InMemoryImpl struct {
 	storage map[string]*person.Person
 }
@@ -20,7 +23,7 @@ func (r *InMemoryImpl) GetPerson(personId string) (*person.Person, error) {
 	if result, ok := r.storage[personId]; ok {
 		return result, nil
 	}
-	return nil, errors.New("not found")
+	return nil, ErrNotFound
 }
 
 func (r *InMemoryImpl) SavePerson(p *person.Person) error {
@@ -1,20 +1,55 @@
 package personrepo
 
 import (
+	"database/sql"
+
+	"github.com/jyangorch/hello-go/exercise-ddd-structure/internal/util"
 	"github.com/jyangorch/hello-go/exercise-ddd-structure/pkg/domain/person"
 )
 
+// table storing persons; the statements are in the SQL common to MySQL and SQLite, so that tests may run on either
+const createPersonTable = `CREATE TABLE IF NOT EXISTS person (
+    id             VARCHAR(255) NOT NULL,
+    name           VARCHAR(255) NOT NULL,
+    location_left  INT          NOT NULL,
+    location_right INT          NOT NULL,
+    PRIMARY KEY (id)
+)`
+
+// inserts the person, replacing the stored person of the same id, in a single statement so that concurrent saves
+// of a new person cannot both attempt the insert; every column is replaced, and no other table references persons
+const upsertPerson = `REPLACE INTO person (id, name, location_left, location_right) VALUES (?, ?, ?, ?)`
+
 type MySqlImpl struct {
+	db *sql.DB
+}
+
+func NewPersonRepositoryMySqlImpl(db *sql.DB) *MySqlImpl {
+	return &MySqlImpl{db: db}
 }
 
-func NewPersonRepositoryMySqlImpl() *MySqlImpl {
-	return &MySqlImpl{}
+// creates the person table unless it exists
+func (r *MySqlImpl) CreateTable() error {
+	_, err := r.db.Exec(createPersonTable)
+	return err
 }
 
 func (r *MySqlImpl) GetPerson(personId string) (*person.Person, error) {
-	panic("not implemented")
+	var name string
+	var left, right int
+	err := r.db.QueryRow(`SELECT name, location_left, location_right FROM person WHERE id = ?`, personId).
+		Scan(&name, &left, &right)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return person.NewPersonAt(personId, name, util.NewLocation(left, right)), nil
 }
 
+// inserts the person, or updates the stored person of the same id
 func (r *MySqlImpl) SavePerson(p *person.Person) error {
-	panic("not implemented")
+	_, err := r.db.Exec(upsertPerson, p.Id(), p.Name(), p.CurrentLocation().Left(), p.CurrentLocation().Right())
+	return err
 }
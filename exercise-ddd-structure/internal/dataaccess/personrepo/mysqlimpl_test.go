@@ -0,0 +1,62 @@
+package personrepo
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jyangorch/hello-go/exercise-ddd-structure/internal/util"
+	"github.com/jyangorch/hello-go/exercise-ddd-structure/pkg/domain/person"
+	_ "github.com/mattn/go-sqlite3"
+	"gotest.tools/v3/assert"
+)
+
+// data source name of a MySQL database to also run the tests on, e.g. user:password@tcp(localhost:3306)/people
+const mysqlDsnEnv = "PERSONREPO_MYSQL_DSN"
+
+func TestMySqlImpl(t *testing.T) {
+
+	t.Run("sqlite", func(t *testing.T) {
+		testMySqlImpl(t, "sqlite3", filepath.Join(t.TempDir(), "person.db"))
+	})
+
+	t.Run("mysql", func(t *testing.T) {
+		dsn := os.Getenv(mysqlDsnEnv)
+		if dsn == "" {
+			t.Skipf("%s is not set", mysqlDsnEnv)
+		}
+		testMySqlImpl(t, "mysql", dsn)
+	})
+}
+
+func testMySqlImpl(t *testing.T, driverName string, dsn string) {
+	db, err := sql.Open(driverName, dsn)
+	assert.NilError(t, err)
+	defer db.Close()
+	repo := NewPersonRepositoryMySqlImpl(db)
+	assert.NilError(t, repo.CreateTable())
+	_, err = db.Exec(`DELETE FROM person WHERE id = ?`, "001")
+	assert.NilError(t, err)
+
+	t.Run("missing person is not found, like in memory", func(t *testing.T) {
+		_, err := repo.GetPerson("001")
+		assert.Check(t, errors.Is(err, ErrNotFound), err)
+		_, err = NewInMemoryImpl().GetPerson("001")
+		assert.Check(t, errors.Is(err, ErrNotFound), err)
+	})
+
+	t.Run("saving twice updates the person", func(t *testing.T) {
+		alice := person.NewPerson("001", "Alice")
+		assert.NilError(t, repo.SavePerson(alice))
+		alice.MoveBy(100, 200)
+		assert.NilError(t, repo.SavePerson(alice))
+
+		loaded, err := repo.GetPerson("001")
+		assert.NilError(t, err)
+		assert.Equal(t, loaded.Name(), "Alice")
+		assert.Equal(t, loaded.CurrentLocation(), util.NewLocation(100, 200))
+	})
+}
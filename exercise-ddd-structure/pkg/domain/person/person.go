@@ -16,6 +16,12 @@ func NewPerson(id string, name string) *Person {
 	return &p
 }
 
+// constructor for a person at a known location, e.g. when loaded from a repository
+func NewPersonAt(id string, name string, location util.Location) *Person {
+	p := Person{id, name, location}
+	return &p
+}
+
 // as aggregate object, method references pointer recevier
 func (p *Person) Id() string {
 	return p.id
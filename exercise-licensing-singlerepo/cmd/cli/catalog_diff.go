@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	app "github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/application/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+)
+
+// Compares two packages or two packaging plans of a catalog, for release notes and customer impact analysis
+func runCatalogDiff(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("catalog-diff", flag.ContinueOnError)
+	catalogFile := flags.String("catalog", "", "catalog file (YAML or JSON); the built-in 2022 catalog if empty")
+	fromPkgId := flags.String("from", "", "package id to compare from")
+	toPkgId := flags.String("to", "", "package id to compare to")
+	fromPlanId := flags.String("from-plan", "", "packaging plan id to compare from")
+	toPlanId := flags.String("to-plan", "", "packaging plan id to compare to")
+	format := flags.String("format", "text", "output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q, expected text or json", *format)
+	}
+
+	cs, err := newCatalogService(*catalogFile)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *fromPkgId != "" && *toPkgId != "":
+		diff, err := cs.DiffPackages(ctx, *fromPkgId, *toPkgId)
+		if err != nil {
+			return err
+		}
+		if *format == "json" {
+			return writeJson(stdout, toPackageDiffJson(diff))
+		}
+		writePackageDiffText(stdout, diff, "")
+		return nil
+	case *fromPlanId != "" && *toPlanId != "":
+		diff, err := cs.DiffPackagingPlans(ctx, *fromPlanId, *toPlanId)
+		if err != nil {
+			return err
+		}
+		if *format == "json" {
+			return writeJson(stdout, toPackagingPlanDiffJson(diff))
+		}
+		writePackagingPlanDiffText(stdout, diff)
+		return nil
+	default:
+		return errors.New("either -from and -to, or -from-plan and -to-plan are required")
+	}
+}
+
+func newCatalogService(catalogFile string) (app.CatalogService, error) {
+	var cpbRepo licensing.CapabilityRepository
+	var pkgRepo licensing.PackageRepository
+	if catalogFile == "" {
+		cpbRepoInMem := storage.NewCapabilityRepoInMem()
+		cpbRepo = cpbRepoInMem
+		pkgRepo = storage.NewPackageRepoInMemWithCapabilityRepo(cpbRepoInMem)
+	} else {
+		fileRepo, err := storage.NewPackageRepoFile(catalogFile)
+		if err != nil {
+			return nil, err
+		}
+		cpbRepo = fileRepo
+		pkgRepo = fileRepo
+	}
+	return app.NewCatalogService(&cpbRepo, &pkgRepo), nil
+}
+
+func writePackageDiffText(w io.Writer, diff licensing.PackageDiff, indent string) {
+	fmt.Fprintf(w, "%sPackage %s -> %s\n", indent, diff.FromPackageId, diff.ToPackageId)
+	if diff.IsEmpty() {
+		fmt.Fprintf(w, "%s  (no changes)\n", indent)
+		return
+	}
+	if diff.NameChange != nil {
+		fmt.Fprintf(w, "%s  name: %q -> %q\n", indent, diff.NameChange.From, diff.NameChange.To)
+	}
+	for _, cpb := range diff.AddedCapabilities {
+		fmt.Fprintf(w, "%s  + %s (%s)%s\n", indent, cpb.Id, cpb.DisplayName, capacityLimitText(cpb, ", "))
+	}
+	for _, cpb := range diff.RemovedCapabilities {
+		fmt.Fprintf(w, "%s  - %s (%s)%s\n", indent, cpb.Id, cpb.DisplayName, capacityLimitText(cpb, ", "))
+	}
+	for _, change := range diff.ChangedCapabilities {
+		if change.DisplayNameChanged {
+			fmt.Fprintf(w, "%s  ~ %s: display name %q -> %q\n", indent, change.CapabilityId, change.From.DisplayName, change.To.DisplayName)
+		}
+		if change.CapacityLimitChanged {
+			fmt.Fprintf(w, "%s  ~ %s: capacity limit %s -> %s\n", indent, change.CapabilityId,
+				capacityLimitText(change.From, ""), capacityLimitText(change.To, ""))
+		}
+	}
+}
+
+func writePackagingPlanDiffText(w io.Writer, diff licensing.PackagingPlanDiff) {
+	fmt.Fprintf(w, "Packaging plan %s -> %s\n", diff.FromPlanId, diff.ToPlanId)
+	for _, pkg := range diff.AddedPackages {
+		fmt.Fprintf(w, "  + package %s (%s)\n", pkg.Id, pkg.Name)
+	}
+	for _, pkg := range diff.RemovedPackages {
+		fmt.Fprintf(w, "  - package %s (%s)\n", pkg.Id, pkg.Name)
+	}
+	for _, pkgDiff := range diff.PackageDiffs {
+		writePackageDiffText(w, pkgDiff, "  ")
+	}
+}
+
+func capacityLimitText(cpb licensing.Capability, prefix string) string {
+	if !cpb.HasCapacityLimit {
+		if prefix != "" {
+			return ""
+		}
+		return "unlimited"
+	}
+	return fmt.Sprintf("%s%d %s", prefix, cpb.CapacityLimit, cpb.CapacityLimitUnit)
+}
+
+func writeJson(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+//
+// JSON representation of the diffs, a stable format for release notes and impact analysis tooling
+//
+
+type stringChangeJson struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type capabilityJson struct {
+	Id                string `json:"id"`
+	DisplayName       string `json:"displayName"`
+	CapacityLimit     *int   `json:"capacityLimit,omitempty"`
+	CapacityLimitUnit string `json:"capacityLimitUnit,omitempty"`
+}
+
+type capabilityChangeJson struct {
+	CapabilityId  string                   `json:"capabilityId"`
+	DisplayName   *stringChangeJson        `json:"displayName,omitempty"`
+	CapacityLimit *capacityLimitChangeJson `json:"capacityLimit,omitempty"`
+}
+
+type capacityLimitChangeJson struct {
+	From capabilityJson `json:"from"`
+	To   capabilityJson `json:"to"`
+}
+
+type packageJson struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type packageDiffJson struct {
+	FromPackageId       string                 `json:"fromPackageId"`
+	ToPackageId         string                 `json:"toPackageId"`
+	Name                *stringChangeJson      `json:"name,omitempty"`
+	AddedCapabilities   []capabilityJson       `json:"addedCapabilities"`
+	RemovedCapabilities []capabilityJson       `json:"removedCapabilities"`
+	ChangedCapabilities []capabilityChangeJson `json:"changedCapabilities"`
+}
+
+type packagingPlanDiffJson struct {
+	FromPlanId      string            `json:"fromPlanId"`
+	ToPlanId        string            `json:"toPlanId"`
+	AddedPackages   []packageJson     `json:"addedPackages"`
+	RemovedPackages []packageJson     `json:"removedPackages"`
+	PackageDiffs    []packageDiffJson `json:"packageDiffs"`
+}
+
+func toCapabilityJson(cpb licensing.Capability) capabilityJson {
+	result := capabilityJson{Id: cpb.Id, DisplayName: cpb.DisplayName}
+	if cpb.HasCapacityLimit {
+		limit := cpb.CapacityLimit
+		result.CapacityLimit = &limit
+		result.CapacityLimitUnit = cpb.CapacityLimitUnit
+	}
+	return result
+}
+
+func toPackageDiffJson(diff licensing.PackageDiff) packageDiffJson {
+	result := packageDiffJson{
+		FromPackageId:       diff.FromPackageId,
+		ToPackageId:         diff.ToPackageId,
+		AddedCapabilities:   make([]capabilityJson, 0),
+		RemovedCapabilities: make([]capabilityJson, 0),
+		ChangedCapabilities: make([]capabilityChangeJson, 0),
+	}
+	if diff.NameChange != nil {
+		result.Name = &stringChangeJson{From: diff.NameChange.From, To: diff.NameChange.To}
+	}
+	for _, cpb := range diff.AddedCapabilities {
+		result.AddedCapabilities = append(result.AddedCapabilities, toCapabilityJson(cpb))
+	}
+	for _, cpb := range diff.RemovedCapabilities {
+		result.RemovedCapabilities = append(result.RemovedCapabilities, toCapabilityJson(cpb))
+	}
+	for _, change := range diff.ChangedCapabilities {
+		changeJson := capabilityChangeJson{CapabilityId: change.CapabilityId}
+		if change.DisplayNameChanged {
+			changeJson.DisplayName = &stringChangeJson{From: change.From.DisplayName, To: change.To.DisplayName}
+		}
+		if change.CapacityLimitChanged {
+			changeJson.CapacityLimit = &capacityLimitChangeJson{From: toCapabilityJson(change.From), To: toCapabilityJson(change.To)}
+		}
+		result.ChangedCapabilities = append(result.ChangedCapabilities, changeJson)
+	}
+	return result
+}
+
+func toPackagingPlanDiffJson(diff licensing.PackagingPlanDiff) packagingPlanDiffJson {
+	result := packagingPlanDiffJson{
+		FromPlanId:      diff.FromPlanId,
+		ToPlanId:        diff.ToPlanId,
+		AddedPackages:   make([]packageJson, 0),
+		RemovedPackages: make([]packageJson, 0),
+		PackageDiffs:    make([]packageDiffJson, 0),
+	}
+	for _, pkg := range diff.AddedPackages {
+		result.AddedPackages = append(result.AddedPackages, packageJson{Id: pkg.Id, Name: pkg.Name})
+	}
+	for _, pkg := range diff.RemovedPackages {
+		result.RemovedPackages = append(result.RemovedPackages, packageJson{Id: pkg.Id, Name: pkg.Name})
+	}
+	for _, pkgDiff := range diff.PackageDiffs {
+		result.PackageDiffs = append(result.PackageDiffs, toPackageDiffJson(pkgDiff))
+	}
+	return result
+}
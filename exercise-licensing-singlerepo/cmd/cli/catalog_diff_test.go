@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+const catalog2023 = `formatVersion: 1
+capabilities:
+  - {id: cpb:sequence, displayName: Squence}
+  - {id: cpb:sentiment, displayName: ML Driven Sentiment}
+  - {id: cpb:calendaring, displayName: Calendering}
+  - {id: cpb:crm-sync, displayName: CRM Sync, capacityLimit: 10000, capacityLimitUnit: CallsPerDay}
+packages:
+  - id: pkg:base-accelerate-2022
+    name: Accelerate
+    capabilities:
+      - {id: cpb:sequence}
+      - {id: cpb:calendaring}
+      - {id: cpb:crm-sync, capacityLimit: 10000, capacityLimitUnit: CallsPerDay}
+  - id: pkg:base-accelerate-2023
+    name: Accelerate
+    capabilities:
+      - {id: cpb:sequence}
+      - {id: cpb:sentiment}
+      - {id: cpb:crm-sync, capacityLimit: 20000, capacityLimitUnit: CallsPerDay}
+packagingPlans:
+  - {id: pkgplan:v1.0, majorVersion: 1, revision: 0, createdAt: "2022-01-01T00:00:00Z", packages: [pkg:base-accelerate-2022]}
+  - {id: pkgplan:v2.0, majorVersion: 2, revision: 0, createdAt: "2023-01-01T00:00:00Z", packages: [pkg:base-accelerate-2023]}
+`
+
+func TestCatalogDiff(t *testing.T) {
+
+	catalogFile := filepath.Join(t.TempDir(), "catalog.yaml")
+	assert.NilError(t, os.WriteFile(catalogFile, []byte(catalog2023), 0o644))
+
+	t.Run("package diff in text", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runCatalogDiff(context.Background(), []string{"-catalog", catalogFile, "-from", "pkg:base-accelerate-2022", "-to", "pkg:base-accelerate-2023"}, &out)
+		assert.NilError(t, err)
+		assert.Equal(t, out.String(), `Package pkg:base-accelerate-2022 -> pkg:base-accelerate-2023
+  + cpb:sentiment (ML Driven Sentiment)
+  - cpb:calendaring (Calendering)
+  ~ cpb:crm-sync: capacity limit 10000 CallsPerDay -> 20000 CallsPerDay
+`)
+	})
+
+	t.Run("packaging plan diff in json", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runCatalogDiff(context.Background(), []string{"-catalog", catalogFile, "-from-plan", "pkgplan:v1.0", "-to-plan", "pkgplan:v2.0", "-format", "json"}, &out)
+		assert.NilError(t, err)
+		assert.Equal(t, out.String(), `{
+  "fromPlanId": "pkgplan:v1.0",
+  "toPlanId": "pkgplan:v2.0",
+  "addedPackages": [],
+  "removedPackages": [],
+  "packageDiffs": [
+    {
+      "fromPackageId": "pkg:base-accelerate-2022",
+      "toPackageId": "pkg:base-accelerate-2023",
+      "addedCapabilities": [
+        {
+          "id": "cpb:sentiment",
+          "displayName": "ML Driven Sentiment"
+        }
+      ],
+      "removedCapabilities": [
+        {
+          "id": "cpb:calendaring",
+          "displayName": "Calendering"
+        }
+      ],
+      "changedCapabilities": [
+        {
+          "capabilityId": "cpb:crm-sync",
+          "capacityLimit": {
+            "from": {
+              "id": "cpb:crm-sync",
+              "displayName": "CRM Sync",
+              "capacityLimit": 10000,
+              "capacityLimitUnit": "CallsPerDay"
+            },
+            "to": {
+              "id": "cpb:crm-sync",
+              "displayName": "CRM Sync",
+              "capacityLimit": 20000,
+              "capacityLimitUnit": "CallsPerDay"
+            }
+          }
+        }
+      ]
+    }
+  ]
+}
+`)
+	})
+
+	t.Run("identical packages of the built-in catalog", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runCatalogDiff(context.Background(), []string{"-from", "pkg:base-optimize-2022", "-to", "pkg:base-optimize-2022"}, &out)
+		assert.NilError(t, err)
+		assert.Equal(t, out.String(), "Package pkg:base-optimize-2022 -> pkg:base-optimize-2022\n  (no changes)\n")
+	})
+}
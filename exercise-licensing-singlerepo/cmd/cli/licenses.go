@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	app "github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/application/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+)
+
+// Issues, assigns, lists and summarizes the licenses of an account, kept in a JSON state file between runs
+func runLicenses(ctx context.Context, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("expected a subcommand: issue, assign, list or summary")
+	}
+	switch args[0] {
+	case "issue":
+		return runLicensesIssue(ctx, args[1:], stdout)
+	case "assign":
+		return runLicensesAssign(ctx, args[1:], stdout)
+	case "list":
+		return runLicensesList(ctx, args[1:], stdout)
+	case "summary":
+		return runLicensesSummary(ctx, args[1:], stdout)
+	default:
+		return fmt.Errorf("unknown subcommand %q, expected issue, assign, list or summary", args[0])
+	}
+}
+
+// Flags shared by the licenses subcommands
+type licensesFlags struct {
+	*flag.FlagSet
+	stateFile   *string
+	catalogFile *string
+	accId       *string
+}
+
+func newLicensesFlags(name string) licensesFlags {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	return licensesFlags{
+		FlagSet:     flags,
+		stateFile:   flags.String("state", "licenses.json", "JSON file keeping the licenses between runs"),
+		catalogFile: flags.String("catalog", "", "catalog file (YAML or JSON); the built-in 2022 catalog if empty"),
+		accId:       flags.String("account", "", "customer account id"),
+	}
+}
+
+func (f licensesFlags) newService() (app.LicensingService, error) {
+	pkgRepo, err := newPackageRepository(*f.catalogFile)
+	if err != nil {
+		return nil, err
+	}
+	fileRepo, err := storage.NewLicenseRepoFile(*f.stateFile, pkgRepo)
+	if err != nil {
+		return nil, err
+	}
+	var licRepo licensing.LicenseRepository = fileRepo
+	return app.NewLicensingService(&licRepo, &pkgRepo), nil
+}
+
+func runLicensesIssue(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := newLicensesFlags("licenses issue")
+	subId := flags.String("subscription", "", "subscription id")
+	pkgId := flags.String("package", "", "package id")
+	count := flags.Int("count", 1, "number of licenses")
+	trial := flags.Bool("trial", false, "issue trial licenses")
+	expires := flags.String("expires", "", "end of term, as RFC 3339 time or YYYY-MM-DD (UTC midnight); none if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *flags.accId == "" || *subId == "" || *pkgId == "" {
+		return errors.New("-account, -subscription and -package are required")
+	}
+	opts := make([]licensing.LicenseIssuanceOption, 0)
+	if *trial {
+		opts = append(opts, licensing.AsTrial())
+	}
+	if *expires != "" {
+		expiresAt, err := parseExpiry(*expires)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, licensing.ExpiringAt(expiresAt))
+	}
+	ls, err := flags.newService()
+	if err != nil {
+		return err
+	}
+	lics, err := ls.IssueLicenses(ctx, app.NewLicenseAdmin("cli"), *flags.accId, *subId, *pkgId, *count, app.WithIssuanceTerms(opts...))
+	if err != nil {
+		return err
+	}
+	for _, lic := range lics {
+		fmt.Fprintf(stdout, "Issued license %s of %s\n", lic.Id(), lic.LicensedPackage().Id)
+	}
+	return nil
+}
+
+func runLicensesAssign(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := newLicensesFlags("licenses assign")
+	pkgId := flags.String("package", "", "package id")
+	insId := flags.String("instance", "", "instance id")
+	insUsrId := flags.String("user", "", "instance user id")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *flags.accId == "" || *pkgId == "" || *insId == "" || *insUsrId == "" {
+		return errors.New("-account, -package, -instance and -user are required")
+	}
+	ls, err := flags.newService()
+	if err != nil {
+		return err
+	}
+	p := app.NewCustomerAdmin("cli", *flags.accId, *insId)
+	lic, err := ls.AssignAvailableLicenseOfPackage(ctx, p, *pkgId, *flags.accId, *insId, *insUsrId)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Assigned license %s to %s\n", lic.Id(), lic.AssignedToLicensee().LicenseeId())
+	return nil
+}
+
+func runLicensesList(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := newLicensesFlags("licenses list")
+	subId := flags.String("subscription", "", "only licenses of the subscription id")
+	pkgId := flags.String("package", "", "only licenses of the package id")
+	unassigned := flags.Bool("unassigned", false, "only unassigned licenses")
+	active := flags.Bool("active", false, "only active licenses within their term")
+	limit := flags.Int("limit", 0, fmt.Sprintf("licenses per page; %d if 0", licensing.DefaultLicensePageSize))
+	cursor := flags.String("cursor", "", "cursor of the page to list, as printed with the previous page")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *flags.accId == "" {
+		return errors.New("-account is required")
+	}
+	query := licensing.LicenseQuery{SubscriptionId: *subId, PackageId: *pkgId, PageSize: *limit, Cursor: *cursor}
+	if *unassigned {
+		assigned := false
+		query.IsAssigned = &assigned
+	}
+	if *active {
+		query.Statuses = []licensing.LicenseStatus{licensing.ACTIVE_LICENSE}
+	}
+	ls, err := flags.newService()
+	if err != nil {
+		return err
+	}
+	page, err := ls.ListLicenses(ctx, app.NewCustomerAdmin("cli", *flags.accId), *flags.accId, query)
+	if err != nil {
+		return err
+	}
+	for _, lic := range page.Licenses {
+		assignee := "unassigned"
+		if lic.IsAssigned() {
+			assignee = lic.AssignedToLicensee().LicenseeId()
+		}
+		expires := "no end of term"
+		if !lic.ExpiresAt().IsZero() {
+			expires = "expires " + lic.ExpiresAt().Format(time.RFC3339)
+		}
+		fmt.Fprintf(stdout, "%s  %s  %s  %s\n", lic.Id(), lic.LicensedPackage().Id, expires, assignee)
+	}
+	if page.NextCursor != "" {
+		fmt.Fprintf(stdout, "More licenses with -cursor %s\n", page.NextCursor)
+	}
+	return nil
+}
+
+func runLicensesSummary(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := newLicensesFlags("licenses summary")
+	expiringSoonDays := flags.Int("expiring-soon-days", int(licensing.DefaultExpiringSoonWindow/(24*time.Hour)),
+		"days within which the term of a license ends for it to count as expiring soon")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *flags.accId == "" {
+		return errors.New("-account is required")
+	}
+	ls, err := flags.newService()
+	if err != nil {
+		return err
+	}
+	summary, err := ls.GatherLicenseAssignmentSummary(ctx, app.NewCustomerAdmin("cli", *flags.accId), *flags.accId,
+		app.ExpiringSoonWithin(time.Duration(*expiringSoonDays)*24*time.Hour))
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tSUBSCRIPTION\tISSUED\tASSIGNED\tUNASSIGNED\tTRIAL\tEXPIRING SOON\tEXPIRED\tCANCELLED\tRENEWED\tIN OVERAGE")
+	printTotals := func(pkgId string, subId string, totals licensing.LicenseAssignmentTotals) {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n", pkgId, subId, totals.Issued, totals.Assigned,
+			totals.Unassigned, totals.Trial, totals.ExpiringSoon, totals.Expired, totals.Cancelled, totals.Renewed, totals.InOverage)
+	}
+	for _, pkgSummary := range summary.Packages {
+		for _, subSummary := range pkgSummary.Subscriptions {
+			printTotals(pkgSummary.PackageId, subSummary.SubscriptionId, subSummary.Totals)
+		}
+		printTotals(pkgSummary.PackageId, "(all)", pkgSummary.Totals)
+	}
+	printTotals("(all)", "(all)", summary.Totals)
+	return tw.Flush()
+}
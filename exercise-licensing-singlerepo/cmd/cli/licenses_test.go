@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestLicenses(t *testing.T) {
+
+	ctx := context.Background()
+	stateFile := filepath.Join(t.TempDir(), "licenses.json")
+	run := func(args ...string) (string, error) {
+		var stdout bytes.Buffer
+		err := runLicenses(ctx, append(args, "-state", stateFile, "-account", "acc-1"), &stdout)
+		return stdout.String(), err
+	}
+
+	out, err := run("issue", "-subscription", "sub-1", "-package", "pkg:base-optimize-2022", "-count", "2", "-expires", "2999-01-01")
+	assert.NilError(t, err)
+	assert.Equal(t, strings.Count(out, "Issued license "), 2, out)
+
+	t.Run("licenses survive between runs", func(t *testing.T) {
+		out, err := run("assign", "-package", "pkg:base-optimize-2022", "-instance", "ins-101", "-user", "usr-1")
+		assert.NilError(t, err)
+		assert.Check(t, strings.HasSuffix(out, " to INSTANCE_USER:ins-101/usr-1\n"), out)
+
+		out, err = run("list")
+		assert.NilError(t, err)
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		assert.Equal(t, len(lines), 2, out)
+		assert.Check(t, strings.Contains(out, "pkg:base-optimize-2022  expires 2999-01-01T00:00:00Z  INSTANCE_USER:ins-101/usr-1\n"), out)
+		assert.Check(t, strings.Contains(out, "pkg:base-optimize-2022  expires 2999-01-01T00:00:00Z  unassigned\n"), out)
+	})
+
+	t.Run("licenses are listed by filter and page", func(t *testing.T) {
+		out, err := run("list", "-unassigned")
+		assert.NilError(t, err)
+		assert.Equal(t, strings.Count(out, "  unassigned\n"), 1, out)
+		assert.Equal(t, strings.Count(out, "\n"), 1, out)
+
+		out, err = run("list", "-limit", "1")
+		assert.NilError(t, err)
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		assert.Equal(t, len(lines), 2, out)
+		assert.Check(t, strings.HasPrefix(lines[1], "More licenses with -cursor "), out)
+		out, err = run("list", "-limit", "1", "-cursor", strings.TrimPrefix(lines[1], "More licenses with -cursor "))
+		assert.NilError(t, err)
+		assert.Equal(t, strings.Count(out, "\n"), 1, out)
+		assert.Check(t, !strings.HasPrefix(out, lines[0]), out)
+	})
+
+	t.Run("licenses are summarized by package and subscription", func(t *testing.T) {
+		out, err := run("summary")
+		assert.NilError(t, err)
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		assert.Equal(t, len(lines), 4, out)
+		assert.Equal(t, strings.Join(strings.Fields(lines[0]), " "), "PACKAGE SUBSCRIPTION ISSUED ASSIGNED UNASSIGNED TRIAL EXPIRING SOON EXPIRED CANCELLED RENEWED IN OVERAGE")
+		assert.Equal(t, strings.Join(strings.Fields(lines[1]), " "), "pkg:base-optimize-2022 sub-1 2 1 1 0 0 0 0 0 0")
+		assert.Equal(t, strings.Join(strings.Fields(lines[3]), " "), "(all) (all) 2 1 1 0 0 0 0 0 0")
+	})
+
+	t.Run("assignment fails once every license is assigned", func(t *testing.T) {
+		_, err := run("assign", "-package", "pkg:base-optimize-2022", "-instance", "ins-101", "-user", "usr-2")
+		assert.NilError(t, err)
+		_, err = run("assign", "-package", "pkg:base-optimize-2022", "-instance", "ins-101", "-user", "usr-3")
+		assert.ErrorContains(t, err, "no more unassigned license for pkgId=pkg:base-optimize-2022")
+	})
+}
@@ -1,5 +1,53 @@
 package main
 
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+)
+
+// A CLI command: parses its own arguments and writes its output to stdout
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string, stdout io.Writer) error
+}
+
+var commands = []command{
+	{"catalog-diff", "compare two packages or two packaging plans", runCatalogDiff},
+	{"offline-license", "generate and inspect signed license files for air-gapped instances", runOfflineLicense},
+	{"licenses", "issue, assign, list and summarize licenses kept in a JSON state file", runLicenses},
+}
+
 func main() {
+	if len(os.Args) < 2 {
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	// interrupting the CLI cancels the command
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(ctx, os.Args[2:], os.Stdout); err != nil {
+				stop()
+				fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+	printUsage(os.Stderr)
+	os.Exit(2)
+}
 
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: cli <command> [flags]")
+	fmt.Fprintln(w, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-16s %s\n", cmd.name, cmd.summary)
+	}
 }
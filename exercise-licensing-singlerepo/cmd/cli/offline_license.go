@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	app "github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/application/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/pkg/offlinelicense"
+)
+
+// Generates and inspects signed license files for air-gapped instances
+func runOfflineLicense(ctx context.Context, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("expected a subcommand: keygen, generate or inspect")
+	}
+	switch args[0] {
+	case "keygen":
+		return runOfflineLicenseKeygen(ctx, args[1:], stdout)
+	case "generate":
+		return runOfflineLicenseGenerate(ctx, args[1:], stdout)
+	case "inspect":
+		return runOfflineLicenseInspect(ctx, args[1:], stdout)
+	default:
+		return fmt.Errorf("unknown subcommand %q, expected keygen, generate or inspect", args[0])
+	}
+}
+
+func runOfflineLicenseKeygen(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("offline-license keygen", flag.ContinueOnError)
+	privateKeyFile := flags.String("private-key", "", "file to write the PEM encoded Ed25519 private key to")
+	publicKeyFile := flags.String("public-key", "", "file to write the PEM encoded Ed25519 public key to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *privateKeyFile == "" || *publicKeyFile == "" {
+		return errors.New("-private-key and -public-key are required")
+	}
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	privPem, err := offlinelicense.MarshalPrivateKeyPem(privKey)
+	if err != nil {
+		return err
+	}
+	pubPem, err := offlinelicense.MarshalPublicKeyPem(pubKey)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*privateKeyFile, privPem, 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(*publicKeyFile, pubPem, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Wrote private key to %s and public key to %s\n", *privateKeyFile, *publicKeyFile)
+	return nil
+}
+
+// Generates a license file from the catalog. Seats are taken as given: unlike the licensing service's export,
+// the CLI has no access to the account's licenses, so the operator is responsible for the seat count.
+func runOfflineLicenseGenerate(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("offline-license generate", flag.ContinueOnError)
+	catalogFile := flags.String("catalog", "", "catalog file (YAML or JSON); the built-in 2022 catalog if empty")
+	privateKeyFile := flags.String("private-key", "", "PEM encoded Ed25519 private key to sign with")
+	keyId := flags.String("key-id", "", "key id of the private key")
+	accId := flags.String("account", "", "customer account id")
+	insId := flags.String("instance", "", "instance id")
+	pkgId := flags.String("package", "", "package id")
+	seats := flags.Int("seats", 0, "number of seats")
+	expires := flags.String("expires", "", "expiry, as RFC 3339 time or YYYY-MM-DD (UTC midnight)")
+	out := flags.String("out", "", "file to write the license file to; stdout if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *privateKeyFile == "" || *keyId == "" || *accId == "" || *insId == "" || *pkgId == "" || *expires == "" {
+		return errors.New("-private-key, -key-id, -account, -instance, -package and -expires are required")
+	}
+	expiresAt, err := parseExpiry(*expires)
+	if err != nil {
+		return err
+	}
+	privPem, err := os.ReadFile(*privateKeyFile)
+	if err != nil {
+		return err
+	}
+	privKey, err := offlinelicense.ParsePrivateKeyPem(privPem)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *privateKeyFile, err)
+	}
+
+	pkgRepo, err := newPackageRepository(*catalogFile)
+	if err != nil {
+		return err
+	}
+	pkg, err := pkgRepo.GetPackageById(ctx, *pkgId)
+	if err != nil {
+		return err
+	}
+	catalog, err := pkgRepo.GetCapabilityCatalog(ctx)
+	if err != nil {
+		return err
+	}
+	file, err := app.NewOfflineLicenseFile(pkg, catalog, *accId, *insId, *seats, time.Now(), expiresAt)
+	if err != nil {
+		return err
+	}
+	data, err := offlinelicense.Sign(file, *keyId, privKey)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = fmt.Fprintln(stdout, string(data))
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Wrote license file %s for %d seats of %s to %s\n", file.Id, file.Seats, file.PackageId, *out)
+	return nil
+}
+
+func runOfflineLicenseInspect(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("offline-license inspect", flag.ContinueOnError)
+	publicKeyFile := flags.String("public-key", "", "PEM encoded Ed25519 public key to verify with; not verified if empty")
+	insId := flags.String("instance", "", "instance id to verify the license file for; the file's own instance if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected exactly one license file")
+	}
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	file, keyId, err := offlinelicense.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "License file %s\n", file.Id)
+	fmt.Fprintf(stdout, "  account:  %s\n", file.AccountId)
+	fmt.Fprintf(stdout, "  instance: %s\n", file.InstanceId)
+	fmt.Fprintf(stdout, "  package:  %s (%s)\n", file.PackageId, file.PackageName)
+	fmt.Fprintf(stdout, "  seats:    %d\n", file.Seats)
+	fmt.Fprintf(stdout, "  issued:   %s\n", file.IssuedAt.Format(time.RFC3339))
+	fmt.Fprintf(stdout, "  expires:  %s\n", file.ExpiresAt.Format(time.RFC3339))
+	fmt.Fprintf(stdout, "  key id:   %s\n", keyId)
+	fmt.Fprintln(stdout, "  capabilities:")
+	for _, cpb := range file.Capabilities {
+		limit := ""
+		if cpb.CapacityLimit != nil {
+			limit = fmt.Sprintf(", %d %s", *cpb.CapacityLimit, cpb.CapacityLimitUnit)
+		}
+		fmt.Fprintf(stdout, "    %s (%s)%s\n", cpb.Id, cpb.DisplayName, limit)
+	}
+
+	if *publicKeyFile == "" {
+		fmt.Fprintln(stdout, "Signature not verified, no public key given")
+		return nil
+	}
+	pubPem, err := os.ReadFile(*publicKeyFile)
+	if err != nil {
+		return err
+	}
+	pubKey, err := offlinelicense.ParsePublicKeyPem(pubPem)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *publicKeyFile, err)
+	}
+	if *insId == "" {
+		*insId = file.InstanceId
+	}
+	verifier := offlinelicense.NewVerifier(map[string]ed25519.PublicKey{keyId: pubKey})
+	if _, err := verifier.Verify(data, *insId); err != nil {
+		return fmt.Errorf("verification failed: %w", err)
+	}
+	fmt.Fprintln(stdout, "Signature and expiry verified")
+	return nil
+}
+
+func parseExpiry(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry %q, expected RFC 3339 time or YYYY-MM-DD", value)
+	}
+	return t, nil
+}
+
+func newPackageRepository(catalogFile string) (licensing.PackageRepository, error) {
+	if catalogFile == "" {
+		return storage.NewPackageRepoInMem(), nil
+	}
+	return storage.NewPackageRepoFile(catalogFile)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestOfflineLicense(t *testing.T) {
+
+	dir := t.TempDir()
+	privateKeyFile := filepath.Join(dir, "key.pem")
+	publicKeyFile := filepath.Join(dir, "key.pub.pem")
+	licenseFile := filepath.Join(dir, "license.json")
+
+	var stdout bytes.Buffer
+	assert.NilError(t, runOfflineLicense(context.Background(), []string{"keygen", "-private-key", privateKeyFile, "-public-key", publicKeyFile}, &stdout))
+	assert.NilError(t, runOfflineLicense(context.Background(), []string{"generate", "-private-key", privateKeyFile, "-key-id", "key-1",
+		"-account", "acc-1", "-instance", "ins-101", "-package", "pkg:base-optimize-2022", "-seats", "25",
+		"-expires", "2999-01-01", "-out", licenseFile}, &stdout))
+
+	t.Run("generated file is inspected and verified", func(t *testing.T) {
+		var stdout bytes.Buffer
+		assert.NilError(t, runOfflineLicense(context.Background(), []string{"inspect", "-public-key", publicKeyFile, licenseFile}, &stdout))
+		out := stdout.String()
+		assert.Check(t, strings.Contains(out, "package:  pkg:base-optimize-2022 (Optimize)\n"), out)
+		assert.Check(t, strings.Contains(out, "seats:    25\n"), out)
+		assert.Check(t, strings.Contains(out, "expires:  2999-01-01T00:00:00Z\n"), out)
+		assert.Check(t, strings.Contains(out, "cpb:crm-sync (CRM Sync), 250000 CallsPerDay\n"), out)
+		assert.Check(t, strings.HasSuffix(out, "Signature and expiry verified\n"), out)
+	})
+
+	t.Run("file for another instance fails verification", func(t *testing.T) {
+		var stdout bytes.Buffer
+		err := runOfflineLicense(context.Background(), []string{"inspect", "-public-key", publicKeyFile, "-instance", "ins-202", licenseFile}, &stdout)
+		assert.ErrorContains(t, err, "verification failed: license file issued for another instance")
+	})
+
+	t.Run("tampered file fails verification", func(t *testing.T) {
+		data, err := os.ReadFile(licenseFile)
+		assert.NilError(t, err)
+		var env map[string]interface{}
+		assert.NilError(t, json.Unmarshal(data, &env))
+		payload, err := base64.StdEncoding.DecodeString(env["payload"].(string))
+		assert.NilError(t, err)
+		env["payload"] = bytes.Replace(payload, []byte(`"seats":25`), []byte(`"seats":250`), 1)
+		data, err = json.Marshal(env)
+		assert.NilError(t, err)
+		tamperedFile := filepath.Join(dir, "tampered.json")
+		assert.NilError(t, os.WriteFile(tamperedFile, data, 0o644))
+
+		var stdout bytes.Buffer
+		err = runOfflineLicense(context.Background(), []string{"inspect", "-public-key", publicKeyFile, tamperedFile}, &stdout)
+		assert.Error(t, err, "verification failed: invalid license file signature")
+	})
+}
@@ -0,0 +1,183 @@
+package licensing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// Decorator of the licensing service enforcing which principal may invoke which use case.
+//
+// Each use case is granted to the role of its caller group on the LicensingService facade, and principals
+// scoped to an account (and instances) may only act on those. NewLicensingService always returns the service
+// wrapped in this decorator, so that driving adapters cannot bypass it.
+type authorizingLicensingService struct {
+	core LicensingService
+
+	// underlying license repository, to authorize on the current state of the licenses a use case changes
+	licRepo *licensing.LicenseRepository
+}
+
+func (as *authorizingLicensingService) IssueLicenses(ctx context.Context, p Principal, accId string, subId string, pkgId string, licenseCount int, opts ...MutationOption) ([]*licensing.License, error) {
+	if err := authorize(p, "IssueLicenses", LICENSE_ADMIN, accId, ""); err != nil {
+		return nil, err
+	}
+	return as.core.IssueLicenses(ctx, p, accId, subId, pkgId, licenseCount, opts...)
+}
+
+func (as *authorizingLicensingService) RenewLicenses(ctx context.Context, p Principal, accId string, subId string, renewedSubId string, opts ...MutationOption) ([]*licensing.License, error) {
+	if err := authorize(p, "RenewLicenses", LICENSE_ADMIN, accId, ""); err != nil {
+		return nil, err
+	}
+	return as.core.RenewLicenses(ctx, p, accId, subId, renewedSubId, opts...)
+}
+
+func (as *authorizingLicensingService) TrueDownLicenses(ctx context.Context, p Principal, accId string, subId string, pkgId string, licenseCount int, opts ...MutationOption) ([]*licensing.License, error) {
+	if err := authorize(p, "TrueDownLicenses", LICENSE_ADMIN, accId, ""); err != nil {
+		return nil, err
+	}
+	return as.core.TrueDownLicenses(ctx, p, accId, subId, pkgId, licenseCount, opts...)
+}
+
+// Reassigning takes the license away from its current licensee, so the principal must manage that licensee's
+// instance too. Authorized on the license as loaded, which is assigned only if still at that version.
+func (as *authorizingLicensingService) AssignSpecificLicense(ctx context.Context, p Principal, licId string, accId string, insId string, insUsrId string, opts ...MutationOption) (*licensing.License, error) {
+	if err := authorize(p, "AssignSpecificLicense", CUSTOMER_ADMIN, accId, insId); err != nil {
+		return nil, err
+	}
+	var assigned *licensing.License
+	err := retryOnLicenseConflict(func() error {
+		lic, err := licensing.NewTenantScopedLicenseRepository(*as.licRepo, accId).GetLicenseById(ctx, licId)
+		if err != nil {
+			return err
+		}
+		if err := authorizeHolderOf(p, "AssignSpecificLicense", lic, accId, insId); err != nil {
+			return err
+		}
+		assignOpts := append(opts[:len(opts):len(opts)], atLicenseVersion(lic.Version()))
+		assigned, err = as.core.AssignSpecificLicense(ctx, p, licId, accId, insId, insUsrId, assignOpts...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return assigned, nil
+}
+
+func (as *authorizingLicensingService) AssignAvailableLicenseOfPackage(ctx context.Context, p Principal, pkgId string, accId string, insId string, insUsrId string, opts ...MutationOption) (*licensing.License, error) {
+	if err := authorize(p, "AssignAvailableLicenseOfPackage", CUSTOMER_ADMIN, accId, insId); err != nil {
+		return nil, err
+	}
+	return as.core.AssignAvailableLicenseOfPackage(ctx, p, pkgId, accId, insId, insUsrId, opts...)
+}
+
+func (as *authorizingLicensingService) AssignAvailableLicensesOfPackage(ctx context.Context, p Principal, pkgId string, accId string, insId string, insUsrIds []string, opts ...MutationOption) ([]*licensing.License, error) {
+	if err := authorize(p, "AssignAvailableLicensesOfPackage", CUSTOMER_ADMIN, accId, insId); err != nil {
+		return nil, err
+	}
+	return as.core.AssignAvailableLicensesOfPackage(ctx, p, pkgId, accId, insId, insUsrIds, opts...)
+}
+
+func (as *authorizingLicensingService) ReserveOfflineSeats(ctx context.Context, p Principal, accId string, insId string, pkgId string, seats int, opts ...MutationOption) ([]*licensing.License, error) {
+	if err := authorize(p, "ReserveOfflineSeats", CUSTOMER_ADMIN, accId, insId); err != nil {
+		return nil, err
+	}
+	return as.core.ReserveOfflineSeats(ctx, p, accId, insId, pkgId, seats, opts...)
+}
+
+func (as *authorizingLicensingService) AllocatePooledCapacityToUser(ctx context.Context, p Principal, accId string, cpbId string, insId string, insUsrId string, amount int, opts ...MutationOption) (*licensing.CapacityPool, error) {
+	if err := authorize(p, "AllocatePooledCapacityToUser", CUSTOMER_ADMIN, accId, insId); err != nil {
+		return nil, err
+	}
+	return as.core.AllocatePooledCapacityToUser(ctx, p, accId, cpbId, insId, insUsrId, amount, opts...)
+}
+
+func (as *authorizingLicensingService) AllocatePooledCapacityToInstance(ctx context.Context, p Principal, accId string, cpbId string, insId string, amount int, opts ...MutationOption) (*licensing.CapacityPool, error) {
+	if err := authorize(p, "AllocatePooledCapacityToInstance", CUSTOMER_ADMIN, accId, insId); err != nil {
+		return nil, err
+	}
+	return as.core.AllocatePooledCapacityToInstance(ctx, p, accId, cpbId, insId, amount, opts...)
+}
+
+func (as *authorizingLicensingService) ListLicenses(ctx context.Context, p Principal, accId string, query licensing.LicenseQuery) (*licensing.LicensePage, error) {
+	if err := authorizeAccountWide(p, "ListLicenses", CUSTOMER_ADMIN, accId); err != nil {
+		return nil, err
+	}
+	return as.core.ListLicenses(ctx, p, accId, query)
+}
+
+func (as *authorizingLicensingService) CountTotalUnassignedLicensesOfPackage(ctx context.Context, p Principal, accId string, pkgId string) (int, error) {
+	if err := authorizeAccountWide(p, "CountTotalUnassignedLicensesOfPackage", CUSTOMER_ADMIN, accId); err != nil {
+		return 0, err
+	}
+	return as.core.CountTotalUnassignedLicensesOfPackage(ctx, p, accId, pkgId)
+}
+
+func (as *authorizingLicensingService) GatherLicenseAssignmentSummary(ctx context.Context, p Principal, accId string, opts ...GatherLicenseAssignmentSummaryOption) (licensing.LicenseAssignmentSummary, error) {
+	if err := authorizeAccountWide(p, "GatherLicenseAssignmentSummary", CUSTOMER_ADMIN, accId); err != nil {
+		return licensing.LicenseAssignmentSummary{}, err
+	}
+	return as.core.GatherLicenseAssignmentSummary(ctx, p, accId, opts...)
+}
+
+func (as *authorizingLicensingService) VerifyEntitlement(ctx context.Context, p Principal, accId string, insId string, insUsrId string, cpbId string, opts ...VerifyEntitlementOption) (licensing.Entitlement, error) {
+	if err := authorize(p, "VerifyEntitlement", APPLICATION, accId, insId); err != nil {
+		return licensing.Entitlement{}, err
+	}
+	return as.core.VerifyEntitlement(ctx, p, accId, insId, insUsrId, cpbId, opts...)
+}
+
+func (as *authorizingLicensingService) ListEntitlements(ctx context.Context, p Principal, accId string, insId string, insUsrId string) ([]licensing.Entitlement, error) {
+	if err := authorize(p, "ListEntitlements", APPLICATION, accId, insId); err != nil {
+		return nil, err
+	}
+	return as.core.ListEntitlements(ctx, p, accId, insId, insUsrId)
+}
+
+func (as *authorizingLicensingService) RecordCapacityUsage(ctx context.Context, p Principal, accId string, insId string, insUsrId string, cpbId string, amount int, opts ...MutationOption) (licensing.Entitlement, error) {
+	if err := authorize(p, "RecordCapacityUsage", APPLICATION, accId, insId); err != nil {
+		return licensing.Entitlement{}, err
+	}
+	return as.core.RecordCapacityUsage(ctx, p, accId, insId, insUsrId, cpbId, amount, opts...)
+}
+
+// Checks the principal holds the role granted the use case, and may act on the account and instance
+func authorize(p Principal, useCase string, role Role, accId string, insId string) error {
+	if !p.HasRole(role) {
+		return fmt.Errorf("%w: principal %s lacks role %s required to %s", ErrPermissionDenied, p.Id, role, useCase)
+	}
+	if !p.canAccess(role, accId, insId) {
+		if insId == "" {
+			return fmt.Errorf("%w: principal %s cannot %s of account accId=%s", ErrPermissionDenied, p.Id, useCase, accId)
+		}
+		return fmt.Errorf("%w: principal %s cannot %s of account accId=%s, instance insId=%s", ErrPermissionDenied, p.Id, useCase, accId, insId)
+	}
+	return nil
+}
+
+// Checks like authorize, and that the principal may act on every instance of the account, for use cases spanning
+// them all
+func authorizeAccountWide(p Principal, useCase string, role Role, accId string) error {
+	if err := authorize(p, useCase, role, accId, ""); err != nil {
+		return err
+	}
+	if role != LICENSE_ADMIN && len(p.InstanceIds) > 0 {
+		return fmt.Errorf("%w: principal %s is scoped to instances %v, cannot %s of the whole account accId=%s",
+			ErrPermissionDenied, p.Id, p.InstanceIds, useCase, accId)
+	}
+	return nil
+}
+
+// Authorizes the principal to take the license away from the instance holding it, if held by another instance
+// than the given one
+func authorizeHolderOf(p Principal, useCase string, lic *licensing.License, accId string, insId string) error {
+	if !lic.IsAssigned() {
+		return nil
+	}
+	insUsr, ok := lic.AssignedToLicensee().(licensing.InstanceUser)
+	if !ok || insUsr.InstanceId == insId {
+		return nil
+	}
+	return authorize(p, useCase, CUSTOMER_ADMIN, accId, insUsr.InstanceId)
+}
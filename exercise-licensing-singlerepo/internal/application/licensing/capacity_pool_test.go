@@ -0,0 +1,136 @@
+package licensing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+	"gotest.tools/v3/assert"
+)
+
+func TestPooledCapacity(t *testing.T) {
+
+	ctx := context.Background()
+	cpbRepoInMem := storage.NewCapabilityRepoInMem()
+	var cpbRepo licensing.CapabilityRepository = cpbRepoInMem
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMemWithCapabilityRepo(cpbRepoInMem)
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var poolRepo licensing.CapacityPoolRepository = storage.NewCapacityPoolRepoInMem()
+	cs := NewCatalogService(&cpbRepo, &pkgRepo)
+	ls := NewLicensingService(&licRepo, &pkgRepo, WithCapacityPoolRepository(&poolRepo))
+
+	accId := "acc-1"
+	subId := "sub-1"
+	pkgId := "pkg:addon-enrichment-2022"
+	cpbId := "cpb:enrichment"
+	insId := "ins-101"
+	insUsrIdAlice := "usr-alice"
+	insUsrIdBob := "usr-bob"
+
+	enrichmentCpb := licensing.Capability{
+		Id:                cpbId,
+		DisplayName:       "Contact Enrichment",
+		HasCapacityLimit:  true,
+		CapacityLimit:     1000,
+		CapacityLimitUnit: "LookupsPerDay",
+		CapacityScope:     licensing.ACCOUNT_POOL_PER_SEAT}
+	_, err := cs.CreateCapability(ctx, enrichmentCpb)
+	assert.NilError(t, err)
+	_, err = cs.CreatePackage(ctx, &licensing.Package{Id: pkgId, Name: "Enrichment Add-On", IncludedCapabilities: []licensing.Capability{enrichmentCpb}})
+	assert.NilError(t, err)
+
+	_, err = ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 3)
+	assert.NilError(t, err)
+	for _, insUsrId := range []string{insUsrIdAlice, insUsrIdBob} {
+		_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, insUsrId)
+		assert.NilError(t, err)
+	}
+
+	t.Run("pool is sized by seats times per-seat allowance", func(t *testing.T) {
+		entitlement, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrIdBob, cpbId)
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, true)
+		assert.Equal(t, entitlement.IsPooledCapacity, true)
+		assert.Equal(t, entitlement.EffectiveCapacityLimit, 3000)
+		assert.Equal(t, entitlement.CapacityLimitUnit, "LookupsPerDay")
+	})
+
+	t.Run("allocation cannot exceed the pool", func(t *testing.T) {
+		_, err := ls.AllocatePooledCapacityToUser(ctx, testCustomerAdmin(accId), accId, cpbId, insId, insUsrIdAlice, 3001)
+		assert.Error(t, err, "cannot allocate 3001 LookupsPerDay of capability cpbId=cpb:enrichment, only 3000 of 3000 are unallocated")
+	})
+
+	t.Run("exhausted personal allocation is reported", func(t *testing.T) {
+		pool, err := ls.AllocatePooledCapacityToUser(ctx, testCustomerAdmin(accId), accId, cpbId, insId, insUsrIdAlice, 1000)
+		assert.NilError(t, err)
+		assert.Equal(t, pool.TotalAllocated(), 1000)
+
+		entitlement, err := ls.RecordCapacityUsage(ctx, testApplication, accId, insId, insUsrIdAlice, cpbId, 1000)
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, false)
+		assert.Equal(t, entitlement.IsEntitledToFeatureButExceedCapability, true)
+		assert.Equal(t, entitlement.IsPersonalAllocationExhausted, true)
+		assert.Equal(t, entitlement.IsPoolExhausted, false)
+	})
+
+	t.Run("exhausted pool is reported to users without allocation", func(t *testing.T) {
+		entitlement, err := ls.RecordCapacityUsage(ctx, testApplication, accId, insId, insUsrIdBob, cpbId, 1500)
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, true)
+		assert.Equal(t, entitlement.EffectiveCapacityLimit, 2000)
+		assert.Equal(t, entitlement.RemainingCapacity, 500)
+
+		entitlement, err = ls.RecordCapacityUsage(ctx, testApplication, accId, insId, insUsrIdBob, cpbId, 500)
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, false)
+		assert.Equal(t, entitlement.IsPoolExhausted, true)
+		assert.Equal(t, entitlement.IsPersonalAllocationExhausted, false)
+	})
+	t.Run("negative usage is rejected", func(t *testing.T) {
+		_, err := ls.RecordCapacityUsage(ctx, testApplication, accId, insId, insUsrIdBob, cpbId, -500)
+		assert.Error(t, err, "usage must not be negative, got -500")
+	})
+
+	t.Run("concurrent usage is all recorded", func(t *testing.T) {
+		before, err := poolRepo.GetCapacityPool(ctx, accId, cpbId)
+		assert.NilError(t, err)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := ls.RecordCapacityUsage(ctx, testApplication, accId, insId, insUsrIdBob, cpbId, 1)
+				assert.Check(t, err)
+			}()
+		}
+		wg.Wait()
+		after, err := poolRepo.GetCapacityPool(ctx, accId, cpbId)
+		assert.NilError(t, err)
+		assert.Equal(t, after.TotalUsage(), before.TotalUsage()+10)
+	})
+
+	t.Run("storage failure does not reset the pool", func(t *testing.T) {
+		var failingPoolRepo licensing.CapacityPoolRepository = failingCapacityPoolRepo{err: errors.New("connection refused")}
+		failingLs := NewLicensingService(&licRepo, &pkgRepo, WithCapacityPoolRepository(&failingPoolRepo))
+		_, err := failingLs.RecordCapacityUsage(ctx, testApplication, accId, insId, insUsrIdBob, cpbId, 1)
+		assert.Error(t, err, "connection refused")
+		_, err = failingLs.AllocatePooledCapacityToUser(ctx, testCustomerAdmin(accId), accId, cpbId, insId, insUsrIdBob, 0)
+		assert.Error(t, err, "connection refused")
+	})
+}
+
+// Capacity pool repository failing every call, as with its database unreachable
+type failingCapacityPoolRepo struct {
+	err error
+}
+
+func (r failingCapacityPoolRepo) GetCapacityPool(ctx context.Context, accId string, cpbId string) (*licensing.CapacityPool, error) {
+	return nil, r.err
+}
+
+func (r failingCapacityPoolRepo) SaveCapacityPool(ctx context.Context, pool *licensing.CapacityPool) error {
+	return r.err
+}
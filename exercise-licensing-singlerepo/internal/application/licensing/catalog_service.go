@@ -0,0 +1,289 @@
+package licensing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// Catalog API Facade, an entry point for Product Management to maintain the capabilities and packages
+// offered to customers, without a code change and redeploy. Each API represents a business use case.
+//
+// Capabilities and packages are never deleted, only archived, so that issued licenses keep resolving.
+// Referential integrity is enforced on every change: a package can only include live capabilities,
+// and a capability cannot be archived while a live package still grants it.
+//
+// DDD classification: Application Service
+type CatalogService interface {
+
+	// Create a new capability; its relationships must reference existing capabilities
+	CreateCapability(ctx context.Context, cpb licensing.Capability) (licensing.Capability, error)
+
+	// Update an existing capability, e.g. its display name or relationships
+	UpdateCapability(ctx context.Context, cpb licensing.Capability) (licensing.Capability, error)
+
+	// Archive a capability no live package grants anymore
+	ArchiveCapability(ctx context.Context, cpbId string) (licensing.Capability, error)
+
+	// Create a new package of live capabilities
+	CreatePackage(ctx context.Context, pkg *licensing.Package) (*licensing.Package, error)
+
+	// Update an existing, live package
+	UpdatePackage(ctx context.Context, pkg *licensing.Package) (*licensing.Package, error)
+
+	// Archive a package, so that no more licenses are issued for it
+	ArchivePackage(ctx context.Context, pkgId string) (*licensing.Package, error)
+
+	// Compare two packages, e.g. to generate release notes of a new package version
+	DiffPackages(ctx context.Context, fromPkgId string, toPkgId string) (licensing.PackageDiff, error)
+
+	// Compare two packaging plans, e.g. to analyze the impact of moving customers to a new plan
+	DiffPackagingPlans(ctx context.Context, fromPlanId string, toPlanId string) (licensing.PackagingPlanDiff, error)
+}
+
+type catalogService struct {
+
+	// underlying capability repository interface to access capabilities
+	cpbRepo *licensing.CapabilityRepository
+
+	// underlying package repository interface to access packages
+	pkgRepo *licensing.PackageRepository
+
+	// notified after every change to the catalog
+	changeHandlers []func()
+}
+
+type CatalogServiceOption func(cs *catalogService)
+
+// Notifies the given handler after every change to the catalog, e.g. to invalidate cached entitlements
+func WithCatalogChangeHandler(handler func()) CatalogServiceOption {
+	return func(cs *catalogService) {
+		cs.changeHandlers = append(cs.changeHandlers, handler)
+	}
+}
+
+func NewCatalogService(
+	cpbRepo *licensing.CapabilityRepository,
+	pkgRepo *licensing.PackageRepository,
+	opts ...CatalogServiceOption) *catalogService {
+	cs := &catalogService{cpbRepo: cpbRepo, pkgRepo: pkgRepo}
+	for _, opt := range opts {
+		opt(cs)
+	}
+	return cs
+}
+
+func (cs *catalogService) CreateCapability(ctx context.Context, cpb licensing.Capability) (licensing.Capability, error) {
+	if cpb.Id == "" {
+		return licensing.Capability{}, fmt.Errorf("capability id is required")
+	}
+	if _, err := (*cs.cpbRepo).GetCapabilityById(ctx, cpb.Id); err == nil {
+		return licensing.Capability{}, fmt.Errorf("capability already exists for cpbId=%s", cpb.Id)
+	}
+	cpb.IsArchived = false
+	if _, err := cs.validateCatalogWith(ctx, cpb); err != nil {
+		return licensing.Capability{}, err
+	}
+	if err := (*cs.cpbRepo).CreateCapability(ctx, cpb); err != nil {
+		return licensing.Capability{}, err
+	}
+	cs.notifyChanged()
+	return cpb, nil
+}
+
+func (cs *catalogService) UpdateCapability(ctx context.Context, cpb licensing.Capability) (licensing.Capability, error) {
+	existing, err := (*cs.cpbRepo).GetCapabilityById(ctx, cpb.Id)
+	if err != nil {
+		return licensing.Capability{}, err
+	}
+	// archival has its own use case
+	cpb.IsArchived = existing.IsArchived
+
+	catalog, err := cs.validateCatalogWith(ctx, cpb)
+	if err != nil {
+		return licensing.Capability{}, err
+	}
+	livePkgs, err := cs.listLivePackages(ctx)
+	if err != nil {
+		return licensing.Capability{}, err
+	}
+	for _, pkg := range livePkgs {
+		if err := catalog.ValidatePackage(pkg); err != nil {
+			return licensing.Capability{}, err
+		}
+	}
+	if err := (*cs.cpbRepo).UpdateCapability(ctx, cpb); err != nil {
+		return licensing.Capability{}, err
+	}
+	cs.notifyChanged()
+	return cpb, nil
+}
+
+func (cs *catalogService) ArchiveCapability(ctx context.Context, cpbId string) (licensing.Capability, error) {
+	cpb, err := (*cs.cpbRepo).GetCapabilityById(ctx, cpbId)
+	if err != nil {
+		return licensing.Capability{}, err
+	}
+	catalog, err := (*cs.pkgRepo).GetCapabilityCatalog(ctx)
+	if err != nil {
+		return licensing.Capability{}, err
+	}
+	livePkgs, err := cs.listLivePackages(ctx)
+	if err != nil {
+		return licensing.Capability{}, err
+	}
+	for _, pkg := range livePkgs {
+		for _, grantedId := range catalog.ImpliedClosure(pkg.IncludedCapabilityIds()) {
+			if grantedId == cpbId {
+				return licensing.Capability{}, fmt.Errorf("capability cpbId=%s is still granted by live package pkgId=%s", cpbId, pkg.Id)
+			}
+		}
+	}
+	cpb.IsArchived = true
+	if err := (*cs.cpbRepo).UpdateCapability(ctx, cpb); err != nil {
+		return licensing.Capability{}, err
+	}
+	cs.notifyChanged()
+	return cpb, nil
+}
+
+func (cs *catalogService) CreatePackage(ctx context.Context, pkg *licensing.Package) (*licensing.Package, error) {
+	if pkg.Id == "" {
+		return nil, fmt.Errorf("package id is required")
+	}
+	if _, err := (*cs.pkgRepo).GetPackageById(ctx, pkg.Id); err == nil {
+		return nil, fmt.Errorf("package already exists for pkgId=%s", pkg.Id)
+	}
+	pkg.IsArchived = false
+	if err := cs.validatePackage(ctx, pkg); err != nil {
+		return nil, err
+	}
+	if err := (*cs.pkgRepo).CreatePackage(ctx, pkg); err != nil {
+		return nil, err
+	}
+	cs.notifyChanged()
+	return pkg, nil
+}
+
+func (cs *catalogService) UpdatePackage(ctx context.Context, pkg *licensing.Package) (*licensing.Package, error) {
+	existing, err := (*cs.pkgRepo).GetPackageById(ctx, pkg.Id)
+	if err != nil {
+		return nil, err
+	}
+	if existing.IsArchived {
+		return nil, fmt.Errorf("package pkgId=%s is archived", pkg.Id)
+	}
+	pkg.IsArchived = false
+	if err := cs.validatePackage(ctx, pkg); err != nil {
+		return nil, err
+	}
+	if err := (*cs.pkgRepo).UpdatePackage(ctx, pkg); err != nil {
+		return nil, err
+	}
+	cs.notifyChanged()
+	return pkg, nil
+}
+
+func (cs *catalogService) ArchivePackage(ctx context.Context, pkgId string) (*licensing.Package, error) {
+	pkg, err := (*cs.pkgRepo).GetPackageById(ctx, pkgId)
+	if err != nil {
+		return nil, err
+	}
+	archived := *pkg
+	archived.IsArchived = true
+	if err := (*cs.pkgRepo).UpdatePackage(ctx, &archived); err != nil {
+		return nil, err
+	}
+	cs.notifyChanged()
+	return &archived, nil
+}
+
+func (cs *catalogService) notifyChanged() {
+	for _, handler := range cs.changeHandlers {
+		handler()
+	}
+}
+
+func (cs *catalogService) DiffPackages(ctx context.Context, fromPkgId string, toPkgId string) (licensing.PackageDiff, error) {
+	from, err := (*cs.pkgRepo).GetPackageById(ctx, fromPkgId)
+	if err != nil {
+		return licensing.PackageDiff{}, err
+	}
+	to, err := (*cs.pkgRepo).GetPackageById(ctx, toPkgId)
+	if err != nil {
+		return licensing.PackageDiff{}, err
+	}
+	catalog, err := (*cs.pkgRepo).GetCapabilityCatalog(ctx)
+	if err != nil {
+		return licensing.PackageDiff{}, err
+	}
+	return licensing.DiffPackages(from, to, catalog), nil
+}
+
+func (cs *catalogService) DiffPackagingPlans(ctx context.Context, fromPlanId string, toPlanId string) (licensing.PackagingPlanDiff, error) {
+	from, err := (*cs.pkgRepo).GetPackagingPlanById(ctx, fromPlanId)
+	if err != nil {
+		return licensing.PackagingPlanDiff{}, err
+	}
+	to, err := (*cs.pkgRepo).GetPackagingPlanById(ctx, toPlanId)
+	if err != nil {
+		return licensing.PackagingPlanDiff{}, err
+	}
+	catalog, err := (*cs.pkgRepo).GetCapabilityCatalog(ctx)
+	if err != nil {
+		return licensing.PackagingPlanDiff{}, err
+	}
+	return licensing.DiffPackagingPlans(from, to, catalog), nil
+}
+
+// Validates the catalog as it would be with the given capability created or replaced
+func (cs *catalogService) validateCatalogWith(ctx context.Context, cpb licensing.Capability) (*licensing.CapabilityCatalog, error) {
+	capabilities, err := (*cs.cpbRepo).ListCapabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replaced := false
+	for i, existing := range capabilities {
+		if existing.Id == cpb.Id {
+			capabilities[i] = cpb
+			replaced = true
+		}
+	}
+	if !replaced {
+		capabilities = append(capabilities, cpb)
+	}
+	return licensing.NewCapabilityCatalog(capabilities)
+}
+
+// Checks the package only includes live capabilities that don't conflict with each other
+func (cs *catalogService) validatePackage(ctx context.Context, pkg *licensing.Package) error {
+	for _, includedCpb := range pkg.IncludedCapabilities {
+		cpb, err := (*cs.cpbRepo).GetCapabilityById(ctx, includedCpb.Id)
+		if err != nil {
+			return fmt.Errorf("package pkgId=%s includes unknown capability cpbId=%s", pkg.Id, includedCpb.Id)
+		}
+		if cpb.IsArchived {
+			return fmt.Errorf("package pkgId=%s includes archived capability cpbId=%s", pkg.Id, includedCpb.Id)
+		}
+	}
+	catalog, err := (*cs.pkgRepo).GetCapabilityCatalog(ctx)
+	if err != nil {
+		return err
+	}
+	return catalog.ValidatePackage(pkg)
+}
+
+func (cs *catalogService) listLivePackages(ctx context.Context) ([]*licensing.Package, error) {
+	pkgs, err := (*cs.pkgRepo).ListPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*licensing.Package, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		if !pkg.IsArchived {
+			results = append(results, pkg)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,82 @@
+package licensing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+	"gotest.tools/v3/assert"
+)
+
+func TestCatalogService(t *testing.T) {
+
+	ctx := context.Background()
+	cpbRepoInMem := storage.NewCapabilityRepoInMem()
+	var cpbRepo licensing.CapabilityRepository = cpbRepoInMem
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMemWithCapabilityRepo(cpbRepoInMem)
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	cs := NewCatalogService(&cpbRepo, &pkgRepo)
+	ls := NewLicensingService(&licRepo, &pkgRepo)
+
+	forecastCpb := licensing.Capability{
+		Id:                   "cpb:forecast",
+		DisplayName:          "Forecast",
+		CapacityLimitUnit:    "NotApplicable",
+		ImpliedCapabilityIds: []string{"cpb:advanced-reporting"}}
+
+	t.Run("create capability and a package granting it", func(t *testing.T) {
+		_, err := cs.CreateCapability(ctx, forecastCpb)
+		assert.NilError(t, err)
+		_, err = cs.CreatePackage(ctx, &licensing.Package{
+			Id:                   "pkg:addon-forecast-2022",
+			Name:                 "Forecast Add-On",
+			IncludedCapabilities: []licensing.Capability{forecastCpb}})
+		assert.NilError(t, err)
+
+		_, err = ls.IssueLicenses(ctx, testLicenseAdmin, "acc-1", "sub-1", "pkg:addon-forecast-2022", 1)
+		assert.NilError(t, err)
+		_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin("acc-1"), "pkg:addon-forecast-2022", "acc-1", "ins-101", "usr-alice")
+		assert.NilError(t, err)
+		entitlement, err := ls.VerifyEntitlement(ctx, testApplication, "acc-1", "ins-101", "usr-alice", "cpb:basic-reporting")
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, true)
+	})
+
+	t.Run("capability with unknown relationship is rejected", func(t *testing.T) {
+		_, err := cs.CreateCapability(ctx, licensing.Capability{Id: "cpb:coach", RequiredCapabilityIds: []string{"cpb:unknown"}})
+		assert.Error(t, err, "capability id=cpb:coach requires unknown capability id=cpb:unknown")
+	})
+
+	t.Run("update introducing a cycle is rejected", func(t *testing.T) {
+		basicReporting, err := cpbRepo.GetCapabilityById(ctx, "cpb:basic-reporting")
+		assert.NilError(t, err)
+		basicReporting.ImpliedCapabilityIds = []string{"cpb:forecast"}
+		_, err = cs.UpdateCapability(ctx, basicReporting)
+		assert.ErrorContains(t, err, "capability implies relationships form a cycle")
+	})
+
+	t.Run("capability granted by a live package cannot be archived", func(t *testing.T) {
+		_, err := cs.ArchiveCapability(ctx, "cpb:basic-reporting")
+		assert.Error(t, err, "capability cpbId=cpb:basic-reporting is still granted by live package pkgId=pkg:addon-forecast-2022")
+	})
+
+	t.Run("archiving the package releases its capability", func(t *testing.T) {
+		_, err := cs.ArchivePackage(ctx, "pkg:addon-forecast-2022")
+		assert.NilError(t, err)
+		archived, err := cs.ArchiveCapability(ctx, "cpb:forecast")
+		assert.NilError(t, err)
+		assert.Equal(t, archived.IsArchived, true)
+
+		_, err = ls.IssueLicenses(ctx, testLicenseAdmin, "acc-1", "sub-1", "pkg:addon-forecast-2022", 1)
+		assert.Error(t, err, "package pkgId=pkg:addon-forecast-2022 is archived")
+	})
+
+	t.Run("package including an archived capability is rejected", func(t *testing.T) {
+		_, err := cs.CreatePackage(ctx, &licensing.Package{
+			Id:                   "pkg:addon-forecast-2023",
+			Name:                 "Forecast Add-On",
+			IncludedCapabilities: []licensing.Capability{forecastCpb}})
+		assert.Error(t, err, "package pkgId=pkg:addon-forecast-2023 includes archived capability cpbId=cpb:forecast")
+	})
+}
@@ -0,0 +1,198 @@
+package licensing
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// Caches entitlement evaluation results, keyed by licensee and capability.
+// An entry only answers lookups on behalf of the customer account it was evaluated for.
+//
+// Entries expire after a TTL, or earlier when a license they were evaluated from reaches its end of term, and are
+// invalidated precisely when a license event affects the licensee (e.g., a license assigned to or taken away from
+// the licensee). Every entry is invalidated when the catalog changes, as capabilities a package grants may have
+// changed; see WithCatalogChangeHandler and HandleCatalogReload.
+//
+// The cache is safe for concurrent use.
+type EntitlementCache struct {
+	mu sync.Mutex
+
+	// how long an evaluated entitlement stays valid
+	ttl time.Duration
+
+	// clock, replaceable for tests
+	now func() time.Time
+
+	// cached entries by licensee id, then by capability id
+	entries map[string]map[string]entitlementCacheEntry
+
+	// generation, bumped on every invalidation; an evaluation stores its result only if neither its licensee nor
+	// the whole cache was invalidated since the generation it started at, so that it never stores a stale result
+	generation uint64
+
+	// generation of the last invalidation, by licensee id; dropped by every sweep
+	invalidatedAt map[string]uint64
+
+	// generation of the last full invalidation
+	allInvalidatedAt uint64
+
+	// generation of the last sweep; evaluations started before it may have missed dropped invalidations,
+	// so their results are not stored
+	sweptAt uint64
+
+	// time of the next sweep of expired entries and invalidations
+	nextSweep time.Time
+
+	stats EntitlementCacheStats
+}
+
+// Hit/miss statistics of an entitlement cache
+type EntitlementCacheStats struct {
+
+	// Lookups answered from the cache
+	Hits uint64
+
+	// Lookups that required an evaluation, including expired entries
+	Misses uint64
+
+	// Lookups that skipped the cache on caller's request
+	Bypasses uint64
+
+	// Licensee invalidations triggered by license events
+	Invalidations uint64
+
+	// Entries currently held, including not yet purged expired ones
+	Size int
+}
+
+type entitlementCacheEntry struct {
+	accountId   string
+	entitlement licensing.Entitlement
+	expiresAt   time.Time
+}
+
+func NewEntitlementCache(ttl time.Duration) *EntitlementCache {
+	return &EntitlementCache{
+		ttl:           ttl,
+		now:           time.Now,
+		entries:       make(map[string]map[string]entitlementCacheEntry),
+		invalidatedAt: make(map[string]uint64),
+	}
+}
+
+// Returns a snapshot of the cache statistics
+func (c *EntitlementCache) Stats() EntitlementCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	for _, byCpb := range c.entries {
+		stats.Size += len(byCpb)
+	}
+	return stats
+}
+
+// Drops every cached entitlement of the given licensee
+func (c *EntitlementCache) InvalidateLicensee(licenseeId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidateLicenseeLocked(licenseeId)
+}
+
+// Drops every cached entitlement
+func (c *EntitlementCache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.allInvalidatedAt = c.generation
+	c.entries = make(map[string]map[string]entitlementCacheEntry)
+}
+
+// Drops every cached entitlement once the catalog was reloaded, e.g. by a package repository watching its file;
+// a failed reload leaves the catalog, and so the cache, as it was
+func (c *EntitlementCache) HandleCatalogReload(err error) {
+	if err == nil {
+		c.InvalidateAll()
+	}
+}
+
+// Invalidates the licensees affected by the license event
+func (c *EntitlementCache) HandleLicenseEvent(evt licensing.LicenseEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, licenseeId := range evt.AffectedLicenseeIds {
+		c.invalidateLicenseeLocked(licenseeId)
+	}
+}
+
+func (c *EntitlementCache) invalidateLicenseeLocked(licenseeId string) {
+	c.generation++
+	c.invalidatedAt[licenseeId] = c.generation
+	c.stats.Invalidations++
+	delete(c.entries, licenseeId)
+}
+
+// Looks up a cached entitlement. On a miss, returns the generation to hand back to store.
+func (c *EntitlementCache) lookup(accId string, licenseeId string, cpbId string) (licensing.Entitlement, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[licenseeId][cpbId]; ok && entry.accountId == accId {
+		if c.now().Before(entry.expiresAt) {
+			c.stats.Hits++
+			return entry.entitlement, 0, true
+		}
+		delete(c.entries[licenseeId], cpbId)
+	}
+	c.stats.Misses++
+	return licensing.Entitlement{}, c.generation, false
+}
+
+// Stores an entitlement evaluated for the account, unless the licensee was invalidated since the lookup.
+// The entry expires after the TTL, or at validUntil if earlier and not zero.
+func (c *EntitlementCache) store(accId string, ent licensing.Entitlement, generation uint64, validUntil time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweepLocked()
+	if generation < c.sweptAt || generation < c.allInvalidatedAt || generation < c.invalidatedAt[ent.EvaluatedUserId] {
+		return
+	}
+	byCpb, ok := c.entries[ent.EvaluatedUserId]
+	if !ok {
+		byCpb = make(map[string]entitlementCacheEntry)
+		c.entries[ent.EvaluatedUserId] = byCpb
+	}
+	expiresAt := c.now().Add(c.ttl)
+	if !validUntil.IsZero() && validUntil.Before(expiresAt) {
+		expiresAt = validUntil
+	}
+	byCpb[ent.EvaluatedCapabilityId] = entitlementCacheEntry{accountId: accId, entitlement: ent, expiresAt: expiresAt}
+}
+
+// Once per TTL, drops the expired entries and the invalidations, so that the cache only holds on to
+// licensees with entitlements still cached
+func (c *EntitlementCache) sweepLocked() {
+	now := c.now()
+	if now.Before(c.nextSweep) {
+		return
+	}
+	for licenseeId, byCpb := range c.entries {
+		for cpbId, entry := range byCpb {
+			if !now.Before(entry.expiresAt) {
+				delete(byCpb, cpbId)
+			}
+		}
+		if len(byCpb) == 0 {
+			delete(c.entries, licenseeId)
+		}
+	}
+	c.invalidatedAt = make(map[string]uint64)
+	c.sweptAt = c.generation
+	c.nextSweep = now.Add(c.ttl)
+}
+
+func (c *EntitlementCache) recordBypass() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Bypasses++
+}
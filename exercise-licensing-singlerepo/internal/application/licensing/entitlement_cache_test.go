@@ -0,0 +1,218 @@
+package licensing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+	"gotest.tools/v3/assert"
+)
+
+func TestVerifyEntitlementWithCache(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	cache := NewEntitlementCache(time.Minute)
+	ls := NewLicensingService(&licRepo, &pkgRepo, WithEntitlementCache(cache))
+
+	accId := "acc-1"
+	subId := "sub-1"
+	pkgId := "pkg:base-optimize-2022"
+	cpbIdSeq := "cpb:sequence"
+	insId := "ins-101"
+	insUsrIdAlice := "usr-alice"
+	insUsrIdBob := "usr-bob"
+
+	_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 1)
+	assert.NilError(t, err)
+	lic, err := ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, insUsrIdAlice)
+	assert.NilError(t, err)
+
+	t.Run("repeated verification is served from cache", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			entitlement, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrIdAlice, cpbIdSeq)
+			assert.NilError(t, err)
+			assert.Equal(t, entitlement.IsEntitled, true)
+		}
+		stats := cache.Stats()
+		assert.Equal(t, stats.Misses, uint64(1))
+		assert.Equal(t, stats.Hits, uint64(2))
+		assert.Equal(t, stats.Size, 1)
+	})
+
+	t.Run("bypass does not touch the cache", func(t *testing.T) {
+		before := cache.Stats()
+		_, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrIdAlice, cpbIdSeq, BypassEntitlementCache())
+		assert.NilError(t, err)
+		after := cache.Stats()
+		assert.Equal(t, after.Bypasses, before.Bypasses+1)
+		assert.Equal(t, after.Hits, before.Hits)
+		assert.Equal(t, after.Misses, before.Misses)
+	})
+
+	t.Run("reassignment invalidates both licensees", func(t *testing.T) {
+		entitlement, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrIdBob, cpbIdSeq)
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, false)
+
+		_, err = ls.AssignSpecificLicense(ctx, testCustomerAdmin(accId), lic.Id(), accId, insId, insUsrIdBob)
+		assert.NilError(t, err)
+		assert.Equal(t, cache.Stats().Size, 0)
+
+		entitlement, err = ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrIdBob, cpbIdSeq)
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, true)
+		entitlement, err = ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrIdAlice, cpbIdSeq)
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, false)
+	})
+}
+
+func TestEntitlementCacheExpiry(t *testing.T) {
+
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewEntitlementCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	ent := licensing.Entitlement{IsEntitled: true, EvaluatedUserId: "usr-1", EvaluatedCapabilityId: "cpb:sequence"}
+
+	t.Run("entry is served until ttl elapses", func(t *testing.T) {
+		_, generation, hit := cache.lookup("acc-1", "usr-1", "cpb:sequence")
+		assert.Equal(t, hit, false)
+		cache.store("acc-1", ent, generation, time.Time{})
+
+		now = now.Add(59 * time.Second)
+		_, _, hit = cache.lookup("acc-1", "usr-1", "cpb:sequence")
+		assert.Equal(t, hit, true)
+
+		now = now.Add(time.Second)
+		_, _, hit = cache.lookup("acc-1", "usr-1", "cpb:sequence")
+		assert.Equal(t, hit, false)
+	})
+
+	t.Run("entry is served until the end of term of its licenses if earlier", func(t *testing.T) {
+		_, generation, _ := cache.lookup("acc-1", "usr-1", "cpb:sequence")
+		cache.store("acc-1", ent, generation, now.Add(10*time.Second))
+
+		now = now.Add(9 * time.Second)
+		_, _, hit := cache.lookup("acc-1", "usr-1", "cpb:sequence")
+		assert.Equal(t, hit, true)
+
+		now = now.Add(time.Second)
+		_, _, hit = cache.lookup("acc-1", "usr-1", "cpb:sequence")
+		assert.Equal(t, hit, false)
+	})
+
+	t.Run("evaluation racing with invalidation is not stored", func(t *testing.T) {
+		_, generation, hit := cache.lookup("acc-1", "usr-1", "cpb:sequence")
+		assert.Equal(t, hit, false)
+		cache.InvalidateLicensee("usr-1")
+		cache.store("acc-1", ent, generation, time.Time{})
+		assert.Equal(t, cache.Stats().Size, 0)
+	})
+
+	t.Run("expired entries and invalidations are swept once per ttl", func(t *testing.T) {
+		_, generation, _ := cache.lookup("acc-1", "usr-1", "cpb:sequence")
+		cache.store("acc-1", ent, generation, time.Time{})
+		for i := 0; i < 100; i++ {
+			cache.InvalidateLicensee(fmt.Sprintf("usr-%d", 1000+i))
+		}
+		assert.Assert(t, len(cache.invalidatedAt) >= 100)
+
+		now = now.Add(time.Minute)
+		other := licensing.Entitlement{IsEntitled: true, EvaluatedUserId: "usr-2", EvaluatedCapabilityId: "cpb:sequence"}
+		_, generation, _ = cache.lookup("acc-1", "usr-2", "cpb:sequence")
+		cache.store("acc-1", other, generation, time.Time{})
+		assert.Equal(t, len(cache.invalidatedAt), 0)
+		assert.Equal(t, len(cache.entries), 1)
+		_, _, hit := cache.lookup("acc-1", "usr-2", "cpb:sequence")
+		assert.Equal(t, hit, true)
+	})
+}
+
+// License repository failing to find licenses by licensee, as with its database unreachable
+type unreachableLicenseeRepo struct {
+	licensing.LicenseRepository
+}
+
+func (r unreachableLicenseeRepo) FindLicensesByAssignedLicenseeId(ctx context.Context, licenseeId string) ([]*licensing.License, error) {
+	return nil, fmt.Errorf("connection refused")
+}
+
+func TestVerifyEntitlementWithCacheOnStorageFailure(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = unreachableLicenseeRepo{storage.NewLicenseRepoInMem()}
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	cache := NewEntitlementCache(time.Minute)
+	ls := NewLicensingService(&licRepo, &pkgRepo, WithEntitlementCache(cache))
+
+	_, err := ls.VerifyEntitlement(ctx, testApplication, "acc-1", "ins-101", "usr-alice", "cpb:sequence")
+	assert.Error(t, err, "connection refused")
+	assert.Equal(t, cache.Stats().Size, 0)
+	_, err = ls.ListEntitlements(ctx, testApplication, "acc-1", "ins-101", "usr-alice")
+	assert.Error(t, err, "connection refused")
+}
+
+func TestEntitlementCacheInvalidatedByCatalogChange(t *testing.T) {
+
+	ctx := context.Background()
+	cpbRepoInMem := storage.NewCapabilityRepoInMem()
+	var cpbRepo licensing.CapabilityRepository = cpbRepoInMem
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMemWithCapabilityRepo(cpbRepoInMem)
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	cache := NewEntitlementCache(time.Minute)
+	cs := NewCatalogService(&cpbRepo, &pkgRepo, WithCatalogChangeHandler(cache.InvalidateAll))
+	ls := NewLicensingService(&licRepo, &pkgRepo, WithEntitlementCache(cache))
+
+	accId := "acc-1"
+	pkgId := "pkg:addon-forecast-2022"
+	insId := "ins-101"
+	insUsrId := "usr-alice"
+	forecastCpb := licensing.Capability{Id: "cpb:forecast", DisplayName: "Forecast", CapacityLimitUnit: "NotApplicable"}
+
+	_, err := cs.CreateCapability(ctx, forecastCpb)
+	assert.NilError(t, err)
+	pkg, err := cs.CreatePackage(ctx, &licensing.Package{
+		Id:                   pkgId,
+		Name:                 "Forecast Add-On",
+		IncludedCapabilities: []licensing.Capability{forecastCpb}})
+	assert.NilError(t, err)
+	_, err = ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-1", pkgId, 1)
+	assert.NilError(t, err)
+	_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, insUsrId)
+	assert.NilError(t, err)
+
+	entitlement, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrId, "cpb:forecast")
+	assert.NilError(t, err)
+	assert.Equal(t, entitlement.IsEntitled, true)
+	assert.Equal(t, cache.Stats().Size, 1)
+
+	t.Run("package no longer granting a capability is verified anew", func(t *testing.T) {
+		seqCpb, err := cpbRepo.GetCapabilityById(ctx, "cpb:sequence")
+		assert.NilError(t, err)
+		pkg.IncludedCapabilities = []licensing.Capability{seqCpb}
+		_, err = cs.UpdatePackage(ctx, pkg)
+		assert.NilError(t, err)
+		assert.Equal(t, cache.Stats().Size, 0)
+
+		entitlement, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrId, "cpb:forecast")
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, false)
+	})
+
+	t.Run("failed catalog reload keeps cached entitlements", func(t *testing.T) {
+		_, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrId, "cpb:sequence")
+		assert.NilError(t, err)
+		size := cache.Stats().Size
+
+		cache.HandleCatalogReload(fmt.Errorf("malformed catalog"))
+		assert.Equal(t, cache.Stats().Size, size)
+		cache.HandleCatalogReload(nil)
+		assert.Equal(t, cache.Stats().Size, 0)
+	})
+}
@@ -0,0 +1,99 @@
+package licensing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/pkg/entitlementtoken"
+)
+
+// Application Service for minting offline entitlement tokens, checked by edge services without
+// calling back to licensing, see package entitlementtoken.
+type EntitlementTokenIssuer interface {
+
+	// Issue a signed, short-lived token listing the capabilities an instance user is entitled to.
+	// The entitlements are evaluated the same way VerifyEntitlement does, on behalf of the principal, and the token
+	// expires after its lifetime, or at the end of term of the first license in force granting them if earlier.
+	IssueEntitlementToken(ctx context.Context, p Principal, accId string, insId string, insUsrId string) (string, error)
+}
+
+type entitlementTokenIssuer struct {
+	ls      LicensingService
+	licRepo *licensing.LicenseRepository
+
+	// Issuer claim of the tokens
+	issuer string
+
+	// Key id of the signing key, published along with its public key
+	keyId string
+
+	signingKey ed25519.PrivateKey
+
+	// Lifetime of the tokens
+	ttl time.Duration
+
+	now func() time.Time
+}
+
+func NewEntitlementTokenIssuer(ls LicensingService, licRepo *licensing.LicenseRepository, issuer string, keyId string, signingKey ed25519.PrivateKey, ttl time.Duration) EntitlementTokenIssuer {
+	return &entitlementTokenIssuer{
+		ls:         ls,
+		licRepo:    licRepo,
+		issuer:     issuer,
+		keyId:      keyId,
+		signingKey: signingKey,
+		ttl:        ttl,
+		now:        time.Now,
+	}
+}
+
+func (ti *entitlementTokenIssuer) IssueEntitlementToken(ctx context.Context, p Principal, accId string, insId string, insUsrId string) (string, error) {
+	entitlements, err := ti.ls.ListEntitlements(ctx, p, accId, insId, insUsrId)
+	if err != nil {
+		return "", err
+	}
+	tokenId := make([]byte, 16)
+	if _, err := rand.Read(tokenId); err != nil {
+		return "", err
+	}
+	now := ti.now()
+	expiresAt := now.Add(ti.ttl)
+	licenses, err := licensing.NewTenantScopedLicenseRepository(*ti.licRepo, accId).
+		FindLicensesByAssignedLicenseeId(ctx, licensing.NewInstanceUser(insId, insUsrId).LicenseeId())
+	if err != nil && !errors.Is(err, licensing.ErrLicenseNotFound) {
+		return "", err
+	}
+	for _, lic := range licenses {
+		if lic.IsInForceAt(now) && !lic.ExpiresAt().IsZero() && lic.ExpiresAt().Before(expiresAt) {
+			expiresAt = lic.ExpiresAt()
+		}
+	}
+	claims := entitlementtoken.Claims{
+		Issuer:       ti.issuer,
+		Subject:      licensing.NewInstanceUser(insId, insUsrId).LicenseeId(),
+		AccountId:    accId,
+		TokenId:      hex.EncodeToString(tokenId),
+		IssuedAt:     now.Unix(),
+		NotBefore:    now.Unix(),
+		ExpiresAt:    expiresAt.Unix(),
+		Entitlements: make([]entitlementtoken.EntitlementClaim, 0),
+	}
+	for _, ent := range entitlements {
+		if !ent.IsEntitled {
+			continue
+		}
+		claim := entitlementtoken.EntitlementClaim{CapabilityId: ent.EvaluatedCapabilityId, IsPooledCapacity: ent.IsPooledCapacity}
+		if ent.HasCapacityLimit {
+			limit := ent.EffectiveCapacityLimit
+			claim.CapacityLimit = &limit
+			claim.CapacityLimitUnit = ent.CapacityLimitUnit
+		}
+		claims.Entitlements = append(claims.Entitlements, claim)
+	}
+	return entitlementtoken.Sign(claims, ti.keyId, ti.signingKey)
+}
@@ -0,0 +1,68 @@
+package licensing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/pkg/entitlementtoken"
+	"gotest.tools/v3/assert"
+)
+
+func TestIssueEntitlementToken(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := NewLicensingService(&licRepo, &pkgRepo)
+
+	accId := "acc-1"
+	pkgId := "pkg:base-optimize-2022"
+	insId := "ins-101"
+	insUsrId := "usr-alice"
+	_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-1", pkgId, 1)
+	assert.NilError(t, err)
+	_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, insUsrId)
+	assert.NilError(t, err)
+
+	pubKey, privKey, err := ed25519.GenerateKey(nil)
+	assert.NilError(t, err)
+	ti := NewEntitlementTokenIssuer(ls, &licRepo, "licensing", "key-1", privKey, 5*time.Minute)
+	verifier := entitlementtoken.NewVerifier(map[string]ed25519.PublicKey{"key-1": pubKey}, entitlementtoken.WithExpectedIssuer("licensing"))
+
+	token, err := ti.IssueEntitlementToken(ctx, testApplication, accId, insId, insUsrId)
+	assert.NilError(t, err)
+	claims, err := verifier.Verify(token)
+	assert.NilError(t, err)
+	assert.Equal(t, claims.Subject, licensing.NewInstanceUser(insId, insUsrId).LicenseeId())
+	assert.Equal(t, claims.ExpiresAt-claims.IssuedAt, int64(300))
+
+	// the token agrees with VerifyEntitlement on every capability of the catalog
+	catalog, err := pkgRepo.GetCapabilityCatalog(ctx)
+	assert.NilError(t, err)
+	for _, cpb := range catalog.Capabilities() {
+		entitlement, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrId, cpb.Id)
+		assert.NilError(t, err)
+		assert.Equal(t, claims.Can(cpb.Id), entitlement.IsEntitled, cpb.Id)
+		if claim, ok := claims.Entitlement(cpb.Id); ok && entitlement.HasCapacityLimit {
+			assert.Equal(t, *claim.CapacityLimit, entitlement.EffectiveCapacityLimit, cpb.Id)
+		}
+	}
+
+	t.Run("token expires with the licenses granting it", func(t *testing.T) {
+		endOfTerm := time.Now().Add(2 * time.Minute)
+		_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-2", "pkg:base-accelerate-2022", 1, WithIssuanceTerms(licensing.ExpiringAt(endOfTerm)))
+		assert.NilError(t, err)
+		_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), "pkg:base-accelerate-2022", accId, insId, insUsrId)
+		assert.NilError(t, err)
+
+		token, err := ti.IssueEntitlementToken(ctx, testApplication, accId, insId, insUsrId)
+		assert.NilError(t, err)
+		claims, err := verifier.Verify(token)
+		assert.NilError(t, err)
+		assert.Equal(t, claims.ExpiresAt, endOfTerm.Unix())
+	})
+}
@@ -0,0 +1,144 @@
+package licensing
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// Returned, wrapped, when an idempotency key is reused for a call with different parameters
+var ErrIdempotencyKeyReused = errors.New("idempotency key reused with different parameters")
+
+// Returned, wrapped, when a call is replayed while the call it replays is still running
+var ErrIdempotentCallInProgress = errors.New("idempotent call in progress")
+
+// Returned, wrapped, when a call is made with an idempotency key on a service without an idempotency store
+var ErrIdempotencyStoreMissing = errors.New("no idempotency store")
+
+// How long the result of a call made with an idempotency key is kept by default
+const DefaultIdempotencyRetention = 24 * time.Hour
+
+// Keeps the results of the calls made with an idempotency key, for a retention window after the call.
+//
+// Only successful calls are kept: a failing use case changes no license (see licensing.LicenseUnitOfWork),
+// so that it can be retried with the same key. Results are kept in memory, apart from the licenses: the store is
+// for a single process serving the licensing service, which every replay must reach within the retention window.
+// Processes sharing a license repository would each run a call replayed to them.
+//
+// The store is safe for concurrent use.
+type IdempotencyStore struct {
+	mu sync.Mutex
+
+	// how long the result of a call is kept
+	retention time.Duration
+
+	// clock, replaceable for tests
+	now func() time.Time
+
+	// records of the calls, running or completed, by account and key
+	records map[idempotencyScopedKey]*idempotencyRecord
+
+	// keys of the completed calls, in order of completion, for purging expired records
+	completed []idempotencyScopedKey
+}
+
+type idempotencyScopedKey struct {
+	accId string
+	key   string
+}
+
+type idempotencyRecord struct {
+
+	// use case and parameters of the call
+	fingerprint string
+
+	// whether the call completed; a running call has no result yet
+	done bool
+
+	result      interface{}
+	completedAt time.Time
+}
+
+func NewIdempotencyStore(retention time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{
+		retention: retention,
+		now:       time.Now,
+		records:   make(map[idempotencyScopedKey]*idempotencyRecord),
+	}
+}
+
+// Starts a call with the key, or returns the result of the completed call with the key to replay it
+func (s *IdempotencyStore) begin(accId string, key string, fingerprint string) (interface{}, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeExpiredLocked()
+	scopedKey := idempotencyScopedKey{accId, key}
+	record, ok := s.records[scopedKey]
+	if !ok {
+		s.records[scopedKey] = &idempotencyRecord{fingerprint: fingerprint}
+		return nil, false, nil
+	}
+	if record.fingerprint != fingerprint {
+		return nil, false, fmt.Errorf("%w: key %q was used for %s", ErrIdempotencyKeyReused, key, record.fingerprint)
+	}
+	if !record.done {
+		return nil, false, fmt.Errorf("%w: key %q", ErrIdempotentCallInProgress, key)
+	}
+	return copyIdempotentResult(record.result), true, nil
+}
+
+// Keeps the result of the successful call started with the key
+func (s *IdempotencyStore) complete(accId string, key string, result interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scopedKey := idempotencyScopedKey{accId, key}
+	record, ok := s.records[scopedKey]
+	if !ok {
+		return
+	}
+	record.done = true
+	record.result = copyIdempotentResult(result)
+	record.completedAt = s.now()
+	s.completed = append(s.completed, scopedKey)
+}
+
+// Forgets the failed call started with the key, so that it can be retried
+func (s *IdempotencyStore) abandon(accId string, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, idempotencyScopedKey{accId, key})
+}
+
+func (s *IdempotencyStore) purgeExpiredLocked() {
+	now := s.now()
+	purged := 0
+	for _, scopedKey := range s.completed {
+		if now.Before(s.records[scopedKey].completedAt.Add(s.retention)) {
+			break
+		}
+		delete(s.records, scopedKey)
+		purged++
+	}
+	s.completed = s.completed[purged:]
+}
+
+// Copies the result of a use case, so that neither the caller nor the store share the licenses and pools they hold
+func copyIdempotentResult(result interface{}) interface{} {
+	switch r := result.(type) {
+	case []*licensing.License:
+		licenses := make([]*licensing.License, len(r))
+		for i, lic := range r {
+			licenses[i] = lic.Clone()
+		}
+		return licenses
+	case *licensing.License:
+		return r.Clone()
+	case *licensing.CapacityPool:
+		return r.Clone()
+	default:
+		return result
+	}
+}
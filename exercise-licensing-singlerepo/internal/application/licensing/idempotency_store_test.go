@@ -0,0 +1,146 @@
+package licensing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+	"gotest.tools/v3/assert"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+
+	ctx := context.Background()
+	accId := "acc-1"
+	subId := "sub-1"
+	pkgId := "pkg:base-optimize-2022"
+	insId := "ins-101"
+
+	// returns a new service, its license repository and its idempotency store, on a clock the test moves
+	setUp := func() (LicensingService, licensing.LicenseRepository, *time.Time) {
+		var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+		var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+		now := time.Now()
+		store := NewIdempotencyStore(time.Hour)
+		store.now = func() time.Time { return now }
+		return NewLicensingService(&licRepo, &pkgRepo, WithIdempotencyStore(store)), licRepo, &now
+	}
+	countLicenses := func(t *testing.T, licRepo licensing.LicenseRepository, accId string) int {
+		licenses, err := licRepo.FindLicensesOfAccount(ctx, accId)
+		assert.NilError(t, err)
+		return len(licenses)
+	}
+	idsOf := func(licenses []*licensing.License) []string {
+		ids := make([]string, len(licenses))
+		for i, lic := range licenses {
+			ids[i] = lic.Id()
+		}
+		return ids
+	}
+
+	t.Run("replayed call returns the original result", func(t *testing.T) {
+		ls, licRepo, _ := setUp()
+		keyed := WithIdempotencyKey("billing-order-42")
+		issued, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 3, WithIssuanceTerms(licensing.AsTrial()), keyed)
+		assert.NilError(t, err)
+		replayed, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 3, WithIssuanceTerms(licensing.AsTrial()), keyed)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, idsOf(replayed), idsOf(issued))
+		assert.Equal(t, countLicenses(t, licRepo, accId), 3)
+
+		// without a key, every call issues licenses
+		_, err = ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 3, WithIssuanceTerms(licensing.AsTrial()))
+		assert.NilError(t, err)
+		assert.Equal(t, countLicenses(t, licRepo, accId), 6)
+	})
+
+	t.Run("key reused with different parameters is rejected", func(t *testing.T) {
+		ls, licRepo, _ := setUp()
+		keyed := WithIdempotencyKey("billing-order-42")
+		_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 3, keyed)
+		assert.NilError(t, err)
+
+		_, err = ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 4, keyed)
+		assert.Assert(t, errors.Is(err, ErrIdempotencyKeyReused), err)
+		_, err = ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 3, WithIssuanceTerms(licensing.AsTrial()), keyed)
+		assert.Assert(t, errors.Is(err, ErrIdempotencyKeyReused), err)
+		_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, "usr-alice", keyed)
+		assert.Assert(t, errors.Is(err, ErrIdempotencyKeyReused), err)
+		assert.Equal(t, countLicenses(t, licRepo, accId), 3)
+	})
+
+	t.Run("failed call is not kept", func(t *testing.T) {
+		ls, _, _ := setUp()
+		keyed := WithIdempotencyKey("assign-alice")
+		_, err := ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, "usr-alice", keyed)
+		assert.Error(t, err, "no more unassigned license for pkgId=pkg:base-optimize-2022")
+
+		_, err = ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 1)
+		assert.NilError(t, err)
+		lic, err := ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, "usr-alice", keyed)
+		assert.NilError(t, err)
+		assert.Equal(t, lic.AssignedToLicensee().LicenseeId(), licensing.NewInstanceUser(insId, "usr-alice").LicenseeId())
+	})
+
+	t.Run("keys are scoped to the account", func(t *testing.T) {
+		ls, licRepo, _ := setUp()
+		keyed := WithIdempotencyKey("billing-order-42")
+		_, err := ls.IssueLicenses(ctx, testLicenseAdmin, "acc-1", subId, pkgId, 2, keyed)
+		assert.NilError(t, err)
+		_, err = ls.IssueLicenses(ctx, testLicenseAdmin, "acc-2", subId, pkgId, 2, keyed)
+		assert.NilError(t, err)
+		assert.Equal(t, countLicenses(t, licRepo, "acc-1"), 2)
+		assert.Equal(t, countLicenses(t, licRepo, "acc-2"), 2)
+	})
+
+	t.Run("keys expire after the retention window", func(t *testing.T) {
+		ls, licRepo, now := setUp()
+		keyed := WithIdempotencyKey("billing-order-42")
+		_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 2, keyed)
+		assert.NilError(t, err)
+
+		*now = now.Add(59 * time.Minute)
+		_, err = ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 2, keyed)
+		assert.NilError(t, err)
+		assert.Equal(t, countLicenses(t, licRepo, accId), 2)
+
+		*now = now.Add(time.Minute)
+		_, err = ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 2, keyed)
+		assert.NilError(t, err)
+		assert.Equal(t, countLicenses(t, licRepo, accId), 4)
+	})
+
+	t.Run("replays are authorized", func(t *testing.T) {
+		ls, _, _ := setUp()
+		keyed := WithIdempotencyKey("billing-order-42")
+		_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 2, keyed)
+		assert.NilError(t, err)
+		_, err = ls.IssueLicenses(ctx, testCustomerAdmin(accId), accId, subId, pkgId, 2, keyed)
+		assert.Assert(t, errors.Is(err, ErrPermissionDenied), err)
+	})
+	t.Run("replay by a refreshed credential returns the original result", func(t *testing.T) {
+		ls, licRepo, _ := setUp()
+		keyed := WithIdempotencyKey("billing-order-42")
+		issued, err := ls.IssueLicenses(ctx, NewLicenseAdmin("billing-credential-1"), accId, subId, pkgId, 2, keyed)
+		assert.NilError(t, err)
+		replayed, err := ls.IssueLicenses(ctx, NewLicenseAdmin("billing-credential-2"), accId, subId, pkgId, 2, keyed)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, idsOf(replayed), idsOf(issued))
+		assert.Equal(t, countLicenses(t, licRepo, accId), 2)
+	})
+
+	t.Run("keys require an idempotency store", func(t *testing.T) {
+		var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+		var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+		ls := NewLicensingService(&licRepo, &pkgRepo)
+		_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 2, WithIdempotencyKey("billing-order-42"))
+		assert.Assert(t, errors.Is(err, ErrIdempotencyStoreMissing), err)
+		assert.Equal(t, countLicenses(t, licRepo, accId), 0)
+
+		_, err = ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 2)
+		assert.NilError(t, err)
+	})
+}
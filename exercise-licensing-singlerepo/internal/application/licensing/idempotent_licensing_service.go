@@ -0,0 +1,192 @@
+package licensing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// Decorator of the licensing service replaying the mutating use cases invoked with an idempotency key,
+// see WithIdempotencyKey. The key option is passed on to the use cases, which ignore it.
+//
+// It runs behind authorizingLicensingService, so that a replay is authorized like the call it replays.
+type idempotentLicensingService struct {
+	core  LicensingService
+	store *IdempotencyStore
+}
+
+func (is *idempotentLicensingService) IssueLicenses(ctx context.Context, p Principal, accId string, subId string, pkgId string, licenseCount int, opts ...MutationOption) ([]*licensing.License, error) {
+	params := fmt.Sprintf("subId=%s pkgId=%s licenseCount=%d %s", subId, pkgId, licenseCount, issuanceTerms(newMutationOptions(opts).issuanceOpts))
+	result, err := is.run("IssueLicenses", accId, params, opts, func() (interface{}, error) {
+		return is.core.IssueLicenses(ctx, p, accId, subId, pkgId, licenseCount, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]*licensing.License), nil
+}
+
+func (is *idempotentLicensingService) RenewLicenses(ctx context.Context, p Principal, accId string, subId string, renewedSubId string, opts ...MutationOption) ([]*licensing.License, error) {
+	params := fmt.Sprintf("subId=%s renewedSubId=%s %s", subId, renewedSubId, issuanceTerms(newMutationOptions(opts).issuanceOpts))
+	result, err := is.run("RenewLicenses", accId, params, opts, func() (interface{}, error) {
+		return is.core.RenewLicenses(ctx, p, accId, subId, renewedSubId, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]*licensing.License), nil
+}
+
+func (is *idempotentLicensingService) TrueDownLicenses(ctx context.Context, p Principal, accId string, subId string, pkgId string, licenseCount int, opts ...MutationOption) ([]*licensing.License, error) {
+	params := fmt.Sprintf("subId=%s pkgId=%s licenseCount=%d", subId, pkgId, licenseCount)
+	result, err := is.run("TrueDownLicenses", accId, params, opts, func() (interface{}, error) {
+		return is.core.TrueDownLicenses(ctx, p, accId, subId, pkgId, licenseCount, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]*licensing.License), nil
+}
+
+func (is *idempotentLicensingService) AssignSpecificLicense(ctx context.Context, p Principal, licId string, accId string, insId string, insUsrId string, opts ...MutationOption) (*licensing.License, error) {
+	params := fmt.Sprintf("licId=%s insId=%s insUsrId=%s", licId, insId, insUsrId)
+	result, err := is.run("AssignSpecificLicense", accId, params, opts, func() (interface{}, error) {
+		return is.core.AssignSpecificLicense(ctx, p, licId, accId, insId, insUsrId, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*licensing.License), nil
+}
+
+func (is *idempotentLicensingService) AssignAvailableLicenseOfPackage(ctx context.Context, p Principal, pkgId string, accId string, insId string, insUsrId string, opts ...MutationOption) (*licensing.License, error) {
+	params := fmt.Sprintf("pkgId=%s insId=%s insUsrId=%s %s", pkgId, insId, insUsrId, assignmentTerms(newMutationOptions(opts).strategy))
+	result, err := is.run("AssignAvailableLicenseOfPackage", accId, params, opts, func() (interface{}, error) {
+		return is.core.AssignAvailableLicenseOfPackage(ctx, p, pkgId, accId, insId, insUsrId, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*licensing.License), nil
+}
+
+func (is *idempotentLicensingService) AssignAvailableLicensesOfPackage(ctx context.Context, p Principal, pkgId string, accId string, insId string, insUsrIds []string, opts ...MutationOption) ([]*licensing.License, error) {
+	params := fmt.Sprintf("pkgId=%s insId=%s insUsrIds=%q %s", pkgId, insId, insUsrIds, assignmentTerms(newMutationOptions(opts).strategy))
+	result, err := is.run("AssignAvailableLicensesOfPackage", accId, params, opts, func() (interface{}, error) {
+		return is.core.AssignAvailableLicensesOfPackage(ctx, p, pkgId, accId, insId, insUsrIds, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]*licensing.License), nil
+}
+
+func (is *idempotentLicensingService) ReserveOfflineSeats(ctx context.Context, p Principal, accId string, insId string, pkgId string, seats int, opts ...MutationOption) ([]*licensing.License, error) {
+	params := fmt.Sprintf("insId=%s pkgId=%s seats=%d", insId, pkgId, seats)
+	result, err := is.run("ReserveOfflineSeats", accId, params, opts, func() (interface{}, error) {
+		return is.core.ReserveOfflineSeats(ctx, p, accId, insId, pkgId, seats, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]*licensing.License), nil
+}
+
+func (is *idempotentLicensingService) AllocatePooledCapacityToUser(ctx context.Context, p Principal, accId string, cpbId string, insId string, insUsrId string, amount int, opts ...MutationOption) (*licensing.CapacityPool, error) {
+	params := fmt.Sprintf("cpbId=%s insId=%s insUsrId=%s amount=%d", cpbId, insId, insUsrId, amount)
+	result, err := is.run("AllocatePooledCapacityToUser", accId, params, opts, func() (interface{}, error) {
+		return is.core.AllocatePooledCapacityToUser(ctx, p, accId, cpbId, insId, insUsrId, amount, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*licensing.CapacityPool), nil
+}
+
+func (is *idempotentLicensingService) AllocatePooledCapacityToInstance(ctx context.Context, p Principal, accId string, cpbId string, insId string, amount int, opts ...MutationOption) (*licensing.CapacityPool, error) {
+	params := fmt.Sprintf("cpbId=%s insId=%s amount=%d", cpbId, insId, amount)
+	result, err := is.run("AllocatePooledCapacityToInstance", accId, params, opts, func() (interface{}, error) {
+		return is.core.AllocatePooledCapacityToInstance(ctx, p, accId, cpbId, insId, amount, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*licensing.CapacityPool), nil
+}
+
+func (is *idempotentLicensingService) RecordCapacityUsage(ctx context.Context, p Principal, accId string, insId string, insUsrId string, cpbId string, amount int, opts ...MutationOption) (licensing.Entitlement, error) {
+	params := fmt.Sprintf("insId=%s insUsrId=%s cpbId=%s amount=%d", insId, insUsrId, cpbId, amount)
+	result, err := is.run("RecordCapacityUsage", accId, params, opts, func() (interface{}, error) {
+		return is.core.RecordCapacityUsage(ctx, p, accId, insId, insUsrId, cpbId, amount, opts...)
+	})
+	if err != nil {
+		return licensing.Entitlement{}, err
+	}
+	return result.(licensing.Entitlement), nil
+}
+
+// Read-only use cases need no key, and are passed through
+
+func (is *idempotentLicensingService) ListLicenses(ctx context.Context, p Principal, accId string, query licensing.LicenseQuery) (*licensing.LicensePage, error) {
+	return is.core.ListLicenses(ctx, p, accId, query)
+}
+
+func (is *idempotentLicensingService) CountTotalUnassignedLicensesOfPackage(ctx context.Context, p Principal, accId string, pkgId string) (int, error) {
+	return is.core.CountTotalUnassignedLicensesOfPackage(ctx, p, accId, pkgId)
+}
+
+func (is *idempotentLicensingService) GatherLicenseAssignmentSummary(ctx context.Context, p Principal, accId string, opts ...GatherLicenseAssignmentSummaryOption) (licensing.LicenseAssignmentSummary, error) {
+	return is.core.GatherLicenseAssignmentSummary(ctx, p, accId, opts...)
+}
+
+func (is *idempotentLicensingService) VerifyEntitlement(ctx context.Context, p Principal, accId string, insId string, insUsrId string, cpbId string, opts ...VerifyEntitlementOption) (licensing.Entitlement, error) {
+	return is.core.VerifyEntitlement(ctx, p, accId, insId, insUsrId, cpbId, opts...)
+}
+
+func (is *idempotentLicensingService) ListEntitlements(ctx context.Context, p Principal, accId string, insId string, insUsrId string) ([]licensing.Entitlement, error) {
+	return is.core.ListEntitlements(ctx, p, accId, insId, insUsrId)
+}
+
+// Runs the call, unless it is made with the key of a completed call, whose result is returned instead.
+// The principal is left out of the fingerprint, so that a retry with a refreshed credential replays the call.
+func (is *idempotentLicensingService) run(useCase string, accId string, params string, opts []MutationOption, call func() (interface{}, error)) (interface{}, error) {
+	key := newMutationOptions(opts).idempotencyKey
+	if key == "" {
+		return call()
+	}
+	if is.store == nil {
+		return nil, fmt.Errorf("%w: cannot run %s with key %q", ErrIdempotencyStoreMissing, useCase, key)
+	}
+	fingerprint := fmt.Sprintf("%s with %s", useCase, params)
+	result, replayed, err := is.store.begin(accId, key, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return result, nil
+	}
+	result, err = call()
+	if err != nil {
+		is.store.abandon(accId, key)
+		return nil, err
+	}
+	is.store.complete(accId, key, result)
+	return result, nil
+}
+
+// Describes the terms set by issuance options, which cannot be compared themselves, by issuing a probe license
+func issuanceTerms(opts []licensing.LicenseIssuanceOption) string {
+	probe := licensing.NewIssuedLicense("", "", &licensing.Package{}, opts...).State()
+	return fmt.Sprintf("trial=%t expiresAt=%s reason=%q",
+		probe.IsTrial, probe.ExpiresAt.UTC().Format(time.RFC3339Nano), probe.IssuanceDetail.IssuanceReason)
+}
+
+// Describes the seat allocation strategy option, which cannot be compared itself
+func assignmentTerms(strategy licensing.SeatAllocationStrategy) string {
+	if strategy == nil {
+		return "strategy=default"
+	}
+	return "strategy=" + strategy.Name()
+}
@@ -0,0 +1,66 @@
+package licensing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+	"gotest.tools/v3/assert"
+)
+
+func TestConcurrentAssignmentNeverDoubleAssigns(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := NewLicensingService(&licRepo, &pkgRepo)
+
+	accId := "acc-1"
+	pkgId := "pkg:base-optimize-2022"
+	insId := "ins-101"
+	seats := 5
+	users := 50
+
+	for round := 0; round < 20; round++ {
+		subId := fmt.Sprintf("sub-%d", round)
+		_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, seats)
+		assert.NilError(t, err)
+
+		var wg sync.WaitGroup
+		assigned := make(chan *licensing.License, users)
+		for i := 0; i < users; i++ {
+			wg.Add(1)
+			go func(insUsrId string) {
+				defer wg.Done()
+				lic, err := ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, insUsrId)
+				if err == nil {
+					assigned <- lic
+				}
+			}(fmt.Sprintf("usr-%d-%d", round, i))
+		}
+		wg.Wait()
+		close(assigned)
+
+		assigneeByLicId := make(map[string]string)
+		for lic := range assigned {
+			_, dup := assigneeByLicId[lic.Id()]
+			assert.Check(t, !dup, "license id=%s assigned twice", lic.Id())
+			assigneeByLicId[lic.Id()] = lic.AssignedToLicensee().LicenseeId()
+		}
+		assert.Equal(t, len(assigneeByLicId), seats)
+
+		// the stored assignment is the one reported to the winning caller
+		for licId, assigneeId := range assigneeByLicId {
+			lic, err := licRepo.GetLicenseById(ctx, licId)
+			assert.NilError(t, err)
+			assert.Equal(t, lic.AssignedToLicensee().LicenseeId(), assigneeId)
+			assert.Equal(t, lic.Version(), int64(2))
+		}
+		count, err := ls.CountTotalUnassignedLicensesOfPackage(ctx, testCustomerAdmin(accId), accId, pkgId)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 0)
+	}
+}
@@ -0,0 +1,223 @@
+package licensing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+	"gotest.tools/v3/assert"
+)
+
+var errStorageUnavailable = errors.New("storage unavailable")
+
+// In-memory license repository whose units of work fail to create licenses after the given number of creates
+type failingCreateLicenseRepo struct {
+	*storage.LicenseRepoInMem
+	createsBeforeFailure int
+}
+
+func (r *failingCreateLicenseRepo) RunAtomically(ctx context.Context, work func(ctx context.Context, licRepo licensing.LicenseRepository) error) error {
+	return r.LicenseRepoInMem.RunAtomically(ctx, func(ctx context.Context, licRepo licensing.LicenseRepository) error {
+		return work(ctx, &failingCreates{LicenseRepository: licRepo, remaining: r.createsBeforeFailure})
+	})
+}
+
+type failingCreates struct {
+	licensing.LicenseRepository
+	remaining int
+}
+
+func (r *failingCreates) CreateLicense(ctx context.Context, lic *licensing.License) error {
+	if r.remaining == 0 {
+		return errStorageUnavailable
+	}
+	r.remaining--
+	return r.LicenseRepository.CreateLicense(ctx, lic)
+}
+
+// Records the published license events
+type licenseEventRecorder struct {
+	events []licensing.LicenseEvent
+}
+
+func (r *licenseEventRecorder) HandleLicenseEvent(evt licensing.LicenseEvent) {
+	r.events = append(r.events, evt)
+}
+
+func (r *licenseEventRecorder) countByType() map[string]int {
+	results := make(map[string]int)
+	for _, evt := range r.events {
+		results[evt.Type.String()]++
+	}
+	return results
+}
+
+func TestMultiLicenseUseCasesAreAtomic(t *testing.T) {
+
+	ctx := context.Background()
+	accId := "acc-1"
+	subId := "sub-1"
+	pkgId := "pkg:base-optimize-2022"
+	insId := "ins-101"
+
+	setUp := func(createsBeforeFailure int) (LicensingService, licensing.LicenseRepository, *licenseEventRecorder) {
+		var licRepo licensing.LicenseRepository = &failingCreateLicenseRepo{storage.NewLicenseRepoInMem(), createsBeforeFailure}
+		var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+		recorder := &licenseEventRecorder{}
+		return NewLicensingService(&licRepo, &pkgRepo, WithLicenseEventHandler(recorder)), licRepo, recorder
+	}
+
+	t.Run("failing issuance leaves no license behind", func(t *testing.T) {
+		ls, licRepo, recorder := setUp(36)
+		_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 100)
+		assert.Assert(t, errors.Is(err, errStorageUnavailable), err)
+		assert.Error(t, err, "issuing license 37 of 100: storage unavailable")
+
+		licenses, err := licRepo.FindLicensesOfAccount(ctx, accId)
+		assert.NilError(t, err)
+		assert.Equal(t, len(licenses), 0)
+		assert.Equal(t, len(recorder.events), 0)
+	})
+
+	t.Run("failing renewal leaves the renewed licenses active", func(t *testing.T) {
+		ls, licRepo, recorder := setUp(2)
+		_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 2)
+		assert.NilError(t, err)
+		_, err = ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 1)
+		assert.NilError(t, err)
+		_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, "usr-alice")
+		assert.NilError(t, err)
+		recorder.events = nil
+
+		// the renewal of the third license fails
+		_, err = ls.RenewLicenses(ctx, testLicenseAdmin, accId, subId, "sub-2")
+		assert.Assert(t, errors.Is(err, errStorageUnavailable), err)
+		licenses, err := licRepo.FindLicensesOfAccount(ctx, accId)
+		assert.NilError(t, err)
+		assert.Equal(t, len(licenses), 3)
+		for _, lic := range licenses {
+			assert.Check(t, lic.IsActive())
+			assert.Equal(t, lic.GoverningSubscriptionId(), subId)
+		}
+		assert.Equal(t, len(recorder.events), 0)
+	})
+
+	t.Run("failing bulk assignment assigns no user", func(t *testing.T) {
+		ls, licRepo, recorder := setUp(2)
+		_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 2)
+		assert.NilError(t, err)
+		recorder.events = nil
+
+		_, err = ls.AssignAvailableLicensesOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId,
+			[]string{"usr-alice", "usr-bob", "usr-charles"})
+		assert.Error(t, err, "no more unassigned license for pkgId=pkg:base-optimize-2022")
+		count, err := licRepo.CountTotalUnassignedLicensesOfPackage(ctx, accId, pkgId)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 2)
+		assert.Equal(t, len(recorder.events), 0)
+	})
+}
+
+func TestRenewLicenses(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	recorder := &licenseEventRecorder{}
+	ls := NewLicensingService(&licRepo, &pkgRepo, WithLicenseEventHandler(recorder))
+
+	accId := "acc-1"
+	pkgId := "pkg:base-optimize-2022"
+	insId := "ins-101"
+
+	issued, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-1", pkgId, 2)
+	assert.NilError(t, err)
+	_, err = ls.AssignSpecificLicense(ctx, testCustomerAdmin(accId), issued[0].Id(), accId, insId, "usr-alice")
+	assert.NilError(t, err)
+	recorder.events = nil
+
+	t.Run("licenses are replaced and keep their assignment", func(t *testing.T) {
+		renewed, err := ls.RenewLicenses(ctx, testLicenseAdmin, accId, "sub-1", "sub-2", WithIssuanceTerms(licensing.AsTrial()))
+		assert.NilError(t, err)
+		assert.Equal(t, len(renewed), 2)
+		for _, lic := range renewed {
+			assert.Equal(t, lic.GoverningSubscriptionId(), "sub-2")
+			assert.Equal(t, lic.IsTrial(), true)
+		}
+
+		alice := licensing.NewInstanceUser(insId, "usr-alice")
+		assigned, err := licRepo.FindLicensesByAssignedLicenseeId(ctx, alice.LicenseeId())
+		assert.NilError(t, err)
+		assert.Equal(t, len(assigned), 1)
+		assert.Equal(t, assigned[0].GoverningSubscriptionId(), "sub-2")
+		for _, lic := range issued {
+			stored, err := licRepo.GetLicenseById(ctx, lic.Id())
+			assert.NilError(t, err)
+			assert.Equal(t, stored.IsActive(), false)
+		}
+		assert.DeepEqual(t, recorder.countByType(), map[string]int{"LICENSE_RENEWED": 2, "LICENSE_ISSUED": 2, "LICENSE_ASSIGNED": 1})
+	})
+
+	t.Run("renewing again finds nothing to renew", func(t *testing.T) {
+		_, err := ls.RenewLicenses(ctx, testLicenseAdmin, accId, "sub-1", "sub-2")
+		assert.Error(t, err, "no active license found for subId=sub-1")
+	})
+}
+
+func TestTrueDownLicenses(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := NewLicensingService(&licRepo, &pkgRepo)
+
+	accId := "acc-1"
+	subId := "sub-1"
+	pkgId := "pkg:base-optimize-2022"
+	insId := "ins-101"
+
+	_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 5)
+	assert.NilError(t, err)
+	_, err = ls.AssignAvailableLicensesOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, []string{"usr-alice", "usr-bob"})
+	assert.NilError(t, err)
+
+	t.Run("truing down below the assigned licenses expires none", func(t *testing.T) {
+		_, err := ls.TrueDownLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 1)
+		assert.Error(t, err, "cannot true down to 1 licenses of pkgId=pkg:base-optimize-2022 under subId=sub-1, 2 are assigned")
+		count, err := ls.CountTotalUnassignedLicensesOfPackage(ctx, testCustomerAdmin(accId), accId, pkgId)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 3)
+	})
+
+	t.Run("surplus unassigned licenses are expired", func(t *testing.T) {
+		expired, err := ls.TrueDownLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 3)
+		assert.NilError(t, err)
+		assert.Equal(t, len(expired), 2)
+		for _, lic := range expired {
+			assert.Equal(t, lic.IsActive(), false)
+			assert.Equal(t, lic.IsAssigned(), false)
+		}
+		_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, "usr-charles")
+		assert.NilError(t, err)
+		_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, "usr-daniel")
+		assert.Error(t, err, "no more unassigned license for pkgId=pkg:base-optimize-2022")
+	})
+
+	t.Run("licenses past their term are not counted", func(t *testing.T) {
+		_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-2", pkgId, 2, WithIssuanceTerms(licensing.ExpiringAt(time.Now().Add(-time.Hour))))
+		assert.NilError(t, err)
+		_, err = ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-2", pkgId, 2)
+		assert.NilError(t, err)
+
+		expired, err := ls.TrueDownLicenses(ctx, testLicenseAdmin, accId, "sub-2", pkgId, 2)
+		assert.NilError(t, err)
+		assert.Equal(t, len(expired), 0)
+		expired, err = ls.TrueDownLicenses(ctx, testLicenseAdmin, accId, "sub-2", pkgId, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, len(expired), 1)
+		assert.Equal(t, expired[0].IsPastTermAt(time.Now()), false)
+	})
+}
@@ -1,6 +1,12 @@
 package licensing
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
 	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
 )
 
@@ -9,36 +15,86 @@ import (
 // This Facade can be invoked by Driving Adapter in any form using any technology framework
 // (e.g., CLI, RPC Service Activity, Queue Consumer Handler, Temporal Activity).
 //
+// Every use case is constrained to the customer account (accId) it is invoked for: licenses and capacity pools
+// of other accounts are neither read nor written, and licenses of other accounts are reported as not found.
+//
+// Every use case is invoked by a principal, and only permitted to the role of its caller group below,
+// see authorizingLicensingService.
+//
+// Use cases changing several licenses change all of them or none, see licensing.LicenseUnitOfWork. Events are
+// published once the changes are committed.
+//
+// Mutating use cases take MutationOption options; those invoked with an idempotency key are only run once per key,
+// see WithIdempotencyKey.
+//
 // DDD classification: Application Service
 type LicensingService interface {
 
 	// ------------------------------------------------------------------------------------------
 	// Below are use cases for Outreach License Adminstration managing license lifecyles
 	// ------------------------------------------------------------------------------------------
-	// Issue X new licenses of the given package, to the given customer account, under the given subscription
-	IssueLicenses(accId string, subId string, pkgId string, licenseCount int) ([]*licensing.License, error)
+	// Issue X new licenses of the given package, to the given customer account, under the given subscription;
+	// options set the terms of the licenses, see WithIssuanceTerms
+	IssueLicenses(ctx context.Context, p Principal, accId string, subId string, pkgId string, licenseCount int, opts ...MutationOption) ([]*licensing.License, error)
+
+	// Renew the active licenses of the given subscription under the renewing subscription: each is replaced by a new
+	// license of the same package, assigned to the same licensee; options set the terms of the new licenses
+	RenewLicenses(ctx context.Context, p Principal, accId string, subId string, renewedSubId string, opts ...MutationOption) ([]*licensing.License, error)
+
+	// Reduce the licenses in force of the given package under the given subscription to licenseCount, by expiring
+	// unassigned licenses, most recently issued first; returns the expired licenses
+	TrueDownLicenses(ctx context.Context, p Principal, accId string, subId string, pkgId string, licenseCount int, opts ...MutationOption) ([]*licensing.License, error)
 
 	// TODO: ExpireLicenses(accId string, subId string)
-	// TODO: RenewLicenses(accId string, subId string)
 
 	// ------------------------------------------------------------------------------------------
 	// Below are use cases for Customer Admin managing user assignment
 	// ------------------------------------------------------------------------------------------
 	// Assign specific license id to user
-	AssignSpecificLicense(licId string, accId string, insId string, insUsrId string) (*licensing.License, error)
+	AssignSpecificLicense(ctx context.Context, p Principal, licId string, accId string, insId string, insUsrId string, opts ...MutationOption) (*licensing.License, error)
 
-	// Assign any available license of a given package to a given user
-	AssignAvailableLicenseOfPackage(pkgId string, accId string, insId string, insUsrId string) (*licensing.License, error)
+	// Assign an available license of a given package to a given user, chosen by the seat allocation strategy
+	AssignAvailableLicenseOfPackage(ctx context.Context, p Principal, pkgId string, accId string, insId string, insUsrId string, opts ...MutationOption) (*licensing.License, error)
 
-	// Count the total unassigned licenses, possessed by the given customer account
-	// FIXME: replace with a more generalize method like GatherLicenseAssignmentSummary returning total assigneds and unassigneds across all packages
-	CountTotalUnassignedLicensesOfPackage(accId string, pkgId string) (int, error)
+	// Assign an available license of a given package to each of the given users of an instance
+	AssignAvailableLicensesOfPackage(ctx context.Context, p Principal, pkgId string, accId string, insId string, insUsrIds []string, opts ...MutationOption) ([]*licensing.License, error)
+
+	// Reserve seats of a given package for an instance deployed without connectivity, returning the licenses backing
+	// them: the licenses of the package already assigned to users of the instance first, then available licenses
+	// chosen by the seat allocation strategy and assigned to the offline seats user of the instance
+	ReserveOfflineSeats(ctx context.Context, p Principal, accId string, insId string, pkgId string, seats int, opts ...MutationOption) ([]*licensing.License, error)
+
+	// Set aside a slice of an account-level capacity pool for a user; 0 removes the slice
+	AllocatePooledCapacityToUser(ctx context.Context, p Principal, accId string, cpbId string, insId string, insUsrId string, amount int, opts ...MutationOption) (*licensing.CapacityPool, error)
+
+	// Set aside a slice of an account-level capacity pool for all users of an instance; 0 removes the slice
+	AllocatePooledCapacityToInstance(ctx context.Context, p Principal, accId string, cpbId string, insId string, amount int, opts ...MutationOption) (*licensing.CapacityPool, error)
+
+	// List the licenses possessed by the given customer account matching the query, a page at a time;
+	// the account of the query is set to the given customer account, and the time of its statuses to now unless set
+	ListLicenses(ctx context.Context, p Principal, accId string, query licensing.LicenseQuery) (*licensing.LicensePage, error)
+
+	// Count the total unassigned licenses, possessed by the given customer account;
+	// see GatherLicenseAssignmentSummary for the totals of every package
+	CountTotalUnassignedLicensesOfPackage(ctx context.Context, p Principal, accId string, pkgId string) (int, error)
+
+	// Gather the totals of the licenses possessed by the given customer account: issued, assigned, unassigned, trial,
+	// expiring soon and in overage, across all packages, per package and per subscription of each package
+	GatherLicenseAssignmentSummary(ctx context.Context, p Principal, accId string, opts ...GatherLicenseAssignmentSummaryOption) (licensing.LicenseAssignmentSummary, error)
 
 	// ------------------------------------------------------------------------------------------
 	// Below are use cases for Outreach Application
 	// ------------------------------------------------------------------------------------------
 	// Verify an instance user has entitlement to the given capability
-	VerifyEntitlement(accId string, insId string, insUsrId string, cpbId string) (licensing.Entitlement, error)
+	VerifyEntitlement(ctx context.Context, p Principal, accId string, insId string, insUsrId string, cpbId string, opts ...VerifyEntitlementOption) (licensing.Entitlement, error)
+
+	// List the entitlements of an instance user to every capability its licenses grant, sorted by capability id.
+	// Capabilities missing from the list are not entitled.
+	ListEntitlements(ctx context.Context, p Principal, accId string, insId string, insUsrId string) ([]licensing.Entitlement, error)
+
+	// Record capacity consumed by an instance user against an account-level capacity pool,
+	// returning the entitlement after the usage
+	RecordCapacityUsage(ctx context.Context, p Principal, accId string, insId string, insUsrId string, cpbId string, amount int, opts ...MutationOption) (licensing.Entitlement, error)
 }
 
 type licensingService struct {
@@ -48,84 +104,768 @@ type licensingService struct {
 
 	// underlying package repository interface to access packages
 	pkgRepo *licensing.PackageRepository
+
+	// optional capacity pool repository interface to access account-level capacity pools
+	poolRepo *licensing.CapacityPoolRepository
+
+	// optional cache of entitlement evaluation results; nil if caching is disabled
+	entCache *EntitlementCache
+
+	// handlers notified of license events after changes are persisted
+	eventHandlers []licensing.LicenseEventHandler
+
+	// strategy choosing the license to assign among the available ones, unless overridden per call
+	seatAllocationStrategy licensing.SeatAllocationStrategy
+
+	// optional results of the calls made with an idempotency key; nil if keys are not supported
+	idemStore *IdempotencyStore
+}
+
+// Optional configuration of the licensing service
+type LicensingServiceOption func(ls *licensingService)
+
+// Caches entitlement evaluation results, invalidated by the license events this service publishes
+func WithEntitlementCache(cache *EntitlementCache) LicensingServiceOption {
+	return func(ls *licensingService) {
+		ls.entCache = cache
+		ls.eventHandlers = append(ls.eventHandlers, cache)
+	}
+}
+
+// Enables account-level pooled capacities, stored in the given repository
+func WithCapacityPoolRepository(poolRepo *licensing.CapacityPoolRepository) LicensingServiceOption {
+	return func(ls *licensingService) {
+		ls.poolRepo = poolRepo
+	}
+}
+
+// Notifies the given handler of every license event this service publishes
+func WithLicenseEventHandler(handler licensing.LicenseEventHandler) LicensingServiceOption {
+	return func(ls *licensingService) {
+		ls.eventHandlers = append(ls.eventHandlers, handler)
+	}
+}
+
+// Chooses the license to assign among the available ones by the given strategy; defaults to soonest expiring first
+func WithDefaultSeatAllocationStrategy(strategy licensing.SeatAllocationStrategy) LicensingServiceOption {
+	return func(ls *licensingService) {
+		ls.seatAllocationStrategy = strategy
+	}
+}
+
+// Keeps the results of calls made with an idempotency key in the given store, see WithIdempotencyKey. The store is
+// kept in memory, so that this option is for deployments where a single process serves the licensing service;
+// without it, calls made with an idempotency key fail with ErrIdempotencyStoreMissing.
+func WithIdempotencyStore(store *IdempotencyStore) LicensingServiceOption {
+	return func(ls *licensingService) {
+		ls.idemStore = store
+	}
+}
+
+// Optional behavior of a single call of a mutating use case. Options not applying to the use case are ignored.
+type MutationOption func(opts *mutationOptions)
+
+type mutationOptions struct {
+	idempotencyKey string
+	issuanceOpts   []licensing.LicenseIssuanceOption
+	strategy       licensing.SeatAllocationStrategy
+	licenseVersion int64
+}
+
+func newMutationOptions(opts []MutationOption) mutationOptions {
+	mutOpts := mutationOptions{}
+	for _, opt := range opts {
+		opt(&mutOpts)
+	}
+	return mutOpts
+}
+
+// Runs the call at most once per key within the retention window of the service's idempotency store,
+// see WithIdempotencyStore. A call replayed with the same key, e.g. retried by a client on timeout, returns the
+// result of the first successful call instead of changing licenses again. Keys are scoped to the customer account
+// of the call, and a key is only replayed by the same use case invoked with the same parameters.
+func WithIdempotencyKey(key string) MutationOption {
+	return func(opts *mutationOptions) {
+		opts.idempotencyKey = key
+	}
+}
+
+// Sets the terms of the licenses issued by IssueLicenses or RenewLicenses, e.g. their end of term
+// or issuing them as trial licenses
+func WithIssuanceTerms(issuanceOpts ...licensing.LicenseIssuanceOption) MutationOption {
+	return func(opts *mutationOptions) {
+		opts.issuanceOpts = append(opts.issuanceOpts, issuanceOpts...)
+	}
+}
+
+// Chooses the license to assign by the given strategy instead of the service's default,
+// e.g. to take the license from a preferred subscription
+func UsingSeatAllocationStrategy(strategy licensing.SeatAllocationStrategy) MutationOption {
+	return func(opts *mutationOptions) {
+		opts.strategy = strategy
+	}
+}
+
+// Assigns the specific license only if still at the given version, as authorized by authorizingLicensingService;
+// fails with licensing.ErrLicenseVersionConflict otherwise, leaving the retry to the caller
+func atLicenseVersion(version int64) MutationOption {
+	return func(opts *mutationOptions) {
+		opts.licenseVersion = version
+	}
 }
 
+// Optional behavior of a single VerifyEntitlement call
+type VerifyEntitlementOption func(opts *verifyEntitlementOptions)
+
+type verifyEntitlementOptions struct {
+	bypassCache bool
+}
+
+// Evaluates the entitlement against the repositories, ignoring any cached result.
+// For consistency-critical callers, e.g. right after changing an assignment.
+func BypassEntitlementCache() VerifyEntitlementOption {
+	return func(opts *verifyEntitlementOptions) {
+		opts.bypassCache = true
+	}
+}
+
+// Optional behavior of a single GatherLicenseAssignmentSummary call
+type GatherLicenseAssignmentSummaryOption func(opts *gatherLicenseAssignmentSummaryOptions)
+
+type gatherLicenseAssignmentSummaryOptions struct {
+	at                 time.Time
+	expiringSoonWindow time.Duration
+}
+
+// Summarizes the licenses as of the given time instead of now, e.g. to foresee the licenses in overage at renewal
+func SummarizedAt(at time.Time) GatherLicenseAssignmentSummaryOption {
+	return func(opts *gatherLicenseAssignmentSummaryOptions) {
+		opts.at = at
+	}
+}
+
+// Counts licenses whose term ends within the given window as expiring soon; defaults to
+// licensing.DefaultExpiringSoonWindow
+func ExpiringSoonWithin(window time.Duration) GatherLicenseAssignmentSummaryOption {
+	return func(opts *gatherLicenseAssignmentSummaryOptions) {
+		opts.expiringSoonWindow = window
+	}
+}
+
+// Creates the licensing service on the given repositories. Use cases changing several licenses are atomic if the
+// license repository implements licensing.LicenseUnitOfWork; on other repositories, a failing use case may leave
+// some of its changes behind.
 func NewLicensingService(
 	licRepo *licensing.LicenseRepository,
-	pkgRepo *licensing.PackageRepository) *licensingService {
-	return &licensingService{licRepo, pkgRepo}
+	pkgRepo *licensing.PackageRepository,
+	opts ...LicensingServiceOption) LicensingService {
+	ls := &licensingService{
+		licRepo:                licRepo,
+		pkgRepo:                pkgRepo,
+		seatAllocationStrategy: licensing.SoonestExpiringFirst(),
+	}
+	for _, opt := range opts {
+		opt(ls)
+	}
+	return &authorizingLicensingService{core: &idempotentLicensingService{core: ls, store: ls.idemStore}, licRepo: licRepo}
 }
 
-func (ls *licensingService) IssueLicenses(accId string, subId string, pkgId string, licenseCount int) ([]*licensing.License, error) {
-	pkg, err := (*ls.pkgRepo).GetPackageById(pkgId)
+func (ls *licensingService) IssueLicenses(ctx context.Context, _ Principal, accId string, subId string, pkgId string, licenseCount int, opts ...MutationOption) ([]*licensing.License, error) {
+	pkg, err := (*ls.pkgRepo).GetPackageById(ctx, pkgId)
 	if err != nil {
 		return nil, err
 	}
-	return ls.IssueLicensesOfPackage(accId, subId, pkg, licenseCount)
+	if pkg.IsArchived {
+		return nil, fmt.Errorf("package pkgId=%s is archived", pkgId)
+	}
+	return ls.IssueLicensesOfPackage(ctx, accId, subId, pkg, licenseCount, newMutationOptions(opts).issuanceOpts...)
 }
 
-func (ls *licensingService) IssueLicensesOfPackage(accId string, subId string, pkg *licensing.Package, licenseCount int) ([]*licensing.License, error) {
+func (ls *licensingService) IssueLicensesOfPackage(ctx context.Context, accId string, subId string, pkg *licensing.Package, licenseCount int, opts ...licensing.LicenseIssuanceOption) ([]*licensing.License, error) {
 	results := make([]*licensing.License, licenseCount)
-	for i := 0; i < licenseCount; i++ {
-		lic := licensing.NewIssuedLicense(accId, subId, pkg)
-		(*ls.licRepo).CreateLicense(lic)
-		results[i] = lic
+	err := ls.atomically(ctx, accId, func(ctx context.Context, licRepo licensing.LicenseRepository) error {
+		for i := 0; i < licenseCount; i++ {
+			lic := licensing.NewIssuedLicense(accId, subId, pkg, opts...)
+			if err := licRepo.CreateLicense(ctx, lic); err != nil {
+				return fmt.Errorf("issuing license %d of %d: %w", i+1, licenseCount, err)
+			}
+			results[i] = lic
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	// This is where we trigger Application Events
+	for _, lic := range results {
+		ls.publish(licensing.LicenseEvent{
+			Type:       licensing.LICENSE_ISSUED,
+			LicenseId:  lic.Id(),
+			AccountId:  lic.PossessingCustomerAccountId(),
+			OccurredAt: time.Now()})
+	}
+	return results, nil
+}
+
+func (ls *licensingService) RenewLicenses(ctx context.Context, _ Principal, accId string, subId string, renewedSubId string, opts ...MutationOption) ([]*licensing.License, error) {
+	issuanceOpts := newMutationOptions(opts).issuanceOpts
+	var results []*licensing.License
+	var events []licensing.LicenseEvent
+	err := retryOnLicenseConflict(func() error {
+		results, events = nil, nil
+		return ls.atomically(ctx, accId, func(ctx context.Context, licRepo licensing.LicenseRepository) error {
+			accountLicenses, err := licRepo.FindLicensesOfAccount(ctx, accId)
+			if err != nil {
+				return err
+			}
+			for _, lic := range accountLicenses {
+				if lic.GoverningSubscriptionId() != subId || !lic.IsActive() {
+					continue
+				}
+				reason := fmt.Sprintf("Renewal of license id=%s", lic.Id())
+				renewedOpts := append([]licensing.LicenseIssuanceOption{licensing.WithIssuanceReason(reason)}, issuanceOpts...)
+				renewed := licensing.NewIssuedLicense(accId, renewedSubId, lic.LicensedPackage(), renewedOpts...)
+				var affectedLicenseeIds []string
+				if lic.IsAssigned() {
+					licensee := lic.AssignedToLicensee()
+					affectedLicenseeIds = []string{licensee.LicenseeId()}
+					lic.Unassign()
+					renewed.Assign(licensee)
+				}
+				lic.RenewTo(renewed.Id(), fmt.Sprintf("Renewed under subId=%s", renewedSubId))
+				if err := licRepo.CreateLicense(ctx, renewed); err != nil {
+					return err
+				}
+				if err := licRepo.UpdateLicense(ctx, lic.Id(), lic); err != nil {
+					return err
+				}
+				results = append(results, renewed)
+				now := time.Now()
+				events = append(events,
+					licensing.LicenseEvent{Type: licensing.LICENSE_RENEWED, LicenseId: lic.Id(), AccountId: accId,
+						AffectedLicenseeIds: affectedLicenseeIds, OccurredAt: now},
+					licensing.LicenseEvent{Type: licensing.LICENSE_ISSUED, LicenseId: renewed.Id(), AccountId: accId, OccurredAt: now})
+				if renewed.IsAssigned() {
+					events = append(events, licensing.LicenseEvent{Type: licensing.LICENSE_ASSIGNED, LicenseId: renewed.Id(),
+						AccountId: accId, AffectedLicenseeIds: affectedLicenseeIds, OccurredAt: now})
+				}
+			}
+			if len(results) == 0 {
+				return fmt.Errorf("no active license found for subId=%s", subId)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, evt := range events {
+		ls.publish(evt)
+	}
 	return results, nil
 }
 
-func (ls *licensingService) AssignAvailableLicenseOfPackage(pkgId string, accId string, insId string, insUsrId string) (*licensing.License, error) {
-	availableLic, err := (*ls.licRepo).FindNextUnassignedLicenseOfPackage(accId, pkgId)
+func (ls *licensingService) TrueDownLicenses(ctx context.Context, _ Principal, accId string, subId string, pkgId string, licenseCount int, _ ...MutationOption) ([]*licensing.License, error) {
+	var results []*licensing.License
+	err := retryOnLicenseConflict(func() error {
+		results = nil
+		return ls.atomically(ctx, accId, func(ctx context.Context, licRepo licensing.LicenseRepository) error {
+			accountLicenses, err := licRepo.FindLicensesOfAccount(ctx, accId)
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			inForce, unassigned := 0, make([]*licensing.License, 0)
+			for _, lic := range accountLicenses {
+				if lic.GoverningSubscriptionId() != subId || lic.LicensedPackage().Id != pkgId || !lic.IsInForceAt(now) {
+					continue
+				}
+				inForce++
+				if !lic.IsAssigned() {
+					unassigned = append(unassigned, lic)
+				}
+			}
+			surplus := inForce - licenseCount
+			if surplus <= 0 {
+				return nil
+			}
+			if surplus > len(unassigned) {
+				return fmt.Errorf("cannot true down to %d licenses of pkgId=%s under subId=%s, %d are assigned",
+					licenseCount, pkgId, subId, inForce-len(unassigned))
+			}
+			sort.SliceStable(unassigned, func(i, j int) bool { return unassigned[i].IssuedAt().After(unassigned[j].IssuedAt()) })
+			for _, lic := range unassigned[:surplus] {
+				lic.Expire()
+				if err := licRepo.UpdateLicense(ctx, lic.Id(), lic); err != nil {
+					return err
+				}
+				results = append(results, lic)
+			}
+			return nil
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
-	return ls.assignSpecificLicenseHelper(availableLic, accId, insId, insUsrId)
+	for _, lic := range results {
+		ls.publish(licensing.LicenseEvent{
+			Type:       licensing.LICENSE_EXPIRED,
+			LicenseId:  lic.Id(),
+			AccountId:  accId,
+			OccurredAt: time.Now()})
+	}
+	return results, nil
+}
+
+// Strategy set by the options of the call, or the service's default
+func (ls *licensingService) seatAllocationStrategyOf(opts []MutationOption) licensing.SeatAllocationStrategy {
+	if strategy := newMutationOptions(opts).strategy; strategy != nil {
+		return strategy
+	}
+	return ls.seatAllocationStrategy
 }
 
-func (ls *licensingService) AssignSpecificLicense(licId string, accId string, insId string, insUsrId string) (*licensing.License, error) {
-	specificLic, err := (*ls.licRepo).GetLicenseById(licId)
+func (ls *licensingService) AssignAvailableLicenseOfPackage(ctx context.Context, _ Principal, pkgId string, accId string, insId string, insUsrId string, opts ...MutationOption) (*licensing.License, error) {
+	strategy := ls.seatAllocationStrategyOf(opts)
+
+	// a concurrent assignment may take the same licenses; the next attempt finds the ones left
+	var availableLic *licensing.License
+	var evt licensing.LicenseEvent
+	err := retryOnLicenseConflict(func() error {
+		var err error
+		availableLic, evt, err = ls.assignAvailableLicenseHelper(ctx, ls.licensesOf(accId), pkgId, accId, insId, insUsrId, strategy)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	return ls.assignSpecificLicenseHelper(specificLic, accId, insId, insUsrId)
+	ls.publish(evt)
+	return availableLic, nil
 }
 
-func (ls *licensingService) assignSpecificLicenseHelper(specificLic *licensing.License, accId string, insId string, insUsrId string) (*licensing.License, error) {
-	specificLic.Assign(licensing.NewInstanceUser(insId, insUsrId))
-	err := (*ls.licRepo).UpdateLicense(specificLic.Id(), specificLic)
+func (ls *licensingService) AssignAvailableLicensesOfPackage(ctx context.Context, _ Principal, pkgId string, accId string, insId string, insUsrIds []string, opts ...MutationOption) ([]*licensing.License, error) {
+	strategy := ls.seatAllocationStrategyOf(opts)
+
+	var results []*licensing.License
+	var events []licensing.LicenseEvent
+	err := retryOnLicenseConflict(func() error {
+		results, events = nil, nil
+		return ls.atomically(ctx, accId, func(ctx context.Context, licRepo licensing.LicenseRepository) error {
+			for _, insUsrId := range insUsrIds {
+				// the repository reads back the assignments made so far, so that every user gets another license
+				availableLic, evt, err := ls.assignAvailableLicenseHelper(ctx, licRepo, pkgId, accId, insId, insUsrId, strategy)
+				if err != nil {
+					return err
+				}
+				results = append(results, availableLic)
+				events = append(events, evt)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, evt := range events {
+		ls.publish(evt)
+	}
+	return results, nil
+}
+
+// Instance user holding the licenses reserved for the offline seats of an instance, see ReserveOfflineSeats
+const OfflineSeatsUserId = "offline-seats"
+
+func (ls *licensingService) ReserveOfflineSeats(ctx context.Context, _ Principal, accId string, insId string, pkgId string, seats int, _ ...MutationOption) ([]*licensing.License, error) {
+	var reserved []*licensing.License
+	var events []licensing.LicenseEvent
+	err := retryOnLicenseConflict(func() error {
+		reserved, events = nil, nil
+		return ls.atomically(ctx, accId, func(ctx context.Context, licRepo licensing.LicenseRepository) error {
+			now := time.Now()
+			licenses, err := licRepo.FindLicensesOfAccount(ctx, accId)
+			if err != nil {
+				return err
+			}
+			for _, lic := range licenses {
+				if len(reserved) < seats && lic.LicensedPackage().Id == pkgId && lic.IsInForceAt(now) && isHeldByInstance(lic, insId) {
+					reserved = append(reserved, lic)
+				}
+			}
+			if len(reserved) == seats {
+				return nil
+			}
+			available, err := licRepo.FindLicensesToAssign(ctx, accId, pkgId, ls.seatAllocationStrategy, now, seats-len(reserved))
+			if err != nil {
+				return err
+			}
+			if len(reserved)+len(available) < seats {
+				return fmt.Errorf("cannot export %d seats of package pkgId=%s to instance insId=%s, account accId=%s has only %d licenses available",
+					seats, pkgId, insId, accId, len(reserved)+len(available))
+			}
+			for _, lic := range available {
+				evt, err := ls.assignSpecificLicenseHelper(ctx, licRepo, lic, accId, insId, OfflineSeatsUserId, now)
+				if err != nil {
+					return err
+				}
+				reserved = append(reserved, lic)
+				events = append(events, evt)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, evt := range events {
+		ls.publish(evt)
+	}
+	return reserved, nil
+}
+
+// Whether the license is assigned to a user of the instance, its offline seats user included
+func isHeldByInstance(lic *licensing.License, insId string) bool {
+	if !lic.IsAssigned() {
+		return false
+	}
+	insUsr, ok := lic.AssignedToLicensee().(licensing.InstanceUser)
+	return ok && insUsr.InstanceId == insId
+}
+
+func (ls *licensingService) AssignSpecificLicense(ctx context.Context, _ Principal, licId string, accId string, insId string, insUsrId string, opts ...MutationOption) (*licensing.License, error) {
+	version := newMutationOptions(opts).licenseVersion
+	var specificLic *licensing.License
+	var evt licensing.LicenseEvent
+	assign := func() error {
+		var err error
+		specificLic, err = ls.licensesOf(accId).GetLicenseById(ctx, licId)
+		if err != nil {
+			return err
+		}
+		if version != 0 && specificLic.Version() != version {
+			return fmt.Errorf("%w: license id=%s is at version %d, assignment was authorized at version %d",
+				licensing.ErrLicenseVersionConflict, licId, specificLic.Version(), version)
+		}
+		evt, err = ls.assignSpecificLicenseHelper(ctx, ls.licensesOf(accId), specificLic, accId, insId, insUsrId, time.Now())
+		return err
+	}
+	var err error
+	if version != 0 {
+		err = assign()
+	} else {
+		err = retryOnLicenseConflict(assign)
+	}
 	if err != nil {
 		return nil, err
 	}
+	ls.publish(evt)
 	return specificLic, nil
 }
 
-func (ls *licensingService) VerifyEntitlement(accId string, insId string, insUsrId string, cpbId string) (licensing.Entitlement, error) {
+// Attempts of a license change conflicting with concurrent changes, before giving up.
+// Every conflict means a concurrent change succeeded, so few attempts are needed unless a license is heavily contended.
+const maxLicenseChangeAttempts = 5
+
+// Runs the license change, re-running it on a version conflict with freshly loaded licenses
+func retryOnLicenseConflict(change func() error) error {
+	return retryOnConflict(licensing.ErrLicenseVersionConflict, maxLicenseChangeAttempts, change)
+}
+
+// Attempts of a pool change conflicting with concurrent changes, before giving up.
+// Usage is recorded to a pool by every user of the account, so pools are far more contended than licenses.
+const maxCapacityPoolChangeAttempts = 20
+
+// Runs the pool change, re-running it on a version conflict with a freshly loaded pool
+func retryOnCapacityPoolConflict(change func() error) error {
+	return retryOnConflict(licensing.ErrCapacityPoolVersionConflict, maxCapacityPoolChangeAttempts, change)
+}
+
+func retryOnConflict(conflict error, maxAttempts int, change func() error) error {
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		err = change()
+		if !errors.Is(err, conflict) {
+			return err
+		}
+	}
+	return fmt.Errorf("gave up after %d attempts: %w", maxAttempts, err)
+}
+
+// Runs work on the licenses of the given customer account as a unit of work, if the license repository supports it
+func (ls *licensingService) atomically(ctx context.Context, accId string, work func(ctx context.Context, licRepo licensing.LicenseRepository) error) error {
+	uow, ok := (*ls.licRepo).(licensing.LicenseUnitOfWork)
+	if !ok {
+		return work(ctx, ls.licensesOf(accId))
+	}
+	return uow.RunAtomically(ctx, func(ctx context.Context, licRepo licensing.LicenseRepository) error {
+		return work(ctx, licensing.NewTenantScopedLicenseRepository(licRepo, accId))
+	})
+}
+
+// Assigns a license of the package chosen by the strategy, returning the event to publish once the change is committed
+func (ls *licensingService) assignAvailableLicenseHelper(ctx context.Context, licRepo licensing.LicenseRepository, pkgId string, accId string, insId string, insUsrId string, strategy licensing.SeatAllocationStrategy) (*licensing.License, licensing.LicenseEvent, error) {
+	now := time.Now()
+	candidates, err := licRepo.FindLicensesToAssign(ctx, accId, pkgId, strategy, now, licenseAssignmentCandidates)
+	if err != nil {
+		return nil, licensing.LicenseEvent{}, err
+	}
+	if len(candidates) == 0 {
+		return nil, licensing.LicenseEvent{}, fmt.Errorf("no more unassigned license for pkgId=%s", pkgId)
+	}
+	// the first license in strategy order is assigned; when a concurrent assignment took it, the next one is
+	for _, availableLic := range candidates {
+		evt, err := ls.assignSpecificLicenseHelper(ctx, licRepo, availableLic, accId, insId, insUsrId, now)
+		if errors.Is(err, licensing.ErrLicenseVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, licensing.LicenseEvent{}, err
+		}
+		return availableLic, evt, nil
+	}
+	return nil, licensing.LicenseEvent{}, fmt.Errorf("all %d licenses to assign next were taken concurrently: %w", len(candidates), licensing.ErrLicenseVersionConflict)
+}
+
+// Licenses to assign next, in strategy order, tried one after the other when concurrent assignments take them
+const licenseAssignmentCandidates = 8
+
+// Assigns the license if in force at the given time, returning the event to publish once the change is committed
+func (ls *licensingService) assignSpecificLicenseHelper(ctx context.Context, licRepo licensing.LicenseRepository, specificLic *licensing.License, accId string, insId string, insUsrId string, at time.Time) (licensing.LicenseEvent, error) {
+	// a license of another customer account is reported as not found, not to reveal it exists
+	if specificLic.PossessingCustomerAccountId() != accId {
+		return licensing.LicenseEvent{}, fmt.Errorf("%w for id=%s", licensing.ErrLicenseNotFound, specificLic.Id())
+	}
+	if !specificLic.IsInForceAt(at) {
+		return licensing.LicenseEvent{}, fmt.Errorf("license id=%s is not in force, status=%s",
+			specificLic.Id(), licensing.LicenseQuery{At: at}.StatusOf(specificLic))
+	}
+	newAssignee := licensing.NewInstanceUser(insId, insUsrId)
+	affectedLicenseeIds := []string{newAssignee.LicenseeId()}
+	if specificLic.IsAssigned() {
+		affectedLicenseeIds = append(affectedLicenseeIds, specificLic.AssignedToLicensee().LicenseeId())
+	}
+	specificLic.Assign(newAssignee)
+	err := licRepo.UpdateLicense(ctx, specificLic.Id(), specificLic)
+	if err != nil {
+		return licensing.LicenseEvent{}, err
+	}
+	return licensing.LicenseEvent{
+		Type:                licensing.LICENSE_ASSIGNED,
+		LicenseId:           specificLic.Id(),
+		AccountId:           specificLic.PossessingCustomerAccountId(),
+		AffectedLicenseeIds: affectedLicenseeIds,
+		OccurredAt:          time.Now()}, nil
+}
+
+func (ls *licensingService) VerifyEntitlement(ctx context.Context, _ Principal, accId string, insId string, insUsrId string, cpbId string, opts ...VerifyEntitlementOption) (licensing.Entitlement, error) {
+	verifyOpts := verifyEntitlementOptions{}
+	for _, opt := range opts {
+		opt(&verifyOpts)
+	}
 
 	insUsr := licensing.NewInstanceUser(insId, insUsrId)
-	licenses, err := (*ls.licRepo).FindLicensesByAssignedLicenseeId(insUsr.LicenseeId())
+	if ls.entCache == nil {
+		return ls.evaluateEntitlement(ctx, accId, insUsr, cpbId)
+	}
+	if verifyOpts.bypassCache {
+		ls.entCache.recordBypass()
+		return ls.evaluateEntitlement(ctx, accId, insUsr, cpbId)
+	}
 
+	cached, generation, hit := ls.entCache.lookup(accId, insUsr.LicenseeId(), cpbId)
+	if hit {
+		return cached, nil
+	}
+	entitlement, validUntil, err := ls.evaluateEntitlementAt(ctx, accId, insUsr, cpbId, time.Now())
 	if err != nil {
-		return licensing.Entitlement{
-			IsEntitled:            false,
-			EvaluatedUserId:       insUsr.LicenseeId(),
-			EvaluatedCapabilityId: cpbId}, nil
+		return entitlement, err
 	}
+	// pool usage changes with every call, so pooled capacities are always evaluated afresh
+	if !entitlement.IsPooledCapacity {
+		ls.entCache.store(accId, entitlement, generation, validUntil)
+	}
+	return entitlement, nil
+}
+
+func (ls *licensingService) evaluateEntitlement(ctx context.Context, accId string, insUsr licensing.InstanceUser, cpbId string) (licensing.Entitlement, error) {
+	entitlement, _, err := ls.evaluateEntitlementAt(ctx, accId, insUsr, cpbId, time.Now())
+	return entitlement, err
+}
+
+// Evaluates the entitlement at the given time, also returning until when it holds: the earliest end of term of
+// the licenses in force, zero if none ends
+func (ls *licensingService) evaluateEntitlementAt(ctx context.Context, accId string, insUsr licensing.InstanceUser, cpbId string, at time.Time) (licensing.Entitlement, time.Time, error) {
+	catalog, err := (*ls.pkgRepo).GetCapabilityCatalog(ctx)
+	if err != nil {
+		return licensing.Entitlement{}, time.Time{}, err
+	}
+	licenses, err := ls.licensesOf(accId).FindLicensesByAssignedLicenseeId(ctx, insUsr.LicenseeId())
+	if errors.Is(err, licensing.ErrLicenseNotFound) {
+		// no license assigned to the user
+		licenses = nil
+	} else if err != nil {
+		return licensing.Entitlement{}, time.Time{}, err
+	}
+	entitlement := licensing.EvaluateEntitlement(insUsr, licenses, catalog, cpbId, at)
+	validUntil := time.Time{}
 	for _, lic := range licenses {
-		pkg := lic.LicensedPackage()
-		if pkg.IncludesCapability(cpbId) {
-			return licensing.Entitlement{
-				IsEntitled:            true,
-				EvaluatedUserId:       insUsr.LicenseeId(),
-				EvaluatedCapabilityId: cpbId}, nil
+		if lic.IsInForceAt(at) && !lic.ExpiresAt().IsZero() && (validUntil.IsZero() || lic.ExpiresAt().Before(validUntil)) {
+			validUntil = lic.ExpiresAt()
+		}
+	}
+
+	cpb, ok := catalog.GetCapability(cpbId)
+	if !ok || !cpb.CapacityScope.IsPooled() || ls.poolRepo == nil {
+		return entitlement, validUntil, nil
+	}
+	pool, err := ls.loadCapacityPool(ctx, accId, cpbId, catalog)
+	if err != nil {
+		return licensing.Entitlement{}, time.Time{}, err
+	}
+	pool.ApplyTo(&entitlement, insUsr.LicenseeId(), insUsr.InstanceId, at)
+	return entitlement, validUntil, nil
+}
+
+func (ls *licensingService) ListEntitlements(ctx context.Context, _ Principal, accId string, insId string, insUsrId string) ([]licensing.Entitlement, error) {
+	catalog, err := (*ls.pkgRepo).GetCapabilityCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	insUsr := licensing.NewInstanceUser(insId, insUsrId)
+	licenses, err := ls.licensesOf(accId).FindLicensesByAssignedLicenseeId(ctx, insUsr.LicenseeId())
+	if errors.Is(err, licensing.ErrLicenseNotFound) {
+		// no license assigned to the user
+		return []licensing.Entitlement{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entitlements := licensing.EvaluateEntitlements(insUsr, licenses, catalog, time.Now())
+	for i, entitlement := range entitlements {
+		cpb, _ := catalog.GetCapability(entitlement.EvaluatedCapabilityId)
+		if !cpb.CapacityScope.IsPooled() || ls.poolRepo == nil {
+			continue
+		}
+		pool, err := ls.loadCapacityPool(ctx, accId, cpb.Id, catalog)
+		if err != nil {
+			return nil, err
+		}
+		pool.ApplyTo(&entitlements[i], insUsr.LicenseeId(), insId, time.Now())
+	}
+	return entitlements, nil
+}
+
+func (ls *licensingService) AllocatePooledCapacityToUser(ctx context.Context, _ Principal, accId string, cpbId string, insId string, insUsrId string, amount int, _ ...MutationOption) (*licensing.CapacityPool, error) {
+	return ls.allocatePooledCapacity(ctx, accId, cpbId, licensing.NewInstanceUser(insId, insUsrId).LicenseeId(), amount)
+}
+
+func (ls *licensingService) AllocatePooledCapacityToInstance(ctx context.Context, _ Principal, accId string, cpbId string, insId string, amount int, _ ...MutationOption) (*licensing.CapacityPool, error) {
+	return ls.allocatePooledCapacity(ctx, accId, cpbId, licensing.InstancePoolHolderId(insId), amount)
+}
+
+func (ls *licensingService) allocatePooledCapacity(ctx context.Context, accId string, cpbId string, holderId string, amount int) (*licensing.CapacityPool, error) {
+	catalog, err := (*ls.pkgRepo).GetCapabilityCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pool *licensing.CapacityPool
+	err = retryOnCapacityPoolConflict(func() error {
+		pool, err = ls.loadCapacityPool(ctx, accId, cpbId, catalog)
+		if err != nil {
+			return err
+		}
+		if err := pool.Allocate(holderId, amount); err != nil {
+			return err
+		}
+		return ls.poolsOf(accId).SaveCapacityPool(ctx, pool)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+func (ls *licensingService) RecordCapacityUsage(ctx context.Context, _ Principal, accId string, insId string, insUsrId string, cpbId string, amount int, _ ...MutationOption) (licensing.Entitlement, error) {
+	catalog, err := (*ls.pkgRepo).GetCapabilityCatalog(ctx)
+	if err != nil {
+		return licensing.Entitlement{}, err
+	}
+	insUsr := licensing.NewInstanceUser(insId, insUsrId)
+	err = retryOnCapacityPoolConflict(func() error {
+		pool, err := ls.loadCapacityPool(ctx, accId, cpbId, catalog)
+		if err != nil {
+			return err
+		}
+		if err := pool.RecordUsage(insUsr.LicenseeId(), insId, amount, time.Now()); err != nil {
+			return err
 		}
+		return ls.poolsOf(accId).SaveCapacityPool(ctx, pool)
+	})
+	if err != nil {
+		return licensing.Entitlement{}, err
+	}
+	return ls.evaluateEntitlement(ctx, accId, insUsr, cpbId)
+}
+
+// Loads the account's pool of the capability, a new one if never saved, sized by the account's current licenses
+func (ls *licensingService) loadCapacityPool(ctx context.Context, accId string, cpbId string, catalog *licensing.CapabilityCatalog) (*licensing.CapacityPool, error) {
+	if ls.poolRepo == nil {
+		return nil, fmt.Errorf("capacity pools are not enabled")
+	}
+	cpb, ok := catalog.GetCapability(cpbId)
+	if !ok || !cpb.CapacityScope.IsPooled() {
+		return nil, fmt.Errorf("capability cpbId=%s has no pooled capacity", cpbId)
 	}
-	return licensing.Entitlement{
-		IsEntitled:            true,
-		EvaluatedUserId:       insUsr.LicenseeId(),
-		EvaluatedCapabilityId: cpbId}, nil
+	pool, err := ls.poolsOf(accId).GetCapacityPool(ctx, accId, cpbId)
+	if errors.Is(err, licensing.ErrCapacityPoolNotFound) {
+		pool = licensing.NewCapacityPool(accId, cpbId)
+	} else if err != nil {
+		return nil, err
+	}
+	accountLicenses, err := ls.licensesOf(accId).FindLicensesOfAccount(ctx, accId)
+	if err != nil {
+		return nil, err
+	}
+	totalLimit, unit, _ := licensing.DerivePoolLimit(accountLicenses, catalog, cpbId, time.Now())
+	pool.Resize(totalLimit, unit)
+	return pool, nil
 }
 
-func (ls *licensingService) CountTotalUnassignedLicensesOfPackage(accId string, pkgId string) (int, error) {
-	return (*ls.licRepo).CountTotalUnassignedLicensesOfPackage(accId, pkgId)
+func (ls *licensingService) ListLicenses(ctx context.Context, _ Principal, accId string, query licensing.LicenseQuery) (*licensing.LicensePage, error) {
+	query.AccountId = accId
+	if query.At.IsZero() {
+		query.At = time.Now()
+	}
+	return ls.licensesOf(accId).ListLicenses(ctx, query)
+}
+
+func (ls *licensingService) CountTotalUnassignedLicensesOfPackage(ctx context.Context, _ Principal, accId string, pkgId string) (int, error) {
+	return ls.licensesOf(accId).CountTotalUnassignedLicensesOfPackage(ctx, accId, pkgId)
+}
+
+func (ls *licensingService) GatherLicenseAssignmentSummary(ctx context.Context, _ Principal, accId string, opts ...GatherLicenseAssignmentSummaryOption) (licensing.LicenseAssignmentSummary, error) {
+	summaryOpts := gatherLicenseAssignmentSummaryOptions{at: time.Now(), expiringSoonWindow: licensing.DefaultExpiringSoonWindow}
+	for _, opt := range opts {
+		opt(&summaryOpts)
+	}
+	licenses, err := ls.licensesOf(accId).FindLicensesOfAccount(ctx, accId)
+	if err != nil {
+		return licensing.LicenseAssignmentSummary{}, err
+	}
+	return licensing.SummarizeLicenseAssignments(accId, licenses, summaryOpts.at, summaryOpts.expiringSoonWindow), nil
+}
+
+// License repository constrained to the given customer account, so that no use case can reach another tenant's licenses
+func (ls *licensingService) licensesOf(accId string) licensing.LicenseRepository {
+	return licensing.NewTenantScopedLicenseRepository(*ls.licRepo, accId)
+}
+
+// Capacity pool repository constrained to the given customer account
+func (ls *licensingService) poolsOf(accId string) licensing.CapacityPoolRepository {
+	return licensing.NewTenantScopedCapacityPoolRepository(*ls.poolRepo, accId)
+}
+
+func (ls *licensingService) publish(evt licensing.LicenseEvent) {
+	for _, handler := range ls.eventHandlers {
+		handler.HandleLicenseEvent(evt)
+	}
 }
@@ -1,7 +1,11 @@
 package licensing
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
 	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
@@ -10,6 +14,7 @@ import (
 
 func TestIssueLicenses(t *testing.T) {
 
+	ctx := context.Background()
 	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
 	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
 	ls := NewLicensingService(&licRepo, &pkgRepo)
@@ -19,7 +24,7 @@ func TestIssueLicenses(t *testing.T) {
 	pkgId := "pkg:base-optimize-2022"
 
 	t.Run("happy case", func(t *testing.T) {
-		licenses, err := ls.IssueLicenses(accId, subId, pkgId, 3)
+		licenses, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 3)
 		assert.NilError(t, err)
 		assert.Equal(t, 3, len(licenses))
 		// print out for debugging
@@ -31,6 +36,7 @@ func TestIssueLicenses(t *testing.T) {
 
 func TestAssignAvailableLicenseOfPackage(t *testing.T) {
 
+	ctx := context.Background()
 	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
 	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
 	ls := NewLicensingService(&licRepo, &pkgRepo)
@@ -44,38 +50,39 @@ func TestAssignAvailableLicenseOfPackage(t *testing.T) {
 	insUsrIdCharles := "usr-charles"
 	insUsrIdDaniel := "usr-daniel"
 
-	_, err := ls.IssueLicenses(accId, subId, pkgId, 3)
+	_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 3)
 	assert.NilError(t, err)
 
 	t.Run("assign 2 licenses should suceed", func(t *testing.T) {
-		lic, err := ls.AssignAvailableLicenseOfPackage(pkgId, accId, insId, insUsrIdAlice)
+		lic, err := ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, insUsrIdAlice)
 		t.Log(lic)
 		assert.NilError(t, err)
 		assert.Check(t, lic != nil)
-		lic, err = ls.AssignAvailableLicenseOfPackage(pkgId, accId, insId, insUsrIdBob)
+		lic, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, insUsrIdBob)
 		t.Log(lic)
 		assert.NilError(t, err)
 		assert.Check(t, lic != nil)
-		unassignedLicensesCount, err := ls.CountTotalUnassignedLicensesOfPackage(accId, pkgId)
+		unassignedLicensesCount, err := ls.CountTotalUnassignedLicensesOfPackage(ctx, testCustomerAdmin(accId), accId, pkgId)
 		assert.NilError(t, err)
 		assert.Equal(t, unassignedLicensesCount, 1)
 	})
 
 	t.Run("assign 1 more licenses should succeed", func(t *testing.T) {
-		ls.AssignAvailableLicenseOfPackage(pkgId, accId, insId, insUsrIdCharles)
-		unassignedLicensesCount, err := ls.CountTotalUnassignedLicensesOfPackage(accId, pkgId)
+		ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, insUsrIdCharles)
+		unassignedLicensesCount, err := ls.CountTotalUnassignedLicensesOfPackage(ctx, testCustomerAdmin(accId), accId, pkgId)
 		assert.NilError(t, err)
 		assert.Equal(t, unassignedLicensesCount, 0)
 	})
 
 	t.Run("assign 1 more licenses should fail", func(t *testing.T) {
-		_, err := ls.AssignAvailableLicenseOfPackage(pkgId, accId, insId, insUsrIdDaniel)
+		_, err := ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, insUsrIdDaniel)
 		assert.Error(t, err, "no more unassigned license for pkgId=pkg:base-optimize-2022")
 	})
 }
 
 func TestAssignSpecificLicense(t *testing.T) {
 
+	ctx := context.Background()
 	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
 	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
 	ls := NewLicensingService(&licRepo, &pkgRepo)
@@ -87,28 +94,41 @@ func TestAssignSpecificLicense(t *testing.T) {
 	insUsrIdAlice := "usr-alice"
 	insUsrIdBob := "usr-bob"
 
-	_, err := ls.IssueLicenses(accId, subId, pkgId, 3)
+	_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 3)
 	assert.NilError(t, err)
 
 	t.Run("reassign should succeed", func(t *testing.T) {
 		// assign a random license to alice
-		lic, err := ls.AssignAvailableLicenseOfPackage(pkgId, accId, insId, insUsrIdAlice)
+		lic, err := ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, insUsrIdAlice)
 		t.Log(lic)
 		assert.NilError(t, err)
 		assert.Check(t, lic != nil)
 		// reassign the same license to bob
-		lic, err = ls.AssignSpecificLicense(lic.Id(), accId, insId, insUsrIdBob)
+		lic, err = ls.AssignSpecificLicense(ctx, testCustomerAdmin(accId), lic.Id(), accId, insId, insUsrIdBob)
 		t.Log(lic)
 		assert.NilError(t, err)
 		assert.Check(t, lic != nil)
-		unassignedLicensesCount, err := ls.CountTotalUnassignedLicensesOfPackage(accId, pkgId)
+		unassignedLicensesCount, err := ls.CountTotalUnassignedLicensesOfPackage(ctx, testCustomerAdmin(accId), accId, pkgId)
 		assert.NilError(t, err)
 		assert.Equal(t, unassignedLicensesCount, 2)
 	})
+
+	t.Run("licenses not in force are not assigned", func(t *testing.T) {
+		pastTerm, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-2", pkgId, 1, WithIssuanceTerms(licensing.ExpiringAt(time.Now().Add(-time.Hour))))
+		assert.NilError(t, err)
+		_, err = ls.AssignSpecificLicense(ctx, testCustomerAdmin(accId), pastTerm[0].Id(), accId, insId, insUsrIdAlice)
+		assert.Error(t, err, fmt.Sprintf("license id=%s is not in force, status=EXPIRED_LICENSE", pastTerm[0].Id()))
+
+		trueDowned, err := ls.TrueDownLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 1)
+		assert.NilError(t, err)
+		_, err = ls.AssignSpecificLicense(ctx, testCustomerAdmin(accId), trueDowned[0].Id(), accId, insId, insUsrIdAlice)
+		assert.ErrorContains(t, err, "is not in force")
+	})
 }
 
 func TestVerifyEntitlement(t *testing.T) {
 
+	ctx := context.Background()
 	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
 	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
 	ls := NewLicensingService(&licRepo, &pkgRepo)
@@ -116,27 +136,256 @@ func TestVerifyEntitlement(t *testing.T) {
 	accId := "acc-1"
 	subId := "sub-1"
 	pkgId := "pkg:base-optimize-2022"
-	cpbIdSeq := "sequence"
-	cpbIdKaia := "kaia"
+	cpbIdSeq := "cpb:sequence"
+	cpbIdKaia := "cpb:kaia-meeting"
+	cpbIdBasicReporting := "cpb:basic-reporting"
 	insId := "ins-101"
 	insUsrIdAlice := "usr-alice"
 	insUsrIdBob := "usr-bob"
 
-	_, err := ls.IssueLicenses(accId, subId, pkgId, 3)
+	_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 3)
 	assert.NilError(t, err)
-	_, err = ls.AssignAvailableLicenseOfPackage(pkgId, accId, insId, insUsrIdAlice)
+	_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, insUsrIdAlice)
 	assert.NilError(t, err)
 
 	t.Run("alice is entitled to sequence", func(t *testing.T) {
-		entitlement, err := ls.VerifyEntitlement(accId, insId, insUsrIdAlice, cpbIdSeq)
+		entitlement, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrIdAlice, cpbIdSeq)
 		assert.NilError(t, err)
 		assert.Equal(t, entitlement.IsEntitled, true)
 	})
 
 	t.Run("bob is not entitled to sequence", func(t *testing.T) {
-		entitlement, err := ls.VerifyEntitlement(accId, insId, insUsrIdBob, cpbIdKaia)
+		entitlement, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrIdBob, cpbIdSeq)
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, false)
+	})
+
+	t.Run("alice is not entitled to kaia outside her package", func(t *testing.T) {
+		entitlement, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrIdAlice, cpbIdKaia)
 		assert.NilError(t, err)
 		assert.Equal(t, entitlement.IsEntitled, false)
 	})
 
+	t.Run("alice is entitled to basic reporting implied by advanced reporting", func(t *testing.T) {
+		entitlement, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrIdAlice, cpbIdBasicReporting)
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, true)
+	})
+
+}
+
+func TestVerifyEntitlementPastTerm(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := NewLicensingService(&licRepo, &pkgRepo, WithEntitlementCache(NewEntitlementCache(time.Hour)))
+
+	accId := "acc-1"
+	insId := "ins-101"
+	insUsrIdAlice := "usr-alice"
+	pkgId := "pkg:base-optimize-2022"
+
+	_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-1", pkgId, 1, WithIssuanceTerms(licensing.ExpiringAt(time.Now().Add(50*time.Millisecond))))
+	assert.NilError(t, err)
+	_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, insUsrIdAlice)
+	assert.NilError(t, err)
+	entitlement, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrIdAlice, "cpb:sequence")
+	assert.NilError(t, err)
+	assert.Equal(t, entitlement.IsEntitled, true)
+
+	// the license term ends before the cache ttl elapses
+	time.Sleep(100 * time.Millisecond)
+	entitlement, err = ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrIdAlice, "cpb:sequence")
+	assert.NilError(t, err)
+	assert.Equal(t, entitlement.IsEntitled, false)
+	entitlements, err := ls.ListEntitlements(ctx, testApplication, accId, insId, insUsrIdAlice)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entitlements), 0)
+}
+
+func TestVerifyEntitlementWithMultipleLicenses(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := NewLicensingService(&licRepo, &pkgRepo)
+
+	accId := "acc-1"
+	subId := "sub-1"
+	insId := "ins-101"
+	insUsrIdAlice := "usr-alice"
+	cpbIdCrmSync := "cpb:crm-sync"
+
+	for _, pkgId := range []string{"pkg:base-accelerate-2022", "pkg:base-optimize-2022"} {
+		_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, subId, pkgId, 1)
+		assert.NilError(t, err)
+		_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, insUsrIdAlice)
+		assert.NilError(t, err)
+	}
+
+	t.Run("alice gets the highest crm sync limit of her licenses", func(t *testing.T) {
+		entitlement, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrIdAlice, cpbIdCrmSync)
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, true)
+		assert.Equal(t, entitlement.HasCapacityLimit, true)
+		assert.Equal(t, entitlement.EffectiveCapacityLimit, 250000)
+		assert.Equal(t, entitlement.CapacityLimitUnit, "CallsPerDay")
+	})
+
+	t.Run("alice is entitled to capabilities of both licenses", func(t *testing.T) {
+		entitlement, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, insUsrIdAlice, "cpb:sentiment")
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, true)
+	})
+}
+
+func TestCanceledContext(t *testing.T) {
+
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := NewLicensingService(&licRepo, &pkgRepo)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ls.IssueLicenses(ctx, testLicenseAdmin, "acc-1", "sub-1", "pkg:base-optimize-2022", 1)
+	assert.Check(t, errors.Is(err, context.Canceled), err)
+	_, err = ls.VerifyEntitlement(ctx, testApplication, "acc-1", "ins-101", "usr-alice", "cpb:sequence")
+	assert.Check(t, errors.Is(err, context.Canceled), err)
+}
+
+func TestAssignAvailableLicenseBySeatAllocationStrategy(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := NewLicensingService(&licRepo, &pkgRepo)
+
+	accId := "acc-1"
+	pkgId := "pkg:base-optimize-2022"
+	insId := "ins-101"
+
+	paid, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-paid", pkgId, 1, WithIssuanceTerms(licensing.ExpiringAt(time.Now().AddDate(1, 0, 0))))
+	assert.NilError(t, err)
+	trial, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-trial", pkgId, 1, WithIssuanceTerms(licensing.AsTrial(), licensing.ExpiringAt(time.Now().AddDate(0, 0, 14))))
+	assert.NilError(t, err)
+
+	t.Run("paid before trial on request", func(t *testing.T) {
+		lic, err := ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, "usr-alice",
+			UsingSeatAllocationStrategy(licensing.PaidBeforeTrial()))
+		assert.NilError(t, err)
+		assert.Equal(t, lic.Id(), paid[0].Id())
+	})
+
+	t.Run("soonest expiring by default", func(t *testing.T) {
+		var expected []string
+		for _, days := range []int{90, 30, 60} {
+			issued, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-paid", pkgId, 1, WithIssuanceTerms(licensing.ExpiringAt(time.Now().AddDate(0, 0, days))))
+			assert.NilError(t, err)
+			expected = append(expected, issued[0].Id())
+		}
+		// the trial ends in 14 days, then the licenses ending in 30, 60 and 90 days
+		expected = []string{trial[0].Id(), expected[1], expected[2], expected[0]}
+
+		for i, insUsrId := range []string{"usr-bob", "usr-carol", "usr-dave", "usr-erin"} {
+			lic, err := ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, insUsrId)
+			assert.NilError(t, err)
+			assert.Equal(t, lic.Id(), expected[i])
+		}
+	})
+}
+
+func TestListLicenses(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := NewLicensingService(&licRepo, &pkgRepo)
+
+	accId := "acc-1"
+	pkgId := "pkg:base-optimize-2022"
+	insId := "ins-101"
+
+	issued, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-1", pkgId, 5)
+	assert.NilError(t, err)
+	_, err = ls.IssueLicenses(ctx, testLicenseAdmin, "acc-2", "sub-1", pkgId, 2)
+	assert.NilError(t, err)
+	_, err = ls.AssignSpecificLicense(ctx, testCustomerAdmin(accId), issued[0].Id(), accId, insId, "usr-alice")
+	assert.NilError(t, err)
+
+	t.Run("unassigned licenses are listed page by page", func(t *testing.T) {
+		unassigned := false
+		query := licensing.LicenseQuery{IsAssigned: &unassigned, SortBy: licensing.SORT_LICENSES_BY_ID, PageSize: 3}
+		page, err := ls.ListLicenses(ctx, testCustomerAdmin(accId), accId, query)
+		assert.NilError(t, err)
+		assert.Equal(t, len(page.Licenses), 3)
+		query.Cursor = page.NextCursor
+		page, err = ls.ListLicenses(ctx, testCustomerAdmin(accId), accId, query)
+		assert.NilError(t, err)
+		assert.Equal(t, len(page.Licenses), 1)
+		assert.Equal(t, page.NextCursor, "")
+	})
+
+	t.Run("licenses of another account are not listed", func(t *testing.T) {
+		page, err := ls.ListLicenses(ctx, testCustomerAdmin(accId), accId, licensing.LicenseQuery{AccountId: "acc-2"})
+		assert.NilError(t, err)
+		assert.Equal(t, len(page.Licenses), 5)
+		for _, lic := range page.Licenses {
+			assert.Equal(t, lic.PossessingCustomerAccountId(), accId)
+		}
+		_, err = ls.ListLicenses(ctx, testCustomerAdmin("acc-2"), accId, licensing.LicenseQuery{})
+		assert.Check(t, errors.Is(err, ErrPermissionDenied), err)
+	})
+}
+
+func TestGatherLicenseAssignmentSummary(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := NewLicensingService(&licRepo, &pkgRepo)
+
+	accId := "acc-1"
+	optimize := "pkg:base-optimize-2022"
+	accelerate := "pkg:base-accelerate-2022"
+	insId := "ins-101"
+	endOfTerm := time.Now().AddDate(0, 0, 10)
+
+	_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-1", optimize, 3, WithIssuanceTerms(licensing.ExpiringAt(endOfTerm)))
+	assert.NilError(t, err)
+	_, err = ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-trial", optimize, 1, WithIssuanceTerms(licensing.AsTrial()))
+	assert.NilError(t, err)
+	_, err = ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-1", accelerate, 2)
+	assert.NilError(t, err)
+	_, err = ls.AssignAvailableLicensesOfPackage(ctx, testCustomerAdmin(accId), optimize, accId, insId, []string{"usr-alice", "usr-bob"},
+		UsingSeatAllocationStrategy(licensing.PaidBeforeTrial()))
+	assert.NilError(t, err)
+
+	t.Run("totals across all packages and per subscription", func(t *testing.T) {
+		summary, err := ls.GatherLicenseAssignmentSummary(ctx, testCustomerAdmin(accId), accId)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, summary.Totals, licensing.LicenseAssignmentTotals{Issued: 6, Assigned: 2, Unassigned: 4, Trial: 1, ExpiringSoon: 3})
+		assert.DeepEqual(t, summary.Package(accelerate).Totals, licensing.LicenseAssignmentTotals{Issued: 2, Unassigned: 2})
+		subscriptions := summary.Package(optimize).Subscriptions
+		assert.Equal(t, len(subscriptions), 2)
+		assert.DeepEqual(t, subscriptions[1], licensing.SubscriptionAssignmentSummary{
+			SubscriptionId: "sub-trial", Totals: licensing.LicenseAssignmentTotals{Issued: 1, Unassigned: 1, Trial: 1}})
+
+		unassigned, err := ls.CountTotalUnassignedLicensesOfPackage(ctx, testCustomerAdmin(accId), accId, optimize)
+		assert.NilError(t, err)
+		assert.Equal(t, summary.Package(optimize).Totals.Unassigned, unassigned)
+	})
+
+	t.Run("licenses assigned past their term are in overage", func(t *testing.T) {
+		summary, err := ls.GatherLicenseAssignmentSummary(ctx, testCustomerAdmin(accId), accId,
+			SummarizedAt(endOfTerm.AddDate(0, 0, 1)), ExpiringSoonWithin(time.Hour))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, summary.Package(optimize).Totals, licensing.LicenseAssignmentTotals{Issued: 1, Unassigned: 1, Trial: 1, Expired: 3, InOverage: 2})
+	})
+
+	t.Run("summary of another account is denied", func(t *testing.T) {
+		_, err := ls.GatherLicenseAssignmentSummary(ctx, testCustomerAdmin("acc-2"), accId)
+		assert.Check(t, errors.Is(err, ErrPermissionDenied), err)
+	})
 }
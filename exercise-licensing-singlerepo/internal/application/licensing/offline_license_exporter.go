@@ -0,0 +1,100 @@
+package licensing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/pkg/offlinelicense"
+)
+
+// Application Service for exporting signed license files to instances deployed without connectivity,
+// see package offlinelicense.
+type OfflineLicenseExporter interface {
+
+	// Export a signed license file granting the instance seats of the package, on behalf of the principal.
+	// The seats are reserved for the instance, see LicensingService.ReserveOfflineSeats, and the file expires at
+	// the given time, or at the end of term of the first license backing its seats if earlier.
+	ExportOfflineLicense(ctx context.Context, p Principal, accId string, insId string, pkgId string, seats int, expiresAt time.Time) ([]byte, error)
+}
+
+type offlineLicenseExporter struct {
+	ls      LicensingService
+	pkgRepo *licensing.PackageRepository
+
+	// Key id of the signing key, published along with its public key
+	keyId string
+
+	signingKey ed25519.PrivateKey
+
+	now func() time.Time
+}
+
+func NewOfflineLicenseExporter(ls LicensingService, pkgRepo *licensing.PackageRepository, keyId string, signingKey ed25519.PrivateKey) OfflineLicenseExporter {
+	return &offlineLicenseExporter{
+		ls:         ls,
+		pkgRepo:    pkgRepo,
+		keyId:      keyId,
+		signingKey: signingKey,
+		now:        time.Now,
+	}
+}
+
+func (ex *offlineLicenseExporter) ExportOfflineLicense(ctx context.Context, p Principal, accId string, insId string, pkgId string, seats int, expiresAt time.Time) ([]byte, error) {
+	pkg, err := (*ex.pkgRepo).GetPackageById(ctx, pkgId)
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := (*ex.pkgRepo).GetCapabilityCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := ex.ls.ReserveOfflineSeats(ctx, p, accId, insId, pkgId, seats)
+	if err != nil {
+		return nil, err
+	}
+	for _, lic := range reserved {
+		if !lic.ExpiresAt().IsZero() && lic.ExpiresAt().Before(expiresAt) {
+			expiresAt = lic.ExpiresAt()
+		}
+	}
+
+	file, err := NewOfflineLicenseFile(pkg, catalog, accId, insId, seats, ex.now(), expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return offlinelicense.Sign(file, ex.keyId, ex.signingKey)
+}
+
+// Builds the content of a license file granting the instance seats of the package, with the package's
+// effective capabilities in the catalog
+func NewOfflineLicenseFile(pkg *licensing.Package, catalog *licensing.CapabilityCatalog, accId string, insId string, seats int, issuedAt time.Time, expiresAt time.Time) (offlinelicense.LicenseFile, error) {
+	fileId := make([]byte, 16)
+	if _, err := rand.Read(fileId); err != nil {
+		return offlinelicense.LicenseFile{}, err
+	}
+	file := offlinelicense.LicenseFile{
+		Id:           hex.EncodeToString(fileId),
+		AccountId:    accId,
+		InstanceId:   insId,
+		PackageId:    pkg.Id,
+		PackageName:  pkg.Name,
+		Seats:        seats,
+		IssuedAt:     issuedAt.UTC(),
+		ExpiresAt:    expiresAt.UTC(),
+		Capabilities: make([]offlinelicense.CapabilityGrant, 0),
+	}
+	for _, cpb := range catalog.EffectiveCapabilities(pkg) {
+		grant := offlinelicense.CapabilityGrant{Id: cpb.Id, DisplayName: cpb.DisplayName}
+		if cpb.HasCapacityLimit {
+			limit := cpb.CapacityLimit
+			grant.CapacityLimit = &limit
+			grant.CapacityLimitUnit = cpb.CapacityLimitUnit
+		}
+		file.Capabilities = append(file.Capabilities, grant)
+	}
+	return file, nil
+}
@@ -0,0 +1,85 @@
+package licensing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/pkg/offlinelicense"
+	"gotest.tools/v3/assert"
+)
+
+func TestExportOfflineLicense(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := NewLicensingService(&licRepo, &pkgRepo)
+
+	accId := "acc-1"
+	pkgId := "pkg:base-optimize-2022"
+	insId := "ins-101"
+	_, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-1", pkgId, 3)
+	assert.NilError(t, err)
+	// a seat used by another instance is not available to the exported instance
+	_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, "ins-202", "usr-alice")
+	assert.NilError(t, err)
+	_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(accId), pkgId, accId, insId, "usr-bob")
+	assert.NilError(t, err)
+
+	pubKey, privKey, err := ed25519.GenerateKey(nil)
+	assert.NilError(t, err)
+	ex := NewOfflineLicenseExporter(ls, &pkgRepo, "key-1", privKey)
+	verifier := offlinelicense.NewVerifier(map[string]ed25519.PublicKey{"key-1": pubKey})
+	expiresAt := time.Now().AddDate(1, 0, 0)
+
+	t.Run("seats beyond the available licenses are rejected", func(t *testing.T) {
+		_, err := ex.ExportOfflineLicense(ctx, testCustomerAdmin(accId), accId, insId, pkgId, 3, expiresAt)
+		assert.Error(t, err, "cannot export 3 seats of package pkgId=pkg:base-optimize-2022 to instance insId=ins-101, account accId=acc-1 has only 2 licenses available")
+	})
+
+	t.Run("exported file verifies offline", func(t *testing.T) {
+		data, err := ex.ExportOfflineLicense(ctx, testCustomerAdmin(accId), accId, insId, pkgId, 2, expiresAt)
+		assert.NilError(t, err)
+		file, err := verifier.Verify(data, insId)
+		assert.NilError(t, err)
+		assert.Equal(t, file.AccountId, accId)
+		assert.Equal(t, file.PackageId, pkgId)
+		assert.Equal(t, file.Seats, 2)
+		crmSync, ok := file.Capability("cpb:crm-sync")
+		assert.Equal(t, ok, true)
+		assert.Equal(t, *crmSync.CapacityLimit, 250000)
+	})
+
+	t.Run("exported seats are reserved for the instance", func(t *testing.T) {
+		unassigned, err := ls.CountTotalUnassignedLicensesOfPackage(ctx, testCustomerAdmin(accId), accId, pkgId)
+		assert.NilError(t, err)
+		assert.Equal(t, unassigned, 0)
+		_, err = ex.ExportOfflineLicense(ctx, testCustomerAdmin(accId), accId, "ins-303", pkgId, 1, expiresAt)
+		assert.Error(t, err, "cannot export 1 seats of package pkgId=pkg:base-optimize-2022 to instance insId=ins-303, account accId=acc-1 has only 0 licenses available")
+
+		// exporting again to the instance reuses its seats
+		_, err = ex.ExportOfflineLicense(ctx, testCustomerAdmin(accId), accId, insId, pkgId, 2, expiresAt)
+		assert.NilError(t, err)
+	})
+
+	t.Run("file expires with the licenses backing its seats", func(t *testing.T) {
+		endOfTerm := time.Now().AddDate(0, 1, 0).Truncate(time.Second)
+		_, err := ls.IssueLicenses(ctx, testLicenseAdmin, "acc-2", "sub-1", pkgId, 1, WithIssuanceTerms(licensing.ExpiringAt(endOfTerm)))
+		assert.NilError(t, err)
+		data, err := ex.ExportOfflineLicense(ctx, testCustomerAdmin("acc-2"), "acc-2", insId, pkgId, 1, expiresAt)
+		assert.NilError(t, err)
+		file, err := verifier.Verify(data, insId)
+		assert.NilError(t, err)
+		assert.Check(t, file.ExpiresAt.Equal(endOfTerm), file.ExpiresAt)
+	})
+
+	t.Run("export to another account is denied", func(t *testing.T) {
+		_, err := ex.ExportOfflineLicense(ctx, testCustomerAdmin("acc-2"), accId, insId, pkgId, 1, expiresAt)
+		assert.Check(t, errors.Is(err, ErrPermissionDenied), err)
+	})
+}
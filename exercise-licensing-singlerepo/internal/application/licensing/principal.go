@@ -0,0 +1,94 @@
+package licensing
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Returned, wrapped, when a principal is not allowed to invoke a use case
+var ErrPermissionDenied = errors.New("permission denied")
+
+//
+// Role "enum", one per caller group of the LicensingService facade
+//
+type Role int
+
+const (
+	// Outreach License Administration, managing license lifecycles across all accounts
+	LICENSE_ADMIN Role = iota
+	// Customer Admin, managing user assignment within its own account
+	CUSTOMER_ADMIN
+	// Outreach Application, verifying entitlements and recording usage on behalf of users
+	APPLICATION
+)
+
+func (r Role) String() string {
+	return [...]string{"LICENSE_ADMIN", "CUSTOMER_ADMIN", "APPLICATION"}[r]
+}
+
+// The authenticated actor invoking a use case, as established by the driving adapter
+type Principal struct {
+
+	// Identifier of the actor, e.g. user id or service name, for auditing
+	Id string
+
+	// Roles granted to the actor
+	Roles []Role
+
+	// Customer account the actor is scoped to; required for customer admins,
+	// optional for applications, ignored for license admins
+	AccountId string
+
+	// Instances the actor is scoped to within its account; all instances of the account if empty
+	InstanceIds []string
+}
+
+func NewLicenseAdmin(id string) Principal {
+	return Principal{Id: id, Roles: []Role{LICENSE_ADMIN}}
+}
+
+// Customer admin of the account, managing the given instances, or all instances of the account if none given
+func NewCustomerAdmin(id string, accId string, insIds ...string) Principal {
+	return Principal{Id: id, Roles: []Role{CUSTOMER_ADMIN}, AccountId: accId, InstanceIds: insIds}
+}
+
+// Application acting for all accounts
+func NewApplicationPrincipal(id string) Principal {
+	return Principal{Id: id, Roles: []Role{APPLICATION}}
+}
+
+func (p Principal) String() string {
+	return fmt.Sprintf("{id=%s, roles=%v, accountId=%s, instanceIds=%v}", p.Id, p.Roles, p.AccountId, p.InstanceIds)
+}
+
+func (p Principal) HasRole(role Role) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the principal, acting in the given role, may act on the account and, if not empty, the instance
+func (p Principal) canAccess(role Role, accId string, insId string) bool {
+	if role == LICENSE_ADMIN {
+		return true
+	}
+	if p.AccountId == "" {
+		// customer admins are always scoped to an account
+		return role != CUSTOMER_ADMIN
+	}
+	if p.AccountId != accId {
+		return false
+	}
+	if insId == "" || len(p.InstanceIds) == 0 {
+		return true
+	}
+	for _, id := range p.InstanceIds {
+		if id == insId {
+			return true
+		}
+	}
+	return false
+}
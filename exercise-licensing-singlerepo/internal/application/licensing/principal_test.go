@@ -0,0 +1,155 @@
+package licensing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+	"gotest.tools/v3/assert"
+)
+
+// Principals of the tests of this package, each holding the role of one caller group
+var (
+	testLicenseAdmin = NewLicenseAdmin("license-admin")
+	testApplication  = NewApplicationPrincipal("outreach-app")
+)
+
+func testCustomerAdmin(accId string) Principal {
+	return NewCustomerAdmin("customer-admin", accId)
+}
+
+func TestAuthorization(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := NewLicensingService(&licRepo, &pkgRepo)
+
+	accId := "acc-1"
+	pkgId := "pkg:base-optimize-2022"
+	insId := "ins-101"
+	licenses, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-1", pkgId, 2)
+	assert.NilError(t, err)
+
+	t.Run("only license admins issue licenses", func(t *testing.T) {
+		_, err := ls.IssueLicenses(ctx, testCustomerAdmin(accId), accId, "sub-1", pkgId, 100)
+		assert.Check(t, errors.Is(err, ErrPermissionDenied))
+		assert.Error(t, err, "permission denied: principal customer-admin lacks role LICENSE_ADMIN required to IssueLicenses")
+		_, err = ls.IssueLicenses(ctx, testApplication, accId, "sub-1", pkgId, 100)
+		assert.Check(t, errors.Is(err, ErrPermissionDenied))
+	})
+
+	t.Run("customer admins manage only their own account", func(t *testing.T) {
+		_, err := ls.AssignSpecificLicense(ctx, testCustomerAdmin("acc-2"), licenses[0].Id(), accId, insId, "usr-alice")
+		assert.Error(t, err, "permission denied: principal customer-admin cannot AssignSpecificLicense of account accId=acc-1, instance insId=ins-101")
+		_, err = ls.CountTotalUnassignedLicensesOfPackage(ctx, testCustomerAdmin("acc-2"), accId, pkgId)
+		assert.Error(t, err, "permission denied: principal customer-admin cannot CountTotalUnassignedLicensesOfPackage of account accId=acc-1")
+		_, err = ls.AssignSpecificLicense(ctx, Principal{Id: "unscoped", Roles: []Role{CUSTOMER_ADMIN}}, licenses[0].Id(), accId, insId, "usr-alice")
+		assert.Check(t, errors.Is(err, ErrPermissionDenied))
+
+		_, err = ls.AssignSpecificLicense(ctx, testCustomerAdmin(accId), licenses[0].Id(), accId, insId, "usr-alice")
+		assert.NilError(t, err)
+	})
+
+	t.Run("customer admins scoped to instances manage only those", func(t *testing.T) {
+		admin := NewCustomerAdmin("instance-admin", accId, insId)
+		_, err := ls.AssignAvailableLicenseOfPackage(ctx, admin, pkgId, accId, "ins-202", "usr-bob")
+		assert.Error(t, err, "permission denied: principal instance-admin cannot AssignAvailableLicenseOfPackage of account accId=acc-1, instance insId=ins-202")
+		_, err = ls.AssignAvailableLicenseOfPackage(ctx, admin, pkgId, accId, insId, "usr-bob")
+		assert.NilError(t, err)
+	})
+
+	t.Run("customer admins scoped to instances take licenses only from those", func(t *testing.T) {
+		_, err := ls.AssignSpecificLicense(ctx, testCustomerAdmin(accId), licenses[0].Id(), accId, "ins-202", "usr-carol")
+		assert.NilError(t, err)
+
+		admin := NewCustomerAdmin("instance-admin", accId, insId)
+		_, err = ls.AssignSpecificLicense(ctx, admin, licenses[0].Id(), accId, insId, "usr-alice")
+		assert.Check(t, errors.Is(err, ErrPermissionDenied))
+		assert.Error(t, err, "permission denied: principal instance-admin cannot AssignSpecificLicense of account accId=acc-1, instance insId=ins-202")
+		lic, err := licRepo.GetLicenseById(ctx, licenses[0].Id())
+		assert.NilError(t, err)
+		assert.Equal(t, lic.AssignedToLicensee().(licensing.InstanceUser).InstanceId, "ins-202")
+
+		_, err = ls.AssignSpecificLicense(ctx, NewCustomerAdmin("instance-admin", accId, insId, "ins-202"), licenses[0].Id(), accId, insId, "usr-alice")
+		assert.NilError(t, err)
+	})
+
+	t.Run("customer admins scoped to instances cannot read the whole account", func(t *testing.T) {
+		admin := NewCustomerAdmin("instance-admin", accId, insId)
+		_, err := ls.ListLicenses(ctx, admin, accId, licensing.LicenseQuery{})
+		assert.Error(t, err, "permission denied: principal instance-admin is scoped to instances [ins-101], cannot ListLicenses of the whole account accId=acc-1")
+		_, err = ls.CountTotalUnassignedLicensesOfPackage(ctx, admin, accId, pkgId)
+		assert.Check(t, errors.Is(err, ErrPermissionDenied))
+		_, err = ls.GatherLicenseAssignmentSummary(ctx, admin, accId)
+		assert.Check(t, errors.Is(err, ErrPermissionDenied))
+
+		_, err = ls.GatherLicenseAssignmentSummary(ctx, testCustomerAdmin(accId), accId)
+		assert.NilError(t, err)
+	})
+
+	t.Run("only applications verify entitlements", func(t *testing.T) {
+		_, err := ls.VerifyEntitlement(ctx, testCustomerAdmin(accId), accId, insId, "usr-alice", "cpb:sequence")
+		assert.Check(t, errors.Is(err, ErrPermissionDenied))
+		_, err = ls.ListEntitlements(ctx, testLicenseAdmin, accId, insId, "usr-alice")
+		assert.Check(t, errors.Is(err, ErrPermissionDenied))
+
+		entitlement, err := ls.VerifyEntitlement(ctx, testApplication, accId, insId, "usr-alice", "cpb:sequence")
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, true)
+	})
+
+	t.Run("applications scoped to an account act only for it", func(t *testing.T) {
+		tenantApp := Principal{Id: "tenant-app", Roles: []Role{APPLICATION}, AccountId: "acc-2"}
+		_, err := ls.VerifyEntitlement(ctx, tenantApp, accId, insId, "usr-alice", "cpb:sequence")
+		assert.Error(t, err, "permission denied: principal tenant-app cannot VerifyEntitlement of account accId=acc-1, instance insId=ins-101")
+	})
+}
+
+func TestAuthorizationOfTheLicenseHolder(t *testing.T) {
+
+	ctx := context.Background()
+	accId := "acc-1"
+	pkgId := "pkg:base-optimize-2022"
+	insId := "ins-101"
+	inMem := storage.NewLicenseRepoInMem()
+	var licRepo licensing.LicenseRepository = inMem
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := NewLicensingService(&licRepo, &pkgRepo)
+	licenses, err := ls.IssueLicenses(ctx, testLicenseAdmin, accId, "sub-1", pkgId, 1)
+	assert.NilError(t, err)
+	licId := licenses[0].Id()
+
+	// the license is reassigned to another instance right after it is first loaded for authorization
+	licRepo = &reassigningLicenseRepo{LicenseRepository: inMem, insId: "ins-202"}
+	admin := NewCustomerAdmin("instance-admin", accId, insId)
+	_, err = ls.AssignSpecificLicense(ctx, admin, licId, accId, insId, "usr-alice")
+	assert.Error(t, err, "permission denied: principal instance-admin cannot AssignSpecificLicense of account accId=acc-1, instance insId=ins-202")
+
+	lic, err := inMem.GetLicenseById(ctx, licId)
+	assert.NilError(t, err)
+	assert.Equal(t, lic.AssignedToLicensee().(licensing.InstanceUser).InstanceId, "ins-202")
+}
+
+// License repository reassigning a license to a user of the given instance once it was first loaded
+type reassigningLicenseRepo struct {
+	licensing.LicenseRepository
+	insId string
+	done  bool
+}
+
+func (r *reassigningLicenseRepo) GetLicenseById(ctx context.Context, licId string) (*licensing.License, error) {
+	lic, err := r.LicenseRepository.GetLicenseById(ctx, licId)
+	if err != nil || r.done {
+		return lic, err
+	}
+	r.done = true
+	holder, err := r.LicenseRepository.GetLicenseById(ctx, licId)
+	if err != nil {
+		return nil, err
+	}
+	holder.Assign(licensing.NewInstanceUser(r.insId, "usr-carol"))
+	return lic, r.LicenseRepository.UpdateLicense(ctx, licId, holder)
+}
@@ -0,0 +1,241 @@
+package licensing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+	"gotest.tools/v3/assert"
+)
+
+// Every use case must stay within the customer account it is invoked for
+func TestCrossTenantAccess(t *testing.T) {
+
+	ctx := context.Background()
+	cpbRepoInMem := storage.NewCapabilityRepoInMem()
+	var cpbRepo licensing.CapabilityRepository = cpbRepoInMem
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMemWithCapabilityRepo(cpbRepoInMem)
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var poolRepo licensing.CapacityPoolRepository = storage.NewCapacityPoolRepoInMem()
+	cs := NewCatalogService(&cpbRepo, &pkgRepo)
+	ls := NewLicensingService(&licRepo, &pkgRepo,
+		WithCapacityPoolRepository(&poolRepo),
+		WithEntitlementCache(NewEntitlementCache(time.Minute)))
+
+	pkgId := "pkg:addon-enrichment-2022"
+	cpbId := "cpb:enrichment"
+	enrichmentCpb := licensing.Capability{
+		Id:                cpbId,
+		DisplayName:       "Contact Enrichment",
+		HasCapacityLimit:  true,
+		CapacityLimit:     1000,
+		CapacityLimitUnit: "LookupsPerDay",
+		CapacityScope:     licensing.ACCOUNT_POOL_PER_SEAT}
+	_, err := cs.CreateCapability(ctx, enrichmentCpb)
+	assert.NilError(t, err)
+	_, err = cs.CreatePackage(ctx, &licensing.Package{Id: pkgId, Name: "Enrichment Add-On", IncludedCapabilities: []licensing.Capability{enrichmentCpb}})
+	assert.NilError(t, err)
+
+	// victim account with 3 licenses, one assigned to alice; attacker account with 1 license, assigned to eve
+	victimAccId, victimInsId, aliceId := "acc-victim", "ins-101", "usr-alice"
+	attackerAccId, attackerInsId, eveId := "acc-attacker", "ins-666", "usr-eve"
+	victimLicenses, err := ls.IssueLicenses(ctx, testLicenseAdmin, victimAccId, "sub-1", pkgId, 3)
+	assert.NilError(t, err)
+	_, err = ls.AssignSpecificLicense(ctx, testCustomerAdmin(victimAccId), victimLicenses[0].Id(), victimAccId, victimInsId, aliceId)
+	assert.NilError(t, err)
+	_, err = ls.IssueLicenses(ctx, testLicenseAdmin, attackerAccId, "sub-2", pkgId, 1)
+	assert.NilError(t, err)
+	_, err = ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(attackerAccId), pkgId, attackerAccId, attackerInsId, eveId)
+	assert.NilError(t, err)
+
+	t.Run("IssueLicenses does not add to another account", func(t *testing.T) {
+		count, err := ls.CountTotalUnassignedLicensesOfPackage(ctx, testCustomerAdmin(victimAccId), victimAccId, pkgId)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 2)
+	})
+
+	t.Run("AssignSpecificLicense of another account's license is not found", func(t *testing.T) {
+		for _, lic := range victimLicenses[:2] {
+			_, err := ls.AssignSpecificLicense(ctx, testCustomerAdmin(attackerAccId), lic.Id(), attackerAccId, attackerInsId, eveId)
+			assert.Error(t, err, "license not found for id="+lic.Id())
+		}
+		lic, err := licRepo.GetLicenseById(ctx, victimLicenses[0].Id())
+		assert.NilError(t, err)
+		assert.Equal(t, lic.AssignedToLicensee().LicenseeId(), licensing.NewInstanceUser(victimInsId, aliceId).LicenseeId())
+		assert.Equal(t, victimLicenses[1].IsAssigned(), false)
+	})
+
+	t.Run("AssignAvailableLicenseOfPackage does not draw from another account", func(t *testing.T) {
+		_, err := ls.AssignAvailableLicenseOfPackage(ctx, testCustomerAdmin(attackerAccId), pkgId, attackerAccId, attackerInsId, "usr-mallory")
+		assert.Error(t, err, "no more unassigned license for pkgId="+pkgId)
+	})
+
+	t.Run("CountTotalUnassignedLicensesOfPackage counts only the account's licenses", func(t *testing.T) {
+		count, err := ls.CountTotalUnassignedLicensesOfPackage(ctx, testCustomerAdmin(attackerAccId), attackerAccId, pkgId)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 0)
+	})
+
+	t.Run("RenewLicenses does not renew another account's subscription", func(t *testing.T) {
+		_, err := ls.RenewLicenses(ctx, testLicenseAdmin, attackerAccId, "sub-1", "sub-3")
+		assert.Error(t, err, "no active license found for subId=sub-1")
+		page, err := ls.ListLicenses(ctx, testCustomerAdmin(victimAccId), victimAccId, licensing.LicenseQuery{SubscriptionId: "sub-3"})
+		assert.NilError(t, err)
+		assert.Equal(t, len(page.Licenses), 0)
+	})
+
+	t.Run("TrueDownLicenses does not expire another account's licenses", func(t *testing.T) {
+		expired, err := ls.TrueDownLicenses(ctx, testLicenseAdmin, attackerAccId, "sub-1", pkgId, 0)
+		assert.NilError(t, err)
+		assert.Equal(t, len(expired), 0)
+		count, err := ls.CountTotalUnassignedLicensesOfPackage(ctx, testCustomerAdmin(victimAccId), victimAccId, pkgId)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 2)
+	})
+
+	t.Run("AssignAvailableLicensesOfPackage does not draw from another account", func(t *testing.T) {
+		_, err := ls.AssignAvailableLicensesOfPackage(ctx, testCustomerAdmin(attackerAccId), pkgId, attackerAccId, attackerInsId, []string{"usr-mallory", "usr-trent"})
+		assert.Error(t, err, "no more unassigned license for pkgId="+pkgId)
+	})
+
+	t.Run("ReserveOfflineSeats does not reserve another account's licenses", func(t *testing.T) {
+		_, err := ls.ReserveOfflineSeats(ctx, testCustomerAdmin(attackerAccId), attackerAccId, attackerInsId, pkgId, 2)
+		assert.Error(t, err, "cannot export 2 seats of package pkgId="+pkgId+" to instance insId=ins-666, account accId=acc-attacker has only 1 licenses available")
+		count, err := ls.CountTotalUnassignedLicensesOfPackage(ctx, testCustomerAdmin(victimAccId), victimAccId, pkgId)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 2)
+	})
+
+	t.Run("ListLicenses lists only the account's licenses", func(t *testing.T) {
+		page, err := ls.ListLicenses(ctx, testCustomerAdmin(attackerAccId), attackerAccId, licensing.LicenseQuery{AccountId: victimAccId})
+		assert.NilError(t, err)
+		assert.Equal(t, len(page.Licenses), 1)
+		assert.Equal(t, page.Licenses[0].PossessingCustomerAccountId(), attackerAccId)
+	})
+
+	t.Run("GatherLicenseAssignmentSummary sums only the account's licenses", func(t *testing.T) {
+		summary, err := ls.GatherLicenseAssignmentSummary(ctx, testCustomerAdmin(attackerAccId), attackerAccId)
+		assert.NilError(t, err)
+		assert.Equal(t, summary.AccountId, attackerAccId)
+		assert.Equal(t, summary.Totals.Issued, 1)
+		assert.Equal(t, summary.Totals.Assigned, 1)
+	})
+
+	t.Run("customer admins of another account are denied every use case", func(t *testing.T) {
+		attacker := testCustomerAdmin(attackerAccId)
+		useCases := map[string]func() error{
+			"AssignSpecificLicense": func() error {
+				_, err := ls.AssignSpecificLicense(ctx, attacker, victimLicenses[1].Id(), victimAccId, victimInsId, eveId)
+				return err
+			},
+			"AssignAvailableLicenseOfPackage": func() error {
+				_, err := ls.AssignAvailableLicenseOfPackage(ctx, attacker, pkgId, victimAccId, victimInsId, eveId)
+				return err
+			},
+			"AssignAvailableLicensesOfPackage": func() error {
+				_, err := ls.AssignAvailableLicensesOfPackage(ctx, attacker, pkgId, victimAccId, victimInsId, []string{eveId})
+				return err
+			},
+			"ReserveOfflineSeats": func() error {
+				_, err := ls.ReserveOfflineSeats(ctx, attacker, victimAccId, victimInsId, pkgId, 1)
+				return err
+			},
+			"AllocatePooledCapacityToUser": func() error {
+				_, err := ls.AllocatePooledCapacityToUser(ctx, attacker, victimAccId, cpbId, victimInsId, eveId, 3000)
+				return err
+			},
+			"AllocatePooledCapacityToInstance": func() error {
+				_, err := ls.AllocatePooledCapacityToInstance(ctx, attacker, victimAccId, cpbId, victimInsId, 3000)
+				return err
+			},
+			"ListLicenses": func() error {
+				_, err := ls.ListLicenses(ctx, attacker, victimAccId, licensing.LicenseQuery{})
+				return err
+			},
+			"CountTotalUnassignedLicensesOfPackage": func() error {
+				_, err := ls.CountTotalUnassignedLicensesOfPackage(ctx, attacker, victimAccId, pkgId)
+				return err
+			},
+			"GatherLicenseAssignmentSummary": func() error {
+				_, err := ls.GatherLicenseAssignmentSummary(ctx, attacker, victimAccId)
+				return err
+			},
+		}
+		for useCase, call := range useCases {
+			err := call()
+			assert.Check(t, errors.Is(err, ErrPermissionDenied), "%s: %v", useCase, err)
+		}
+		count, err := ls.CountTotalUnassignedLicensesOfPackage(ctx, testCustomerAdmin(victimAccId), victimAccId, pkgId)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 2)
+	})
+
+	t.Run("applications scoped to another account are denied every use case", func(t *testing.T) {
+		attackerApp := Principal{Id: "attacker-app", Roles: []Role{APPLICATION}, AccountId: attackerAccId}
+		_, err := ls.VerifyEntitlement(ctx, attackerApp, victimAccId, victimInsId, aliceId, cpbId)
+		assert.Check(t, errors.Is(err, ErrPermissionDenied), err)
+		_, err = ls.ListEntitlements(ctx, attackerApp, victimAccId, victimInsId, aliceId)
+		assert.Check(t, errors.Is(err, ErrPermissionDenied), err)
+		_, err = ls.RecordCapacityUsage(ctx, attackerApp, victimAccId, victimInsId, aliceId, cpbId, 3000)
+		assert.Check(t, errors.Is(err, ErrPermissionDenied), err)
+	})
+
+	t.Run("VerifyEntitlement ignores licenses of another account", func(t *testing.T) {
+		// cached on behalf of the victim account first
+		entitlement, err := ls.VerifyEntitlement(ctx, testApplication, victimAccId, victimInsId, aliceId, cpbId)
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, true)
+
+		entitlement, err = ls.VerifyEntitlement(ctx, testApplication, attackerAccId, victimInsId, aliceId, cpbId)
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, false)
+	})
+
+	t.Run("ListEntitlements ignores licenses of another account", func(t *testing.T) {
+		entitlements, err := ls.ListEntitlements(ctx, testApplication, attackerAccId, victimInsId, aliceId)
+		assert.NilError(t, err)
+		assert.Equal(t, len(entitlements), 0)
+	})
+
+	t.Run("AllocatePooledCapacityToUser draws only from the account's pool", func(t *testing.T) {
+		_, err := ls.AllocatePooledCapacityToUser(ctx, testCustomerAdmin(attackerAccId), attackerAccId, cpbId, attackerInsId, eveId, 2000)
+		assert.Error(t, err, "cannot allocate 2000 LookupsPerDay of capability cpbId=cpb:enrichment, only 1000 of 1000 are unallocated")
+	})
+
+	t.Run("AllocatePooledCapacityToInstance draws only from the account's pool", func(t *testing.T) {
+		pool, err := ls.AllocatePooledCapacityToInstance(ctx, testCustomerAdmin(attackerAccId), attackerAccId, cpbId, attackerInsId, 1000)
+		assert.NilError(t, err)
+		assert.Equal(t, pool.AccountId(), attackerAccId)
+
+		victimPool, err := ls.AllocatePooledCapacityToInstance(ctx, testCustomerAdmin(victimAccId), victimAccId, cpbId, victimInsId, 0)
+		assert.NilError(t, err)
+		assert.Equal(t, victimPool.TotalAllocated(), 0)
+		assert.Equal(t, victimPool.TotalLimit(), 3000)
+	})
+
+	t.Run("RecordCapacityUsage does not drain another account's pool", func(t *testing.T) {
+		entitlement, err := ls.RecordCapacityUsage(ctx, testApplication, attackerAccId, attackerInsId, eveId, cpbId, 1000)
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsPersonalAllocationExhausted, true)
+
+		entitlement, err = ls.VerifyEntitlement(ctx, testApplication, victimAccId, victimInsId, aliceId, cpbId)
+		assert.NilError(t, err)
+		assert.Equal(t, entitlement.IsEntitled, true)
+		assert.Equal(t, entitlement.RemainingCapacity, 3000)
+	})
+
+	t.Run("tenant-scoped repository hides other accounts", func(t *testing.T) {
+		scoped := licensing.NewTenantScopedLicenseRepository(licRepo, attackerAccId)
+		_, err := scoped.GetLicenseById(ctx, victimLicenses[0].Id())
+		assert.Error(t, err, "license not found for id="+victimLicenses[0].Id())
+		_, err = scoped.FindLicensesOfAccount(ctx, victimAccId)
+		assert.Error(t, err, "account accId=acc-victim is outside of the tenant scope accId=acc-attacker")
+		err = scoped.UpdateLicense(ctx, victimLicenses[1].Id(), victimLicenses[1])
+		assert.Error(t, err, "account accId=acc-victim is outside of the tenant scope accId=acc-attacker")
+		err = scoped.CreateLicense(ctx, licensing.NewIssuedLicense(victimAccId, "sub-1", victimLicenses[0].LicensedPackage()))
+		assert.Error(t, err, "account accId=acc-victim is outside of the tenant scope accId=acc-attacker")
+	})
+}
@@ -1,5 +1,46 @@
 package licensing
 
+//
+// Capacity merge rule "enum"
+//
+type CapacityMergeRule int
+
+const (
+	// the highest limit among the user's licenses applies
+	MERGE_MAX CapacityMergeRule = iota
+	// the limits of all the user's licenses add up
+	MERGE_SUM
+	// the limit of the user's earliest issued license applies
+	MERGE_FIRST_WINS
+)
+
+func (r CapacityMergeRule) String() string {
+	return [...]string{"MERGE_MAX", "MERGE_SUM", "MERGE_FIRST_WINS"}[r]
+}
+
+//
+// Capacity scope "enum"
+//
+type CapacityScope int
+
+const (
+	// every user gets the capacity limit for themselves
+	PER_USER CapacityScope = iota
+	// the account shares one pool; each license granting the capability adds its capacity limit to the pool
+	ACCOUNT_POOL_PER_SEAT
+	// the account shares one pool of the capacity limit, regardless of its seat count
+	ACCOUNT_POOL_FLAT
+)
+
+func (s CapacityScope) String() string {
+	return [...]string{"PER_USER", "ACCOUNT_POOL_PER_SEAT", "ACCOUNT_POOL_FLAT"}[s]
+}
+
+// Whether the capacity is bought per account and shared by all its users
+func (s CapacityScope) IsPooled() bool {
+	return s != PER_USER
+}
+
 // Definition: A unit of software functionality at which user’s entitlement is evaluated.
 // DDD Classification: Entity
 type Capability struct {
@@ -18,4 +59,23 @@ type Capability struct {
 
 	// Unit of the capacity limit
 	CapacityLimitUnit string
+
+	// How the capacity limits combine when a user holds multiple licenses granting this capability
+	CapacityMergeRule CapacityMergeRule
+
+	// Whether the capacity limit applies to every user, or to a pool shared by the account
+	CapacityScope CapacityScope
+
+	// Ids of capabilities implied by this capability, e.g. advanced reporting implies basic reporting.
+	// Whoever is entitled to this capability is also entitled to the implied ones.
+	ImpliedCapabilityIds []string
+
+	// Ids of capabilities that must be entitled as well for this capability to be entitled
+	RequiredCapabilityIds []string
+
+	// Ids of capabilities that cannot be included in the same package as this capability
+	ConflictingCapabilityIds []string
+
+	// True if this capability is retired and cannot be included in new packages
+	IsArchived bool
 }
@@ -0,0 +1,212 @@
+package licensing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Definition: The set of all known capabilities and the relationships between them
+// (implies, requires, conflicts-with).
+//
+// A package only needs to list its headline capabilities; whatever they imply is
+// included transitively, so basic capabilities don't have to be copied into every higher package.
+//
+// DDD Classification: Value Object
+type CapabilityCatalog struct {
+	capabilities map[string]Capability
+}
+
+// Builds a catalog of the given capabilities, rejecting invalid relationships
+func NewCapabilityCatalog(capabilities []Capability) (*CapabilityCatalog, error) {
+	c := &CapabilityCatalog{capabilities: make(map[string]Capability)}
+	for _, cpb := range capabilities {
+		if _, ok := c.capabilities[cpb.Id]; ok {
+			return nil, fmt.Errorf("duplicate capability id=%s in catalog", cpb.Id)
+		}
+		c.capabilities[cpb.Id] = cpb
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Get the capability of the given id from the catalog
+func (c *CapabilityCatalog) GetCapability(cpbId string) (Capability, bool) {
+	cpb, ok := c.capabilities[cpbId]
+	return cpb, ok
+}
+
+// All capabilities of the catalog, sorted by id
+func (c *CapabilityCatalog) Capabilities() []Capability {
+	results := make([]Capability, 0, len(c.capabilities))
+	for _, cpb := range c.capabilities {
+		results = append(results, cpb)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Id < results[j].Id })
+	return results
+}
+
+// Checks that every relationship references a known capability, that neither implications
+// nor requirements form a cycle, and that no capability implies what it conflicts with
+func (c *CapabilityCatalog) Validate() error {
+	for _, cpb := range c.Capabilities() {
+		relationships := []struct {
+			name       string
+			relatedIds []string
+		}{
+			{"implies", cpb.ImpliedCapabilityIds},
+			{"requires", cpb.RequiredCapabilityIds},
+			{"conflicts-with", cpb.ConflictingCapabilityIds},
+		}
+		for _, relationship := range relationships {
+			for _, relatedId := range relationship.relatedIds {
+				if _, ok := c.capabilities[relatedId]; !ok {
+					return fmt.Errorf("capability id=%s %s unknown capability id=%s", cpb.Id, relationship.name, relatedId)
+				}
+				if relatedId == cpb.Id {
+					return fmt.Errorf("capability id=%s %s itself", cpb.Id, relationship.name)
+				}
+			}
+		}
+	}
+	if err := c.detectCycle("implies", func(cpb Capability) []string { return cpb.ImpliedCapabilityIds }); err != nil {
+		return err
+	}
+	if err := c.detectCycle("requires", func(cpb Capability) []string { return cpb.RequiredCapabilityIds }); err != nil {
+		return err
+	}
+	for _, cpb := range c.Capabilities() {
+		if err := c.checkNoConflicts(c.ImpliedClosure([]string{cpb.Id})); err != nil {
+			return fmt.Errorf("capability id=%s implies conflicting capabilities: %w", cpb.Id, err)
+		}
+	}
+	return nil
+}
+
+// Checks that the package only includes known capabilities, limited in the catalog's unit,
+// and that none of them, including the implied ones, conflict with each other
+func (c *CapabilityCatalog) ValidatePackage(pkg *Package) error {
+	for _, includedCpb := range pkg.IncludedCapabilities {
+		cpb, ok := c.capabilities[includedCpb.Id]
+		if !ok {
+			return fmt.Errorf("package id=%s includes unknown capability id=%s", pkg.Id, includedCpb.Id)
+		}
+		// limits of different packages can only be merged when measured in the same unit
+		if includedCpb.HasCapacityLimit && cpb.HasCapacityLimit && includedCpb.CapacityLimitUnit != cpb.CapacityLimitUnit {
+			return fmt.Errorf("package id=%s limits capability id=%s in %s, but the catalog in %s",
+				pkg.Id, includedCpb.Id, includedCpb.CapacityLimitUnit, cpb.CapacityLimitUnit)
+		}
+	}
+	if err := c.checkNoConflicts(c.ImpliedClosure(pkg.IncludedCapabilityIds())); err != nil {
+		return fmt.Errorf("package id=%s: %w", pkg.Id, err)
+	}
+	return nil
+}
+
+// Returns the given capability ids plus every capability they imply transitively, sorted
+func (c *CapabilityCatalog) ImpliedClosure(cpbIds []string) []string {
+	return c.closure(cpbIds, func(cpb Capability) []string { return cpb.ImpliedCapabilityIds })
+}
+
+// Returns the given capability ids plus every capability they require transitively, sorted
+func (c *CapabilityCatalog) RequiredClosure(cpbIds []string) []string {
+	return c.closure(cpbIds, func(cpb Capability) []string { return cpb.RequiredCapabilityIds })
+}
+
+// Returns the capabilities a package grants: its included capabilities plus the implied ones.
+// Included capabilities keep the package's own definition (e.g., its capacity limit),
+// implied ones take the catalog definition.
+func (c *CapabilityCatalog) EffectiveCapabilities(pkg *Package) []Capability {
+	results := make([]Capability, 0, len(pkg.IncludedCapabilities))
+	for _, cpbId := range c.ImpliedClosure(pkg.IncludedCapabilityIds()) {
+		if includedCpb, ok := pkg.GetIncludedCapability(cpbId); ok {
+			results = append(results, includedCpb)
+		} else if cpb, ok := c.capabilities[cpbId]; ok {
+			results = append(results, cpb)
+		}
+	}
+	return results
+}
+
+func (c *CapabilityCatalog) closure(cpbIds []string, edges func(cpb Capability) []string) []string {
+	visited := make(map[string]bool)
+	pending := append([]string{}, cpbIds...)
+	for len(pending) > 0 {
+		cpbId := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if visited[cpbId] {
+			continue
+		}
+		visited[cpbId] = true
+		if cpb, ok := c.capabilities[cpbId]; ok {
+			pending = append(pending, edges(cpb)...)
+		}
+	}
+	results := make([]string, 0, len(visited))
+	for cpbId := range visited {
+		results = append(results, cpbId)
+	}
+	sort.Strings(results)
+	return results
+}
+
+func (c *CapabilityCatalog) checkNoConflicts(cpbIds []string) error {
+	present := make(map[string]bool)
+	for _, cpbId := range cpbIds {
+		present[cpbId] = true
+	}
+	for _, cpbId := range cpbIds {
+		for _, conflictingId := range c.capabilities[cpbId].ConflictingCapabilityIds {
+			if present[conflictingId] {
+				return fmt.Errorf("capability id=%s conflicts with capability id=%s", cpbId, conflictingId)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *CapabilityCatalog) detectCycle(relationship string, edges func(cpb Capability) []string) error {
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+	state := make(map[string]int)
+	path := make([]string, 0)
+
+	var visit func(cpbId string) error
+	visit = func(cpbId string) error {
+		switch state[cpbId] {
+		case inProgress:
+			start := 0
+			for i, id := range path {
+				if id == cpbId {
+					start = i
+				}
+			}
+			cycle := append(append([]string{}, path[start:]...), cpbId)
+			return fmt.Errorf("capability %s relationships form a cycle: %s", relationship, strings.Join(cycle, " -> "))
+		case done:
+			return nil
+		}
+		state[cpbId] = inProgress
+		path = append(path, cpbId)
+		for _, nextId := range edges(c.capabilities[cpbId]) {
+			if err := visit(nextId); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[cpbId] = done
+		return nil
+	}
+
+	for _, cpb := range c.Capabilities() {
+		if err := visit(cpb.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
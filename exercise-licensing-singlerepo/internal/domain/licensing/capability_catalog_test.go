@@ -0,0 +1,62 @@
+package licensing
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestCapabilityCatalog(t *testing.T) {
+
+	basic := Capability{Id: "cpb:basic-reporting"}
+	advanced := Capability{Id: "cpb:advanced-reporting", ImpliedCapabilityIds: []string{"cpb:basic-reporting"}}
+	premium := Capability{Id: "cpb:premium-reporting", ImpliedCapabilityIds: []string{"cpb:advanced-reporting"}}
+	calendaring := Capability{Id: "cpb:calendaring"}
+	kaia := Capability{Id: "cpb:kaia-meeting", RequiredCapabilityIds: []string{"cpb:calendaring"}}
+
+	t.Run("implied closure is transitive", func(t *testing.T) {
+		catalog, err := NewCapabilityCatalog([]Capability{basic, advanced, premium})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, catalog.ImpliedClosure([]string{"cpb:premium-reporting"}),
+			[]string{"cpb:advanced-reporting", "cpb:basic-reporting", "cpb:premium-reporting"})
+	})
+
+	t.Run("implication cycle is rejected", func(t *testing.T) {
+		cyclicBasic := Capability{Id: "cpb:basic-reporting", ImpliedCapabilityIds: []string{"cpb:premium-reporting"}}
+		_, err := NewCapabilityCatalog([]Capability{cyclicBasic, advanced, premium})
+		assert.Error(t, err, "capability implies relationships form a cycle: "+
+			"cpb:advanced-reporting -> cpb:basic-reporting -> cpb:premium-reporting -> cpb:advanced-reporting")
+	})
+
+	t.Run("unknown related capability is rejected", func(t *testing.T) {
+		_, err := NewCapabilityCatalog([]Capability{kaia})
+		assert.Error(t, err, "capability id=cpb:kaia-meeting requires unknown capability id=cpb:calendaring")
+	})
+
+	t.Run("package including conflicting capabilities is rejected", func(t *testing.T) {
+		legacy := Capability{Id: "cpb:legacy-reporting", ConflictingCapabilityIds: []string{"cpb:basic-reporting"}}
+		catalog, err := NewCapabilityCatalog([]Capability{basic, advanced, legacy})
+		assert.NilError(t, err)
+		pkg := &Package{Id: "pkg:test", IncludedCapabilities: []Capability{advanced, legacy}}
+		assert.Error(t, catalog.ValidatePackage(pkg),
+			"package id=pkg:test: capability id=cpb:legacy-reporting conflicts with capability id=cpb:basic-reporting")
+	})
+
+	t.Run("entitlement requires the required capabilities", func(t *testing.T) {
+		catalog, err := NewCapabilityCatalog([]Capability{calendaring, kaia})
+		assert.NilError(t, err)
+		usr := NewInstanceUser("ins-1", "usr-1")
+		kaiaOnly := NewIssuedLicense("acc-1", "sub-1", &Package{Id: "pkg:kaia", IncludedCapabilities: []Capability{kaia}})
+		kaiaOnly.Assign(usr)
+
+		entitlement := EvaluateEntitlement(usr, []*License{kaiaOnly}, catalog, "cpb:kaia-meeting", time.Now())
+		assert.Equal(t, entitlement.IsEntitled, false)
+		assert.DeepEqual(t, entitlement.MissingRequiredCapabilityIds, []string{"cpb:calendaring"})
+
+		calendar := NewIssuedLicense("acc-1", "sub-1", &Package{Id: "pkg:calendar", IncludedCapabilities: []Capability{calendaring}})
+		calendar.Assign(usr)
+		entitlement = EvaluateEntitlement(usr, []*License{kaiaOnly, calendar}, catalog, "cpb:kaia-meeting", time.Now())
+		assert.Equal(t, entitlement.IsEntitled, true)
+	})
+}
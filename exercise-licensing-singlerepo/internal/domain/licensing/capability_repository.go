@@ -0,0 +1,20 @@
+package licensing
+
+import "context"
+
+// Definition: Repository for Capability.
+// DDD Classification: Repository
+type CapabilityRepository interface {
+
+	// Create capability
+	CreateCapability(ctx context.Context, cpb Capability) error
+
+	// Update capability
+	UpdateCapability(ctx context.Context, cpb Capability) error
+
+	// Get capability by id
+	GetCapabilityById(ctx context.Context, cpbId string) (Capability, error)
+
+	// List all capabilities, including the archived ones
+	ListCapabilities(ctx context.Context) ([]Capability, error)
+}
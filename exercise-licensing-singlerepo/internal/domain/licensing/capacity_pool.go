@@ -0,0 +1,257 @@
+package licensing
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// CapacityPool represents a capacity bought per account rather than per seat (e.g., CRM sync calls),
+// shared by all users of the account.
+//
+// The total limit of the pool is derived from the account's active licenses, see DerivePoolLimit.
+// Customer admins can set aside slices of the pool for individual users or instances. Usage of a user
+// is charged to the user's slice, else to its instance's slice, else to the unallocated rest of the pool.
+//
+// Usage is counted per period of a UTC day, matching capacity units like "CallsPerDay".
+//
+// DDD Classification: Aggregate
+type CapacityPool struct {
+
+	// The customer account sharing this pool
+	accountId string
+
+	// The pooled capability
+	capabilityId string
+
+	// Total limit of the pool, derived from the account's active licenses
+	totalLimit int
+
+	// Unit of the total limit
+	capacityLimitUnit string
+
+	// Slices of the pool set aside, by holder id (a licensee id, or an instance holder id)
+	allocations map[string]int
+
+	// Usage of the current period, by holder id; unallocated usage is keyed by the empty id
+	usage map[string]int
+
+	// Start of the current usage period
+	periodStart time.Time
+
+	// Version of the persisted state this pool was loaded from, for optimistic concurrency control;
+	// 0 if never persisted
+	version int64
+}
+
+func NewCapacityPool(accId string, cpbId string) *CapacityPool {
+	return &CapacityPool{
+		accountId:    accId,
+		capabilityId: cpbId,
+		allocations:  make(map[string]int),
+		usage:        make(map[string]int),
+	}
+}
+
+// Holder id of an instance, for allocating a slice of the pool to all users of the instance
+func InstancePoolHolderId(insId string) string {
+	return fmt.Sprintf("INSTANCE:%s", insId)
+}
+
+// Derives the total limit of the capability's pool from the account's licenses:
+// the per-seat limits of all licenses in force at the given time granting the capability add up, while for
+// a flat pool the highest limit applies. Returns false if no license in force grants a limited capacity.
+//
+// DDD Classification: Domain Service
+func DerivePoolLimit(accountLicenses []*License, catalog *CapabilityCatalog, cpbId string, at time.Time) (int, string, bool) {
+	scope := PER_USER
+	if cpb, ok := catalog.GetCapability(cpbId); ok {
+		scope = cpb.CapacityScope
+	}
+	total, unit, found := 0, "", false
+	for _, lic := range accountLicenses {
+		if !lic.IsInForceAt(at) {
+			continue
+		}
+		for _, cpb := range catalog.EffectiveCapabilities(lic.LicensedPackage()) {
+			if cpb.Id != cpbId || !cpb.HasCapacityLimit {
+				continue
+			}
+			if scope == ACCOUNT_POOL_FLAT {
+				if cpb.CapacityLimit > total {
+					total = cpb.CapacityLimit
+				}
+			} else {
+				total += cpb.CapacityLimit
+			}
+			unit, found = cpb.CapacityLimitUnit, true
+		}
+	}
+	return total, unit, found
+}
+
+func (p *CapacityPool) String() string {
+	return fmt.Sprintf(`{accountId=%s, capabilityId=%s, totalLimit=%d %s, allocations=%v, usage=%v}`,
+		p.accountId, p.capabilityId, p.totalLimit, p.capacityLimitUnit, p.allocations, p.usage)
+}
+
+// Returns a deep copy of the pool, so that callers can hold on to a pool without sharing its state
+func (p *CapacityPool) Clone() *CapacityPool {
+	clone := *p
+	clone.allocations = make(map[string]int, len(p.allocations))
+	for holderId, amount := range p.allocations {
+		clone.allocations[holderId] = amount
+	}
+	clone.usage = make(map[string]int, len(p.usage))
+	for holderId, amount := range p.usage {
+		clone.usage[holderId] = amount
+	}
+	return &clone
+}
+
+// Version of the persisted state this pool was loaded from; 0 if never persisted.
+// CapacityPoolRepository.SaveCapacityPool only succeeds if the stored pool is still at this version.
+func (p *CapacityPool) Version() int64 {
+	return p.version
+}
+
+// Records the version the pool is persisted at. For use by CapacityPoolRepository implementations only.
+func (p *CapacityPool) SetPersistedVersion(version int64) {
+	p.version = version
+}
+
+func (p *CapacityPool) AccountId() string {
+	return p.accountId
+}
+
+func (p *CapacityPool) CapabilityId() string {
+	return p.capabilityId
+}
+
+func (p *CapacityPool) TotalLimit() int {
+	return p.totalLimit
+}
+
+func (p *CapacityPool) CapacityLimitUnit() string {
+	return p.capacityLimitUnit
+}
+
+// Slice of the pool set aside for the holder, 0 if none
+func (p *CapacityPool) Allocation(holderId string) int {
+	return p.allocations[holderId]
+}
+
+// Sum of all slices set aside
+func (p *CapacityPool) TotalAllocated() int {
+	total := 0
+	for _, amount := range p.allocations {
+		total += amount
+	}
+	return total
+}
+
+// Usage of the current period, over all holders
+func (p *CapacityPool) TotalUsage() int {
+	total := 0
+	for _, amount := range p.usage {
+		total += amount
+	}
+	return total
+}
+
+// Ids of the holders with a slice of the pool, sorted
+func (p *CapacityPool) AllocationHolderIds() []string {
+	results := make([]string, 0, len(p.allocations))
+	for holderId := range p.allocations {
+		results = append(results, holderId)
+	}
+	sort.Strings(results)
+	return results
+}
+
+// Updates the total limit after the account's licenses changed
+func (p *CapacityPool) Resize(totalLimit int, unit string) {
+	p.totalLimit = totalLimit
+	p.capacityLimitUnit = unit
+}
+
+// Sets aside a slice of the pool for the holder, replacing its previous slice; 0 removes the slice.
+// Slices cannot add up to more than the total limit.
+func (p *CapacityPool) Allocate(holderId string, amount int) error {
+	if amount < 0 {
+		return fmt.Errorf("allocation must not be negative, got %d", amount)
+	}
+	allocatedToOthers := p.TotalAllocated() - p.allocations[holderId]
+	if allocatedToOthers+amount > p.totalLimit {
+		return fmt.Errorf("cannot allocate %d %s of capability cpbId=%s, only %d of %d are unallocated",
+			amount, p.capacityLimitUnit, p.capabilityId, p.totalLimit-allocatedToOthers, p.totalLimit)
+	}
+	if amount == 0 {
+		delete(p.allocations, holderId)
+	} else {
+		p.allocations[holderId] = amount
+	}
+	return nil
+}
+
+// Charges the usage of a user of the given instance at the given time
+func (p *CapacityPool) RecordUsage(licenseeId string, insId string, amount int, at time.Time) error {
+	if amount < 0 {
+		return fmt.Errorf("usage must not be negative, got %d", amount)
+	}
+	p.rollPeriod(at)
+	p.usage[p.chargedHolderId(licenseeId, insId)] += amount
+	return nil
+}
+
+// Fills in the pool status of a user of the given instance into the entitlement
+func (p *CapacityPool) ApplyTo(ent *Entitlement, licenseeId string, insId string, at time.Time) {
+	p.rollPeriod(at)
+	ent.IsPooledCapacity = true
+	ent.CapacityLimitUnit = p.capacityLimitUnit
+	ent.HasCapacityLimit = true
+
+	remainingPool := p.totalLimit - p.TotalUsage()
+	ent.IsPoolExhausted = remainingPool <= 0
+
+	holderId := p.chargedHolderId(licenseeId, insId)
+	if holderId != "" {
+		ent.HasPersonalAllocation = true
+		ent.EffectiveCapacityLimit = p.allocations[holderId]
+		ent.RemainingCapacity = p.allocations[holderId] - p.usage[holderId]
+		ent.IsPersonalAllocationExhausted = ent.RemainingCapacity <= 0
+	} else {
+		// users without a slice share what is not set aside
+		ent.EffectiveCapacityLimit = p.totalLimit - p.TotalAllocated()
+		ent.RemainingCapacity = ent.EffectiveCapacityLimit - p.usage[""]
+		ent.IsPoolExhausted = ent.IsPoolExhausted || ent.RemainingCapacity <= 0
+	}
+	if ent.RemainingCapacity < 0 {
+		ent.RemainingCapacity = 0
+	}
+	if ent.IsEntitled && (ent.IsPoolExhausted || ent.IsPersonalAllocationExhausted) {
+		ent.IsEntitled = false
+		ent.IsEntitledToFeatureButExceedCapability = true
+	}
+}
+
+// Holder whose slice is charged for a user of the given instance; empty if neither has a slice
+func (p *CapacityPool) chargedHolderId(licenseeId string, insId string) string {
+	if _, ok := p.allocations[licenseeId]; ok {
+		return licenseeId
+	}
+	if _, ok := p.allocations[InstancePoolHolderId(insId)]; ok {
+		return InstancePoolHolderId(insId)
+	}
+	return ""
+}
+
+// Starts a new usage period when the given time is past the current period
+func (p *CapacityPool) rollPeriod(at time.Time) {
+	y, m, d := at.UTC().Date()
+	periodStart := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	if periodStart.After(p.periodStart) {
+		p.periodStart = periodStart
+		p.usage = make(map[string]int)
+	}
+}
@@ -0,0 +1,24 @@
+package licensing
+
+import (
+	"context"
+	"errors"
+)
+
+// Returned, wrapped, by GetCapacityPool when no pool was saved for the account and capability
+var ErrCapacityPoolNotFound = errors.New("capacity pool not found")
+
+// Returned, wrapped, by SaveCapacityPool when the pool was changed since it was loaded
+var ErrCapacityPoolVersionConflict = errors.New("capacity pool version conflict")
+
+// Definition: Repository for CapacityPool.
+// DDD Classification: Repository
+type CapacityPoolRepository interface {
+
+	// Get the pool of the capability shared by the customer account; ErrCapacityPoolNotFound if never saved
+	GetCapacityPool(ctx context.Context, accId string, cpbId string) (*CapacityPool, error)
+
+	// Create the pool, or replace it provided the stored pool is still at the version pool was loaded from
+	// (compare-and-swap); on success, pool is at the next version. Fails with ErrCapacityPoolVersionConflict otherwise.
+	SaveCapacityPool(ctx context.Context, pool *CapacityPool) error
+}
@@ -0,0 +1,171 @@
+package licensing
+
+import "sort"
+
+// Definition: What changed between two versions of a package, e.g. Accelerate 2022 to Accelerate 2023.
+//
+// Capabilities are compared as customers experience them, i.e. including the implied capabilities.
+//
+// DDD Classification: Value Object
+type PackageDiff struct {
+
+	// Id of the package compared from
+	FromPackageId string
+
+	// Id of the package compared to
+	ToPackageId string
+
+	// Readable name change; nil if the name is unchanged
+	NameChange *StringChange
+
+	// Capabilities granted by the "to" package only, sorted by id
+	AddedCapabilities []Capability
+
+	// Capabilities granted by the "from" package only, sorted by id
+	RemovedCapabilities []Capability
+
+	// Capabilities granted by both packages but defined differently, sorted by id
+	ChangedCapabilities []CapabilityChange
+}
+
+// A changed string value
+type StringChange struct {
+	From string
+	To   string
+}
+
+// Definition: How a capability granted by two package versions differs between them
+// DDD Classification: Value Object
+type CapabilityChange struct {
+
+	// Id of the changed capability
+	CapabilityId string
+
+	// Definition in the "from" package
+	From Capability
+
+	// Definition in the "to" package
+	To Capability
+
+	// True if the customer-facing display name changed
+	DisplayNameChanged bool
+
+	// True if the capacity limit or its unit changed, or the limit was added or removed
+	CapacityLimitChanged bool
+}
+
+// Definition: What changed between two packaging plans.
+//
+// Packages are matched by readable name, as a new version of a package has a new id
+// (e.g., "pkg:base-accelerate-2022" is replaced by "pkg:base-accelerate-2023").
+//
+// DDD Classification: Value Object
+type PackagingPlanDiff struct {
+
+	// Id of the packaging plan compared from
+	FromPlanId string
+
+	// Id of the packaging plan compared to
+	ToPlanId string
+
+	// Packages whose name only appears in the "to" plan, sorted by name
+	AddedPackages []*Package
+
+	// Packages whose name only appears in the "from" plan, sorted by name
+	RemovedPackages []*Package
+
+	// Diffs of the packages supported by both plans, sorted by name; unchanged packages included
+	PackageDiffs []PackageDiff
+}
+
+// True if the packages grant the same capabilities in the same way under the same name
+func (d PackageDiff) IsEmpty() bool {
+	return d.NameChange == nil && len(d.AddedCapabilities) == 0 && len(d.RemovedCapabilities) == 0 && len(d.ChangedCapabilities) == 0
+}
+
+// True if both plans support the same packages granting the same capabilities
+func (d PackagingPlanDiff) IsEmpty() bool {
+	for _, pkgDiff := range d.PackageDiffs {
+		if !pkgDiff.IsEmpty() {
+			return false
+		}
+	}
+	return len(d.AddedPackages) == 0 && len(d.RemovedPackages) == 0
+}
+
+// Compares two packages, using the catalog to resolve implied capabilities
+//
+// DDD Classification: Domain Service
+func DiffPackages(from *Package, to *Package, catalog *CapabilityCatalog) PackageDiff {
+	if catalog == nil {
+		catalog = &CapabilityCatalog{}
+	}
+	diff := PackageDiff{FromPackageId: from.Id, ToPackageId: to.Id}
+	if from.Name != to.Name {
+		diff.NameChange = &StringChange{From: from.Name, To: to.Name}
+	}
+
+	fromCpbs := make(map[string]Capability)
+	for _, cpb := range catalog.EffectiveCapabilities(from) {
+		fromCpbs[cpb.Id] = cpb
+	}
+	toCpbs := make(map[string]Capability)
+	for _, cpb := range catalog.EffectiveCapabilities(to) {
+		toCpbs[cpb.Id] = cpb
+		fromCpb, ok := fromCpbs[cpb.Id]
+		if !ok {
+			diff.AddedCapabilities = append(diff.AddedCapabilities, cpb)
+			continue
+		}
+		change := CapabilityChange{
+			CapabilityId:       cpb.Id,
+			From:               fromCpb,
+			To:                 cpb,
+			DisplayNameChanged: fromCpb.DisplayName != cpb.DisplayName,
+			CapacityLimitChanged: fromCpb.HasCapacityLimit != cpb.HasCapacityLimit ||
+				(cpb.HasCapacityLimit && (fromCpb.CapacityLimit != cpb.CapacityLimit || fromCpb.CapacityLimitUnit != cpb.CapacityLimitUnit)),
+		}
+		if change.DisplayNameChanged || change.CapacityLimitChanged {
+			diff.ChangedCapabilities = append(diff.ChangedCapabilities, change)
+		}
+	}
+	for _, cpb := range catalog.EffectiveCapabilities(from) {
+		if _, ok := toCpbs[cpb.Id]; !ok {
+			diff.RemovedCapabilities = append(diff.RemovedCapabilities, cpb)
+		}
+	}
+	return diff
+}
+
+// Compares two packaging plans, using the catalog to resolve implied capabilities
+//
+// DDD Classification: Domain Service
+func DiffPackagingPlans(from *PackagingPlan, to *PackagingPlan, catalog *CapabilityCatalog) PackagingPlanDiff {
+	diff := PackagingPlanDiff{FromPlanId: from.Id, ToPlanId: to.Id}
+
+	fromPkgs := make(map[string]*Package)
+	for _, pkg := range from.SupportedPackages {
+		fromPkgs[pkg.Name] = pkg
+	}
+	toPkgs := make(map[string]*Package)
+	for _, pkg := range sortedByName(to.SupportedPackages) {
+		toPkgs[pkg.Name] = pkg
+		if fromPkg, ok := fromPkgs[pkg.Name]; ok {
+			diff.PackageDiffs = append(diff.PackageDiffs, DiffPackages(fromPkg, pkg, catalog))
+		} else {
+			diff.AddedPackages = append(diff.AddedPackages, pkg)
+		}
+	}
+	for _, pkg := range sortedByName(from.SupportedPackages) {
+		if _, ok := toPkgs[pkg.Name]; !ok {
+			diff.RemovedPackages = append(diff.RemovedPackages, pkg)
+		}
+	}
+	return diff
+}
+
+func sortedByName(pkgs []*Package) []*Package {
+	results := append([]*Package{}, pkgs...)
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results
+}
@@ -16,4 +16,32 @@ type Entitlement struct {
 
 	// Capability id evaluated for entitlement
 	EvaluatedCapabilityId string
+
+	// Required capability ids the user is not entitled to, which prevent the entitlement
+	MissingRequiredCapabilityIds []string
+
+	// Whether the entitled capability is capped by a capacity limit
+	HasCapacityLimit bool
+
+	// Capacity limit in effect for the user, merged across all the user's licenses granting the capability
+	EffectiveCapacityLimit int
+
+	// Unit of the effective capacity limit
+	CapacityLimitUnit string
+
+	// True if the capacity is an account-level pool shared by all users, see CapacityPool.
+	// The effective capacity limit is then the user's personal allocation, or else the whole pool.
+	IsPooledCapacity bool
+
+	// True if the user has an allocation of the pool, personally or through its instance
+	HasPersonalAllocation bool
+
+	// Capacity left for the user in the current usage period
+	RemainingCapacity int
+
+	// True if the account's pool is used up
+	IsPoolExhausted bool
+
+	// True if the user's personal allocation of the pool is used up
+	IsPersonalAllocationExhausted bool
 }
@@ -0,0 +1,122 @@
+package licensing
+
+import (
+	"sort"
+	"time"
+)
+
+// Evaluates whether the licensee is entitled to the capability through the given licenses.
+//
+// The licensee is entitled when one of its licenses in force at the given time grants the capability, either directly
+// or implied through the catalog, and every capability it requires is granted as well.
+//
+// When several licenses grant the capability, their capacity limits are merged by the capability's
+// merge rule from the catalog. A grant without capacity limit makes the merged capacity unlimited,
+// unless the merge rule is first-wins.
+//
+// DDD Classification: Domain Service
+func EvaluateEntitlement(licensee Licensee, licenses []*License, catalog *CapabilityCatalog, cpbId string, at time.Time) Entitlement {
+	if catalog == nil {
+		catalog = &CapabilityCatalog{}
+	}
+	result := Entitlement{
+		IsEntitled:            false,
+		EvaluatedUserId:       licensee.LicenseeId(),
+		EvaluatedCapabilityId: cpbId,
+	}
+
+	granted := make(map[string]bool)
+	grants := make([]Capability, 0)
+	for _, lic := range sortedByIssuance(licenses) {
+		if !lic.IsInForceAt(at) {
+			continue
+		}
+		for _, cpb := range catalog.EffectiveCapabilities(lic.LicensedPackage()) {
+			granted[cpb.Id] = true
+			if cpb.Id == cpbId {
+				grants = append(grants, cpb)
+			}
+		}
+	}
+	if !granted[cpbId] {
+		return result
+	}
+
+	for _, requiredId := range catalog.RequiredClosure([]string{cpbId}) {
+		if !granted[requiredId] {
+			result.MissingRequiredCapabilityIds = append(result.MissingRequiredCapabilityIds, requiredId)
+		}
+	}
+	result.IsEntitled = len(result.MissingRequiredCapabilityIds) == 0
+
+	mergeRule := grants[0].CapacityMergeRule
+	if cpb, ok := catalog.GetCapability(cpbId); ok {
+		mergeRule = cpb.CapacityMergeRule
+	}
+	merged := mergeCapacityLimits(grants, mergeRule)
+	result.HasCapacityLimit = merged.HasCapacityLimit
+	result.EffectiveCapacityLimit = merged.CapacityLimit
+	result.CapacityLimitUnit = merged.CapacityLimitUnit
+	return result
+}
+
+// Evaluates the entitlements of the licensee to every capability its licenses in force at the given time grant,
+// sorted by capability id. Capabilities missing from the result are not entitled.
+//
+// DDD Classification: Domain Service
+func EvaluateEntitlements(licensee Licensee, licenses []*License, catalog *CapabilityCatalog, at time.Time) []Entitlement {
+	if catalog == nil {
+		catalog = &CapabilityCatalog{}
+	}
+	granted := make([]string, 0)
+	for _, lic := range licenses {
+		if lic.IsInForceAt(at) {
+			granted = append(granted, lic.LicensedPackage().IncludedCapabilityIds()...)
+		}
+	}
+	results := make([]Entitlement, 0)
+	for _, cpbId := range catalog.ImpliedClosure(granted) {
+		results = append(results, EvaluateEntitlement(licensee, licenses, catalog, cpbId, at))
+	}
+	return results
+}
+
+// Merges the capacity limits of the grants of the same capability, ordered by license issuance
+func mergeCapacityLimits(grants []Capability, rule CapacityMergeRule) Capability {
+	merged := grants[0]
+	if rule == MERGE_FIRST_WINS {
+		return merged
+	}
+	for _, grant := range grants[1:] {
+		if !merged.HasCapacityLimit {
+			break
+		}
+		if !grant.HasCapacityLimit {
+			merged.HasCapacityLimit = false
+			merged.CapacityLimit = 0
+			merged.CapacityLimitUnit = grant.CapacityLimitUnit
+			break
+		}
+		switch rule {
+		case MERGE_SUM:
+			merged.CapacityLimit += grant.CapacityLimit
+		case MERGE_MAX:
+			if grant.CapacityLimit > merged.CapacityLimit {
+				merged.CapacityLimit = grant.CapacityLimit
+			}
+		}
+	}
+	return merged
+}
+
+// Orders licenses by issuance time, then id, so that evaluation does not depend on repository order
+func sortedByIssuance(licenses []*License) []*License {
+	results := append([]*License{}, licenses...)
+	sort.SliceStable(results, func(i, j int) bool {
+		if !results[i].IssuedAt().Equal(results[j].IssuedAt()) {
+			return results[i].IssuedAt().Before(results[j].IssuedAt())
+		}
+		return results[i].Id() < results[j].Id()
+	})
+	return results
+}
@@ -0,0 +1,68 @@
+package licensing
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestEvaluateEntitlementMergesCapacity(t *testing.T) {
+
+	usr := NewInstanceUser("ins-1", "usr-1")
+	crmSync := func(limit int, rule CapacityMergeRule) Capability {
+		return Capability{Id: "cpb:crm-sync", HasCapacityLimit: limit > 0, CapacityLimit: limit, CapacityLimitUnit: "CallsPerDay", CapacityMergeRule: rule}
+	}
+	licensesOf := func(limits ...int) []*License {
+		licenses := make([]*License, 0)
+		for _, limit := range limits {
+			lic := NewIssuedLicense("acc-1", "sub-1", &Package{Id: "pkg:test", IncludedCapabilities: []Capability{crmSync(limit, MERGE_MAX)}})
+			lic.Assign(usr)
+			licenses = append(licenses, lic)
+		}
+		return licenses
+	}
+
+	cases := []struct {
+		name          string
+		rule          CapacityMergeRule
+		limits        []int
+		expectLimited bool
+		expectLimit   int
+	}{
+		{"max", MERGE_MAX, []int{10000, 250000, 1000}, true, 250000},
+		{"sum", MERGE_SUM, []int{10000, 250000, 1000}, true, 261000},
+		{"first wins", MERGE_FIRST_WINS, []int{10000, 250000, 1000}, true, 10000},
+		{"unlimited grant wins", MERGE_SUM, []int{10000, 0}, false, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			catalog, err := NewCapabilityCatalog([]Capability{crmSync(10000, c.rule)})
+			assert.NilError(t, err)
+			entitlement := EvaluateEntitlement(usr, licensesOf(c.limits...), catalog, "cpb:crm-sync", time.Now())
+			assert.Equal(t, entitlement.IsEntitled, true)
+			assert.Equal(t, entitlement.HasCapacityLimit, c.expectLimited)
+			assert.Equal(t, entitlement.EffectiveCapacityLimit, c.expectLimit)
+		})
+	}
+}
+
+func TestEvaluateEntitlementOnlyByLicensesInForce(t *testing.T) {
+
+	usr := NewInstanceUser("ins-1", "usr-1")
+	sequence := Capability{Id: "cpb:sequence", HasCapacityLimit: true, CapacityLimit: 100, CapacityLimitUnit: "ActiveSequences"}
+	pkg := &Package{Id: "pkg:test", IncludedCapabilities: []Capability{sequence}}
+	catalog, err := NewCapabilityCatalog([]Capability{sequence})
+	assert.NilError(t, err)
+	at := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	lic := NewIssuedLicense("acc-1", "sub-1", pkg, ExpiringAt(at))
+	lic.Assign(usr)
+	assert.Equal(t, EvaluateEntitlement(usr, []*License{lic}, catalog, "cpb:sequence", at.Add(-time.Second)).IsEntitled, true)
+	assert.Equal(t, EvaluateEntitlement(usr, []*License{lic}, catalog, "cpb:sequence", at).IsEntitled, false)
+	assert.Equal(t, len(EvaluateEntitlements(usr, []*License{lic}, catalog, at)), 0)
+	_, _, found := DerivePoolLimit([]*License{lic}, catalog, "cpb:sequence", at.Add(-time.Second))
+	assert.Equal(t, found, true)
+	_, _, found = DerivePoolLimit([]*License{lic}, catalog, "cpb:sequence", at)
+	assert.Equal(t, found, false)
+}
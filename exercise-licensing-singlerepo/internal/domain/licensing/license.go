@@ -56,6 +56,13 @@ type License struct {
 
 	// True if this license is a trial license
 	isTrial bool
+
+	// Time when the term of this license ends. Zero if the license does not end on its own.
+	expiresAt time.Time
+
+	// Version of the persisted state this license was loaded from, for optimistic concurrency control;
+	// 0 if the license was never persisted
+	version int64
 }
 
 type LicenseIssuanceDetail struct {
@@ -91,7 +98,31 @@ type LicenseRenewalDetail struct {
 	RenewalReason string
 }
 
-func NewIssuedLicense(accId string, subId string, pkg *Package) *License {
+// Optional terms of a license being issued
+type LicenseIssuanceOption func(lic *License)
+
+// Issues the license as a trial license
+func AsTrial() LicenseIssuanceOption {
+	return func(lic *License) {
+		lic.isTrial = true
+	}
+}
+
+// Issues the license with a term ending at the given time
+func ExpiringAt(expiresAt time.Time) LicenseIssuanceOption {
+	return func(lic *License) {
+		lic.expiresAt = expiresAt
+	}
+}
+
+// Issues the license for the given reason, e.g. as the renewal of another license
+func WithIssuanceReason(reason string) LicenseIssuanceOption {
+	return func(lic *License) {
+		lic.issuanceDetail.IssuanceReason = reason
+	}
+}
+
+func NewIssuedLicense(accId string, subId string, pkg *Package, opts ...LicenseIssuanceOption) *License {
 	lic := &License{
 		id:                          uuid.NewString(),
 		possessingCustomerAccountId: accId,
@@ -99,6 +130,9 @@ func NewIssuedLicense(accId string, subId string, pkg *Package) *License {
 		governingSubscriptionId:     subId,
 		issuanceDetail:              &LicenseIssuanceDetail{IssuedAt: time.Now(), IssuanceReason: "New Logo (FIXME)"},
 	}
+	for _, opt := range opts {
+		opt(lic)
+	}
 	return lic
 }
 
@@ -129,10 +163,32 @@ func (lic *License) GoverningSubscriptionId() string {
 	return lic.governingSubscriptionId
 }
 
+// Time when this license was issued
+func (lic *License) IssuedAt() time.Time {
+	if lic.issuanceDetail == nil {
+		return time.Time{}
+	}
+	return lic.issuanceDetail.IssuedAt
+}
+
 func (lic *License) IsActive() bool {
 	return lic.cancellationDetail == nil && lic.expirationDetail == nil && lic.renewalDetail == nil
 }
 
+// Status of the license in its lifecycle
+func (lic *License) Status() LicenseStatus {
+	switch {
+	case lic.renewalDetail != nil:
+		return RENEWED_LICENSE
+	case lic.cancellationDetail != nil:
+		return CANCELLED_LICENSE
+	case lic.expirationDetail != nil:
+		return EXPIRED_LICENSE
+	default:
+		return ACTIVE_LICENSE
+	}
+}
+
 func (lic *License) AssignedToLicensee() Licensee {
 	return lic.currentAssignment.Assignee
 }
@@ -143,19 +199,147 @@ func (lic *License) IsAssigned() bool {
 }
 
 func (lic *License) Assign(licensee Licensee) {
-	if lic.currentAssignment != nil {
-		lic.previousAssignments = append(lic.previousAssignments, lic.currentAssignment)
-	}
+	lic.Unassign()
 	lic.currentAssignment = NewCurrentLicenseAssignment(licensee)
 }
 
 func (lic *License) Unassign() {
 	if lic.currentAssignment != nil {
+		lic.currentAssignment.UnassignedAt = time.Now()
 		lic.previousAssignments = append(lic.previousAssignments, lic.currentAssignment)
 	}
 	lic.currentAssignment = nil
 }
 
+// Ends the license at the end of its term, or earlier; an expired license is no longer active
+func (lic *License) Expire() {
+	if lic.expirationDetail == nil {
+		lic.expirationDetail = &LicenseExpirationDetail{ExpiredAt: time.Now()}
+	}
+}
+
+// Records that the license is renewed to the given license; a renewed license is no longer active
+func (lic *License) RenewTo(renewedToLicId string, reason string) {
+	if lic.renewalDetail == nil {
+		lic.renewalDetail = &LicenseRenewalDetail{RenewedToLicenseId: renewedToLicId, RenewedAt: time.Now(), RenewalReason: reason}
+	}
+}
+
 func (lic *License) IsTrial() bool {
 	return lic.isTrial
 }
+
+// Time when the term of this license ends; zero if the license does not end on its own
+func (lic *License) ExpiresAt() time.Time {
+	return lic.expiresAt
+}
+
+// Whether the term of this license has ended at the given time
+func (lic *License) IsPastTermAt(at time.Time) bool {
+	return !lic.expiresAt.IsZero() && !at.Before(lic.expiresAt)
+}
+
+// Whether this license is in force at the given time: active and within its term. Only licenses in force grant
+// their capabilities, back capacity pools and offline seats, or are assigned.
+func (lic *License) IsInForceAt(at time.Time) bool {
+	return lic.IsActive() && !lic.IsPastTermAt(at)
+}
+
+// Version of the persisted state this license was loaded from; 0 if never persisted.
+// LicenseRepository.UpdateLicense only succeeds if the stored license is still at this version.
+func (lic *License) Version() int64 {
+	return lic.version
+}
+
+// Records the version the license is persisted at. For use by LicenseRepository implementations only.
+func (lic *License) SetPersistedVersion(version int64) {
+	lic.version = version
+}
+
+// Returns a deep copy of the license, so that repositories can hand out licenses without sharing their state
+func (lic *License) Clone() *License {
+	clone := *lic
+	if lic.issuanceDetail != nil {
+		detail := *lic.issuanceDetail
+		clone.issuanceDetail = &detail
+	}
+	if lic.expirationDetail != nil {
+		detail := *lic.expirationDetail
+		clone.expirationDetail = &detail
+	}
+	if lic.cancellationDetail != nil {
+		detail := *lic.cancellationDetail
+		clone.cancellationDetail = &detail
+	}
+	if lic.renewalDetail != nil {
+		detail := *lic.renewalDetail
+		clone.renewalDetail = &detail
+	}
+	if lic.currentAssignment != nil {
+		assignment := *lic.currentAssignment
+		clone.currentAssignment = &assignment
+	}
+	clone.previousAssignments = make([]*LicenseAssignment, len(lic.previousAssignments))
+	for i, previous := range lic.previousAssignments {
+		assignment := *previous
+		clone.previousAssignments[i] = &assignment
+	}
+	return &clone
+}
+
+// Persisted state of a license, for LicenseRepository implementations that store licenses outside of memory
+type LicenseState struct {
+	Id                          string
+	LicensedPackage             *Package
+	PossessingCustomerAccountId string
+	GoverningSubscriptionId     string
+	IssuanceDetail              *LicenseIssuanceDetail
+	ExpirationDetail            *LicenseExpirationDetail
+	CancellationDetail          *LicenseCancellationDetail
+	RenewalDetail               *LicenseRenewalDetail
+	CurrentAssignment           *LicenseAssignment
+	PreviousAssignments         []*LicenseAssignment
+	IsTrial                     bool
+	ExpiresAt                   time.Time
+	Version                     int64
+}
+
+// Returns a copy of the state of the license, for storing it
+func (lic *License) State() LicenseState {
+	clone := lic.Clone()
+	return LicenseState{
+		Id:                          clone.id,
+		LicensedPackage:             clone.licensedPackage,
+		PossessingCustomerAccountId: clone.possessingCustomerAccountId,
+		GoverningSubscriptionId:     clone.governingSubscriptionId,
+		IssuanceDetail:              clone.issuanceDetail,
+		ExpirationDetail:            clone.expirationDetail,
+		CancellationDetail:          clone.cancellationDetail,
+		RenewalDetail:               clone.renewalDetail,
+		CurrentAssignment:           clone.currentAssignment,
+		PreviousAssignments:         clone.previousAssignments,
+		IsTrial:                     clone.isTrial,
+		ExpiresAt:                   clone.expiresAt,
+		Version:                     clone.version,
+	}
+}
+
+// Restores a license from its stored state
+func RestoreLicense(state LicenseState) *License {
+	lic := &License{
+		id:                          state.Id,
+		licensedPackage:             state.LicensedPackage,
+		possessingCustomerAccountId: state.PossessingCustomerAccountId,
+		governingSubscriptionId:     state.GoverningSubscriptionId,
+		issuanceDetail:              state.IssuanceDetail,
+		expirationDetail:            state.ExpirationDetail,
+		cancellationDetail:          state.CancellationDetail,
+		renewalDetail:               state.RenewalDetail,
+		currentAssignment:           state.CurrentAssignment,
+		previousAssignments:         state.PreviousAssignments,
+		isTrial:                     state.IsTrial,
+		expiresAt:                   state.ExpiresAt,
+		version:                     state.Version,
+	}
+	return lic.Clone()
+}
@@ -0,0 +1,187 @@
+package licensing
+
+import (
+	"sort"
+	"time"
+)
+
+// Window within which the term of a license ends for it to count as expiring soon, unless set otherwise
+const DefaultExpiringSoonWindow = 30 * 24 * time.Hour
+
+// Definition: Totals of a set of licenses possessed by a customer account.
+//
+// A license is in force while it is active and within its term. Only licenses in force are counted as issued,
+// assigned, unassigned, trial or expiring soon, so that unassigned licenses are the ones left to assign, see
+// LicenseRepository.CountTotalUnassignedLicensesOfPackage. Licenses no longer in force are counted by why they
+// ended; one still assigned is also counted in overage, its licensee holding it beyond the licenses the account
+// is entitled to.
+// DDD Classification: Value Object
+type LicenseAssignmentTotals struct {
+
+	// Licenses issued and in force, assigned or not
+	Issued int
+
+	// Licenses in force assigned to a licensee
+	Assigned int
+
+	// Licenses in force available to assign
+	Unassigned int
+
+	// Licenses in force issued as trial licenses
+	Trial int
+
+	// Licenses in force whose term ends within the expiring soon window
+	ExpiringSoon int
+
+	// Licenses no longer in force as expired, or past their term
+	Expired int
+
+	// Licenses no longer in force as cancelled
+	Cancelled int
+
+	// Licenses no longer in force as renewed to another license
+	Renewed int
+
+	// Licenses still assigned though no longer in force
+	InOverage int
+}
+
+// Definition: Totals of the licenses of one subscription, within a package
+// DDD Classification: Value Object
+type SubscriptionAssignmentSummary struct {
+	SubscriptionId string
+	Totals         LicenseAssignmentTotals
+}
+
+// Definition: Totals of the licenses of one package, broken down by subscription
+// DDD Classification: Value Object
+type PackageAssignmentSummary struct {
+	PackageId string
+	Totals    LicenseAssignmentTotals
+
+	// Breakdown by governing subscription, sorted by subscription id
+	Subscriptions []SubscriptionAssignmentSummary
+}
+
+// Definition: Totals of the licenses possessed by a customer account, across all packages and per package
+// DDD Classification: Value Object
+type LicenseAssignmentSummary struct {
+	AccountId string
+
+	// Time the licenses were summarized at, against which terms are compared
+	SummarizedAt time.Time
+
+	// Window within which the term of a license ends for it to count as expiring soon
+	ExpiringSoonWindow time.Duration
+
+	// Totals across all packages
+	Totals LicenseAssignmentTotals
+
+	// Breakdown by licensed package, sorted by package id
+	Packages []PackageAssignmentSummary
+}
+
+// Summarizes the assignment of the given licenses of the customer account, at the given time; licenses of other
+// accounts are left out.
+//
+// DDD Classification: Domain Service
+func SummarizeLicenseAssignments(accId string, licenses []*License, at time.Time, expiringSoonWindow time.Duration) LicenseAssignmentSummary {
+	summary := LicenseAssignmentSummary{
+		AccountId:          accId,
+		SummarizedAt:       at,
+		ExpiringSoonWindow: expiringSoonWindow,
+		Packages:           make([]PackageAssignmentSummary, 0),
+	}
+	byPackage := make(map[string]map[string]*LicenseAssignmentTotals)
+	for _, lic := range licenses {
+		if lic.PossessingCustomerAccountId() != accId {
+			continue
+		}
+		pkgId := lic.LicensedPackage().Id
+		bySubscription, ok := byPackage[pkgId]
+		if !ok {
+			bySubscription = make(map[string]*LicenseAssignmentTotals)
+			byPackage[pkgId] = bySubscription
+		}
+		totals, ok := bySubscription[lic.GoverningSubscriptionId()]
+		if !ok {
+			totals = &LicenseAssignmentTotals{}
+			bySubscription[lic.GoverningSubscriptionId()] = totals
+		}
+		totals.count(lic, at, expiringSoonWindow)
+	}
+
+	pkgIds := make([]string, 0, len(byPackage))
+	for pkgId := range byPackage {
+		pkgIds = append(pkgIds, pkgId)
+	}
+	sort.Strings(pkgIds)
+	for _, pkgId := range pkgIds {
+		pkgSummary := PackageAssignmentSummary{PackageId: pkgId, Subscriptions: make([]SubscriptionAssignmentSummary, 0)}
+		bySubscription := byPackage[pkgId]
+		subIds := make([]string, 0, len(bySubscription))
+		for subId := range bySubscription {
+			subIds = append(subIds, subId)
+		}
+		sort.Strings(subIds)
+		for _, subId := range subIds {
+			totals := *bySubscription[subId]
+			pkgSummary.Subscriptions = append(pkgSummary.Subscriptions, SubscriptionAssignmentSummary{SubscriptionId: subId, Totals: totals})
+			pkgSummary.Totals.add(totals)
+		}
+		summary.Packages = append(summary.Packages, pkgSummary)
+		summary.Totals.add(pkgSummary.Totals)
+	}
+	return summary
+}
+
+// Returns the summary of the given package, zero totals if the account possesses no license of it
+func (s LicenseAssignmentSummary) Package(pkgId string) PackageAssignmentSummary {
+	for _, pkgSummary := range s.Packages {
+		if pkgSummary.PackageId == pkgId {
+			return pkgSummary
+		}
+	}
+	return PackageAssignmentSummary{PackageId: pkgId, Subscriptions: make([]SubscriptionAssignmentSummary, 0)}
+}
+
+func (t *LicenseAssignmentTotals) count(lic *License, at time.Time, expiringSoonWindow time.Duration) {
+	if !lic.IsInForceAt(at) {
+		switch lic.Status() {
+		case RENEWED_LICENSE:
+			t.Renewed++
+		case CANCELLED_LICENSE:
+			t.Cancelled++
+		default:
+			t.Expired++
+		}
+		if lic.IsAssigned() {
+			t.InOverage++
+		}
+		return
+	}
+	t.Issued++
+	if lic.IsAssigned() {
+		t.Assigned++
+	} else {
+		t.Unassigned++
+	}
+	if lic.IsTrial() {
+		t.Trial++
+	}
+	if lic.IsPastTermAt(at.Add(expiringSoonWindow)) {
+		t.ExpiringSoon++
+	}
+}
+
+func (t *LicenseAssignmentTotals) add(other LicenseAssignmentTotals) {
+	t.Issued += other.Issued
+	t.Assigned += other.Assigned
+	t.Unassigned += other.Unassigned
+	t.Trial += other.Trial
+	t.ExpiringSoon += other.ExpiringSoon
+	t.Expired += other.Expired
+	t.Cancelled += other.Cancelled
+	t.Renewed += other.Renewed
+	t.InOverage += other.InOverage
+}
@@ -0,0 +1,43 @@
+package licensing
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestSummarizeLicenseAssignments(t *testing.T) {
+
+	at := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	optimize := &Package{Id: "pkg:optimize"}
+	accelerate := &Package{Id: "pkg:accelerate"}
+	alice := NewInstanceUser("ins-1", "usr-alice")
+	bob := NewInstanceUser("ins-1", "usr-bob")
+
+	assigned := NewIssuedLicense("acc-1", "sub-1", optimize)
+	assigned.Assign(alice)
+	expiringTrial := NewIssuedLicense("acc-1", "sub-1", optimize, AsTrial(), ExpiringAt(at.AddDate(0, 0, 14)))
+	pastTerm := NewIssuedLicense("acc-1", "sub-2", optimize, ExpiringAt(at.AddDate(0, 0, -1)))
+	pastTerm.Assign(bob)
+	expired := NewIssuedLicense("acc-1", "sub-2", optimize)
+	expired.Expire()
+	unassigned := NewIssuedLicense("acc-1", "sub-2", accelerate, ExpiringAt(at.AddDate(1, 0, 0)))
+	renewed := NewIssuedLicense("acc-1", "sub-2", accelerate)
+	renewed.RenewTo(unassigned.Id(), "annual renewal")
+	otherAccount := NewIssuedLicense("acc-2", "sub-1", optimize)
+	licenses := []*License{assigned, expiringTrial, pastTerm, expired, unassigned, renewed, otherAccount}
+
+	summary := SummarizeLicenseAssignments("acc-1", licenses, at, DefaultExpiringSoonWindow)
+
+	assert.DeepEqual(t, summary.Totals, LicenseAssignmentTotals{Issued: 3, Assigned: 1, Unassigned: 2, Trial: 1, ExpiringSoon: 1,
+		Expired: 2, Renewed: 1, InOverage: 1})
+	assert.Equal(t, len(summary.Packages), 2)
+	assert.Equal(t, summary.Packages[0].PackageId, "pkg:accelerate")
+	assert.DeepEqual(t, summary.Package("pkg:accelerate").Totals, LicenseAssignmentTotals{Issued: 1, Unassigned: 1, Renewed: 1})
+	assert.DeepEqual(t, summary.Package("pkg:optimize").Subscriptions, []SubscriptionAssignmentSummary{
+		{SubscriptionId: "sub-1", Totals: LicenseAssignmentTotals{Issued: 2, Assigned: 1, Unassigned: 1, Trial: 1, ExpiringSoon: 1}},
+		{SubscriptionId: "sub-2", Totals: LicenseAssignmentTotals{Expired: 2, InOverage: 1}},
+	})
+	assert.DeepEqual(t, summary.Package("pkg:missing").Totals, LicenseAssignmentTotals{})
+}
@@ -0,0 +1,49 @@
+package licensing
+
+import "time"
+
+//
+// License event type "enum"
+//
+type LicenseEventType int
+
+const (
+	LICENSE_ISSUED LicenseEventType = iota
+	LICENSE_ASSIGNED
+	LICENSE_UNASSIGNED
+	LICENSE_CHANGED
+	LICENSE_EXPIRED
+	LICENSE_RENEWED
+)
+
+func (et LicenseEventType) String() string {
+	return [...]string{"LICENSE_ISSUED", "LICENSE_ASSIGNED", "LICENSE_UNASSIGNED", "LICENSE_CHANGED", "LICENSE_EXPIRED", "LICENSE_RENEWED"}[et]
+}
+
+// Definition: A fact about a license that happened in the past, published after the change is persisted.
+// DDD Classification: Domain Event
+type LicenseEvent struct {
+
+	// What happened to the license
+	Type LicenseEventType
+
+	// Id of the license the event is about
+	LicenseId string
+
+	// The customer account possessing the license
+	AccountId string
+
+	// Licensees whose entitlements may be affected by this event,
+	// e.g. both the new and the previous assignee of a reassigned license
+	AffectedLicenseeIds []string
+
+	// When the event happened
+	OccurredAt time.Time
+}
+
+// Receives license events, e.g. to invalidate derived state like cached entitlements
+type LicenseEventHandler interface {
+
+	// Handle a published license event
+	HandleLicenseEvent(evt LicenseEvent)
+}
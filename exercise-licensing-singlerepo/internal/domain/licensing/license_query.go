@@ -0,0 +1,301 @@
+package licensing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"time"
+)
+
+// Returned, wrapped, when the cursor of a license query is malformed or was returned for another query
+var ErrInvalidLicenseCursor = errors.New("invalid license cursor")
+
+// Page size of a license query not setting one
+const DefaultLicensePageSize = 50
+
+// Largest page size of a license query; larger page sizes are reduced to it
+const MaxLicensePageSize = 1000
+
+//
+// License status "enum", the stage of a license in its lifecycle
+//
+type LicenseStatus int
+
+const (
+	// Neither expired, cancelled nor renewed
+	ACTIVE_LICENSE LicenseStatus = iota
+	// Expired, or active but past its term, see LicenseQuery.StatusOf
+	EXPIRED_LICENSE
+	CANCELLED_LICENSE
+	// Renewed to another license, whether or not it also expired
+	RENEWED_LICENSE
+)
+
+func (s LicenseStatus) String() string {
+	return [...]string{"ACTIVE_LICENSE", "EXPIRED_LICENSE", "CANCELLED_LICENSE", "RENEWED_LICENSE"}[s]
+}
+
+//
+// License sort key "enum", the order of listed licenses; licenses of equal keys are ordered by id
+//
+type LicenseSortKey int
+
+const (
+	SORT_LICENSES_BY_ISSUED_AT LicenseSortKey = iota
+	// Licenses without end of term come first
+	SORT_LICENSES_BY_EXPIRES_AT
+	SORT_LICENSES_BY_ID
+)
+
+func (k LicenseSortKey) String() string {
+	return [...]string{"SORT_LICENSES_BY_ISSUED_AT", "SORT_LICENSES_BY_EXPIRES_AT", "SORT_LICENSES_BY_ID"}[k]
+}
+
+// Definition: Criteria of a license listing, with the order and page of the listing.
+// Filters left at their zero value do not filter; the others combine, so that listed licenses match all of them.
+// DDD Classification: Specification
+type LicenseQuery struct {
+
+	// Possessing customer account id
+	AccountId string
+
+	// Governing subscription id
+	SubscriptionId string
+
+	// Licensed package id
+	PackageId string
+
+	// Whether the licenses are currently assigned; assigned or not if nil
+	IsAssigned *bool
+
+	// Statuses of the licenses at the time At, any of them; any status if empty
+	Statuses []LicenseStatus
+
+	// Time the statuses are evaluated at, so that an active license past its term is expired; now if zero
+	At time.Time
+
+	// Whether the licenses are trial licenses; trial or not if nil
+	IsTrial *bool
+
+	// Licensee the licenses are currently assigned to
+	LicenseeId string
+
+	// Licenses issued at or after this time
+	IssuedFrom time.Time
+
+	// Licenses issued before this time
+	IssuedBefore time.Time
+
+	// Order of the listing, ascending unless Descending
+	SortBy     LicenseSortKey
+	Descending bool
+
+	// Licenses per page; DefaultLicensePageSize if 0, at most MaxLicensePageSize
+	PageSize int
+
+	// Cursor of the page to list, the NextCursor of the previous page; the first page if empty
+	Cursor string
+}
+
+// Definition: A page of a license listing
+type LicensePage struct {
+
+	// Licenses of the page, in the order of the query
+	Licenses []*License
+
+	// Cursor of the next page, to set on the query; empty on the last page
+	NextCursor string
+}
+
+// Position in a license listing, after the last license of a page
+type LicenseCursor struct {
+
+	// Sort key of the last license, see LicenseQuery.SortKeyOf
+	SortKey int64
+
+	// Id of the last license
+	LicenseId string
+}
+
+// On the wire, the cursor also identifies the query it was returned for
+type licenseCursorJson struct {
+	SortKey   int64  `json:"k"`
+	LicenseId string `json:"id"`
+	Query     uint32 `json:"q"`
+}
+
+// Checks the page size and cursor of the query, returning the page size to list and the decoded cursor,
+// nil for the first page
+func (q LicenseQuery) Page() (int, *LicenseCursor, error) {
+	pageSize := q.PageSize
+	switch {
+	case pageSize < 0:
+		return 0, nil, fmt.Errorf("invalid page size %d", pageSize)
+	case pageSize == 0:
+		pageSize = DefaultLicensePageSize
+	case pageSize > MaxLicensePageSize:
+		pageSize = MaxLicensePageSize
+	}
+	if q.Cursor == "" {
+		return pageSize, nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrInvalidLicenseCursor, err)
+	}
+	var cursor licenseCursorJson
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrInvalidLicenseCursor, err)
+	}
+	if cursor.Query != q.fingerprint() {
+		return 0, nil, fmt.Errorf("%w: cursor of another query", ErrInvalidLicenseCursor)
+	}
+	return pageSize, &LicenseCursor{SortKey: cursor.SortKey, LicenseId: cursor.LicenseId}, nil
+}
+
+// Returns the cursor of the page after the given license, the last of its page
+func (q LicenseQuery) CursorAfter(last *License) string {
+	data, _ := json.Marshal(licenseCursorJson{SortKey: q.SortKeyOf(last), LicenseId: last.Id(), Query: q.fingerprint()})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Whether the license matches the filters of the query
+func (q LicenseQuery) Matches(lic *License) bool {
+	if q.AccountId != "" && lic.PossessingCustomerAccountId() != q.AccountId {
+		return false
+	}
+	if q.SubscriptionId != "" && lic.GoverningSubscriptionId() != q.SubscriptionId {
+		return false
+	}
+	if q.PackageId != "" && lic.LicensedPackage().Id != q.PackageId {
+		return false
+	}
+	if q.IsAssigned != nil && lic.IsAssigned() != *q.IsAssigned {
+		return false
+	}
+	if len(q.Statuses) > 0 && !q.hasStatus(q.StatusOf(lic)) {
+		return false
+	}
+	if q.IsTrial != nil && lic.IsTrial() != *q.IsTrial {
+		return false
+	}
+	if q.LicenseeId != "" && (!lic.IsAssigned() || lic.AssignedToLicensee().LicenseeId() != q.LicenseeId) {
+		return false
+	}
+	if !q.IssuedFrom.IsZero() && lic.IssuedAt().Before(q.IssuedFrom) {
+		return false
+	}
+	if !q.IssuedBefore.IsZero() && !lic.IssuedAt().Before(q.IssuedBefore) {
+		return false
+	}
+	return true
+}
+
+// Time the statuses of the query are evaluated at
+func (q LicenseQuery) StatusAt() time.Time {
+	if q.At.IsZero() {
+		return time.Now()
+	}
+	return q.At
+}
+
+// Status of the license at the time of the query: the status in its lifecycle, expired once an active license
+// is past its term
+func (q LicenseQuery) StatusOf(lic *License) LicenseStatus {
+	if lic.IsActive() && lic.IsPastTermAt(q.StatusAt()) {
+		return EXPIRED_LICENSE
+	}
+	return lic.Status()
+}
+
+func (q LicenseQuery) hasStatus(status LicenseStatus) bool {
+	for _, s := range q.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort key of the license for the order of the query: the time sorted by in Unix nanoseconds, math.MinInt64 if not set,
+// or 0 when sorting by id
+func (q LicenseQuery) SortKeyOf(lic *License) int64 {
+	switch q.SortBy {
+	case SORT_LICENSES_BY_ISSUED_AT:
+		return licenseSortTime(lic.IssuedAt())
+	case SORT_LICENSES_BY_EXPIRES_AT:
+		return licenseSortTime(lic.ExpiresAt())
+	default:
+		return 0
+	}
+}
+
+func licenseSortTime(t time.Time) int64 {
+	if t.IsZero() {
+		return math.MinInt64
+	}
+	return t.UnixNano()
+}
+
+// Whether license a is listed before license b in the order of the query
+func (q LicenseQuery) Precedes(a *License, b *License) bool {
+	return q.precedesPosition(q.SortKeyOf(a), a.Id(), q.SortKeyOf(b), b.Id())
+}
+
+// Whether the license is listed after the cursor in the order of the query
+func (q LicenseQuery) IsAfter(cursor LicenseCursor, lic *License) bool {
+	return q.precedesPosition(cursor.SortKey, cursor.LicenseId, q.SortKeyOf(lic), lic.Id())
+}
+
+func (q LicenseQuery) precedesPosition(keyA int64, idA string, keyB int64, idB string) bool {
+	if keyA != keyB {
+		return (keyA < keyB) != q.Descending
+	}
+	if idA == idB {
+		return false
+	}
+	return (idA < idB) != q.Descending
+}
+
+// Identifies the filters and order of the query, not to resume a listing with the cursor of another
+// The time of the statuses is left out, so that the pages of a listing may be listed at successive times
+func (q LicenseQuery) fingerprint() uint32 {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%q %q %q %v %v %v %q %d %d %v %v", q.AccountId, q.SubscriptionId, q.PackageId,
+		optionalBool(q.IsAssigned), q.Statuses, optionalBool(q.IsTrial), q.LicenseeId,
+		licenseSortTime(q.IssuedFrom), licenseSortTime(q.IssuedBefore), q.SortBy, q.Descending)
+	return h.Sum32()
+}
+
+func optionalBool(b *bool) string {
+	if b == nil {
+		return "any"
+	}
+	return fmt.Sprint(*b)
+}
+
+// Lists the page of the query among the given licenses, in any order; for repositories filtering licenses in memory
+func ListLicensePage(licenses []*License, q LicenseQuery) (*LicensePage, error) {
+	pageSize, cursor, err := q.Page()
+	if err != nil {
+		return nil, err
+	}
+	q.At = q.StatusAt()
+	matching := make([]*License, 0)
+	for _, lic := range licenses {
+		if q.Matches(lic) && (cursor == nil || q.IsAfter(*cursor, lic)) {
+			matching = append(matching, lic)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool { return q.Precedes(matching[i], matching[j]) })
+	page := &LicensePage{Licenses: matching}
+	if len(matching) > pageSize {
+		page.Licenses = matching[:pageSize]
+		page.NextCursor = q.CursorAfter(page.Licenses[pageSize-1])
+	}
+	return page, nil
+}
@@ -1,24 +1,50 @@
 package licensing
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Returned, wrapped, by UpdateLicense when the license was changed since it was loaded
+var ErrLicenseVersionConflict = errors.New("license version conflict")
+
+// Returned, wrapped, when no license is stored for the id, or none is assigned to the licensee
+var ErrLicenseNotFound = errors.New("license not found")
+
 // Definition: Repository for License.
+// Implementations honor the cancellation and deadline of the context, and pass it on to their database driver.
 // DDD Classification: Repository
 type LicenseRepository interface {
 
-	// Create license
-	CreateLicense(lic *License) error
+	// Create license; on success, lic is at version 1
+	CreateLicense(ctx context.Context, lic *License) error
+
+	// Update license, provided the stored license is still at the version newLic was loaded from (compare-and-swap);
+	// fails with ErrLicenseVersionConflict otherwise. On success, newLic is at the new version.
+	UpdateLicense(ctx context.Context, licId string, newLic *License) error
+
+	// Get license by id; fails with ErrLicenseNotFound if none is stored
+	GetLicenseById(ctx context.Context, licId string) (*License, error)
+
+	// Find licenses by licensee id; fails with ErrLicenseNotFound if none is assigned to the licensee
+	FindLicensesByAssignedLicenseeId(ctx context.Context, licenseeId string) ([]*License, error)
 
-	// Update license
-	UpdateLicense(licId string, newLic *License) error
+	// Find all licenses possessed by the customer account id, assigned or not
+	FindLicensesOfAccount(ctx context.Context, accId string) ([]*License, error)
 
-	// Get license by id
-	GetLicenseById(licId string) (*License, error)
+	// Find all unassigned licenses in force now of the given package id under the customer account id,
+	// in no particular order
+	FindUnassignedLicensesOfPackage(ctx context.Context, accId string, pkgId string) ([]*License, error)
 
-	// Find licenses by licensee id
-	FindLicensesByAssignedLicenseeId(licenseeId string) ([]*License, error)
+	// Find the first licenses to assign of the given package id under the customer account id, up to limit:
+	// the unassigned licenses in force at the given time, in the order of the strategy; see SelectLicensesToAssign
+	FindLicensesToAssign(ctx context.Context, accId string, pkgId string, strategy SeatAllocationStrategy, at time.Time, limit int) ([]*License, error)
 
-	// Find next unassigned license of the given package id under the customer account id
-	FindNextUnassignedLicenseOfPackage(accId string, pkgId string) (*License, error)
+	// Count the total unassigned license in force now of the given package id under the customer account id,
+	// as LicenseAssignmentTotals counts them
+	CountTotalUnassignedLicensesOfPackage(ctx context.Context, accId string, pkgId string) (int, error)
 
-	// Count the total unassigned license of the given package id under the customer account id
-	CountTotalUnassignedLicensesOfPackage(accId string, pkgId string) (int, error)
+	// List a page of the licenses matching the query, in the order of the query; see LicenseQuery
+	ListLicenses(ctx context.Context, query LicenseQuery) (*LicensePage, error)
 }
@@ -0,0 +1,16 @@
+package licensing
+
+import "context"
+
+// Definition: Unit of work over licenses, so that a use case changing several licenses persists either all of its
+// changes or none of them.
+// DDD Classification: Unit of Work
+type LicenseUnitOfWork interface {
+
+	// Run work against a license repository whose changes are committed together when work returns nil, and discarded
+	// when it returns an error. Within work, the repository reads back its own uncommitted changes.
+	//
+	// Committing fails with ErrLicenseVersionConflict if a license the work changed was changed concurrently;
+	// the work may then be run again. Licenses changed by a discarded unit of work are stale and must be reloaded.
+	RunAtomically(ctx context.Context, work func(ctx context.Context, licRepo LicenseRepository) error) error
+}
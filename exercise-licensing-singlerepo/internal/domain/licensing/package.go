@@ -16,9 +16,13 @@ type Package struct {
 
 	// Capability included in this package
 	IncludedCapabilities []Capability
+
+	// True if this package is retired and no more licenses can be issued for it
+	IsArchived bool
 }
 
-// Checks whether this package includes the given capability id
+// Checks whether this package includes the given capability id.
+// Flat match only, see CapabilityCatalog.EffectiveCapabilities for the implied capabilities.
 func (p *Package) IncludesCapability(cpbId string) bool {
 	for _, includedCpb := range p.IncludedCapabilities {
 		if includedCpb.Id == cpbId {
@@ -27,3 +31,22 @@ func (p *Package) IncludesCapability(cpbId string) bool {
 	}
 	return false
 }
+
+// Get the included capability of the given id, as defined by this package (e.g., with its capacity limit)
+func (p *Package) GetIncludedCapability(cpbId string) (Capability, bool) {
+	for _, includedCpb := range p.IncludedCapabilities {
+		if includedCpb.Id == cpbId {
+			return includedCpb, true
+		}
+	}
+	return Capability{}, false
+}
+
+// Ids of the capabilities included in this package, not including the implied ones
+func (p *Package) IncludedCapabilityIds() []string {
+	results := make([]string, len(p.IncludedCapabilities))
+	for i, includedCpb := range p.IncludedCapabilities {
+		results[i] = includedCpb.Id
+	}
+	return results
+}
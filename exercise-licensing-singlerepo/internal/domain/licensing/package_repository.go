@@ -1,8 +1,25 @@
 package licensing
 
+import "context"
+
 // repository interface for package
 type PackageRepository interface {
 
 	// Get package by id
-	GetPackageById(pkgId string) (*Package, error)
+	GetPackageById(ctx context.Context, pkgId string) (*Package, error)
+
+	// Get packaging plan by id
+	GetPackagingPlanById(ctx context.Context, planId string) (*PackagingPlan, error)
+
+	// Create package
+	CreatePackage(ctx context.Context, pkg *Package) error
+
+	// Update package
+	UpdatePackage(ctx context.Context, pkg *Package) error
+
+	// List all packages, including the archived ones, sorted by id
+	ListPackages(ctx context.Context) ([]*Package, error)
+
+	// Get the catalog of all capabilities and their relationships
+	GetCapabilityCatalog(ctx context.Context) (*CapabilityCatalog, error)
 }
@@ -0,0 +1,155 @@
+package licensing
+
+import (
+	"sort"
+	"time"
+)
+
+// Strategy choosing which of the unassigned licenses of a package is assigned next.
+//
+// A strategy ranks licenses by their governing subscription and whether they are trial licenses, and orders the
+// licenses of equal rank by one of the seat allocation orders, so that repositories can keep licenses ready to
+// assign in that order. Every strategy orders the licenses completely, falling back to the oldest issued license
+// and then to the license id, so that the choice never depends on repository order.
+//
+// DDD Classification: Domain Service
+type SeatAllocationStrategy interface {
+
+	// Name of the strategy, for logs and error messages
+	Name() string
+
+	// Rank of the licenses governed by the subscription, trial or not; licenses of lower rank are assigned first
+	Rank(subId string, isTrial bool) int
+
+	// Order of the licenses of equal rank
+	Order() SeatAllocationOrder
+
+	// Whether license a is assigned before license b
+	Precedes(a *License, b *License) bool
+}
+
+//
+// Seat allocation order "enum", the order in which licenses of equal rank are assigned
+//
+type SeatAllocationOrder int
+
+const (
+	// The license whose term ends first, then the oldest issued; licenses without end of term come last
+	SOONEST_EXPIRING_FIRST SeatAllocationOrder = iota
+	// The oldest issued license
+	OLDEST_ISSUED_FIRST
+)
+
+func (o SeatAllocationOrder) String() string {
+	return [...]string{"SOONEST_EXPIRING_FIRST", "OLDEST_ISSUED_FIRST"}[o]
+}
+
+// Whether license a is assigned before license b, in this order
+func (o SeatAllocationOrder) Precedes(a *License, b *License) bool {
+	if o == SOONEST_EXPIRING_FIRST && !a.ExpiresAt().Equal(b.ExpiresAt()) {
+		switch {
+		case a.ExpiresAt().IsZero():
+			return false
+		case b.ExpiresAt().IsZero():
+			return true
+		}
+		return a.ExpiresAt().Before(b.ExpiresAt())
+	}
+	if !a.IssuedAt().Equal(b.IssuedAt()) {
+		return a.IssuedAt().Before(b.IssuedAt())
+	}
+	return a.Id() < b.Id()
+}
+
+// Assigns the license whose term ends first, so that expiring licenses are used up before the others.
+// Licenses without end of term come last.
+func SoonestExpiringFirst() SeatAllocationStrategy {
+	return seatAllocationStrategy{name: "soonest-expiring-first", order: SOONEST_EXPIRING_FIRST,
+		rank: func(subId string, isTrial bool) int { return 0 }}
+}
+
+// Assigns the license issued first
+func OldestIssuedFirst() SeatAllocationStrategy {
+	return seatAllocationStrategy{name: "oldest-issued-first", order: OLDEST_ISSUED_FIRST,
+		rank: func(subId string, isTrial bool) int { return 0 }}
+}
+
+// Assigns paid licenses before trial licenses, the soonest expiring first within each
+func PaidBeforeTrial() SeatAllocationStrategy {
+	return seatAllocationStrategy{name: "paid-before-trial", order: SOONEST_EXPIRING_FIRST,
+		rank: func(subId string, isTrial bool) int {
+			if isTrial {
+				return 1
+			}
+			return 0
+		}}
+}
+
+// Assigns licenses governed by the given subscription before all others, the soonest expiring first within each
+func PreferredSubscription(preferredSubId string) SeatAllocationStrategy {
+	return seatAllocationStrategy{name: "preferred-subscription:" + preferredSubId, order: SOONEST_EXPIRING_FIRST,
+		rank: func(subId string, isTrial bool) int {
+			if subId == preferredSubId {
+				return 0
+			}
+			return 1
+		}}
+}
+
+// Selects the license to assign next among the candidates by the strategy, skipping licenses
+// that are assigned, no longer active or past their term at the given time. Returns nil if none is left.
+//
+// DDD Classification: Domain Service
+func SelectLicenseToAssign(candidates []*License, strategy SeatAllocationStrategy, at time.Time) *License {
+	selected := SelectLicensesToAssign(candidates, strategy, at, 1)
+	if len(selected) == 0 {
+		return nil
+	}
+	return selected[0]
+}
+
+// Selects up to limit licenses to assign next among the candidates, in the order of the strategy, skipping licenses
+// that are assigned, no longer active or past their term at the given time
+//
+// DDD Classification: Domain Service
+func SelectLicensesToAssign(candidates []*License, strategy SeatAllocationStrategy, at time.Time, limit int) []*License {
+	assignable := make([]*License, 0, len(candidates))
+	for _, lic := range candidates {
+		if !lic.IsAssigned() && lic.IsInForceAt(at) {
+			assignable = append(assignable, lic)
+		}
+	}
+	sort.SliceStable(assignable, func(i, j int) bool {
+		return strategy.Precedes(assignable[i], assignable[j])
+	})
+	if len(assignable) > limit {
+		assignable = assignable[:limit]
+	}
+	return assignable
+}
+
+type seatAllocationStrategy struct {
+	name  string
+	rank  func(subId string, isTrial bool) int
+	order SeatAllocationOrder
+}
+
+func (s seatAllocationStrategy) Name() string {
+	return s.name
+}
+
+func (s seatAllocationStrategy) Rank(subId string, isTrial bool) int {
+	return s.rank(subId, isTrial)
+}
+
+func (s seatAllocationStrategy) Order() SeatAllocationOrder {
+	return s.order
+}
+
+func (s seatAllocationStrategy) Precedes(a *License, b *License) bool {
+	aRank, bRank := s.rank(a.GoverningSubscriptionId(), a.IsTrial()), s.rank(b.GoverningSubscriptionId(), b.IsTrial())
+	if aRank != bRank {
+		return aRank < bRank
+	}
+	return s.order.Precedes(a, b)
+}
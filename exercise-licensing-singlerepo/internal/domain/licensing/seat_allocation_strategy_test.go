@@ -0,0 +1,62 @@
+package licensing
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestSelectLicenseToAssign(t *testing.T) {
+
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	pkg := &Package{Id: "pkg:test"}
+	issue := func(subId string, issuedAt time.Time, opts ...LicenseIssuanceOption) *License {
+		lic := NewIssuedLicense("acc-1", subId, pkg, opts...)
+		lic.issuanceDetail.IssuedAt = issuedAt
+		return lic
+	}
+
+	perpetual := issue("sub-1", now.AddDate(0, -3, 0))
+	expiringLate := issue("sub-1", now.AddDate(0, -2, 0), ExpiringAt(now.AddDate(1, 0, 0)))
+	expiringSoon := issue("sub-2", now.AddDate(0, -1, 0), ExpiringAt(now.AddDate(0, 1, 0)))
+	trialExpiringSooner := issue("sub-3", now.AddDate(0, 0, -7), AsTrial(), ExpiringAt(now.AddDate(0, 0, 7)))
+	pastTerm := issue("sub-1", now.AddDate(-1, 0, 0), ExpiringAt(now.AddDate(0, 0, -1)))
+	assigned := issue("sub-1", now.AddDate(-2, 0, 0))
+	assigned.Assign(NewInstanceUser("ins-1", "usr-1"))
+	candidates := []*License{perpetual, expiringLate, expiringSoon, trialExpiringSooner, pastTerm, assigned}
+
+	cases := []struct {
+		strategy SeatAllocationStrategy
+		expected *License
+	}{
+		{SoonestExpiringFirst(), trialExpiringSooner},
+		{OldestIssuedFirst(), perpetual},
+		{PaidBeforeTrial(), expiringSoon},
+		{PreferredSubscription("sub-1"), expiringLate},
+		{PreferredSubscription("sub-unknown"), trialExpiringSooner},
+	}
+	for _, c := range cases {
+		t.Run(c.strategy.Name(), func(t *testing.T) {
+			selected := SelectLicenseToAssign(candidates, c.strategy, now)
+			assert.Equal(t, selected.Id(), c.expected.Id())
+
+			// the choice does not depend on the order of the candidates
+			reversed := make([]*License, 0, len(candidates))
+			for i := len(candidates) - 1; i >= 0; i-- {
+				reversed = append(reversed, candidates[i])
+			}
+			assert.Equal(t, SelectLicenseToAssign(reversed, c.strategy, now).Id(), c.expected.Id())
+		})
+	}
+
+	t.Run("licenses to assign next in order", func(t *testing.T) {
+		selected := SelectLicensesToAssign(candidates, PaidBeforeTrial(), now, 3)
+		assert.DeepEqual(t, []string{selected[0].Id(), selected[1].Id(), selected[2].Id()},
+			[]string{expiringSoon.Id(), expiringLate.Id(), perpetual.Id()})
+	})
+
+	t.Run("nothing left to assign", func(t *testing.T) {
+		assert.Check(t, SelectLicenseToAssign([]*License{pastTerm, assigned}, SoonestExpiringFirst(), now) == nil)
+	})
+}
@@ -0,0 +1,133 @@
+package licensing
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// License repository constrained to the licenses possessed by a single customer account (the tenant).
+//
+// Licenses of other accounts are reported as not found rather than forbidden, so that a caller
+// cannot probe for license ids of other tenants. Queries naming another account fail.
+//
+// DDD Classification: Repository
+type tenantScopedLicenseRepository struct {
+	repo  LicenseRepository
+	accId string
+}
+
+func NewTenantScopedLicenseRepository(repo LicenseRepository, accId string) LicenseRepository {
+	return &tenantScopedLicenseRepository{repo: repo, accId: accId}
+}
+
+func (r *tenantScopedLicenseRepository) CreateLicense(ctx context.Context, lic *License) error {
+	if lic.PossessingCustomerAccountId() != r.accId {
+		return r.outOfScopeError(lic.PossessingCustomerAccountId())
+	}
+	return r.repo.CreateLicense(ctx, lic)
+}
+
+func (r *tenantScopedLicenseRepository) UpdateLicense(ctx context.Context, licId string, newLic *License) error {
+	if newLic.PossessingCustomerAccountId() != r.accId {
+		return r.outOfScopeError(newLic.PossessingCustomerAccountId())
+	}
+	// the stored license must be in scope too, not only the new state
+	if _, err := r.GetLicenseById(ctx, licId); err != nil {
+		return err
+	}
+	return r.repo.UpdateLicense(ctx, licId, newLic)
+}
+
+func (r *tenantScopedLicenseRepository) GetLicenseById(ctx context.Context, licId string) (*License, error) {
+	lic, err := r.repo.GetLicenseById(ctx, licId)
+	if err != nil {
+		return nil, err
+	}
+	if lic.PossessingCustomerAccountId() != r.accId {
+		return nil, fmt.Errorf("%w for id=%s", ErrLicenseNotFound, licId)
+	}
+	return lic, nil
+}
+
+func (r *tenantScopedLicenseRepository) FindLicensesByAssignedLicenseeId(ctx context.Context, licenseeId string) ([]*License, error) {
+	licenses, err := r.repo.FindLicensesByAssignedLicenseeId(ctx, licenseeId)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*License, 0, len(licenses))
+	for _, lic := range licenses {
+		if lic.PossessingCustomerAccountId() == r.accId {
+			results = append(results, lic)
+		}
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%w: none assigned to licenseeId=%s", ErrLicenseNotFound, licenseeId)
+	}
+	return results, nil
+}
+
+func (r *tenantScopedLicenseRepository) FindLicensesOfAccount(ctx context.Context, accId string) ([]*License, error) {
+	if accId != r.accId {
+		return nil, r.outOfScopeError(accId)
+	}
+	return r.repo.FindLicensesOfAccount(ctx, accId)
+}
+
+func (r *tenantScopedLicenseRepository) FindUnassignedLicensesOfPackage(ctx context.Context, accId string, pkgId string) ([]*License, error) {
+	if accId != r.accId {
+		return nil, r.outOfScopeError(accId)
+	}
+	return r.repo.FindUnassignedLicensesOfPackage(ctx, accId, pkgId)
+}
+
+func (r *tenantScopedLicenseRepository) FindLicensesToAssign(ctx context.Context, accId string, pkgId string, strategy SeatAllocationStrategy, at time.Time, limit int) ([]*License, error) {
+	if accId != r.accId {
+		return nil, r.outOfScopeError(accId)
+	}
+	return r.repo.FindLicensesToAssign(ctx, accId, pkgId, strategy, at, limit)
+}
+
+func (r *tenantScopedLicenseRepository) CountTotalUnassignedLicensesOfPackage(ctx context.Context, accId string, pkgId string) (int, error) {
+	if accId != r.accId {
+		return 0, r.outOfScopeError(accId)
+	}
+	return r.repo.CountTotalUnassignedLicensesOfPackage(ctx, accId, pkgId)
+}
+
+func (r *tenantScopedLicenseRepository) ListLicenses(ctx context.Context, query LicenseQuery) (*LicensePage, error) {
+	if query.AccountId != r.accId {
+		return nil, r.outOfScopeError(query.AccountId)
+	}
+	return r.repo.ListLicenses(ctx, query)
+}
+
+func (r *tenantScopedLicenseRepository) outOfScopeError(accId string) error {
+	return fmt.Errorf("account accId=%s is outside of the tenant scope accId=%s", accId, r.accId)
+}
+
+// Capacity pool repository constrained to the pools of a single customer account (the tenant)
+//
+// DDD Classification: Repository
+type tenantScopedCapacityPoolRepository struct {
+	repo  CapacityPoolRepository
+	accId string
+}
+
+func NewTenantScopedCapacityPoolRepository(repo CapacityPoolRepository, accId string) CapacityPoolRepository {
+	return &tenantScopedCapacityPoolRepository{repo: repo, accId: accId}
+}
+
+func (r *tenantScopedCapacityPoolRepository) GetCapacityPool(ctx context.Context, accId string, cpbId string) (*CapacityPool, error) {
+	if accId != r.accId {
+		return nil, fmt.Errorf("account accId=%s is outside of the tenant scope accId=%s", accId, r.accId)
+	}
+	return r.repo.GetCapacityPool(ctx, accId, cpbId)
+}
+
+func (r *tenantScopedCapacityPoolRepository) SaveCapacityPool(ctx context.Context, pool *CapacityPool) error {
+	if pool.AccountId() != r.accId {
+		return fmt.Errorf("account accId=%s is outside of the tenant scope accId=%s", pool.AccountId(), r.accId)
+	}
+	return r.repo.SaveCapacityPool(ctx, pool)
+}
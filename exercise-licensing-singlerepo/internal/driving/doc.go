@@ -0,0 +1,6 @@
+// Driving Layer code
+//
+// Driving Layer adapts a concrete technology (HTTP, CLI, Queue Consumer Handler, etc.) to the API facade of
+// the Application Layer: it translates incoming requests into use case invocations, and their results back
+// into the representation of the technology. It contains no business logic of its own.
+package driving
@@ -0,0 +1,163 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	app "github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/application/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// Paths served by the entitlement handler
+const (
+	// GET ?accId=&insId=&insUsrId= lists the entitlements of an instance user
+	EntitlementsPath = "/v1/entitlements"
+
+	// GET ?accId=&insId=&insUsrId=&cpbId= verifies the entitlement of an instance user to a capability
+	VerifyEntitlementPath = "/v1/entitlements/verify"
+)
+
+// JSON representation of an entitlement
+type EntitlementJson struct {
+	IsEntitled                             bool     `json:"isEntitled"`
+	IsEntitledToFeatureButExceedCapability bool     `json:"isEntitledToFeatureButExceedCapability"`
+	EvaluatedUserId                        string   `json:"evaluatedUserId"`
+	EvaluatedCapabilityId                  string   `json:"evaluatedCapabilityId"`
+	MissingRequiredCapabilityIds           []string `json:"missingRequiredCapabilityIds,omitempty"`
+	HasCapacityLimit                       bool     `json:"hasCapacityLimit"`
+	EffectiveCapacityLimit                 int      `json:"effectiveCapacityLimit"`
+	CapacityLimitUnit                      string   `json:"capacityLimitUnit,omitempty"`
+	IsPooledCapacity                       bool     `json:"isPooledCapacity"`
+	HasPersonalAllocation                  bool     `json:"hasPersonalAllocation"`
+	RemainingCapacity                      int      `json:"remainingCapacity"`
+	IsPoolExhausted                        bool     `json:"isPoolExhausted"`
+	IsPersonalAllocationExhausted          bool     `json:"isPersonalAllocationExhausted"`
+}
+
+// JSON representation of the entitlements of an instance user
+type EntitlementsJson struct {
+	Entitlements []EntitlementJson `json:"entitlements"`
+}
+
+// JSON representation of a failed request
+type ErrorJson struct {
+	Error string `json:"error"`
+}
+
+func ToEntitlementJson(ent licensing.Entitlement) EntitlementJson {
+	return EntitlementJson{
+		IsEntitled:                             ent.IsEntitled,
+		IsEntitledToFeatureButExceedCapability: ent.IsEntitledToFeatureButExceedCapability,
+		EvaluatedUserId:                        ent.EvaluatedUserId,
+		EvaluatedCapabilityId:                  ent.EvaluatedCapabilityId,
+		MissingRequiredCapabilityIds:           ent.MissingRequiredCapabilityIds,
+		HasCapacityLimit:                       ent.HasCapacityLimit,
+		EffectiveCapacityLimit:                 ent.EffectiveCapacityLimit,
+		CapacityLimitUnit:                      ent.CapacityLimitUnit,
+		IsPooledCapacity:                       ent.IsPooledCapacity,
+		HasPersonalAllocation:                  ent.HasPersonalAllocation,
+		RemainingCapacity:                      ent.RemainingCapacity,
+		IsPoolExhausted:                        ent.IsPoolExhausted,
+		IsPersonalAllocationExhausted:          ent.IsPersonalAllocationExhausted,
+	}
+}
+
+// Establishes the principal of an HTTP request, e.g. from its bearer token; an error rejects the request
+type Authenticator func(r *http.Request) (app.Principal, error)
+
+// Serves the entitlement checks of the licensing service over HTTP
+type entitlementHandler struct {
+	ls           app.LicensingService
+	authenticate Authenticator
+}
+
+func NewEntitlementHandler(ls app.LicensingService, authenticate Authenticator) http.Handler {
+	h := &entitlementHandler{ls: ls, authenticate: authenticate}
+	mux := http.NewServeMux()
+	mux.HandleFunc(EntitlementsPath, h.listEntitlements)
+	mux.HandleFunc(VerifyEntitlementPath, h.verifyEntitlement)
+	return mux
+}
+
+func (h *entitlementHandler) listEntitlements(w http.ResponseWriter, r *http.Request) {
+	params, ok := requiredQueryParams(w, r, "accId", "insId", "insUsrId")
+	if !ok {
+		return
+	}
+	principal, ok := principalOf(w, r, h.authenticate)
+	if !ok {
+		return
+	}
+	entitlements, err := h.ls.ListEntitlements(r.Context(), principal, params["accId"], params["insId"], params["insUsrId"])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	result := EntitlementsJson{Entitlements: make([]EntitlementJson, 0, len(entitlements))}
+	for _, ent := range entitlements {
+		result.Entitlements = append(result.Entitlements, ToEntitlementJson(ent))
+	}
+	writeJsonResponse(w, http.StatusOK, result)
+}
+
+func (h *entitlementHandler) verifyEntitlement(w http.ResponseWriter, r *http.Request) {
+	params, ok := requiredQueryParams(w, r, "accId", "insId", "insUsrId", "cpbId")
+	if !ok {
+		return
+	}
+	principal, ok := principalOf(w, r, h.authenticate)
+	if !ok {
+		return
+	}
+	ent, err := h.ls.VerifyEntitlement(r.Context(), principal, params["accId"], params["insId"], params["insUsrId"], params["cpbId"])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, ToEntitlementJson(ent))
+}
+
+// Authenticates the request, responding with an error if it fails
+func principalOf(w http.ResponseWriter, r *http.Request, authenticate Authenticator) (app.Principal, bool) {
+	principal, err := authenticate(r)
+	if err != nil {
+		writeJsonResponse(w, http.StatusUnauthorized, ErrorJson{Error: err.Error()})
+		return app.Principal{}, false
+	}
+	return principal, true
+}
+
+func writeServiceError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, app.ErrPermissionDenied) {
+		status = http.StatusForbidden
+	}
+	writeJsonResponse(w, status, ErrorJson{Error: err.Error()})
+}
+
+// Reads the named query parameters of a GET request, responding with an error if any is missing
+func requiredQueryParams(w http.ResponseWriter, r *http.Request, names ...string) (map[string]string, bool) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJsonResponse(w, http.StatusMethodNotAllowed, ErrorJson{Error: "method " + r.Method + " not allowed"})
+		return nil, false
+	}
+	params := make(map[string]string)
+	query := r.URL.Query()
+	for _, name := range names {
+		value := query.Get(name)
+		if value == "" {
+			writeJsonResponse(w, http.StatusBadRequest, ErrorJson{Error: "missing query parameter " + name})
+			return nil, false
+		}
+		params[name] = value
+	}
+	return params, true
+}
+
+func writeJsonResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
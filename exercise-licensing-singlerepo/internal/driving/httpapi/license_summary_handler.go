@@ -0,0 +1,138 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	app "github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/application/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// Paths served by the license summary handler
+const (
+	// GET ?accId=[&expiringSoonDays=][&at=] gathers the license assignment summary of a customer account;
+	// at is an RFC 3339 time, now if missing
+	LicenseAssignmentSummaryPath = "/v1/licenses/summary"
+)
+
+// JSON representation of license assignment totals
+type LicenseAssignmentTotalsJson struct {
+	Issued       int `json:"issued"`
+	Assigned     int `json:"assigned"`
+	Unassigned   int `json:"unassigned"`
+	Trial        int `json:"trial"`
+	ExpiringSoon int `json:"expiringSoon"`
+	Expired      int `json:"expired"`
+	Cancelled    int `json:"cancelled"`
+	Renewed      int `json:"renewed"`
+	InOverage    int `json:"inOverage"`
+}
+
+// JSON representation of the license assignment totals of a subscription
+type SubscriptionAssignmentSummaryJson struct {
+	SubscriptionId string                      `json:"subscriptionId"`
+	Totals         LicenseAssignmentTotalsJson `json:"totals"`
+}
+
+// JSON representation of the license assignment totals of a package
+type PackageAssignmentSummaryJson struct {
+	PackageId     string                              `json:"packageId"`
+	Totals        LicenseAssignmentTotalsJson         `json:"totals"`
+	Subscriptions []SubscriptionAssignmentSummaryJson `json:"subscriptions"`
+}
+
+// JSON representation of the license assignment summary of a customer account
+type LicenseAssignmentSummaryJson struct {
+	AccountId        string                         `json:"accountId"`
+	SummarizedAt     time.Time                      `json:"summarizedAt"`
+	ExpiringSoonDays int                            `json:"expiringSoonDays"`
+	Totals           LicenseAssignmentTotalsJson    `json:"totals"`
+	Packages         []PackageAssignmentSummaryJson `json:"packages"`
+}
+
+func ToLicenseAssignmentSummaryJson(summary licensing.LicenseAssignmentSummary) LicenseAssignmentSummaryJson {
+	result := LicenseAssignmentSummaryJson{
+		AccountId:        summary.AccountId,
+		SummarizedAt:     summary.SummarizedAt,
+		ExpiringSoonDays: int(summary.ExpiringSoonWindow / (24 * time.Hour)),
+		Totals:           toLicenseAssignmentTotalsJson(summary.Totals),
+		Packages:         make([]PackageAssignmentSummaryJson, 0, len(summary.Packages)),
+	}
+	for _, pkgSummary := range summary.Packages {
+		pkgJson := PackageAssignmentSummaryJson{
+			PackageId:     pkgSummary.PackageId,
+			Totals:        toLicenseAssignmentTotalsJson(pkgSummary.Totals),
+			Subscriptions: make([]SubscriptionAssignmentSummaryJson, 0, len(pkgSummary.Subscriptions)),
+		}
+		for _, subSummary := range pkgSummary.Subscriptions {
+			pkgJson.Subscriptions = append(pkgJson.Subscriptions, SubscriptionAssignmentSummaryJson{
+				SubscriptionId: subSummary.SubscriptionId,
+				Totals:         toLicenseAssignmentTotalsJson(subSummary.Totals),
+			})
+		}
+		result.Packages = append(result.Packages, pkgJson)
+	}
+	return result
+}
+
+func toLicenseAssignmentTotalsJson(totals licensing.LicenseAssignmentTotals) LicenseAssignmentTotalsJson {
+	return LicenseAssignmentTotalsJson{
+		Issued:       totals.Issued,
+		Assigned:     totals.Assigned,
+		Unassigned:   totals.Unassigned,
+		Trial:        totals.Trial,
+		ExpiringSoon: totals.ExpiringSoon,
+		Expired:      totals.Expired,
+		Cancelled:    totals.Cancelled,
+		Renewed:      totals.Renewed,
+		InOverage:    totals.InOverage,
+	}
+}
+
+// Serves the license assignment summaries of the licensing service over HTTP, for customer admins
+type licenseSummaryHandler struct {
+	ls           app.LicensingService
+	authenticate Authenticator
+}
+
+func NewLicenseSummaryHandler(ls app.LicensingService, authenticate Authenticator) http.Handler {
+	h := &licenseSummaryHandler{ls: ls, authenticate: authenticate}
+	mux := http.NewServeMux()
+	mux.HandleFunc(LicenseAssignmentSummaryPath, h.gatherLicenseAssignmentSummary)
+	return mux
+}
+
+func (h *licenseSummaryHandler) gatherLicenseAssignmentSummary(w http.ResponseWriter, r *http.Request) {
+	params, ok := requiredQueryParams(w, r, "accId")
+	if !ok {
+		return
+	}
+	opts := make([]app.GatherLicenseAssignmentSummaryOption, 0)
+	if value := r.URL.Query().Get("expiringSoonDays"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			writeJsonResponse(w, http.StatusBadRequest, ErrorJson{Error: "invalid query parameter expiringSoonDays " + value})
+			return
+		}
+		opts = append(opts, app.ExpiringSoonWithin(time.Duration(days)*24*time.Hour))
+	}
+	if value := r.URL.Query().Get("at"); value != "" {
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeJsonResponse(w, http.StatusBadRequest, ErrorJson{Error: "invalid query parameter at " + value})
+			return
+		}
+		opts = append(opts, app.SummarizedAt(at))
+	}
+	principal, ok := principalOf(w, r, h.authenticate)
+	if !ok {
+		return
+	}
+	summary, err := h.ls.GatherLicenseAssignmentSummary(r.Context(), principal, params["accId"], opts...)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, ToLicenseAssignmentSummaryJson(summary))
+}
@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	app "github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/application/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+	"gotest.tools/v3/assert"
+)
+
+func TestLicenseSummaryHandler(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := app.NewLicensingService(&licRepo, &pkgRepo)
+	_, err := ls.IssueLicenses(ctx, app.NewLicenseAdmin("license-admin"), "acc-1", "sub-1", "pkg:base-optimize-2022", 2)
+	assert.NilError(t, err)
+	_, err = ls.AssignAvailableLicenseOfPackage(ctx, app.NewCustomerAdmin("customer-admin", "acc-1"), "pkg:base-optimize-2022", "acc-1", "ins-101", "usr-alice")
+	assert.NilError(t, err)
+
+	server := httptest.NewServer(NewLicenseSummaryHandler(ls, func(r *http.Request) (app.Principal, error) {
+		if r.Header.Get("Authorization") != "Bearer admin-secret" {
+			return app.Principal{}, errors.New("invalid credentials")
+		}
+		return app.NewCustomerAdmin("customer-admin", "acc-1"), nil
+	}))
+	defer server.Close()
+	get := func(t *testing.T, query string, v interface{}) int {
+		req, err := http.NewRequest(http.MethodGet, server.URL+LicenseAssignmentSummaryPath+"?"+query, nil)
+		assert.NilError(t, err)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		resp, err := server.Client().Do(req)
+		assert.NilError(t, err)
+		defer resp.Body.Close()
+		assert.NilError(t, json.NewDecoder(resp.Body).Decode(v))
+		return resp.StatusCode
+	}
+
+	t.Run("summary of the account", func(t *testing.T) {
+		var summary LicenseAssignmentSummaryJson
+		assert.Equal(t, get(t, "accId=acc-1&expiringSoonDays=7", &summary), http.StatusOK)
+		assert.Equal(t, summary.ExpiringSoonDays, 7)
+		assert.DeepEqual(t, summary.Totals, LicenseAssignmentTotalsJson{Issued: 2, Assigned: 1, Unassigned: 1})
+		assert.Equal(t, len(summary.Packages), 1)
+		assert.DeepEqual(t, summary.Packages[0].Subscriptions, []SubscriptionAssignmentSummaryJson{
+			{SubscriptionId: "sub-1", Totals: LicenseAssignmentTotalsJson{Issued: 2, Assigned: 1, Unassigned: 1}},
+		})
+	})
+
+	t.Run("invalid and forbidden requests", func(t *testing.T) {
+		var errJson ErrorJson
+		assert.Equal(t, get(t, "accId=acc-1&at=yesterday", &errJson), http.StatusBadRequest)
+		assert.Equal(t, errJson.Error, "invalid query parameter at yesterday")
+		assert.Equal(t, get(t, "accId=acc-2", &errJson), http.StatusForbidden)
+	})
+}
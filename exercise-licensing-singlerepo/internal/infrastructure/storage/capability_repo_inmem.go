@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+type CapabilityRepoInMem struct {
+	storage map[string]licensing.Capability
+}
+
+func NewCapabilityRepoInMem() *CapabilityRepoInMem {
+	r := CapabilityRepoInMem{}
+	r.storage = make(map[string]licensing.Capability)
+
+	for _, cpb := range loadCatalog2022().capabilities {
+		r.storage[cpb.Id] = cpb
+	}
+	return &r
+}
+
+func (r *CapabilityRepoInMem) CreateCapability(ctx context.Context, cpb licensing.Capability) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, ok := r.storage[cpb.Id]; ok {
+		return fmt.Errorf("capability already exists for cpbId=%s", cpb.Id)
+	}
+	r.storage[cpb.Id] = cpb
+	return nil
+}
+
+func (r *CapabilityRepoInMem) UpdateCapability(ctx context.Context, cpb licensing.Capability) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, ok := r.storage[cpb.Id]; !ok {
+		return fmt.Errorf("capability not found for cpbId=%s", cpb.Id)
+	}
+	r.storage[cpb.Id] = cpb
+	return nil
+}
+
+func (r *CapabilityRepoInMem) GetCapabilityById(ctx context.Context, cpbId string) (licensing.Capability, error) {
+	if err := ctx.Err(); err != nil {
+		return licensing.Capability{}, err
+	}
+	if result, ok := r.storage[cpbId]; ok {
+		return result, nil
+	}
+	return licensing.Capability{}, fmt.Errorf("capability not found for cpbId=%s", cpbId)
+}
+
+func (r *CapabilityRepoInMem) ListCapabilities(ctx context.Context) ([]licensing.Capability, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	results := make([]licensing.Capability, 0, len(r.storage))
+	for _, cpb := range r.storage {
+		results = append(results, cpb)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Id < results[j].Id })
+	return results, nil
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// Capacity pool repository kept in memory, safe for concurrent use.
+// Pools are stored and returned as copies, so that callers never share state with the repository.
+type CapacityPoolRepoInMem struct {
+	mu      sync.Mutex
+	storage map[string]*licensing.CapacityPool
+}
+
+func NewCapacityPoolRepoInMem() *CapacityPoolRepoInMem {
+	r := CapacityPoolRepoInMem{}
+	r.storage = make(map[string]*licensing.CapacityPool)
+	return &r
+}
+
+func (r *CapacityPoolRepoInMem) GetCapacityPool(ctx context.Context, accId string, cpbId string) (*licensing.CapacityPool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if result, ok := r.storage[capacityPoolKey(accId, cpbId)]; ok {
+		return result.Clone(), nil
+	}
+	return nil, fmt.Errorf("%w for accId=%s, cpbId=%s", licensing.ErrCapacityPoolNotFound, accId, cpbId)
+}
+
+func (r *CapacityPoolRepoInMem) SaveCapacityPool(ctx context.Context, pool *licensing.CapacityPool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := capacityPoolKey(pool.AccountId(), pool.CapabilityId())
+	var storedVersion int64
+	if stored, ok := r.storage[key]; ok {
+		storedVersion = stored.Version()
+	}
+	if storedVersion != pool.Version() {
+		return fmt.Errorf("%w: pool of accId=%s, cpbId=%s is at version %d, save is based on version %d",
+			licensing.ErrCapacityPoolVersionConflict, pool.AccountId(), pool.CapabilityId(), storedVersion, pool.Version())
+	}
+	pool.SetPersistedVersion(storedVersion + 1)
+	r.storage[key] = pool.Clone()
+	return nil
+}
+
+func capacityPoolKey(accId string, cpbId string) string {
+	return accId + "/" + cpbId
+}
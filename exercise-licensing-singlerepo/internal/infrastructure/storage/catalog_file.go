@@ -0,0 +1,395 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"gopkg.in/yaml.v3"
+)
+
+// Version of the catalog file format understood by this parser
+const catalogFileFormatVersion = 1
+
+// A problem found in a catalog file, pointing at the offending field
+type CatalogFileError struct {
+
+	// Path of the catalog file
+	File string
+
+	// Line of the offending field, 0 if not known
+	Line int
+
+	// Path of the offending field within the document, e.g. "packages[1].capabilities[0].id"
+	Field string
+
+	// What is wrong with the field
+	Message string
+}
+
+func (e *CatalogFileError) Error() string {
+	return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Field, e.Message)
+}
+
+// All problems found in a catalog file
+type CatalogFileErrors []*CatalogFileError
+
+func (errs CatalogFileErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Catalog content defined by a catalog file
+type catalogContent struct {
+	capabilities []licensing.Capability
+	packages     []*licensing.Package
+	plans        []*licensing.PackagingPlan
+	catalog      *licensing.CapabilityCatalog
+}
+
+// Parses and validates a catalog file, in YAML or JSON (a subset of YAML).
+//
+// See catalog/catalog-2022.yaml for the format.
+func parseCatalogFile(file string, data []byte) (*catalogContent, error) {
+	p := &catalogParser{file: file}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, CatalogFileErrors{{File: file, Field: "(document)", Message: err.Error()}}
+	}
+	if len(doc.Content) == 0 {
+		return nil, CatalogFileErrors{{File: file, Field: "(document)", Message: "empty catalog file"}}
+	}
+	content := p.parseDocument(doc.Content[0])
+	if len(p.errs) > 0 {
+		return nil, p.errs
+	}
+	return content, nil
+}
+
+type catalogParser struct {
+	file string
+	errs CatalogFileErrors
+}
+
+func (p *catalogParser) fail(node *yaml.Node, field string, format string, args ...interface{}) {
+	p.errs = append(p.errs, &CatalogFileError{File: p.file, Line: node.Line, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (p *catalogParser) parseDocument(root *yaml.Node) *catalogContent {
+	fields := p.mapping(root, "(document)", "formatVersion", "capabilities", "packages", "packagingPlans")
+	if fields == nil {
+		return nil
+	}
+	version, ok := p.int(fields, "formatVersion", "formatVersion", true)
+	if ok && version != catalogFileFormatVersion {
+		p.fail(fields.values["formatVersion"], "formatVersion", "unsupported format version %d, expected %d", version, catalogFileFormatVersion)
+	}
+
+	content := &catalogContent{}
+	capabilitiesById := make(map[string]licensing.Capability)
+	for i, node := range p.sequence(fields, "capabilities", "capabilities", true) {
+		path := fmt.Sprintf("capabilities[%d]", i)
+		cpb, ok := p.parseCapability(node, path)
+		if !ok {
+			continue
+		}
+		if _, dup := capabilitiesById[cpb.Id]; dup {
+			p.fail(node, path+".id", "duplicate capability id %q", cpb.Id)
+			continue
+		}
+		capabilitiesById[cpb.Id] = cpb
+		content.capabilities = append(content.capabilities, cpb)
+	}
+
+	packagesById := make(map[string]*licensing.Package)
+	packageNodes := make(map[string]*yaml.Node)
+	for i, node := range p.sequence(fields, "packages", "packages", true) {
+		path := fmt.Sprintf("packages[%d]", i)
+		pkg, ok := p.parsePackage(node, path, capabilitiesById)
+		if !ok {
+			continue
+		}
+		if _, dup := packagesById[pkg.Id]; dup {
+			p.fail(node, path+".id", "duplicate package id %q", pkg.Id)
+			continue
+		}
+		packagesById[pkg.Id] = pkg
+		packageNodes[pkg.Id] = node
+		content.packages = append(content.packages, pkg)
+	}
+
+	plansById := make(map[string]bool)
+	for i, node := range p.sequence(fields, "packagingPlans", "packagingPlans", false) {
+		path := fmt.Sprintf("packagingPlans[%d]", i)
+		plan, ok := p.parsePackagingPlan(node, path, packagesById)
+		if !ok {
+			continue
+		}
+		if plansById[plan.Id] {
+			p.fail(node, path+".id", "duplicate packaging plan id %q", plan.Id)
+			continue
+		}
+		plansById[plan.Id] = true
+		content.plans = append(content.plans, plan)
+	}
+
+	if len(p.errs) > 0 {
+		return nil
+	}
+	catalog, err := licensing.NewCapabilityCatalog(content.capabilities)
+	if err != nil {
+		p.fail(fields.keys["capabilities"], "capabilities", "%s", err)
+		return nil
+	}
+	for i, pkg := range content.packages {
+		if err := catalog.ValidatePackage(pkg); err != nil {
+			p.fail(packageNodes[pkg.Id], fmt.Sprintf("packages[%d]", i), "%s", err)
+		}
+	}
+	content.catalog = catalog
+	return content
+}
+
+func (p *catalogParser) parseCapability(node *yaml.Node, path string) (licensing.Capability, bool) {
+	fields := p.mapping(node, path,
+		"id", "displayName", "capacityLimit", "capacityLimitUnit", "capacityMergeRule", "capacityScope", "implies", "requires", "conflictsWith", "archived")
+	if fields == nil {
+		return licensing.Capability{}, false
+	}
+	errCount := len(p.errs)
+	cpb := licensing.Capability{
+		Id:                       p.string(fields, "id", path+".id", true),
+		DisplayName:              p.string(fields, "displayName", path+".displayName", true),
+		ImpliedCapabilityIds:     p.strings(fields, "implies", path+".implies"),
+		RequiredCapabilityIds:    p.strings(fields, "requires", path+".requires"),
+		ConflictingCapabilityIds: p.strings(fields, "conflictsWith", path+".conflictsWith"),
+		IsArchived:               p.bool(fields, "archived", path+".archived"),
+	}
+	p.capacityLimit(fields, path, &cpb)
+	switch rule := p.string(fields, "capacityMergeRule", path+".capacityMergeRule", false); rule {
+	case "", "max":
+		cpb.CapacityMergeRule = licensing.MERGE_MAX
+	case "sum":
+		cpb.CapacityMergeRule = licensing.MERGE_SUM
+	case "first-wins":
+		cpb.CapacityMergeRule = licensing.MERGE_FIRST_WINS
+	default:
+		p.fail(fields.values["capacityMergeRule"], path+".capacityMergeRule", "unknown merge rule %q, expected one of: max, sum, first-wins", rule)
+	}
+	switch scope := p.string(fields, "capacityScope", path+".capacityScope", false); scope {
+	case "", "user":
+		cpb.CapacityScope = licensing.PER_USER
+	case "account-pool-per-seat":
+		cpb.CapacityScope = licensing.ACCOUNT_POOL_PER_SEAT
+	case "account-pool-flat":
+		cpb.CapacityScope = licensing.ACCOUNT_POOL_FLAT
+	default:
+		p.fail(fields.values["capacityScope"], path+".capacityScope", "unknown capacity scope %q, expected one of: user, account-pool-per-seat, account-pool-flat", scope)
+	}
+	if cpb.CapacityScope.IsPooled() && !cpb.HasCapacityLimit {
+		p.fail(fields.values["capacityScope"], path+".capacityScope", "a pooled capacity requires capacityLimit")
+	}
+	return cpb, len(p.errs) == errCount
+}
+
+func (p *catalogParser) parsePackage(node *yaml.Node, path string, capabilitiesById map[string]licensing.Capability) (*licensing.Package, bool) {
+	fields := p.mapping(node, path, "id", "name", "capabilities", "archived")
+	if fields == nil {
+		return nil, false
+	}
+	errCount := len(p.errs)
+	pkg := &licensing.Package{
+		Id:         p.string(fields, "id", path+".id", true),
+		Name:       p.string(fields, "name", path+".name", true),
+		IsArchived: p.bool(fields, "archived", path+".archived"),
+	}
+	for i, cpbNode := range p.sequence(fields, "capabilities", path+".capabilities", true) {
+		cpbPath := fmt.Sprintf("%s.capabilities[%d]", path, i)
+		cpbFields := p.mapping(cpbNode, cpbPath, "id", "capacityLimit", "capacityLimitUnit")
+		if cpbFields == nil {
+			continue
+		}
+		cpbId := p.string(cpbFields, "id", cpbPath+".id", true)
+		if cpbId == "" {
+			continue
+		}
+		cpb, ok := capabilitiesById[cpbId]
+		if !ok {
+			p.fail(cpbFields.values["id"], cpbPath+".id", "unknown capability id %q", cpbId)
+			continue
+		}
+		_, hasLimit := cpbFields.values["capacityLimit"]
+		_, hasUnit := cpbFields.values["capacityLimitUnit"]
+		if hasLimit || hasUnit {
+			p.capacityLimit(cpbFields, cpbPath, &cpb)
+		}
+		pkg.IncludedCapabilities = append(pkg.IncludedCapabilities, cpb)
+	}
+	return pkg, len(p.errs) == errCount
+}
+
+func (p *catalogParser) parsePackagingPlan(node *yaml.Node, path string, packagesById map[string]*licensing.Package) (*licensing.PackagingPlan, bool) {
+	fields := p.mapping(node, path, "id", "majorVersion", "revision", "createdAt", "packages")
+	if fields == nil {
+		return nil, false
+	}
+	errCount := len(p.errs)
+	plan := &licensing.PackagingPlan{
+		Id: p.string(fields, "id", path+".id", true),
+	}
+	plan.MajorVersion, _ = p.int(fields, "majorVersion", path+".majorVersion", true)
+	plan.Revision, _ = p.int(fields, "revision", path+".revision", true)
+	if createdAt := p.string(fields, "createdAt", path+".createdAt", true); createdAt != "" {
+		t, err := time.Parse(time.RFC3339, createdAt)
+		if err != nil {
+			p.fail(fields.values["createdAt"], path+".createdAt", "expected an RFC 3339 timestamp, got %q", createdAt)
+		}
+		plan.CreatedAt = t
+	}
+	for i, pkgNode := range p.sequence(fields, "packages", path+".packages", true) {
+		pkgPath := fmt.Sprintf("%s.packages[%d]", path, i)
+		if pkgNode.Kind != yaml.ScalarNode {
+			p.fail(pkgNode, pkgPath, "expected a package id")
+			continue
+		}
+		pkg, ok := packagesById[pkgNode.Value]
+		if !ok {
+			p.fail(pkgNode, pkgPath, "unknown package id %q", pkgNode.Value)
+			continue
+		}
+		plan.SupportedPackages = append(plan.SupportedPackages, pkg)
+	}
+	return plan, len(p.errs) == errCount
+}
+
+// Parses the capacity limit fields of a capability definition into the given capability
+func (p *catalogParser) capacityLimit(fields *catalogFields, path string, cpb *licensing.Capability) {
+	limit, hasLimit := p.int(fields, "capacityLimit", path+".capacityLimit", false)
+	unit := p.string(fields, "capacityLimitUnit", path+".capacityLimitUnit", hasLimit)
+	if !hasLimit {
+		if unit != "" {
+			p.fail(fields.keys["capacityLimitUnit"], path+".capacityLimitUnit", "capacityLimitUnit requires capacityLimit")
+		}
+		cpb.HasCapacityLimit, cpb.CapacityLimit, cpb.CapacityLimitUnit = false, 0, "NotApplicable"
+		return
+	}
+	if limit <= 0 {
+		p.fail(fields.values["capacityLimit"], path+".capacityLimit", "must be positive, got %d", limit)
+	}
+	cpb.HasCapacityLimit, cpb.CapacityLimit, cpb.CapacityLimitUnit = true, limit, unit
+}
+
+// Fields of a mapping node
+type catalogFields struct {
+
+	// the mapping node itself
+	node *yaml.Node
+
+	// value nodes by field name
+	values map[string]*yaml.Node
+
+	// key nodes by field name
+	keys map[string]*yaml.Node
+}
+
+// Returns the fields of a mapping node, reporting unknown and duplicate fields; nil if not a mapping
+func (p *catalogParser) mapping(node *yaml.Node, path string, known ...string) *catalogFields {
+	if node.Kind != yaml.MappingNode {
+		p.fail(node, path, "expected a mapping")
+		return nil
+	}
+	fields := &catalogFields{node: node, values: make(map[string]*yaml.Node), keys: make(map[string]*yaml.Node)}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		isKnown := false
+		for _, name := range known {
+			isKnown = isKnown || name == key.Value
+		}
+		if !isKnown {
+			p.fail(key, path+"."+key.Value, "unknown field, expected one of: %s", strings.Join(known, ", "))
+			continue
+		}
+		if _, dup := fields.values[key.Value]; dup {
+			p.fail(key, path+"."+key.Value, "duplicate field")
+			continue
+		}
+		fields.values[key.Value] = value
+		fields.keys[key.Value] = key
+	}
+	return fields
+}
+
+func (p *catalogParser) string(fields *catalogFields, name string, path string, required bool) string {
+	node, ok := fields.values[name]
+	if !ok {
+		if required {
+			p.fail(fields.node, path, "required field is missing")
+		}
+		return ""
+	}
+	if node.Kind != yaml.ScalarNode || node.Value == "" {
+		p.fail(node, path, "expected a non-empty string")
+		return ""
+	}
+	return node.Value
+}
+
+func (p *catalogParser) int(fields *catalogFields, name string, path string, required bool) (int, bool) {
+	node, ok := fields.values[name]
+	if !ok {
+		if required {
+			p.fail(fields.node, path, "required field is missing")
+		}
+		return 0, false
+	}
+	var value int
+	if node.Kind != yaml.ScalarNode || node.Decode(&value) != nil {
+		p.fail(node, path, "expected an integer, got %q", node.Value)
+		return 0, false
+	}
+	return value, true
+}
+
+func (p *catalogParser) bool(fields *catalogFields, name string, path string) bool {
+	node, ok := fields.values[name]
+	if !ok {
+		return false
+	}
+	var value bool
+	if node.Kind != yaml.ScalarNode || node.Decode(&value) != nil {
+		p.fail(node, path, "expected true or false, got %q", node.Value)
+		return false
+	}
+	return value
+}
+
+func (p *catalogParser) strings(fields *catalogFields, name string, path string) []string {
+	var results []string
+	for i, node := range p.sequence(fields, name, path, false) {
+		if node.Kind != yaml.ScalarNode || node.Value == "" {
+			p.fail(node, fmt.Sprintf("%s[%d]", path, i), "expected a non-empty string")
+			continue
+		}
+		results = append(results, node.Value)
+	}
+	return results
+}
+
+func (p *catalogParser) sequence(fields *catalogFields, name string, path string, required bool) []*yaml.Node {
+	node, ok := fields.values[name]
+	if !ok {
+		if required {
+			p.fail(fields.node, path, "required field is missing")
+		}
+		return nil
+	}
+	if node.Kind != yaml.SequenceNode {
+		p.fail(node, path, "expected a list")
+		return nil
+	}
+	return node.Content
+}
@@ -0,0 +1,18 @@
+package storage
+
+import (
+	_ "embed"
+)
+
+// Catalog of the 2022 packaging plan, seeding the in-memory repositories
+//
+//go:embed catalog/catalog-2022.yaml
+var catalog2022File []byte
+
+func loadCatalog2022() *catalogContent {
+	content, err := parseCatalogFile("catalog/catalog-2022.yaml", catalog2022File)
+	if err != nil {
+		panic(err)
+	}
+	return content
+}
@@ -0,0 +1,94 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage/storagetest"
+	_ "github.com/mattn/go-sqlite3"
+	"gotest.tools/v3/assert"
+)
+
+// Every repository implementation passes the same conformance suite, so that they are interchangeable
+
+func TestLicenseRepositoryConformance(t *testing.T) {
+
+	newInMem := func(t *testing.T) (licensing.LicenseRepository, licensing.PackageRepository) {
+		return storage.NewLicenseRepoInMem(), storage.NewPackageRepoInMem()
+	}
+	newFile := func(t *testing.T) (licensing.LicenseRepository, licensing.PackageRepository) {
+		pkgRepo := storage.NewPackageRepoInMem()
+		r, err := storage.NewLicenseRepoFile(filepath.Join(t.TempDir(), "licenses.json"), pkgRepo)
+		assert.NilError(t, err)
+		return r, pkgRepo
+	}
+	newSql := func(t *testing.T) (licensing.LicenseRepository, licensing.PackageRepository) {
+		db := openSqlDb(t)
+		pkgRepo := storage.NewPackageRepoSql(db)
+		return storage.NewLicenseRepoSql(db, pkgRepo), pkgRepo
+	}
+	newEventSourced := func(t *testing.T) (licensing.LicenseRepository, licensing.PackageRepository) {
+		pkgRepo := storage.NewPackageRepoInMem()
+		return storage.NewLicenseRepoEventSourced(pkgRepo, storage.WithSnapshotInterval(2)), pkgRepo
+	}
+
+	t.Run("in-memory", func(t *testing.T) {
+		storagetest.TestLicenseRepository(t, newInMem)
+		storagetest.TestLicenseUnitOfWork(t, newInMem)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		storagetest.TestLicenseRepository(t, newFile)
+		storagetest.TestLicenseUnitOfWork(t, newFile)
+	})
+
+	t.Run("sql", func(t *testing.T) {
+		storagetest.TestLicenseRepository(t, newSql)
+		storagetest.TestLicenseUnitOfWork(t, newSql)
+	})
+
+	t.Run("event-sourced", func(t *testing.T) {
+		storagetest.TestLicenseRepository(t, newEventSourced)
+		storagetest.TestLicenseUnitOfWork(t, newEventSourced)
+	})
+}
+
+func TestPackageRepositoryConformance(t *testing.T) {
+
+	newInMem := func(t *testing.T) licensing.PackageRepository { return storage.NewPackageRepoInMem() }
+	newSql := func(t *testing.T) licensing.PackageRepository { return storage.NewPackageRepoSql(openSqlDb(t)) }
+
+	t.Run("in-memory", func(t *testing.T) {
+		storagetest.TestPackageRepository(t, newInMem)
+		storagetest.TestPackageRepositoryWrites(t, newInMem)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		storagetest.TestPackageRepository(t, func(t *testing.T) licensing.PackageRepository {
+			r, err := storage.NewPackageRepoFile("catalog/catalog-2022.yaml")
+			assert.NilError(t, err)
+			return r
+		})
+	})
+
+	t.Run("sql", func(t *testing.T) {
+		storagetest.TestPackageRepository(t, newSql)
+		storagetest.TestPackageRepositoryWrites(t, newSql)
+	})
+}
+
+// Opens a migrated SQLite database in a temporary file, holding the 2022 catalog
+func openSqlDb(t *testing.T) *sql.DB {
+	path := filepath.Join(t.TempDir(), "licensing.db")
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=10000&_txlock=immediate")
+	assert.NilError(t, err)
+	t.Cleanup(func() { db.Close() })
+	ctx := context.Background()
+	assert.NilError(t, storage.MigrateSqlSchema(ctx, db))
+	assert.NilError(t, storage.NewPackageRepoSql(db).ImportCatalogFile(ctx, "catalog/catalog-2022.yaml"))
+	return db
+}
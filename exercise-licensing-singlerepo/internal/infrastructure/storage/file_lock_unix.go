@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// Advisory lock on a lock file, held by the open file descriptor
+type fileLock struct {
+	f *os.File
+}
+
+// Locks the file at path, shared or exclusively, creating the file if missing. Blocks until the lock is granted.
+func lockFile(path string, exclusive bool) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err = syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "flock", Path: path, Err: err}
+	}
+	return &fileLock{f: f}, nil
+}
+
+func (l *fileLock) unlock() error {
+	// closing the descriptor releases the lock
+	return l.f.Close()
+}
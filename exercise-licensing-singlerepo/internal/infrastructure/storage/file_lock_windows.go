@@ -0,0 +1,47 @@
+//go:build windows
+// +build windows
+
+package storage
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Lock on a lock file, held by the open file handle
+type fileLock struct {
+	f *os.File
+}
+
+// Locks the file at path, shared or exclusively, creating the file if missing. Blocks until the lock is granted.
+func lockFile(path string, exclusive bool) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	var flags uint32
+	if exclusive {
+		flags = windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	// lock the whole file, whatever its size
+	ol := new(windows.Overlapped)
+	if err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, ^uint32(0), ^uint32(0), ol); err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "LockFileEx", Path: path, Err: err}
+	}
+	return &fileLock{f: f}, nil
+}
+
+func (l *fileLock) unlock() error {
+	ol := new(windows.Overlapped)
+	err := windows.UnlockFileEx(windows.Handle(l.f.Fd()), 0, ^uint32(0), ^uint32(0), ol)
+	// closing the handle releases the lock too, should unlocking fail
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return &os.PathError{Op: "UnlockFileEx", Path: l.f.Name(), Err: err}
+	}
+	return nil
+}
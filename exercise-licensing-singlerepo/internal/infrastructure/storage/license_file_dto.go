@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// JSON document of LicenseRepoFile.
+//
+// The DTOs below define the file format independently of the License aggregate, so that refactoring the domain
+// does not change the format by accident. Changing the format requires a new format version.
+type licenseFileDocument struct {
+	FormatVersion int              `json:"formatVersion"`
+	Licenses      []licenseFileDto `json:"licenses"`
+}
+
+type licenseFileDto struct {
+	Id                  string                     `json:"id"`
+	AccountId           string                     `json:"accountId"`
+	SubscriptionId      string                     `json:"subscriptionId"`
+	PackageId           string                     `json:"packageId"`
+	IsTrial             bool                       `json:"isTrial,omitempty"`
+	ExpiresAt           *time.Time                 `json:"expiresAt,omitempty"`
+	Issuance            *licenseIssuanceFileDto    `json:"issuance,omitempty"`
+	ExpiredAt           *time.Time                 `json:"expiredAt,omitempty"`
+	CancelledAt         *time.Time                 `json:"cancelledAt,omitempty"`
+	Renewal             *licenseRenewalFileDto     `json:"renewal,omitempty"`
+	CurrentAssignment   *licenseAssignmentFileDto  `json:"currentAssignment,omitempty"`
+	PreviousAssignments []licenseAssignmentFileDto `json:"previousAssignments,omitempty"`
+	Version             int64                      `json:"version"`
+}
+
+type licenseIssuanceFileDto struct {
+	IssuedAt time.Time `json:"issuedAt"`
+	Reason   string    `json:"reason"`
+}
+
+type licenseRenewalFileDto struct {
+	RenewedToLicenseId string    `json:"renewedToLicenseId"`
+	RenewedAt          time.Time `json:"renewedAt"`
+	Reason             string    `json:"reason"`
+}
+
+type licenseAssignmentFileDto struct {
+	Licensee     licenseeFileDto `json:"licensee"`
+	AssignedAt   time.Time       `json:"assignedAt"`
+	UnassignedAt *time.Time      `json:"unassignedAt,omitempty"`
+}
+
+// A licensee by type, with the fields of its type
+type licenseeFileDto struct {
+	Id             string `json:"id"`
+	Type           string `json:"type"`
+	InstanceId     string `json:"instanceId,omitempty"`
+	UserId         string `json:"userId"`
+	OrganizationId string `json:"organizationId,omitempty"`
+	EmailAddress   string `json:"emailAddress,omitempty"`
+}
+
+func toLicenseFileDto(state licensing.LicenseState) licenseFileDto {
+	dto := licenseFileDto{
+		Id:             state.Id,
+		AccountId:      state.PossessingCustomerAccountId,
+		SubscriptionId: state.GoverningSubscriptionId,
+		PackageId:      state.LicensedPackage.Id,
+		IsTrial:        state.IsTrial,
+		ExpiresAt:      optionalTime(state.ExpiresAt),
+		Version:        state.Version,
+	}
+	if state.IssuanceDetail != nil {
+		dto.Issuance = &licenseIssuanceFileDto{IssuedAt: state.IssuanceDetail.IssuedAt, Reason: state.IssuanceDetail.IssuanceReason}
+	}
+	if state.ExpirationDetail != nil {
+		dto.ExpiredAt = &state.ExpirationDetail.ExpiredAt
+	}
+	if state.CancellationDetail != nil {
+		dto.CancelledAt = &state.CancellationDetail.CancelledAt
+	}
+	if state.RenewalDetail != nil {
+		dto.Renewal = &licenseRenewalFileDto{
+			RenewedToLicenseId: state.RenewalDetail.RenewedToLicenseId,
+			RenewedAt:          state.RenewalDetail.RenewedAt,
+			Reason:             state.RenewalDetail.RenewalReason,
+		}
+	}
+	if state.CurrentAssignment != nil {
+		assignment := toLicenseAssignmentFileDto(state.CurrentAssignment)
+		dto.CurrentAssignment = &assignment
+	}
+	for _, previous := range state.PreviousAssignments {
+		dto.PreviousAssignments = append(dto.PreviousAssignments, toLicenseAssignmentFileDto(previous))
+	}
+	return dto
+}
+
+func (dto *licenseFileDto) toLicenseState(pkg *licensing.Package) (licensing.LicenseState, error) {
+	state := licensing.LicenseState{
+		Id:                          dto.Id,
+		LicensedPackage:             pkg,
+		PossessingCustomerAccountId: dto.AccountId,
+		GoverningSubscriptionId:     dto.SubscriptionId,
+		IsTrial:                     dto.IsTrial,
+		Version:                     dto.Version,
+	}
+	if dto.ExpiresAt != nil {
+		state.ExpiresAt = *dto.ExpiresAt
+	}
+	if dto.Issuance != nil {
+		state.IssuanceDetail = &licensing.LicenseIssuanceDetail{IssuedAt: dto.Issuance.IssuedAt, IssuanceReason: dto.Issuance.Reason}
+	}
+	if dto.ExpiredAt != nil {
+		state.ExpirationDetail = &licensing.LicenseExpirationDetail{ExpiredAt: *dto.ExpiredAt}
+	}
+	if dto.CancelledAt != nil {
+		state.CancellationDetail = &licensing.LicenseCancellationDetail{CancelledAt: *dto.CancelledAt}
+	}
+	if dto.Renewal != nil {
+		state.RenewalDetail = &licensing.LicenseRenewalDetail{
+			RenewedToLicenseId: dto.Renewal.RenewedToLicenseId,
+			RenewedAt:          dto.Renewal.RenewedAt,
+			RenewalReason:      dto.Renewal.Reason,
+		}
+	}
+	if dto.CurrentAssignment != nil {
+		assignment, err := dto.CurrentAssignment.toLicenseAssignment()
+		if err != nil {
+			return state, fmt.Errorf("license id=%s: %w", dto.Id, err)
+		}
+		state.CurrentAssignment = assignment
+	}
+	for _, previousDto := range dto.PreviousAssignments {
+		previous, err := previousDto.toLicenseAssignment()
+		if err != nil {
+			return state, fmt.Errorf("license id=%s: %w", dto.Id, err)
+		}
+		state.PreviousAssignments = append(state.PreviousAssignments, previous)
+	}
+	return state, nil
+}
+
+func toLicenseAssignmentFileDto(assignment *licensing.LicenseAssignment) licenseAssignmentFileDto {
+	dto := licenseAssignmentFileDto{
+		AssignedAt:   assignment.AssignedAt,
+		UnassignedAt: optionalTime(assignment.UnassignedAt),
+		Licensee: licenseeFileDto{
+			Id:   assignment.Assignee.LicenseeId(),
+			Type: assignment.Assignee.LicenseeType().String(),
+		},
+	}
+	switch l := assignment.Assignee.(type) {
+	case licensing.InstanceUser:
+		dto.Licensee.InstanceId, dto.Licensee.UserId = l.InstanceId, l.InstanceScopeUserId
+	case licensing.OrganizationUser:
+		dto.Licensee.OrganizationId, dto.Licensee.UserId, dto.Licensee.EmailAddress = l.OrganizationId, l.OrganizationScopeUserId, l.EmailAddress
+	}
+	return dto
+}
+
+func (dto licenseAssignmentFileDto) toLicenseAssignment() (*licensing.LicenseAssignment, error) {
+	assignment := &licensing.LicenseAssignment{AssignedAt: dto.AssignedAt}
+	if dto.UnassignedAt != nil {
+		assignment.UnassignedAt = *dto.UnassignedAt
+	}
+	switch dto.Licensee.Type {
+	case licensing.INSTANCE_USER.String():
+		assignment.Assignee = licensing.NewInstanceUser(dto.Licensee.InstanceId, dto.Licensee.UserId)
+	case licensing.ORGANIZATION_USER.String():
+		assignment.Assignee = licensing.NewOrganizationUser(dto.Licensee.OrganizationId, dto.Licensee.UserId, dto.Licensee.EmailAddress)
+	default:
+		return nil, fmt.Errorf("unsupported licensee type %q", dto.Licensee.Type)
+	}
+	return assignment, nil
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"container/heap"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// Licenses kept in a binary heap by an order, with O(log n) add and remove by id.
+// The first licenses in order are found by walking the heap from its root, without disturbing it.
+type licenseHeap struct {
+	licenses  []*licensing.License
+	positions map[string]int
+	precedes  func(a *licensing.License, b *licensing.License) bool
+}
+
+func newLicenseHeap(precedes func(a *licensing.License, b *licensing.License) bool) *licenseHeap {
+	return &licenseHeap{positions: make(map[string]int), precedes: precedes}
+}
+
+func (h *licenseHeap) add(lic *licensing.License) {
+	if _, ok := h.positions[lic.Id()]; ok {
+		return
+	}
+	heap.Push(h, lic)
+}
+
+func (h *licenseHeap) remove(licId string) {
+	if pos, ok := h.positions[licId]; ok {
+		heap.Remove(h, pos)
+	}
+}
+
+// Returns the first licenses in order that are kept, up to limit; visits the licenses passed over on the way
+func (h *licenseHeap) first(limit int, keep func(lic *licensing.License) bool) []*licensing.License {
+	results := make([]*licensing.License, 0)
+	// positions of the licenses whose parents were visited, the next one in order on top
+	frontier := &licenseHeapFrontier{h: h}
+	if h.Len() > 0 {
+		heap.Push(frontier, 0)
+	}
+	for frontier.Len() > 0 && len(results) < limit {
+		pos := heap.Pop(frontier).(int)
+		if keep(h.licenses[pos]) {
+			results = append(results, h.licenses[pos])
+		}
+		for _, child := range []int{2*pos + 1, 2*pos + 2} {
+			if child < h.Len() {
+				heap.Push(frontier, child)
+			}
+		}
+	}
+	return results
+}
+
+// Counts the licenses matching, provided that no license following one not matching in order matches;
+// visits only the licenses matching and the ones right after them
+func (h *licenseHeap) countFirst(matches func(lic *licensing.License) bool) int {
+	count := 0
+	pending := []int{0}
+	for len(pending) > 0 {
+		pos := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if pos >= h.Len() || !matches(h.licenses[pos]) {
+			continue
+		}
+		count++
+		pending = append(pending, 2*pos+1, 2*pos+2)
+	}
+	return count
+}
+
+func (h *licenseHeap) Len() int {
+	return len(h.licenses)
+}
+
+func (h *licenseHeap) Less(i, j int) bool {
+	return h.precedes(h.licenses[i], h.licenses[j])
+}
+
+func (h *licenseHeap) Swap(i, j int) {
+	h.licenses[i], h.licenses[j] = h.licenses[j], h.licenses[i]
+	h.positions[h.licenses[i].Id()] = i
+	h.positions[h.licenses[j].Id()] = j
+}
+
+func (h *licenseHeap) Push(x interface{}) {
+	lic := x.(*licensing.License)
+	h.positions[lic.Id()] = len(h.licenses)
+	h.licenses = append(h.licenses, lic)
+}
+
+func (h *licenseHeap) Pop() interface{} {
+	last := h.licenses[len(h.licenses)-1]
+	h.licenses[len(h.licenses)-1] = nil
+	h.licenses = h.licenses[:len(h.licenses)-1]
+	delete(h.positions, last.Id())
+	return last
+}
+
+// Positions in a license heap, ordered as the licenses at them
+type licenseHeapFrontier struct {
+	h         *licenseHeap
+	positions []int
+}
+
+func (f *licenseHeapFrontier) Len() int {
+	return len(f.positions)
+}
+
+func (f *licenseHeapFrontier) Less(i, j int) bool {
+	return f.h.Less(f.positions[i], f.positions[j])
+}
+
+func (f *licenseHeapFrontier) Swap(i, j int) {
+	f.positions[i], f.positions[j] = f.positions[j], f.positions[i]
+}
+
+func (f *licenseHeapFrontier) Push(x interface{}) {
+	f.positions = append(f.positions, x.(int))
+}
+
+func (f *licenseHeapFrontier) Pop() interface{} {
+	last := f.positions[len(f.positions)-1]
+	f.positions = f.positions[:len(f.positions)-1]
+	return last
+}
@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// In-memory, event-sourced license repository, safe for concurrent use.
+//
+// Instead of overwriting licenses, the repository appends the events of every change to the stream of the license:
+// issued, assigned, unassigned, expired and renewed. A license is rebuilt from its latest snapshot and the events
+// recorded after it; snapshots are taken every few versions, so that rebuilding does not slow down as streams grow.
+// Appending is optimistic: the events of an update are only appended if the stream is still at the version the
+// license was loaded from.
+//
+// The find and count methods are served by a read model kept up to date with the log. Other read models are
+// built by replaying the log, see ReplayEvents.
+type LicenseRepoEventSourced struct {
+	mu sync.RWMutex
+
+	// log of all streams, in order of appending
+	log []LicenseStreamEvent
+
+	// positions of the events in log, by license id
+	streams map[string][]int64
+
+	// latest snapshot of each license
+	snapshots map[string]licensing.LicenseState
+
+	// versions between snapshots of a license; no snapshots if 0
+	snapshotInterval int64
+
+	// current state of the licenses, serving the queries
+	readModel *LicenseRepoInMem
+
+	// resolves the packages of rebuilt licenses
+	pkgRepo licensing.PackageRepository
+}
+
+type LicenseRepoEventSourcedOption func(r *LicenseRepoEventSourced)
+
+// Takes a snapshot of a license every given number of versions; every 50 versions by default, never if 0
+func WithSnapshotInterval(versions int64) LicenseRepoEventSourcedOption {
+	return func(r *LicenseRepoEventSourced) {
+		r.snapshotInterval = versions
+	}
+}
+
+func NewLicenseRepoEventSourced(pkgRepo licensing.PackageRepository, opts ...LicenseRepoEventSourcedOption) *LicenseRepoEventSourced {
+	r := &LicenseRepoEventSourced{
+		streams:          make(map[string][]int64),
+		snapshots:        make(map[string]licensing.LicenseState),
+		snapshotInterval: 50,
+		readModel:        NewLicenseRepoInMem(),
+		pkgRepo:          pkgRepo,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *LicenseRepoEventSourced) CreateLicense(ctx context.Context, lic *licensing.License) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.streams[lic.Id()]; ok {
+		return fmt.Errorf("license id=%s already exists", lic.Id())
+	}
+	state := lic.State()
+	issued := licenseIssuedEvent(state)
+	var issuedState licensing.LicenseState
+	err := applyLicenseStreamEvent(&issuedState, issued, func(string) (*licensing.Package, error) { return state.LicensedPackage, nil })
+	if err != nil {
+		return err
+	}
+	changes, err := licenseChangeEvents(issuedState, state)
+	if err != nil {
+		return err
+	}
+	r.append(lic, append([]LicenseStreamEvent{issued}, changes...), 1)
+	return nil
+}
+
+func (r *LicenseRepoEventSourced) UpdateLicense(ctx context.Context, licId string, newLic *licensing.License) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, err := r.rebuild(ctx, newLic.Id())
+	if err != nil {
+		return err
+	}
+	if stored.Version != newLic.Version() {
+		return fmt.Errorf("%w: license id=%s is at version %d, update is based on version %d",
+			licensing.ErrLicenseVersionConflict, newLic.Id(), stored.Version, newLic.Version())
+	}
+	events, err := licenseChangeEvents(stored, newLic.State())
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		// nothing happened to the license, it stays at its version
+		return nil
+	}
+	r.append(newLic, events, stored.Version+1)
+	return nil
+}
+
+func (r *LicenseRepoEventSourced) GetLicenseById(ctx context.Context, licId string) (*licensing.License, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	state, err := r.rebuild(ctx, licId)
+	if err != nil {
+		return nil, err
+	}
+	return licensing.RestoreLicense(state), nil
+}
+
+func (r *LicenseRepoEventSourced) FindLicensesByAssignedLicenseeId(ctx context.Context, licenseeId string) ([]*licensing.License, error) {
+	return r.readModel.FindLicensesByAssignedLicenseeId(ctx, licenseeId)
+}
+
+func (r *LicenseRepoEventSourced) FindLicensesOfAccount(ctx context.Context, accId string) ([]*licensing.License, error) {
+	return r.readModel.FindLicensesOfAccount(ctx, accId)
+}
+
+func (r *LicenseRepoEventSourced) FindUnassignedLicensesOfPackage(ctx context.Context, accId string, pkgId string) ([]*licensing.License, error) {
+	return r.readModel.FindUnassignedLicensesOfPackage(ctx, accId, pkgId)
+}
+
+func (r *LicenseRepoEventSourced) FindLicensesToAssign(ctx context.Context, accId string, pkgId string, strategy licensing.SeatAllocationStrategy, at time.Time, limit int) ([]*licensing.License, error) {
+	return r.readModel.FindLicensesToAssign(ctx, accId, pkgId, strategy, at, limit)
+}
+
+func (r *LicenseRepoEventSourced) CountTotalUnassignedLicensesOfPackage(ctx context.Context, accId string, pkgId string) (int, error) {
+	return r.readModel.CountTotalUnassignedLicensesOfPackage(ctx, accId, pkgId)
+}
+
+func (r *LicenseRepoEventSourced) ListLicenses(ctx context.Context, query licensing.LicenseQuery) (*licensing.LicensePage, error) {
+	return r.readModel.ListLicenses(ctx, query)
+}
+
+// Runs work on a repository staging its changes, and appends the events of all of them if work succeeds.
+// Fails with licensing.ErrLicenseVersionConflict, appending no events, if a license changed in the meantime.
+func (r *LicenseRepoEventSourced) RunAtomically(ctx context.Context, work func(ctx context.Context, licRepo licensing.LicenseRepository) error) error {
+	return runStaged(ctx, r, work, func(changes []stagedLicense) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		// all changes are turned into events before appending any, as a change may not be expressible as events
+		events := make([][]LicenseStreamEvent, len(changes))
+		for i, change := range changes {
+			state := change.lic.State()
+			var from licensing.LicenseState
+			if _, ok := r.streams[change.lic.Id()]; ok {
+				stored, err := r.rebuild(ctx, change.lic.Id())
+				if err != nil {
+					return err
+				}
+				from = stored
+			} else {
+				issued := licenseIssuedEvent(state)
+				err := applyLicenseStreamEvent(&from, issued, func(string) (*licensing.Package, error) { return state.LicensedPackage, nil })
+				if err != nil {
+					return err
+				}
+				events[i] = append(events[i], issued)
+			}
+			if err := checkStagedLicense(change, from.Version); err != nil {
+				return err
+			}
+			changeEvents, err := licenseChangeEvents(from, state)
+			if err != nil {
+				return err
+			}
+			events[i] = append(events[i], changeEvents...)
+		}
+		for i, change := range changes {
+			if len(events[i]) > 0 {
+				r.append(change.lic, events[i], change.lic.Version())
+			}
+		}
+		return nil
+	})
+}
+
+// Returns the events of the license, in order
+func (r *LicenseRepoEventSourced) LicenseHistory(ctx context.Context, licId string) ([]LicenseStreamEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	positions, ok := r.streams[licId]
+	if !ok {
+		return nil, fmt.Errorf("%w for id=%s", licensing.ErrLicenseNotFound, licId)
+	}
+	results := make([]LicenseStreamEvent, 0, len(positions))
+	for _, position := range positions {
+		results = append(results, r.log[position-1])
+	}
+	return results, nil
+}
+
+// Passes the events of all licenses after the given log position to apply, in order, e.g. to build a read model.
+// Replaying from position 0 replays the whole log. Stops at the first error of apply.
+//
+// Events appended while replaying are not passed on; replay again from the last position seen to catch up.
+func (r *LicenseRepoEventSourced) ReplayEvents(ctx context.Context, afterPosition int64, apply func(evt LicenseStreamEvent) error) error {
+	r.mu.RLock()
+	events := r.log[:len(r.log):len(r.log)]
+	r.mu.RUnlock()
+	if afterPosition < 0 {
+		afterPosition = 0
+	}
+	for i := afterPosition; i < int64(len(events)); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := apply(events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rebuilds the current state of the license from its latest snapshot and the events after it
+func (r *LicenseRepoEventSourced) rebuild(ctx context.Context, licId string) (licensing.LicenseState, error) {
+	positions, ok := r.streams[licId]
+	if !ok {
+		return licensing.LicenseState{}, fmt.Errorf("%w for id=%s", licensing.ErrLicenseNotFound, licId)
+	}
+	var state licensing.LicenseState
+	if snapshot, ok := r.snapshots[licId]; ok {
+		// copied, as applying events changes the assignments in place
+		state = licensing.RestoreLicense(snapshot).State()
+	}
+	snapshotVersion := state.Version
+	pkg := func(pkgId string) (*licensing.Package, error) { return r.pkgRepo.GetPackageById(ctx, pkgId) }
+	for _, position := range positions {
+		evt := r.log[position-1]
+		if evt.Version <= snapshotVersion {
+			continue
+		}
+		if err := applyLicenseStreamEvent(&state, evt, pkg); err != nil {
+			return licensing.LicenseState{}, err
+		}
+	}
+	return state, nil
+}
+
+// Appends the events of the change of the license to its stream at the given version, and brings the license,
+// its snapshot and the read model to that version
+func (r *LicenseRepoEventSourced) append(lic *licensing.License, events []LicenseStreamEvent, version int64) {
+	for _, evt := range events {
+		evt.Position = int64(len(r.log)) + 1
+		evt.Version = version
+		r.log = append(r.log, evt)
+		r.streams[evt.LicenseId] = append(r.streams[evt.LicenseId], evt.Position)
+	}
+	lic.SetPersistedVersion(version)
+	if r.snapshotInterval > 0 && version%r.snapshotInterval == 0 {
+		r.snapshots[lic.Id()] = lic.State()
+	}
+	r.readModel.put(lic)
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"gotest.tools/v3/assert"
+)
+
+func TestLicenseRepoEventSourced(t *testing.T) {
+
+	ctx := context.Background()
+	pkgRepo := NewPackageRepoInMem()
+	pkg, err := pkgRepo.GetPackageById(ctx, "pkg:base-optimize-2022")
+	assert.NilError(t, err)
+	alice := licensing.NewInstanceUser("ins-101", "usr-alice")
+	bob := licensing.NewInstanceUser("ins-101", "usr-bob")
+
+	t.Run("changes are recorded as events", func(t *testing.T) {
+		r := NewLicenseRepoEventSourced(pkgRepo)
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", pkg, licensing.AsTrial())
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+		lic.Assign(alice)
+		assert.NilError(t, r.UpdateLicense(ctx, lic.Id(), lic))
+		lic.Assign(bob)
+		assert.NilError(t, r.UpdateLicense(ctx, lic.Id(), lic))
+		lic.Expire()
+		lic.RenewTo("lic-2", "Renewal")
+		assert.NilError(t, r.UpdateLicense(ctx, lic.Id(), lic))
+
+		history, err := r.LicenseHistory(ctx, lic.Id())
+		assert.NilError(t, err)
+		got := make([]string, 0)
+		for _, evt := range history {
+			got = append(got, evt.String())
+		}
+		assert.DeepEqual(t, got, []string{
+			"#1 LICENSE_ISSUED license id=" + lic.Id() + " at version 1",
+			"#2 LICENSE_ASSIGNED license id=" + lic.Id() + " at version 2",
+			"#3 LICENSE_UNASSIGNED license id=" + lic.Id() + " at version 3",
+			"#4 LICENSE_ASSIGNED license id=" + lic.Id() + " at version 3",
+			"#5 LICENSE_EXPIRED license id=" + lic.Id() + " at version 4",
+			"#6 LICENSE_RENEWED license id=" + lic.Id() + " at version 4",
+		})
+
+		rebuilt, err := r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		assert.DeepEqual(t, rebuilt.State(), lic.State())
+	})
+
+	t.Run("licenses are rebuilt from snapshots", func(t *testing.T) {
+		r := NewLicenseRepoEventSourced(pkgRepo, WithSnapshotInterval(3))
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", pkg)
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+		for i := 0; i < 4; i++ {
+			lic.Assign(alice)
+			lic.Unassign()
+			assert.NilError(t, r.UpdateLicense(ctx, lic.Id(), lic))
+		}
+		assert.Equal(t, r.snapshots[lic.Id()].Version, int64(3))
+
+		rebuilt, err := r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		assert.Equal(t, rebuilt.Version(), int64(5))
+		assert.DeepEqual(t, rebuilt.State(), lic.State())
+	})
+
+	t.Run("changes not expressible as events are rejected", func(t *testing.T) {
+		r := NewLicenseRepoEventSourced(pkgRepo)
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", pkg)
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+		state := lic.State()
+		state.IsTrial = true
+		err := r.UpdateLicense(ctx, lic.Id(), licensing.RestoreLicense(state))
+		assert.Error(t, err, "license id="+lic.Id()+": terms of a license cannot change")
+	})
+
+	t.Run("read models are built by replaying the log", func(t *testing.T) {
+		r := NewLicenseRepoEventSourced(pkgRepo)
+		for i := 0; i < 3; i++ {
+			lic := licensing.NewIssuedLicense("acc-1", "sub-1", pkg)
+			assert.NilError(t, r.CreateLicense(ctx, lic))
+			lic.Assign(alice)
+			assert.NilError(t, r.UpdateLicense(ctx, lic.Id(), lic))
+			if i > 0 {
+				lic.Unassign()
+				assert.NilError(t, r.UpdateLicense(ctx, lic.Id(), lic))
+			}
+		}
+
+		// number of assignments ever made per licensee
+		assignments := make(map[string]int)
+		var last int64
+		replay := func(evt LicenseStreamEvent) error {
+			if evt.Type == licensing.LICENSE_ASSIGNED {
+				assignments[evt.Licensee.LicenseeId()]++
+			}
+			last = evt.Position
+			return nil
+		}
+		assert.NilError(t, r.ReplayEvents(ctx, 0, replay))
+		assert.DeepEqual(t, assignments, map[string]int{alice.LicenseeId(): 3})
+
+		// catching up replays the new events only
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", pkg)
+		lic.Assign(bob)
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+		assert.NilError(t, r.ReplayEvents(ctx, last, replay))
+		assert.DeepEqual(t, assignments, map[string]int{alice.LicenseeId(): 3, bob.LicenseeId(): 1})
+	})
+}
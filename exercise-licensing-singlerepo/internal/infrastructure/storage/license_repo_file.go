@@ -0,0 +1,351 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// Current version of the license file format; documents of other versions are rejected, until a later version
+// brings a migration of the older documents
+const licenseFileFormatVersion = 1
+
+var errUnsupportedLicenseFileFormat = errors.New("unsupported format version")
+
+// License repository persisting to a JSON document, so that licensing state survives between CLI runs
+// without a database. Suited for single nodes and small numbers of licenses: every call reads the document,
+// and every change rewrites it.
+//
+// Changes are durable and atomic: the new document is written to a temporary file, synced and renamed over
+// the document. The previous document is kept as <path>.bak, from which the repository recovers when the
+// document is missing or corrupt, e.g. after a partial copy; the last change is lost in that case.
+//
+// Processes sharing the document serialize through a lock on <path>.lock; readers share the lock,
+// writers hold it exclusively from reading the document to renaming its new version.
+type LicenseRepoFile struct {
+	path string
+
+	// resolves the packages of loaded licenses
+	pkgRepo licensing.PackageRepository
+
+	// serializes the goroutines of this process, in addition to the file lock between processes
+	mu sync.RWMutex
+}
+
+// Creates the repository on the document at path, creating the document if missing
+func NewLicenseRepoFile(path string, pkgRepo licensing.PackageRepository) (*LicenseRepoFile, error) {
+	r := &LicenseRepoFile{path: path, pkgRepo: pkgRepo}
+	err := r.update(func(doc *licenseFileDocument) error { return nil })
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *LicenseRepoFile) CreateLicense(ctx context.Context, lic *licensing.License) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := r.update(func(doc *licenseFileDocument) error {
+		for _, stored := range doc.Licenses {
+			if stored.Id == lic.Id() {
+				return fmt.Errorf("license id=%s already exists", lic.Id())
+			}
+		}
+		state := lic.State()
+		state.Version = 1
+		doc.Licenses = append(doc.Licenses, toLicenseFileDto(state))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	lic.SetPersistedVersion(1)
+	return nil
+}
+
+func (r *LicenseRepoFile) UpdateLicense(ctx context.Context, licId string, newLic *licensing.License) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := r.update(func(doc *licenseFileDocument) error {
+		for i, stored := range doc.Licenses {
+			if stored.Id != newLic.Id() {
+				continue
+			}
+			if stored.Version != newLic.Version() {
+				return fmt.Errorf("%w: license id=%s is at version %d, update is based on version %d",
+					licensing.ErrLicenseVersionConflict, newLic.Id(), stored.Version, newLic.Version())
+			}
+			state := newLic.State()
+			state.Version = stored.Version + 1
+			doc.Licenses[i] = toLicenseFileDto(state)
+			return nil
+		}
+		return fmt.Errorf("%w for id=%s", licensing.ErrLicenseNotFound, newLic.Id())
+	})
+	if err != nil {
+		return err
+	}
+	newLic.SetPersistedVersion(newLic.Version() + 1)
+	return nil
+}
+
+func (r *LicenseRepoFile) GetLicenseById(ctx context.Context, licId string) (*licensing.License, error) {
+	results, err := r.find(ctx, func(dto *licenseFileDto) bool { return dto.Id == licId })
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%w for id=%s", licensing.ErrLicenseNotFound, licId)
+	}
+	return results[0], nil
+}
+
+func (r *LicenseRepoFile) FindLicensesByAssignedLicenseeId(ctx context.Context, licenseeId string) ([]*licensing.License, error) {
+	results, err := r.find(ctx, func(dto *licenseFileDto) bool {
+		return dto.CurrentAssignment != nil && dto.CurrentAssignment.Licensee.Id == licenseeId
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%w: none assigned to licenseeId=%s", licensing.ErrLicenseNotFound, licenseeId)
+	}
+	return results, nil
+}
+
+func (r *LicenseRepoFile) FindLicensesOfAccount(ctx context.Context, accId string) ([]*licensing.License, error) {
+	return r.find(ctx, func(dto *licenseFileDto) bool { return dto.AccountId == accId })
+}
+
+func (r *LicenseRepoFile) FindUnassignedLicensesOfPackage(ctx context.Context, accId string, pkgId string) ([]*licensing.License, error) {
+	return r.findUnassignedInForceAt(ctx, accId, pkgId, time.Now())
+}
+
+func (r *LicenseRepoFile) findUnassignedInForceAt(ctx context.Context, accId string, pkgId string, at time.Time) ([]*licensing.License, error) {
+	return r.find(ctx, func(dto *licenseFileDto) bool {
+		return dto.AccountId == accId && dto.PackageId == pkgId && dto.CurrentAssignment == nil &&
+			dto.ExpiredAt == nil && dto.CancelledAt == nil && dto.Renewal == nil && (dto.ExpiresAt == nil || at.Before(*dto.ExpiresAt))
+	})
+}
+
+func (r *LicenseRepoFile) FindLicensesToAssign(ctx context.Context, accId string, pkgId string, strategy licensing.SeatAllocationStrategy, at time.Time, limit int) ([]*licensing.License, error) {
+	candidates, err := r.findUnassignedInForceAt(ctx, accId, pkgId, at)
+	if err != nil {
+		return nil, err
+	}
+	return licensing.SelectLicensesToAssign(candidates, strategy, at, limit), nil
+}
+
+func (r *LicenseRepoFile) CountTotalUnassignedLicensesOfPackage(ctx context.Context, accId string, pkgId string) (int, error) {
+	results, err := r.FindUnassignedLicensesOfPackage(ctx, accId, pkgId)
+	return len(results), err
+}
+
+func (r *LicenseRepoFile) ListLicenses(ctx context.Context, query licensing.LicenseQuery) (*licensing.LicensePage, error) {
+	licenses, err := r.find(ctx, func(dto *licenseFileDto) bool { return query.AccountId == "" || dto.AccountId == query.AccountId })
+	if err != nil {
+		return nil, err
+	}
+	return licensing.ListLicensePage(licenses, query)
+}
+
+// Runs work on a repository staging its changes, and writes them all in one new document if work succeeds.
+// Fails with licensing.ErrLicenseVersionConflict, writing none of the changes, if a license changed in the meantime.
+func (r *LicenseRepoFile) RunAtomically(ctx context.Context, work func(ctx context.Context, licRepo licensing.LicenseRepository) error) error {
+	return runStaged(ctx, r, work, func(changes []stagedLicense) error {
+		return r.update(func(doc *licenseFileDocument) error {
+			positions := make(map[string]int, len(doc.Licenses))
+			for i, stored := range doc.Licenses {
+				positions[stored.Id] = i
+			}
+			for _, change := range changes {
+				var storedVersion int64
+				if i, ok := positions[change.lic.Id()]; ok {
+					storedVersion = doc.Licenses[i].Version
+				}
+				if err := checkStagedLicense(change, storedVersion); err != nil {
+					return err
+				}
+			}
+			for _, change := range changes {
+				dto := toLicenseFileDto(change.lic.State())
+				if i, ok := positions[change.lic.Id()]; ok {
+					doc.Licenses[i] = dto
+				} else {
+					doc.Licenses = append(doc.Licenses, dto)
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// Loads the licenses matching the predicate, in order of issuance
+func (r *LicenseRepoFile) find(ctx context.Context, matches func(dto *licenseFileDto) bool) ([]*licensing.License, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	lock, err := lockFile(r.path+".lock", false)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
+	doc, _, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := make(map[string]*licensing.Package)
+	results := make([]*licensing.License, 0)
+	for i := range doc.Licenses {
+		dto := &doc.Licenses[i]
+		if !matches(dto) {
+			continue
+		}
+		if _, ok := pkgs[dto.PackageId]; !ok {
+			pkg, err := r.pkgRepo.GetPackageById(ctx, dto.PackageId)
+			if err != nil {
+				return nil, err
+			}
+			pkgs[dto.PackageId] = pkg
+		}
+		state, err := dto.toLicenseState(pkgs[dto.PackageId])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.path, err)
+		}
+		results = append(results, licensing.RestoreLicense(state))
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if !results[i].IssuedAt().Equal(results[j].IssuedAt()) {
+			return results[i].IssuedAt().Before(results[j].IssuedAt())
+		}
+		return results[i].Id() < results[j].Id()
+	})
+	return results, nil
+}
+
+// Applies the change to the current document and writes the result, holding the lock exclusively
+func (r *LicenseRepoFile) update(change func(doc *licenseFileDocument) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lock, err := lockFile(r.path+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	doc, recovered, err := r.read()
+	if err != nil {
+		return err
+	}
+	if err := change(doc); err != nil {
+		return err
+	}
+	return r.write(doc, recovered)
+}
+
+// Reads the document, recovering from the backup if the document is missing or corrupt, and reporting whether it did.
+// A missing document without backup is an empty document.
+func (r *LicenseRepoFile) read() (*licenseFileDocument, bool, error) {
+	doc, err := readLicenseFile(r.path)
+	if err == nil {
+		return doc, false, nil
+	}
+	if errors.Is(err, errUnsupportedLicenseFileFormat) {
+		// written by a newer version, which the backup cannot stand in for
+		return nil, false, err
+	}
+	backup, backupErr := readLicenseFile(r.path + ".bak")
+	if backupErr == nil {
+		return backup, true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) && errors.Is(backupErr, os.ErrNotExist) {
+		return &licenseFileDocument{FormatVersion: licenseFileFormatVersion, Licenses: []licenseFileDto{}}, false, nil
+	}
+	return nil, false, err
+}
+
+// Writes the document to a temporary file and renames it over the current document, kept as backup
+// unless the current document is the broken one the backup was recovered from
+func (r *LicenseRepoFile) write(doc *licenseFileDocument, recovered bool) error {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := r.path + ".tmp"
+	if err := writeFileSynced(tmpPath, data); err != nil {
+		return err
+	}
+	// the backup is a second link to the current document, so that there always is a complete document
+	if !recovered {
+		backupPath := r.path + ".bak"
+		if err := os.Remove(backupPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := os.Link(r.path, backupPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(tmpPath, r.path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(r.path))
+}
+
+func readLicenseFile(path string) (*licenseFileDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := &licenseFileDocument{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if doc.FormatVersion != licenseFileFormatVersion {
+		return nil, fmt.Errorf("%s: %w %d", path, errUnsupportedLicenseFileFormat, doc.FormatVersion)
+	}
+	if doc.Licenses == nil {
+		doc.Licenses = []licenseFileDto{}
+	}
+	return doc, nil
+}
+
+func writeFileSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Syncs the directory, so that a rename within it is durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"gotest.tools/v3/assert"
+)
+
+func TestLicenseRepoFile(t *testing.T) {
+
+	ctx := context.Background()
+	pkgRepo := NewPackageRepoInMem()
+	pkg, err := pkgRepo.GetPackageById(ctx, "pkg:base-optimize-2022")
+	assert.NilError(t, err)
+	newRepo := func(t *testing.T, path string) *LicenseRepoFile {
+		r, err := NewLicenseRepoFile(path, pkgRepo)
+		assert.NilError(t, err)
+		return r
+	}
+
+	t.Run("licenses persist across repositories", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "licenses.json")
+		expiresAt := time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC)
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", pkg, licensing.AsTrial(), licensing.ExpiringAt(expiresAt))
+		assert.NilError(t, newRepo(t, path).CreateLicense(ctx, lic))
+		lic.Assign(licensing.NewOrganizationUser("org-1", "usr-1", "usr-1@example.com"))
+		assert.NilError(t, newRepo(t, path).UpdateLicense(ctx, lic.Id(), lic))
+
+		loaded, err := newRepo(t, path).GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		assert.DeepEqual(t, loaded.State(), lic.State())
+	})
+
+	t.Run("recovers the previous document from the backup of a corrupt document", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "licenses.json")
+		r := newRepo(t, path)
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", pkg)
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+		lost := licensing.NewIssuedLicense("acc-1", "sub-1", pkg)
+		assert.NilError(t, r.CreateLicense(ctx, lost))
+		assert.NilError(t, os.WriteFile(path, []byte(`{"formatVersion": 1, "licen`), 0o644))
+
+		_, err := r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		_, err = r.GetLicenseById(ctx, lost.Id())
+		assert.ErrorContains(t, err, "license not found")
+
+		// the next change replaces the corrupt document, and keeps the backup it was recovered from
+		other := licensing.NewIssuedLicense("acc-1", "sub-1", pkg)
+		assert.NilError(t, r.CreateLicense(ctx, other))
+		backup, err := readLicenseFile(path + ".bak")
+		assert.NilError(t, err)
+		assert.Equal(t, len(backup.Licenses), 1)
+		licenses, err := r.FindLicensesOfAccount(ctx, "acc-1")
+		assert.NilError(t, err)
+		assert.Equal(t, len(licenses), 2)
+	})
+
+	t.Run("rejects unsupported format versions", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "licenses.json")
+		assert.NilError(t, os.WriteFile(path, []byte(`{"formatVersion": 2, "licenses": []}`), 0o644))
+
+		_, err := NewLicenseRepoFile(path, pkgRepo)
+		assert.Check(t, errors.Is(err, errUnsupportedLicenseFileFormat), err)
+		assert.ErrorContains(t, err, "unsupported format version 2")
+	})
+}
@@ -1,64 +1,429 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
 )
 
+// In-memory license repository, safe for concurrent use.
+//
+// It stores and hands out copies of licenses, so that callers only change the stored state through UpdateLicense.
+// Secondary indexes by account, by account+package+active+assigned-state and by licensee keep the find and count
+// methods independent of the total number of licenses. The licenses ready to assign are also kept in the orders
+// licenses are assigned in, so that the licenses to assign next are found without sorting them all.
 type LicenseRepoInMem struct {
+	mu      sync.RWMutex
 	storage map[string]*licensing.License
+
+	// license ids by possessing account id
+	byAccount map[string]*licenseIdSet
+
+	// license ids by possessing account id, package id, whether active and whether assigned
+	byAssignmentState map[assignmentStateKey]*licenseIdSet
+
+	// license ids by assigned licensee id
+	byLicensee map[string]*licenseIdSet
+
+	// active, unassigned licenses by possessing account id and package id, then by rank
+	byAssignmentOrder map[packageOfAccountKey]map[assignmentRankKey]*licensesInAssignmentOrder
+}
+
+type packageOfAccountKey struct {
+	accId string
+	pkgId string
+}
+
+// What seat allocation strategies rank licenses by, see licensing.SeatAllocationStrategy
+type assignmentRankKey struct {
+	subId   string
+	isTrial bool
+}
+
+// Licenses of equal rank, in each of the seat allocation orders
+type licensesInAssignmentOrder struct {
+	soonestExpiringFirst *licenseHeap
+	oldestIssuedFirst    *licenseHeap
+}
+
+func newLicensesInAssignmentOrder() *licensesInAssignmentOrder {
+	return &licensesInAssignmentOrder{
+		soonestExpiringFirst: newLicenseHeap(licensing.SOONEST_EXPIRING_FIRST.Precedes),
+		oldestIssuedFirst:    newLicenseHeap(licensing.OLDEST_ISSUED_FIRST.Precedes),
+	}
+}
+
+func (o *licensesInAssignmentOrder) inOrder(order licensing.SeatAllocationOrder) *licenseHeap {
+	if order == licensing.OLDEST_ISSUED_FIRST {
+		return o.oldestIssuedFirst
+	}
+	return o.soonestExpiringFirst
+}
+
+type assignmentStateKey struct {
+	accId      string
+	pkgId      string
+	isActive   bool
+	isAssigned bool
 }
 
 func NewLicenseRepoInMem() *LicenseRepoInMem {
 	r := LicenseRepoInMem{}
 	r.storage = make(map[string]*licensing.License)
+	r.byAccount = make(map[string]*licenseIdSet)
+	r.byAssignmentState = make(map[assignmentStateKey]*licenseIdSet)
+	r.byLicensee = make(map[string]*licenseIdSet)
+	r.byAssignmentOrder = make(map[packageOfAccountKey]map[assignmentRankKey]*licensesInAssignmentOrder)
 	return &r
 }
 
-func (r *LicenseRepoInMem) CreateLicense(lic *licensing.License) error {
-	r.storage[lic.Id()] = lic
+func (r *LicenseRepoInMem) CreateLicense(ctx context.Context, lic *licensing.License) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.storage[lic.Id()]; ok {
+		return fmt.Errorf("license id=%s already exists", lic.Id())
+	}
+	lic.SetPersistedVersion(1)
+	stored := lic.Clone()
+	r.storage[lic.Id()] = stored
+	r.index(stored)
 	return nil
 }
 
-func (r *LicenseRepoInMem) UpdateLicense(licId string, newLic *licensing.License) error {
-	r.storage[newLic.Id()] = newLic
+func (r *LicenseRepoInMem) UpdateLicense(ctx context.Context, licId string, newLic *licensing.License) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.storage[newLic.Id()]
+	if !ok {
+		return fmt.Errorf("%w for id=%s", licensing.ErrLicenseNotFound, newLic.Id())
+	}
+	if stored.Version() != newLic.Version() {
+		return fmt.Errorf("%w: license id=%s is at version %d, update is based on version %d",
+			licensing.ErrLicenseVersionConflict, newLic.Id(), stored.Version(), newLic.Version())
+	}
+	newLic.SetPersistedVersion(stored.Version() + 1)
+	r.unindex(stored)
+	stored = newLic.Clone()
+	r.storage[newLic.Id()] = stored
+	r.index(stored)
 	return nil
 }
 
-func (r *LicenseRepoInMem) GetLicenseById(licId string) (*licensing.License, error) {
+// Stores a copy of the license at its version, replacing the stored license without comparing versions.
+// For read models kept up to date by another repository, which owns the versioning.
+func (r *LicenseRepoInMem) put(lic *licensing.License) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.store(lic)
+}
+
+// Runs work on a repository staging its changes, and applies them all at once if work succeeds.
+// Fails with licensing.ErrLicenseVersionConflict, applying none of the changes, if a license changed in the meantime.
+func (r *LicenseRepoInMem) RunAtomically(ctx context.Context, work func(ctx context.Context, licRepo licensing.LicenseRepository) error) error {
+	return runStaged(ctx, r, work, func(changes []stagedLicense) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for _, change := range changes {
+			var storedVersion int64
+			if stored, ok := r.storage[change.lic.Id()]; ok {
+				storedVersion = stored.Version()
+			}
+			if err := checkStagedLicense(change, storedVersion); err != nil {
+				return err
+			}
+		}
+		for _, change := range changes {
+			r.store(change.lic)
+		}
+		return nil
+	})
+}
+
+// Stores a copy of the license, replacing the stored license; the caller holds the write lock
+func (r *LicenseRepoInMem) store(lic *licensing.License) {
+	if stored, ok := r.storage[lic.Id()]; ok {
+		r.unindex(stored)
+	}
+	stored := lic.Clone()
+	r.storage[lic.Id()] = stored
+	r.index(stored)
+}
+
+func (r *LicenseRepoInMem) GetLicenseById(ctx context.Context, licId string) (*licensing.License, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	if result, ok := r.storage[licId]; ok {
-		return result, nil
+		return result.Clone(), nil
+	}
+	return nil, fmt.Errorf("%w for id=%s", licensing.ErrLicenseNotFound, licId)
+}
+
+func (r *LicenseRepoInMem) FindLicensesByAssignedLicenseeId(ctx context.Context, licenseeId string) ([]*licensing.License, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("license not found for id=%s", licId)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	results := r.clonesOf(r.byLicensee[licenseeId])
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%w: none assigned to licenseeId=%s", licensing.ErrLicenseNotFound, licenseeId)
+	}
+	return results, nil
+}
+
+func (r *LicenseRepoInMem) FindLicensesOfAccount(ctx context.Context, accId string) ([]*licensing.License, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.clonesOf(r.byAccount[accId]), nil
+}
+
+func (r *LicenseRepoInMem) FindUnassignedLicensesOfPackage(ctx context.Context, accId string, pkgId string) ([]*licensing.License, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := time.Now()
+	results := r.clonesOf(r.byAssignmentState[assignmentStateKey{accId, pkgId, true, false}])
+	inForce := results[:0]
+	for _, lic := range results {
+		if !lic.IsPastTermAt(now) {
+			inForce = append(inForce, lic)
+		}
+	}
+	return inForce, nil
 }
 
-func (r *LicenseRepoInMem) FindLicensesByAssignedLicenseeId(licenseeId string) ([]*licensing.License, error) {
+func (r *LicenseRepoInMem) FindLicensesToAssign(ctx context.Context, accId string, pkgId string, strategy licensing.SeatAllocationStrategy, at time.Time, limit int) ([]*licensing.License, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	byRank := make(map[int][]*licenseHeap)
+	ranks := make([]int, 0)
+	for rankKey, licenses := range r.byAssignmentOrder[packageOfAccountKey{accId, pkgId}] {
+		rank := strategy.Rank(rankKey.subId, rankKey.isTrial)
+		if _, ok := byRank[rank]; !ok {
+			ranks = append(ranks, rank)
+		}
+		byRank[rank] = append(byRank[rank], licenses.inOrder(strategy.Order()))
+	}
+	sort.Ints(ranks)
+
+	inForce := func(lic *licensing.License) bool { return !lic.IsPastTermAt(at) }
 	results := make([]*licensing.License, 0)
-	for _, elem := range r.storage {
-		if elem.IsAssigned() && elem.AssignedToLicensee().LicenseeId() == licenseeId {
-			results = append(results, elem)
-			return results, nil
+	for _, rank := range ranks {
+		if len(results) >= limit {
+			break
+		}
+		// the first licenses of each heap of the rank, merged
+		merged := make([]*licensing.License, 0)
+		for _, licenses := range byRank[rank] {
+			merged = append(merged, licenses.first(limit-len(results), inForce)...)
+		}
+		sort.Slice(merged, func(i, j int) bool { return strategy.Precedes(merged[i], merged[j]) })
+		for _, lic := range merged {
+			if len(results) >= limit {
+				break
+			}
+			results = append(results, lic.Clone())
+		}
+	}
+	return results, nil
+}
+
+func (r *LicenseRepoInMem) CountTotalUnassignedLicensesOfPackage(ctx context.Context, accId string, pkgId string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	// the licenses past their term come first in the soonest expiring order, and are the only ones visited
+	now := time.Now()
+	pastTerm := 0
+	for _, licenses := range r.byAssignmentOrder[packageOfAccountKey{accId, pkgId}] {
+		pastTerm += licenses.soonestExpiringFirst.countFirst(func(lic *licensing.License) bool { return lic.IsPastTermAt(now) })
+	}
+	return r.byAssignmentState[assignmentStateKey{accId, pkgId, true, false}].len() - pastTerm, nil
+}
+
+func (r *LicenseRepoInMem) ListLicenses(ctx context.Context, query licensing.LicenseQuery) (*licensing.LicensePage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	// narrowed down by the most selective index the query allows
+	var candidates []*licensing.License
+	switch {
+	case query.LicenseeId != "":
+		candidates = r.storedOf(r.byLicensee[query.LicenseeId])
+	case query.AccountId != "":
+		candidates = r.storedOf(r.byAccount[query.AccountId])
+	default:
+		candidates = make([]*licensing.License, 0, len(r.storage))
+		for _, lic := range r.storage {
+			candidates = append(candidates, lic)
+		}
+	}
+	page, err := licensing.ListLicensePage(candidates, query)
+	if err != nil {
+		return nil, err
+	}
+	for i, lic := range page.Licenses {
+		page.Licenses[i] = lic.Clone()
+	}
+	return page, nil
+}
+
+// Returns the stored licenses, not copies, for reading under the lock
+func (r *LicenseRepoInMem) storedOf(ids *licenseIdSet) []*licensing.License {
+	results := make([]*licensing.License, 0, ids.len())
+	if ids == nil {
+		return results
+	}
+	for _, licId := range ids.ids {
+		results = append(results, r.storage[licId])
+	}
+	return results
+}
+
+func (r *LicenseRepoInMem) clonesOf(ids *licenseIdSet) []*licensing.License {
+	results := make([]*licensing.License, 0, ids.len())
+	if ids == nil {
+		return results
+	}
+	for _, licId := range ids.ids {
+		results = append(results, r.storage[licId].Clone())
+	}
+	return results
+}
+
+func (r *LicenseRepoInMem) index(lic *licensing.License) {
+	addToIndex(r.byAccount, lic.PossessingCustomerAccountId(), lic.Id())
+	stateKey := assignmentStateKey{lic.PossessingCustomerAccountId(), lic.LicensedPackage().Id, lic.IsActive(), lic.IsAssigned()}
+	ids, ok := r.byAssignmentState[stateKey]
+	if !ok {
+		ids = newLicenseIdSet()
+		r.byAssignmentState[stateKey] = ids
+	}
+	ids.add(lic.Id())
+	if lic.IsAssigned() {
+		addToIndex(r.byLicensee, lic.AssignedToLicensee().LicenseeId(), lic.Id())
+	}
+	if lic.IsActive() && !lic.IsAssigned() {
+		pkgKey := packageOfAccountKey{lic.PossessingCustomerAccountId(), lic.LicensedPackage().Id}
+		byRank, ok := r.byAssignmentOrder[pkgKey]
+		if !ok {
+			byRank = make(map[assignmentRankKey]*licensesInAssignmentOrder)
+			r.byAssignmentOrder[pkgKey] = byRank
+		}
+		rankKey := assignmentRankKey{lic.GoverningSubscriptionId(), lic.IsTrial()}
+		licenses, ok := byRank[rankKey]
+		if !ok {
+			licenses = newLicensesInAssignmentOrder()
+			byRank[rankKey] = licenses
 		}
+		licenses.soonestExpiringFirst.add(lic)
+		licenses.oldestIssuedFirst.add(lic)
 	}
-	return nil, fmt.Errorf("no license found assigned to licenseeId=%s", licenseeId)
 }
 
-func (r *LicenseRepoInMem) FindNextUnassignedLicenseOfPackage(accId string, pkgId string) (*licensing.License, error) {
-	for _, elem := range r.storage {
-		if elem.PossessingCustomerAccountId() == accId && elem.LicensedPackage().Id == pkgId && !elem.IsAssigned() {
-			return elem, nil
+func (r *LicenseRepoInMem) unindex(lic *licensing.License) {
+	removeFromIndex(r.byAccount, lic.PossessingCustomerAccountId(), lic.Id())
+	stateKey := assignmentStateKey{lic.PossessingCustomerAccountId(), lic.LicensedPackage().Id, lic.IsActive(), lic.IsAssigned()}
+	if ids, ok := r.byAssignmentState[stateKey]; ok {
+		ids.remove(lic.Id())
+		if ids.len() == 0 {
+			delete(r.byAssignmentState, stateKey)
+		}
+	}
+	if lic.IsAssigned() {
+		removeFromIndex(r.byLicensee, lic.AssignedToLicensee().LicenseeId(), lic.Id())
+	}
+	pkgKey := packageOfAccountKey{lic.PossessingCustomerAccountId(), lic.LicensedPackage().Id}
+	rankKey := assignmentRankKey{lic.GoverningSubscriptionId(), lic.IsTrial()}
+	if licenses, ok := r.byAssignmentOrder[pkgKey][rankKey]; ok {
+		licenses.soonestExpiringFirst.remove(lic.Id())
+		licenses.oldestIssuedFirst.remove(lic.Id())
+		if licenses.soonestExpiringFirst.Len() == 0 {
+			delete(r.byAssignmentOrder[pkgKey], rankKey)
+			if len(r.byAssignmentOrder[pkgKey]) == 0 {
+				delete(r.byAssignmentOrder, pkgKey)
+			}
 		}
 	}
-	return nil, fmt.Errorf("no more unassigned license for pkgId=%s", pkgId)
 }
 
-func (r *LicenseRepoInMem) CountTotalUnassignedLicensesOfPackage(accId string, pkgId string) (int, error) {
-	count := 0
-	for _, elem := range r.storage {
-		if elem.PossessingCustomerAccountId() == accId && elem.LicensedPackage().Id == pkgId && !elem.IsAssigned() {
-			count++
+func addToIndex(index map[string]*licenseIdSet, key string, licId string) {
+	ids, ok := index[key]
+	if !ok {
+		ids = newLicenseIdSet()
+		index[key] = ids
+	}
+	ids.add(licId)
+}
+
+func removeFromIndex(index map[string]*licenseIdSet, key string, licId string) {
+	if ids, ok := index[key]; ok {
+		ids.remove(licId)
+		if ids.len() == 0 {
+			delete(index, key)
 		}
 	}
-	return count, nil
+}
+
+// Set of license ids with O(1) add and remove, kept in a slice for cheap iteration
+type licenseIdSet struct {
+	ids       []string
+	positions map[string]int
+}
+
+func newLicenseIdSet() *licenseIdSet {
+	return &licenseIdSet{positions: make(map[string]int)}
+}
+
+func (s *licenseIdSet) add(licId string) {
+	if _, ok := s.positions[licId]; ok {
+		return
+	}
+	s.positions[licId] = len(s.ids)
+	s.ids = append(s.ids, licId)
+}
+
+// Removes the id by moving the last id into its place
+func (s *licenseIdSet) remove(licId string) {
+	pos, ok := s.positions[licId]
+	if !ok {
+		return
+	}
+	last := s.ids[len(s.ids)-1]
+	s.ids[pos] = last
+	s.positions[last] = pos
+	s.ids = s.ids[:len(s.ids)-1]
+	delete(s.positions, licId)
+}
+
+func (s *licenseIdSet) len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.ids)
 }
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+const (
+	benchAccounts           = 1000
+	benchLicensesPerAccount = 1000
+)
+
+var (
+	benchRepo     *LicenseRepoInMem
+	benchRepoOnce sync.Once
+)
+
+// Repository of 1M licenses over 1000 accounts and 2 packages, half of them assigned; shared by the benchmarks
+func benchLicenseRepo(b *testing.B) *LicenseRepoInMem {
+	benchRepoOnce.Do(func() {
+		ctx := context.Background()
+		pkgRepo := NewPackageRepoInMem()
+		pkgs := make([]*licensing.Package, 0)
+		for _, pkgId := range []string{"pkg:base-optimize-2022", "pkg:base-accelerate-2022"} {
+			pkg, err := pkgRepo.GetPackageById(ctx, pkgId)
+			if err != nil {
+				b.Fatal(err)
+			}
+			pkgs = append(pkgs, pkg)
+		}
+		benchRepo = NewLicenseRepoInMem()
+		for a := 0; a < benchAccounts; a++ {
+			accId := fmt.Sprintf("acc-%d", a)
+			for i := 0; i < benchLicensesPerAccount; i++ {
+				lic := licensing.NewIssuedLicense(accId, "sub-1", pkgs[i%len(pkgs)])
+				if i%4 < 2 {
+					lic.Assign(licensing.NewInstanceUser(fmt.Sprintf("ins-%d", a), fmt.Sprintf("usr-%d", i/2)))
+				}
+				if err := benchRepo.CreateLicense(ctx, lic); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.ResetTimer()
+	return benchRepo
+}
+
+func BenchmarkLicenseRepoInMem(b *testing.B) {
+
+	ctx := context.Background()
+	accId := fmt.Sprintf("acc-%d", benchAccounts/2)
+	pkgId := "pkg:base-optimize-2022"
+
+	b.Run("FindUnassignedLicensesOfPackage", func(b *testing.B) {
+		r := benchLicenseRepo(b)
+		for i := 0; i < b.N; i++ {
+			if _, err := r.FindUnassignedLicensesOfPackage(ctx, accId, pkgId); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("FindLicensesToAssign", func(b *testing.B) {
+		r := benchLicenseRepo(b)
+		strategy := licensing.SoonestExpiringFirst()
+		now := time.Now()
+		for i := 0; i < b.N; i++ {
+			if _, err := r.FindLicensesToAssign(ctx, accId, pkgId, strategy, now, 8); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("CountTotalUnassignedLicensesOfPackage", func(b *testing.B) {
+		r := benchLicenseRepo(b)
+		for i := 0; i < b.N; i++ {
+			if _, err := r.CountTotalUnassignedLicensesOfPackage(ctx, accId, pkgId); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("FindLicensesByAssignedLicenseeId", func(b *testing.B) {
+		r := benchLicenseRepo(b)
+		licenseeId := licensing.NewInstanceUser(fmt.Sprintf("ins-%d", benchAccounts/2), "usr-0").LicenseeId()
+		for i := 0; i < b.N; i++ {
+			if _, err := r.FindLicensesByAssignedLicenseeId(ctx, licenseeId); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("AssignAndUnassign", func(b *testing.B) {
+		r := benchLicenseRepo(b)
+		user := licensing.NewInstanceUser("ins-bench", "usr-bench")
+		// spread over many licenses, so that the assignment history of single licenses stays short
+		unassignedIds := make([]string, 0)
+		for a := 0; a < benchAccounts; a++ {
+			licenses, err := r.FindUnassignedLicensesOfPackage(ctx, fmt.Sprintf("acc-%d", a), pkgId)
+			if err != nil {
+				b.Fatal(err)
+			}
+			unassignedIds = append(unassignedIds, licenses[0].Id())
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			lic, err := r.GetLicenseById(ctx, unassignedIds[i%len(unassignedIds)])
+			if err != nil {
+				b.Fatal(err)
+			}
+			lic.Assign(user)
+			if err := r.UpdateLicense(ctx, lic.Id(), lic); err != nil {
+				b.Fatal(err)
+			}
+			lic.Unassign()
+			if err := r.UpdateLicense(ctx, lic.Id(), lic); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("ParallelReads", func(b *testing.B) {
+		r := benchLicenseRepo(b)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := r.CountTotalUnassignedLicensesOfPackage(ctx, accId, pkgId); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
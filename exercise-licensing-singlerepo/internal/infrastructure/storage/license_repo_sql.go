@@ -0,0 +1,578 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// License repository on a SQL database, see MigrateSqlSchema for its schema.
+//
+// A license is stored as a row of licenses, its current assignment as a row of license_assignments and
+// its previous assignments as rows of license_assignment_history. Licenses reference their package by id,
+// resolved through the package repository when loading.
+//
+// UpdateLicense compares and swaps the version column, so that concurrent updates from several processes
+// are detected as version conflicts rather than lost. Units of work run in a database transaction, see RunAtomically.
+type LicenseRepoSql struct {
+	db *sql.DB
+
+	// transaction of the unit of work the repository is handed to; nil outside units of work
+	tx *sql.Tx
+
+	// resolves the packages of loaded licenses
+	pkgRepo licensing.PackageRepository
+}
+
+// Creates the repository on a database whose schema is up to date, see MigrateSqlSchema
+func NewLicenseRepoSql(db *sql.DB, pkgRepo licensing.PackageRepository) *LicenseRepoSql {
+	return &LicenseRepoSql{db: db, pkgRepo: pkgRepo}
+}
+
+// Runs work in a database transaction, on a repository reading and writing through the transaction.
+// Work run by a repository already in a transaction joins that transaction.
+func (r *LicenseRepoSql) RunAtomically(ctx context.Context, work func(ctx context.Context, licRepo licensing.LicenseRepository) error) error {
+	if r.tx != nil {
+		return work(ctx, r)
+	}
+	return inSqlTx(ctx, r.db, func(tx *sql.Tx) error {
+		return work(ctx, &LicenseRepoSql{db: r.db, tx: tx, pkgRepo: r.pkgRepo})
+	})
+}
+
+func (r *LicenseRepoSql) CreateLicense(ctx context.Context, lic *licensing.License) error {
+	state := lic.State()
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		exists, err := sqlRowExists(ctx, tx, `SELECT 1 FROM licenses WHERE id = ?`, state.Id)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("license id=%s already exists", state.Id)
+		}
+		issuedAt, issuanceReason := sqlIssuanceDetail(state.IssuanceDetail)
+		expiredAt, cancelledAt := sqlExpirationAndCancellation(state)
+		renewedToId, renewedAt, renewalReason := sqlRenewalDetail(state.RenewalDetail)
+		_, err = tx.ExecContext(ctx, `INSERT INTO licenses (id, account_id, subscription_id, package_id, is_trial, expires_at,
+issued_at, issuance_reason, expired_at, cancelled_at, renewed_to_license_id, renewed_at, renewal_reason, version)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			state.Id, state.PossessingCustomerAccountId, state.GoverningSubscriptionId, state.LicensedPackage.Id,
+			state.IsTrial, sqlTime(state.ExpiresAt), issuedAt, issuanceReason, expiredAt, cancelledAt,
+			renewedToId, renewedAt, renewalReason, 1)
+		if err != nil {
+			return err
+		}
+		return r.saveAssignments(ctx, tx, state, 0)
+	})
+	if err != nil {
+		return err
+	}
+	lic.SetPersistedVersion(1)
+	return nil
+}
+
+func (r *LicenseRepoSql) UpdateLicense(ctx context.Context, licId string, newLic *licensing.License) error {
+	state := newLic.State()
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		issuedAt, issuanceReason := sqlIssuanceDetail(state.IssuanceDetail)
+		expiredAt, cancelledAt := sqlExpirationAndCancellation(state)
+		renewedToId, renewedAt, renewalReason := sqlRenewalDetail(state.RenewalDetail)
+		result, err := tx.ExecContext(ctx, `UPDATE licenses SET account_id = ?, subscription_id = ?, package_id = ?,
+is_trial = ?, expires_at = ?, issued_at = ?, issuance_reason = ?, expired_at = ?, cancelled_at = ?,
+renewed_to_license_id = ?, renewed_at = ?, renewal_reason = ?, version = ? WHERE id = ? AND version = ?`,
+			state.PossessingCustomerAccountId, state.GoverningSubscriptionId, state.LicensedPackage.Id,
+			state.IsTrial, sqlTime(state.ExpiresAt), issuedAt, issuanceReason, expiredAt, cancelledAt,
+			renewedToId, renewedAt, renewalReason, state.Version+1, state.Id, state.Version)
+		if err != nil {
+			return err
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return r.updateFailure(ctx, tx, state)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM license_assignments WHERE license_id = ?`, state.Id); err != nil {
+			return err
+		}
+		var storedHistory int
+		err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM license_assignment_history WHERE license_id = ?`, state.Id).Scan(&storedHistory)
+		if err != nil {
+			return err
+		}
+		return r.saveAssignments(ctx, tx, state, storedHistory)
+	})
+	if err != nil {
+		return err
+	}
+	newLic.SetPersistedVersion(state.Version + 1)
+	return nil
+}
+
+// Tells apart an update of a missing license from an update based on a stale version
+func (r *LicenseRepoSql) updateFailure(ctx context.Context, tx *sql.Tx, state licensing.LicenseState) error {
+	var storedVersion int64
+	err := tx.QueryRowContext(ctx, `SELECT version FROM licenses WHERE id = ?`, state.Id).Scan(&storedVersion)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w for id=%s", licensing.ErrLicenseNotFound, state.Id)
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: license id=%s is at version %d, update is based on version %d",
+		licensing.ErrLicenseVersionConflict, state.Id, storedVersion, state.Version)
+}
+
+// Inserts the current assignment, and the previous assignments after the ones already stored, as history only grows
+func (r *LicenseRepoSql) saveAssignments(ctx context.Context, tx *sql.Tx, state licensing.LicenseState, storedHistory int) error {
+	if state.CurrentAssignment != nil {
+		lc, err := sqlLicenseeColumnsOf(state.CurrentAssignment.Assignee)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO license_assignments (license_id, licensee_id, licensee_type, instance_id,
+user_id, organization_id, email_address, assigned_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			state.Id, lc.licenseeId, lc.licenseeType, lc.instanceId, lc.userId, lc.organizationId, lc.emailAddress,
+			state.CurrentAssignment.AssignedAt.UnixNano())
+		if err != nil {
+			return err
+		}
+	}
+	for i := storedHistory; i < len(state.PreviousAssignments); i++ {
+		previous := state.PreviousAssignments[i]
+		lc, err := sqlLicenseeColumnsOf(previous.Assignee)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO license_assignment_history (license_id, position, licensee_id, licensee_type,
+instance_id, user_id, organization_id, email_address, assigned_at, unassigned_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			state.Id, i, lc.licenseeId, lc.licenseeType, lc.instanceId, lc.userId, lc.organizationId, lc.emailAddress,
+			previous.AssignedAt.UnixNano(), sqlTime(previous.UnassignedAt))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *LicenseRepoSql) GetLicenseById(ctx context.Context, licId string) (*licensing.License, error) {
+	results, err := r.queryLicenses(ctx, `l.id = ?`, licId)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%w for id=%s", licensing.ErrLicenseNotFound, licId)
+	}
+	return results[0], nil
+}
+
+func (r *LicenseRepoSql) FindLicensesByAssignedLicenseeId(ctx context.Context, licenseeId string) ([]*licensing.License, error) {
+	results, err := r.queryLicenses(ctx, `l.id IN (SELECT license_id FROM license_assignments WHERE licensee_id = ?)`, licenseeId)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%w: none assigned to licenseeId=%s", licensing.ErrLicenseNotFound, licenseeId)
+	}
+	return results, nil
+}
+
+func (r *LicenseRepoSql) FindLicensesOfAccount(ctx context.Context, accId string) ([]*licensing.License, error) {
+	return r.queryLicenses(ctx, `l.account_id = ?`, accId)
+}
+
+// Condition of the unassigned licenses of a package of an account in force at a time, given as Unix nanoseconds
+const sqlUnassignedLicensesOfPackage = `l.account_id = ? AND l.package_id = ?
+AND l.renewed_at IS NULL AND l.cancelled_at IS NULL AND l.expired_at IS NULL
+AND (l.expires_at IS NULL OR l.expires_at > ?)
+AND NOT EXISTS (SELECT 1 FROM license_assignments ua WHERE ua.license_id = l.id)`
+
+func (r *LicenseRepoSql) FindUnassignedLicensesOfPackage(ctx context.Context, accId string, pkgId string) ([]*licensing.License, error) {
+	return r.queryLicenses(ctx, sqlUnassignedLicensesOfPackage, accId, pkgId, time.Now().UnixNano())
+}
+
+func (r *LicenseRepoSql) FindLicensesToAssign(ctx context.Context, accId string, pkgId string, strategy licensing.SeatAllocationStrategy, at time.Time, limit int) ([]*licensing.License, error) {
+	toAssign := sqlUnassignedLicensesOfPackage
+	args := []interface{}{accId, pkgId, at.UnixNano()}
+
+	// the subscriptions and trial flags the strategy ranks by, grouped by rank
+	rows, err := r.querier().QueryContext(ctx, `SELECT DISTINCT l.subscription_id, l.is_trial FROM licenses l WHERE `+toAssign, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byRank := make(map[int][]string)
+	rankArgs := make(map[int][]interface{})
+	ranks := make([]int, 0)
+	for rows.Next() {
+		var subId string
+		var isTrial bool
+		if err := rows.Scan(&subId, &isTrial); err != nil {
+			return nil, err
+		}
+		rank := strategy.Rank(subId, isTrial)
+		if _, ok := byRank[rank]; !ok {
+			ranks = append(ranks, rank)
+		}
+		byRank[rank] = append(byRank[rank], `(l.subscription_id = ? AND l.is_trial = ?)`)
+		rankArgs[rank] = append(rankArgs[rank], subId, isTrial)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	sort.Ints(ranks)
+
+	orderBy := `l.issued_at, l.id`
+	if strategy.Order() == licensing.SOONEST_EXPIRING_FIRST {
+		orderBy = `l.expires_at IS NULL, l.expires_at, ` + orderBy
+	}
+	ids := make([]string, 0)
+	for _, rank := range ranks {
+		if len(ids) >= limit {
+			break
+		}
+		rankIds, err := queryStrings(ctx, r.querier(), `SELECT l.id FROM licenses l WHERE `+toAssign+
+			` AND (`+strings.Join(byRank[rank], " OR ")+`) ORDER BY `+orderBy+fmt.Sprintf(` LIMIT %d`, limit-len(ids)),
+			append(append([]interface{}{}, args...), rankArgs[rank]...)...)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, rankIds...)
+	}
+	return r.queryLicensesInOrder(ctx, ids)
+}
+
+func (r *LicenseRepoSql) CountTotalUnassignedLicensesOfPackage(ctx context.Context, accId string, pkgId string) (int, error) {
+	var count int
+	err := r.querier().QueryRowContext(ctx, `SELECT COUNT(*) FROM licenses l WHERE `+sqlUnassignedLicensesOfPackage,
+		accId, pkgId, time.Now().UnixNano()).Scan(&count)
+	return count, err
+}
+
+func (r *LicenseRepoSql) ListLicenses(ctx context.Context, query licensing.LicenseQuery) (*licensing.LicensePage, error) {
+	pageSize, cursor, err := query.Page()
+	if err != nil {
+		return nil, err
+	}
+	conditions := []string{"1 = 1"}
+	args := make([]interface{}, 0)
+	where := func(condition string, conditionArgs ...interface{}) {
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+	if query.AccountId != "" {
+		where(`l.account_id = ?`, query.AccountId)
+	}
+	if query.SubscriptionId != "" {
+		where(`l.subscription_id = ?`, query.SubscriptionId)
+	}
+	if query.PackageId != "" {
+		where(`l.package_id = ?`, query.PackageId)
+	}
+	if query.IsAssigned != nil && *query.IsAssigned {
+		where(`EXISTS (SELECT 1 FROM license_assignments qa WHERE qa.license_id = l.id)`)
+	}
+	if query.IsAssigned != nil && !*query.IsAssigned {
+		where(`NOT EXISTS (SELECT 1 FROM license_assignments qa WHERE qa.license_id = l.id)`)
+	}
+	if len(query.Statuses) > 0 {
+		args = append(args, query.StatusAt().UnixNano())
+		placeholders := make([]string, len(query.Statuses))
+		for i, status := range query.Statuses {
+			placeholders[i] = "?"
+			args = append(args, int(status))
+		}
+		conditions = append(conditions, sqlLicenseStatus+` IN (`+strings.Join(placeholders, ", ")+`)`)
+	}
+	if query.IsTrial != nil {
+		where(`l.is_trial = ?`, *query.IsTrial)
+	}
+	if query.LicenseeId != "" {
+		where(`l.id IN (SELECT license_id FROM license_assignments WHERE licensee_id = ?)`, query.LicenseeId)
+	}
+	if !query.IssuedFrom.IsZero() {
+		where(`l.issued_at >= ?`, query.IssuedFrom.UnixNano())
+	}
+	if !query.IssuedBefore.IsZero() {
+		where(`l.issued_at < ?`, query.IssuedBefore.UnixNano())
+	}
+
+	// licenses without the time sorted by are keyed as the domain keys them, see LicenseQuery.SortKeyOf;
+	// the smallest BIGINT is written as an expression, as its literal is read as a floating point number
+	sortKey := `0`
+	switch query.SortBy {
+	case licensing.SORT_LICENSES_BY_ISSUED_AT:
+		sortKey = `COALESCE(l.issued_at, -9223372036854775807 - 1)`
+	case licensing.SORT_LICENSES_BY_EXPIRES_AT:
+		sortKey = `COALESCE(l.expires_at, -9223372036854775807 - 1)`
+	}
+	comparison, direction := ">", "ASC"
+	if query.Descending {
+		comparison, direction = "<", "DESC"
+	}
+	// the constant key of licenses sorted by id is left out, as ORDER BY reads an integer as a column position
+	orderBy := `l.id ` + direction
+	if query.SortBy != licensing.SORT_LICENSES_BY_ID {
+		orderBy = sortKey + ` ` + direction + `, ` + orderBy
+	}
+	if cursor != nil {
+		where(`(`+sortKey+` `+comparison+` ? OR (`+sortKey+` = ? AND l.id `+comparison+` ?))`,
+			cursor.SortKey, cursor.SortKey, cursor.LicenseId)
+	}
+
+	// the ids of the page come first, and one more to tell whether there is a next page
+	ids, err := queryStrings(ctx, r.querier(), `SELECT l.id FROM licenses l WHERE `+strings.Join(conditions, " AND ")+
+		` ORDER BY `+orderBy+fmt.Sprintf(` LIMIT %d`, pageSize+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	hasNext := len(ids) > pageSize
+	if hasNext {
+		ids = ids[:pageSize]
+	}
+	licenses, err := r.queryLicensesInOrder(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	page := &licensing.LicensePage{Licenses: licenses}
+	if hasNext {
+		page.NextCursor = query.CursorAfter(page.Licenses[len(page.Licenses)-1])
+	}
+	return page, nil
+}
+
+// Status of licenses l at a time, given as Unix nanoseconds, as licensing.LicenseQuery.StatusOf
+const sqlLicenseStatus = `(CASE WHEN l.renewed_at IS NOT NULL THEN 3 WHEN l.cancelled_at IS NOT NULL THEN 2
+WHEN l.expired_at IS NOT NULL OR l.expires_at <= ? THEN 1 ELSE 0 END)`
+
+// Runs fn in the transaction of the unit of work, or in a transaction of its own outside units of work
+func (r *LicenseRepoSql) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if r.tx != nil {
+		return fn(r.tx)
+	}
+	return inSqlTx(ctx, r.db, fn)
+}
+
+// Returns the transaction of the unit of work to read from, or the database outside units of work
+func (r *LicenseRepoSql) querier() sqlQuerier {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.db
+}
+
+// Loads the licenses of the given ids, in the order of the ids
+func (r *LicenseRepoSql) queryLicensesInOrder(ctx context.Context, ids []string) ([]*licensing.License, error) {
+	results := make([]*licensing.License, 0, len(ids))
+	if len(ids) == 0 {
+		return results, nil
+	}
+	placeholders := make([]string, len(ids))
+	idArgs := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		idArgs[i] = id
+	}
+	licenses, err := r.queryLicenses(ctx, `l.id IN (`+strings.Join(placeholders, ", ")+`)`, idArgs...)
+	if err != nil {
+		return nil, err
+	}
+	byId := make(map[string]*licensing.License, len(licenses))
+	for _, lic := range licenses {
+		byId[lic.Id()] = lic
+	}
+	for _, id := range ids {
+		results = append(results, byId[id])
+	}
+	return results, nil
+}
+
+// Loads the licenses matching the condition on licenses l, with their assignments, in order of issuance
+func (r *LicenseRepoSql) queryLicenses(ctx context.Context, where string, args ...interface{}) ([]*licensing.License, error) {
+	rows, err := r.querier().QueryContext(ctx, `SELECT l.id, l.account_id, l.subscription_id, l.package_id, l.is_trial, l.expires_at,
+l.issued_at, l.issuance_reason, l.expired_at, l.cancelled_at, l.renewed_to_license_id, l.renewed_at, l.renewal_reason, l.version
+FROM licenses l WHERE `+where+` ORDER BY l.issued_at, l.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	states := make([]*licensing.LicenseState, 0)
+	statesById := make(map[string]*licensing.LicenseState)
+	pkgIds := make(map[string]string)
+	for rows.Next() {
+		state := &licensing.LicenseState{}
+		var pkgId string
+		var expiresAt, issuedAt, expiredAt, cancelledAt, renewedAt sql.NullInt64
+		var issuanceReason, renewedToId, renewalReason sql.NullString
+		err := rows.Scan(&state.Id, &state.PossessingCustomerAccountId, &state.GoverningSubscriptionId, &pkgId,
+			&state.IsTrial, &expiresAt, &issuedAt, &issuanceReason, &expiredAt, &cancelledAt,
+			&renewedToId, &renewedAt, &renewalReason, &state.Version)
+		if err != nil {
+			return nil, err
+		}
+		state.ExpiresAt = timeOfSql(expiresAt)
+		if issuedAt.Valid {
+			state.IssuanceDetail = &licensing.LicenseIssuanceDetail{IssuedAt: timeOfSql(issuedAt), IssuanceReason: issuanceReason.String}
+		}
+		if expiredAt.Valid {
+			state.ExpirationDetail = &licensing.LicenseExpirationDetail{ExpiredAt: timeOfSql(expiredAt)}
+		}
+		if cancelledAt.Valid {
+			state.CancellationDetail = &licensing.LicenseCancellationDetail{CancelledAt: timeOfSql(cancelledAt)}
+		}
+		if renewedAt.Valid {
+			state.RenewalDetail = &licensing.LicenseRenewalDetail{
+				RenewedToLicenseId: renewedToId.String, RenewedAt: timeOfSql(renewedAt), RenewalReason: renewalReason.String}
+		}
+		states = append(states, state)
+		statesById[state.Id] = state
+		pkgIds[state.Id] = pkgId
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(states) == 0 {
+		return []*licensing.License{}, nil
+	}
+	if err := r.loadAssignments(ctx, statesById, where, args...); err != nil {
+		return nil, err
+	}
+
+	pkgs := make(map[string]*licensing.Package)
+	results := make([]*licensing.License, 0, len(states))
+	for _, state := range states {
+		pkgId := pkgIds[state.Id]
+		if _, ok := pkgs[pkgId]; !ok {
+			pkg, err := r.pkgRepo.GetPackageById(ctx, pkgId)
+			if err != nil {
+				return nil, err
+			}
+			pkgs[pkgId] = pkg
+		}
+		state.LicensedPackage = pkgs[pkgId]
+		results = append(results, licensing.RestoreLicense(*state))
+	}
+	return results, nil
+}
+
+// Loads the current and previous assignments of the licenses matching the condition on licenses l
+func (r *LicenseRepoSql) loadAssignments(ctx context.Context, statesById map[string]*licensing.LicenseState, where string, args ...interface{}) error {
+	rows, err := r.querier().QueryContext(ctx, `SELECT a.license_id, a.licensee_type, a.instance_id, a.user_id, a.organization_id,
+a.email_address, a.assigned_at FROM license_assignments a JOIN licenses l ON l.id = a.license_id WHERE `+where, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var licId string
+		var lc sqlLicenseeColumns
+		var assignedAt int64
+		if err := rows.Scan(&licId, &lc.licenseeType, &lc.instanceId, &lc.userId, &lc.organizationId, &lc.emailAddress, &assignedAt); err != nil {
+			return err
+		}
+		assignee, err := lc.licensee()
+		if err != nil {
+			return err
+		}
+		statesById[licId].CurrentAssignment = &licensing.LicenseAssignment{Assignee: assignee, AssignedAt: timeOfSql(sql.NullInt64{Int64: assignedAt, Valid: true})}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	historyRows, err := r.querier().QueryContext(ctx, `SELECT h.license_id, h.licensee_type, h.instance_id, h.user_id, h.organization_id,
+h.email_address, h.assigned_at, h.unassigned_at FROM license_assignment_history h JOIN licenses l ON l.id = h.license_id
+WHERE `+where+` ORDER BY h.license_id, h.position`, args...)
+	if err != nil {
+		return err
+	}
+	defer historyRows.Close()
+	for historyRows.Next() {
+		var licId string
+		var lc sqlLicenseeColumns
+		var assignedAt int64
+		var unassignedAt sql.NullInt64
+		err := historyRows.Scan(&licId, &lc.licenseeType, &lc.instanceId, &lc.userId, &lc.organizationId, &lc.emailAddress,
+			&assignedAt, &unassignedAt)
+		if err != nil {
+			return err
+		}
+		assignee, err := lc.licensee()
+		if err != nil {
+			return err
+		}
+		state := statesById[licId]
+		state.PreviousAssignments = append(state.PreviousAssignments, &licensing.LicenseAssignment{
+			Assignee:     assignee,
+			AssignedAt:   timeOfSql(sql.NullInt64{Int64: assignedAt, Valid: true}),
+			UnassignedAt: timeOfSql(unassignedAt)})
+	}
+	return historyRows.Err()
+}
+
+// Columns a licensee is stored in
+type sqlLicenseeColumns struct {
+	licenseeId     string
+	licenseeType   int
+	instanceId     string
+	userId         string
+	organizationId string
+	emailAddress   string
+}
+
+func sqlLicenseeColumnsOf(licensee licensing.Licensee) (sqlLicenseeColumns, error) {
+	lc := sqlLicenseeColumns{licenseeId: licensee.LicenseeId(), licenseeType: int(licensee.LicenseeType())}
+	switch l := licensee.(type) {
+	case licensing.InstanceUser:
+		lc.instanceId, lc.userId = l.InstanceId, l.InstanceScopeUserId
+	case licensing.OrganizationUser:
+		lc.organizationId, lc.userId, lc.emailAddress = l.OrganizationId, l.OrganizationScopeUserId, l.EmailAddress
+	default:
+		return lc, fmt.Errorf("cannot store licensee of type %s", licensee.LicenseeType())
+	}
+	return lc, nil
+}
+
+func (lc sqlLicenseeColumns) licensee() (licensing.Licensee, error) {
+	switch licensing.LicenseeType(lc.licenseeType) {
+	case licensing.INSTANCE_USER:
+		return licensing.NewInstanceUser(lc.instanceId, lc.userId), nil
+	case licensing.ORGANIZATION_USER:
+		return licensing.NewOrganizationUser(lc.organizationId, lc.userId, lc.emailAddress), nil
+	}
+	return nil, fmt.Errorf("cannot load licensee of type %d", lc.licenseeType)
+}
+
+func sqlIssuanceDetail(detail *licensing.LicenseIssuanceDetail) (sql.NullInt64, sql.NullString) {
+	if detail == nil {
+		return sql.NullInt64{}, sql.NullString{}
+	}
+	return sql.NullInt64{Int64: detail.IssuedAt.UnixNano(), Valid: true}, sql.NullString{String: detail.IssuanceReason, Valid: true}
+}
+
+func sqlExpirationAndCancellation(state licensing.LicenseState) (sql.NullInt64, sql.NullInt64) {
+	var expiredAt, cancelledAt sql.NullInt64
+	if state.ExpirationDetail != nil {
+		expiredAt = sql.NullInt64{Int64: state.ExpirationDetail.ExpiredAt.UnixNano(), Valid: true}
+	}
+	if state.CancellationDetail != nil {
+		cancelledAt = sql.NullInt64{Int64: state.CancellationDetail.CancelledAt.UnixNano(), Valid: true}
+	}
+	return expiredAt, cancelledAt
+}
+
+func sqlRenewalDetail(detail *licensing.LicenseRenewalDetail) (sql.NullString, sql.NullInt64, sql.NullString) {
+	if detail == nil {
+		return sql.NullString{}, sql.NullInt64{}, sql.NullString{}
+	}
+	return sql.NullString{String: detail.RenewedToLicenseId, Valid: true},
+		sql.NullInt64{Int64: detail.RenewedAt.UnixNano(), Valid: true},
+		sql.NullString{String: detail.RenewalReason, Valid: true}
+}
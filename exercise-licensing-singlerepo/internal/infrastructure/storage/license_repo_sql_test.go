@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	_ "github.com/mattn/go-sqlite3"
+	"gotest.tools/v3/assert"
+)
+
+func TestLicenseRepoSql(t *testing.T) {
+
+	ctx := context.Background()
+	newRepo := func(t *testing.T) (*LicenseRepoSql, *licensing.Package) {
+		pkgRepo := NewPackageRepoSql(openTestSqlDb(t))
+		assert.NilError(t, pkgRepo.importCatalog(ctx, loadCatalog2022()))
+		pkg, err := pkgRepo.GetPackageById(ctx, "pkg:base-optimize-2022")
+		assert.NilError(t, err)
+		return NewLicenseRepoSql(pkgRepo.db, pkgRepo), pkg
+	}
+
+	t.Run("license terms round-trip", func(t *testing.T) {
+		r, pkg := newRepo(t)
+		expiresAt := time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC)
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", pkg, licensing.AsTrial(), licensing.ExpiringAt(expiresAt))
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+
+		loaded, err := r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		assert.Equal(t, loaded.IsTrial(), true)
+		assert.Equal(t, loaded.ExpiresAt(), expiresAt)
+		assert.Check(t, loaded.IssuedAt().Equal(lic.IssuedAt()))
+		assert.DeepEqual(t, loaded.LicensedPackage(), pkg)
+	})
+
+	t.Run("concurrent updates of the same version conflict", func(t *testing.T) {
+		r, pkg := newRepo(t)
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", pkg)
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(insUsrId string) {
+				defer wg.Done()
+				loaded := lic.Clone()
+				loaded.Assign(licensing.NewInstanceUser("ins-101", insUsrId))
+				errs <- r.UpdateLicense(ctx, loaded.Id(), loaded)
+			}(fmt.Sprintf("usr-%d", i))
+		}
+		wg.Wait()
+		close(errs)
+		succeeded := 0
+		for err := range errs {
+			if err == nil {
+				succeeded++
+				continue
+			}
+			assert.Check(t, errors.Is(err, licensing.ErrLicenseVersionConflict), err)
+		}
+		assert.Equal(t, succeeded, 1)
+	})
+}
+
+func TestPackageRepoSql(t *testing.T) {
+
+	ctx := context.Background()
+	r := NewPackageRepoSql(openTestSqlDb(t))
+	assert.NilError(t, r.importCatalog(ctx, loadCatalog2022()))
+	inMem := NewPackageRepoInMem()
+
+	t.Run("imported catalog matches the in-memory one", func(t *testing.T) {
+		pkgs, err := r.ListPackages(ctx)
+		assert.NilError(t, err)
+		expectedPkgs, err := inMem.ListPackages(ctx)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, pkgs, expectedPkgs)
+
+		capabilities, err := r.ListCapabilities(ctx)
+		assert.NilError(t, err)
+		expectedCapabilities, err := NewCapabilityRepoInMem().ListCapabilities(ctx)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, capabilities, expectedCapabilities)
+
+		plan, err := r.GetPackagingPlanById(ctx, "pkgplan:v1.0")
+		assert.NilError(t, err)
+		expectedPlan, err := inMem.GetPackagingPlanById(ctx, "pkgplan:v1.0")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, plan, expectedPlan)
+	})
+
+}
+
+func TestMigrateSqlSchema(t *testing.T) {
+
+	ctx := context.Background()
+	db := openTestSqlDb(t)
+
+	// migrating an up-to-date schema changes nothing
+	assert.NilError(t, MigrateSqlSchema(ctx, db))
+	versions, err := queryStrings(ctx, db, `SELECT name FROM schema_migrations ORDER BY version`)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, versions, []string{"0001_create_catalog.sql", "0002_create_licenses.sql", "0003_index_licenses_by_issuance.sql"})
+}
+
+// Opens a migrated SQLite database in a temporary file. Writers take the lock when beginning transactions
+// and wait for each other, like they would on MySQL.
+func openTestSqlDb(t *testing.T) *sql.DB {
+	path := filepath.Join(t.TempDir(), "licensing.db")
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=10000&_txlock=immediate")
+	assert.NilError(t, err)
+	t.Cleanup(func() { db.Close() })
+	assert.NilError(t, MigrateSqlSchema(context.Background(), db))
+	return db
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// An event in the stream of a license, as recorded by LicenseRepoEventSourced.
+// The state of a license is the result of applying the events of its stream in order.
+type LicenseStreamEvent struct {
+
+	// Position of the event in the log of all streams, from 1
+	Position int64
+
+	// Id of the license whose stream the event belongs to
+	LicenseId string
+
+	// Version of the license after the change the event is part of; a change may record several events
+	Version int64
+
+	// LICENSE_ISSUED, LICENSE_ASSIGNED, LICENSE_UNASSIGNED, LICENSE_EXPIRED or LICENSE_RENEWED
+	Type licensing.LicenseEventType
+
+	// When the event happened, e.g. when the license was issued for LICENSE_ISSUED
+	OccurredAt time.Time
+
+	// The customer account possessing the license, and the licensed package; set on every event for read models
+	AccountId string
+	PackageId string
+
+	// Terms of the license, set on LICENSE_ISSUED
+	SubscriptionId string
+	IssuanceReason string
+	IsTrial        bool
+	ExpiresAt      time.Time
+
+	// The assigned licensee, set on LICENSE_ASSIGNED
+	Licensee licensing.Licensee
+
+	// The license renewed to and why, set on LICENSE_RENEWED
+	RenewedToLicenseId string
+	RenewalReason      string
+}
+
+func (evt LicenseStreamEvent) String() string {
+	return fmt.Sprintf("#%d %s license id=%s at version %d", evt.Position, evt.Type, evt.LicenseId, evt.Version)
+}
+
+// Returns the event issuing the license in the given state; the state of the license right after the event is
+// the state without assignments, expiration and renewal
+func licenseIssuedEvent(state licensing.LicenseState) LicenseStreamEvent {
+	evt := LicenseStreamEvent{
+		Type:           licensing.LICENSE_ISSUED,
+		LicenseId:      state.Id,
+		AccountId:      state.PossessingCustomerAccountId,
+		PackageId:      state.LicensedPackage.Id,
+		SubscriptionId: state.GoverningSubscriptionId,
+		IsTrial:        state.IsTrial,
+		ExpiresAt:      state.ExpiresAt,
+	}
+	if state.IssuanceDetail != nil {
+		evt.OccurredAt = state.IssuanceDetail.IssuedAt
+		evt.IssuanceReason = state.IssuanceDetail.IssuanceReason
+	}
+	return evt
+}
+
+// Returns the events changing the license from one state to the other, in order of application.
+//
+// Only changes expressible as events can be recorded: assigning and unassigning, expiring and renewing.
+// The terms of the license and its assignment history are immutable.
+func licenseChangeEvents(from licensing.LicenseState, to licensing.LicenseState) ([]LicenseStreamEvent, error) {
+	if to.PossessingCustomerAccountId != from.PossessingCustomerAccountId || to.GoverningSubscriptionId != from.GoverningSubscriptionId ||
+		to.LicensedPackage.Id != from.LicensedPackage.Id || to.IsTrial != from.IsTrial || !to.ExpiresAt.Equal(from.ExpiresAt) ||
+		!sameIssuance(from.IssuanceDetail, to.IssuanceDetail) || !sameCancellation(from.CancellationDetail, to.CancellationDetail) {
+		return nil, fmt.Errorf("license id=%s: terms of a license cannot change", from.Id)
+	}
+	newEvent := func(eventType licensing.LicenseEventType, at time.Time) LicenseStreamEvent {
+		return LicenseStreamEvent{
+			Type:       eventType,
+			LicenseId:  from.Id,
+			AccountId:  from.PossessingCustomerAccountId,
+			PackageId:  from.LicensedPackage.Id,
+			OccurredAt: at,
+		}
+	}
+	events := make([]LicenseStreamEvent, 0)
+
+	fromAssignments, toAssignments := allAssignments(from), allAssignments(to)
+	if len(toAssignments) < len(fromAssignments) {
+		return nil, fmt.Errorf("license id=%s: assignment history cannot be removed", from.Id)
+	}
+	for i, assignment := range fromAssignments {
+		if assignment.Assignee.LicenseeId() != toAssignments[i].Assignee.LicenseeId() || !assignment.AssignedAt.Equal(toAssignments[i].AssignedAt) {
+			return nil, fmt.Errorf("license id=%s: assignment history cannot be rewritten", from.Id)
+		}
+		// the current assignment was ended
+		if i == len(from.PreviousAssignments) && i < len(to.PreviousAssignments) {
+			events = append(events, newEvent(licensing.LICENSE_UNASSIGNED, toAssignments[i].UnassignedAt))
+		}
+	}
+	for i := len(fromAssignments); i < len(toAssignments); i++ {
+		assigned := newEvent(licensing.LICENSE_ASSIGNED, toAssignments[i].AssignedAt)
+		assigned.Licensee = toAssignments[i].Assignee
+		events = append(events, assigned)
+		if i < len(to.PreviousAssignments) {
+			events = append(events, newEvent(licensing.LICENSE_UNASSIGNED, toAssignments[i].UnassignedAt))
+		}
+	}
+
+	switch {
+	case from.ExpirationDetail == nil && to.ExpirationDetail != nil:
+		events = append(events, newEvent(licensing.LICENSE_EXPIRED, to.ExpirationDetail.ExpiredAt))
+	case from.ExpirationDetail != nil && (to.ExpirationDetail == nil || !to.ExpirationDetail.ExpiredAt.Equal(from.ExpirationDetail.ExpiredAt)):
+		return nil, fmt.Errorf("license id=%s: expiration cannot be changed or undone", from.Id)
+	}
+	switch {
+	case from.RenewalDetail == nil && to.RenewalDetail != nil:
+		renewed := newEvent(licensing.LICENSE_RENEWED, to.RenewalDetail.RenewedAt)
+		renewed.RenewedToLicenseId = to.RenewalDetail.RenewedToLicenseId
+		renewed.RenewalReason = to.RenewalDetail.RenewalReason
+		events = append(events, renewed)
+	case from.RenewalDetail != nil && !sameRenewal(from.RenewalDetail, to.RenewalDetail):
+		return nil, fmt.Errorf("license id=%s: renewal cannot be changed or undone", from.Id)
+	}
+	return events, nil
+}
+
+// Applies the event to the state of the license. The state of a license that is not issued yet is the zero value;
+// pkg resolves the package of the LICENSE_ISSUED event.
+func applyLicenseStreamEvent(state *licensing.LicenseState, evt LicenseStreamEvent, pkg func(pkgId string) (*licensing.Package, error)) error {
+	if evt.Type != licensing.LICENSE_ISSUED && state.Id == "" {
+		return fmt.Errorf("%s: license is not issued", evt)
+	}
+	switch evt.Type {
+	case licensing.LICENSE_ISSUED:
+		if state.Id != "" {
+			return fmt.Errorf("%s: license is already issued", evt)
+		}
+		licensedPkg, err := pkg(evt.PackageId)
+		if err != nil {
+			return err
+		}
+		*state = licensing.LicenseState{
+			Id:                          evt.LicenseId,
+			LicensedPackage:             licensedPkg,
+			PossessingCustomerAccountId: evt.AccountId,
+			GoverningSubscriptionId:     evt.SubscriptionId,
+			IsTrial:                     evt.IsTrial,
+			ExpiresAt:                   evt.ExpiresAt,
+		}
+		if !evt.OccurredAt.IsZero() || evt.IssuanceReason != "" {
+			state.IssuanceDetail = &licensing.LicenseIssuanceDetail{IssuedAt: evt.OccurredAt, IssuanceReason: evt.IssuanceReason}
+		}
+	case licensing.LICENSE_ASSIGNED:
+		if state.CurrentAssignment != nil {
+			return fmt.Errorf("%s: license is already assigned", evt)
+		}
+		state.CurrentAssignment = &licensing.LicenseAssignment{Assignee: evt.Licensee, AssignedAt: evt.OccurredAt}
+	case licensing.LICENSE_UNASSIGNED:
+		if state.CurrentAssignment == nil {
+			return fmt.Errorf("%s: license is not assigned", evt)
+		}
+		state.CurrentAssignment.UnassignedAt = evt.OccurredAt
+		state.PreviousAssignments = append(state.PreviousAssignments, state.CurrentAssignment)
+		state.CurrentAssignment = nil
+	case licensing.LICENSE_EXPIRED:
+		state.ExpirationDetail = &licensing.LicenseExpirationDetail{ExpiredAt: evt.OccurredAt}
+	case licensing.LICENSE_RENEWED:
+		state.RenewalDetail = &licensing.LicenseRenewalDetail{
+			RenewedToLicenseId: evt.RenewedToLicenseId,
+			RenewedAt:          evt.OccurredAt,
+			RenewalReason:      evt.RenewalReason,
+		}
+	default:
+		return fmt.Errorf("%s: unsupported event type", evt)
+	}
+	state.Version = evt.Version
+	return nil
+}
+
+// Previous assignments, followed by the current assignment if any
+func allAssignments(state licensing.LicenseState) []*licensing.LicenseAssignment {
+	results := append([]*licensing.LicenseAssignment{}, state.PreviousAssignments...)
+	if state.CurrentAssignment != nil {
+		results = append(results, state.CurrentAssignment)
+	}
+	return results
+}
+
+func sameIssuance(a *licensing.LicenseIssuanceDetail, b *licensing.LicenseIssuanceDetail) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.IssuedAt.Equal(b.IssuedAt) && a.IssuanceReason == b.IssuanceReason
+}
+
+func sameCancellation(a *licensing.LicenseCancellationDetail, b *licensing.LicenseCancellationDetail) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.CancelledAt.Equal(b.CancelledAt)
+}
+
+func sameRenewal(a *licensing.LicenseRenewalDetail, b *licensing.LicenseRenewalDetail) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.RenewedToLicenseId == b.RenewedToLicenseId && a.RenewedAt.Equal(b.RenewedAt) && a.RenewalReason == b.RenewalReason
+}
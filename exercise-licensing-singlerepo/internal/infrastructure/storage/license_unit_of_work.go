@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// A license change staged by a unit of work, to be committed by its repository
+type stagedLicense struct {
+
+	// state of the license after the change, at its version within the unit of work
+	lic *licensing.License
+
+	// version of the stored license the change is based on; 0 if the license is created by the unit of work
+	baseVersion int64
+}
+
+// Runs work against a staging repository over base, and passes the staged changes to commit if work succeeds.
+// commit applies the changes atomically, after checking that no stored license changed since its base version.
+//
+// Used by the repositories without transactions of their own, see licensing.LicenseUnitOfWork
+func runStaged(ctx context.Context, base licensing.LicenseRepository, work func(ctx context.Context, licRepo licensing.LicenseRepository) error, commit func(changes []stagedLicense) error) error {
+	staging := &stagingLicenseRepository{base: base, staged: make(map[string]*stagedLicense)}
+	if err := work(ctx, staging); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	changes := staging.changes()
+	if len(changes) == 0 {
+		return nil
+	}
+	return commit(changes)
+}
+
+// Checks a staged change against the version of the stored license, 0 if the license is not stored
+func checkStagedLicense(change stagedLicense, storedVersion int64) error {
+	if change.baseVersion == 0 && storedVersion != 0 {
+		return fmt.Errorf("license id=%s already exists", change.lic.Id())
+	}
+	if change.baseVersion != 0 && storedVersion == 0 {
+		return fmt.Errorf("%w for id=%s", licensing.ErrLicenseNotFound, change.lic.Id())
+	}
+	if storedVersion != change.baseVersion {
+		return fmt.Errorf("%w: license id=%s is at version %d, update is based on version %d",
+			licensing.ErrLicenseVersionConflict, change.lic.Id(), storedVersion, change.baseVersion)
+	}
+	return nil
+}
+
+// License repository staging the changes of a unit of work over a base repository, reading through to the base
+// repository for the licenses it did not change
+type stagingLicenseRepository struct {
+	base licensing.LicenseRepository
+
+	mu     sync.Mutex
+	staged map[string]*stagedLicense
+
+	// ids of the staged licenses, in order of first change
+	order []string
+}
+
+func (r *stagingLicenseRepository) CreateLicense(ctx context.Context, lic *licensing.License) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.staged[lic.Id()]; ok {
+		return fmt.Errorf("license id=%s already exists", lic.Id())
+	}
+	// the commit checks again, as the license may be created concurrently
+	_, err := r.base.GetLicenseById(ctx, lic.Id())
+	if err == nil {
+		return fmt.Errorf("license id=%s already exists", lic.Id())
+	}
+	if !errors.Is(err, licensing.ErrLicenseNotFound) {
+		return err
+	}
+	lic.SetPersistedVersion(1)
+	r.stage(lic, 0)
+	return nil
+}
+
+func (r *stagingLicenseRepository) UpdateLicense(ctx context.Context, licId string, newLic *licensing.License) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var currentVersion, baseVersion int64
+	if staged, ok := r.staged[newLic.Id()]; ok {
+		currentVersion, baseVersion = staged.lic.Version(), staged.baseVersion
+	} else {
+		stored, err := r.base.GetLicenseById(ctx, newLic.Id())
+		if err != nil {
+			return err
+		}
+		currentVersion, baseVersion = stored.Version(), stored.Version()
+	}
+	if currentVersion != newLic.Version() {
+		return fmt.Errorf("%w: license id=%s is at version %d, update is based on version %d",
+			licensing.ErrLicenseVersionConflict, newLic.Id(), currentVersion, newLic.Version())
+	}
+	newLic.SetPersistedVersion(currentVersion + 1)
+	r.stage(newLic, baseVersion)
+	return nil
+}
+
+func (r *stagingLicenseRepository) GetLicenseById(ctx context.Context, licId string) (*licensing.License, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if staged, ok := r.staged[licId]; ok {
+		return staged.lic.Clone(), nil
+	}
+	return r.base.GetLicenseById(ctx, licId)
+}
+
+func (r *stagingLicenseRepository) FindLicensesByAssignedLicenseeId(ctx context.Context, licenseeId string) ([]*licensing.License, error) {
+	stored, err := r.base.FindLicensesByAssignedLicenseeId(ctx, licenseeId)
+	if errors.Is(err, licensing.ErrLicenseNotFound) {
+		// none stored, but maybe staged
+		stored, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	results, err := r.merge(ctx, stored, func(lic *licensing.License) bool {
+		return lic.IsAssigned() && lic.AssignedToLicensee().LicenseeId() == licenseeId
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%w: none assigned to licenseeId=%s", licensing.ErrLicenseNotFound, licenseeId)
+	}
+	return results, nil
+}
+
+func (r *stagingLicenseRepository) FindLicensesOfAccount(ctx context.Context, accId string) ([]*licensing.License, error) {
+	stored, err := r.base.FindLicensesOfAccount(ctx, accId)
+	if err != nil {
+		return nil, err
+	}
+	return r.merge(ctx, stored, func(lic *licensing.License) bool { return lic.PossessingCustomerAccountId() == accId })
+}
+
+func (r *stagingLicenseRepository) FindUnassignedLicensesOfPackage(ctx context.Context, accId string, pkgId string) ([]*licensing.License, error) {
+	stored, err := r.base.FindUnassignedLicensesOfPackage(ctx, accId, pkgId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return r.merge(ctx, stored, func(lic *licensing.License) bool {
+		return lic.PossessingCustomerAccountId() == accId && lic.LicensedPackage().Id == pkgId && lic.IsInForceAt(now) && !lic.IsAssigned()
+	})
+}
+
+func (r *stagingLicenseRepository) FindLicensesToAssign(ctx context.Context, accId string, pkgId string, strategy licensing.SeatAllocationStrategy, at time.Time, limit int) ([]*licensing.License, error) {
+	// every staged license may take the place of a stored one, so that enough stored licenses are found to fill in
+	r.mu.Lock()
+	stagedCount := len(r.staged)
+	r.mu.Unlock()
+	stored, err := r.base.FindLicensesToAssign(ctx, accId, pkgId, strategy, at, limit+stagedCount)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := r.merge(ctx, stored, func(lic *licensing.License) bool {
+		return lic.PossessingCustomerAccountId() == accId && lic.LicensedPackage().Id == pkgId
+	})
+	if err != nil {
+		return nil, err
+	}
+	return licensing.SelectLicensesToAssign(candidates, strategy, at, limit), nil
+}
+
+func (r *stagingLicenseRepository) CountTotalUnassignedLicensesOfPackage(ctx context.Context, accId string, pkgId string) (int, error) {
+	results, err := r.FindUnassignedLicensesOfPackage(ctx, accId, pkgId)
+	return len(results), err
+}
+
+func (r *stagingLicenseRepository) ListLicenses(ctx context.Context, query licensing.LicenseQuery) (*licensing.LicensePage, error) {
+	// staged changes may move licenses in and out of any page, so that the page is listed among all stored matches
+	if _, _, err := query.Page(); err != nil {
+		return nil, err
+	}
+	baseQuery := query
+	baseQuery.PageSize = licensing.MaxLicensePageSize
+	baseQuery.Cursor = ""
+	stored := make([]*licensing.License, 0)
+	for {
+		page, err := r.base.ListLicenses(ctx, baseQuery)
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, page.Licenses...)
+		if page.NextCursor == "" {
+			break
+		}
+		baseQuery.Cursor = page.NextCursor
+	}
+	// the stored licenses the unit of work changed are matched again, in their staged state
+	merged, err := r.merge(ctx, stored, query.Matches)
+	if err != nil {
+		return nil, err
+	}
+	return licensing.ListLicensePage(merged, query)
+}
+
+// Replaces the stored licenses found by a query with their staged changes, and adds the staged licenses
+// the query matches
+func (r *stagingLicenseRepository) merge(ctx context.Context, stored []*licensing.License, matches func(lic *licensing.License) bool) ([]*licensing.License, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	results := make([]*licensing.License, 0, len(stored))
+	for _, lic := range stored {
+		if _, ok := r.staged[lic.Id()]; !ok {
+			results = append(results, lic)
+		}
+	}
+	for _, licId := range r.order {
+		if staged := r.staged[licId]; matches(staged.lic) {
+			results = append(results, staged.lic.Clone())
+		}
+	}
+	return results, nil
+}
+
+func (r *stagingLicenseRepository) stage(lic *licensing.License, baseVersion int64) {
+	if _, ok := r.staged[lic.Id()]; !ok {
+		r.order = append(r.order, lic.Id())
+	}
+	r.staged[lic.Id()] = &stagedLicense{lic: lic.Clone(), baseVersion: baseVersion}
+}
+
+func (r *stagingLicenseRepository) changes() []stagedLicense {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	results := make([]stagedLicense, 0, len(r.order))
+	for _, licId := range r.order {
+		results = append(results, *r.staged[licId])
+	}
+	return results
+}
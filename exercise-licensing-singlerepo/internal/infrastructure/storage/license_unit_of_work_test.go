@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"gotest.tools/v3/assert"
+)
+
+func TestStagedLicenseChanges(t *testing.T) {
+
+	ctx := context.Background()
+	pkg, err := NewPackageRepoInMem().GetPackageById(ctx, "pkg:base-optimize-2022")
+	assert.NilError(t, err)
+	alice := licensing.NewInstanceUser("ins-101", "usr-alice")
+	bob := licensing.NewInstanceUser("ins-101", "usr-bob")
+
+	t.Run("concurrent changes fail the commit", func(t *testing.T) {
+		r := NewLicenseRepoInMem()
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", pkg)
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+		created := licensing.NewIssuedLicense("acc-1", "sub-1", pkg)
+
+		err := r.RunAtomically(ctx, func(ctx context.Context, licRepo licensing.LicenseRepository) error {
+			if err := licRepo.CreateLicense(ctx, created); err != nil {
+				return err
+			}
+			staged, err := licRepo.GetLicenseById(ctx, lic.Id())
+			if err != nil {
+				return err
+			}
+			staged.Assign(alice)
+			if err := licRepo.UpdateLicense(ctx, staged.Id(), staged); err != nil {
+				return err
+			}
+			// changed outside the unit of work before it commits
+			lic.Assign(bob)
+			return r.UpdateLicense(ctx, lic.Id(), lic)
+		})
+		assert.Assert(t, errors.Is(err, licensing.ErrLicenseVersionConflict), err)
+
+		_, err = r.GetLicenseById(ctx, created.Id())
+		assert.Error(t, err, "license not found for id="+created.Id())
+		stored, err := r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		assert.Equal(t, stored.AssignedToLicensee().LicenseeId(), bob.LicenseeId())
+	})
+
+	t.Run("licenses changed several times commit at their last version", func(t *testing.T) {
+		r := NewLicenseRepoEventSourced(NewPackageRepoInMem())
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", pkg)
+
+		err := r.RunAtomically(ctx, func(ctx context.Context, licRepo licensing.LicenseRepository) error {
+			if err := licRepo.CreateLicense(ctx, lic); err != nil {
+				return err
+			}
+			lic.Assign(alice)
+			if err := licRepo.UpdateLicense(ctx, lic.Id(), lic); err != nil {
+				return err
+			}
+			lic.Assign(bob)
+			return licRepo.UpdateLicense(ctx, lic.Id(), lic)
+		})
+		assert.NilError(t, err)
+		assert.Equal(t, lic.Version(), int64(3))
+
+		stored, err := r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		assert.DeepEqual(t, stored.State(), lic.State())
+		history, err := r.LicenseHistory(ctx, lic.Id())
+		assert.NilError(t, err)
+		got := make([]string, 0)
+		for _, evt := range history {
+			got = append(got, evt.String())
+		}
+		assert.DeepEqual(t, got, []string{
+			"#1 LICENSE_ISSUED license id=" + lic.Id() + " at version 3",
+			"#2 LICENSE_ASSIGNED license id=" + lic.Id() + " at version 3",
+			"#3 LICENSE_UNASSIGNED license id=" + lic.Id() + " at version 3",
+			"#4 LICENSE_ASSIGNED license id=" + lic.Id() + " at version 3",
+		})
+	})
+
+	t.Run("storage failures of the base repository fail the unit of work", func(t *testing.T) {
+		base := &unreachableLicenseRepo{LicenseRepoInMem: NewLicenseRepoInMem()}
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", pkg)
+
+		err := runStaged(ctx, base, func(ctx context.Context, licRepo licensing.LicenseRepository) error {
+			_, err := licRepo.FindLicensesByAssignedLicenseeId(ctx, alice.LicenseeId())
+			assert.Assert(t, errors.Is(err, errRepoUnreachable), err)
+			return licRepo.CreateLicense(ctx, lic)
+		}, func(changes []stagedLicense) error {
+			t.Fatal("unit of work committed")
+			return nil
+		})
+		assert.Assert(t, errors.Is(err, errRepoUnreachable), err)
+	})
+}
+
+var errRepoUnreachable = errors.New("repository unreachable")
+
+// In-memory license repository failing every lookup as if its database were down
+type unreachableLicenseRepo struct {
+	*LicenseRepoInMem
+}
+
+func (r *unreachableLicenseRepo) GetLicenseById(ctx context.Context, licId string) (*licensing.License, error) {
+	return nil, errRepoUnreachable
+}
+
+func (r *unreachableLicenseRepo) FindLicensesByAssignedLicenseeId(ctx context.Context, licenseeId string) ([]*licensing.License, error) {
+	return nil, errRepoUnreachable
+}
@@ -0,0 +1,60 @@
+-- Capabilities, packages and packaging plans
+
+CREATE TABLE capabilities (
+    id                  VARCHAR(255) NOT NULL,
+    display_name        VARCHAR(255) NOT NULL,
+    has_capacity_limit  BOOLEAN      NOT NULL,
+    capacity_limit      BIGINT       NOT NULL,
+    capacity_limit_unit VARCHAR(255) NOT NULL,
+    capacity_merge_rule INT          NOT NULL,
+    capacity_scope      INT          NOT NULL,
+    is_archived         BOOLEAN      NOT NULL,
+    PRIMARY KEY (id)
+);
+
+-- relation is one of IMPLIES, REQUIRES, CONFLICTS_WITH
+CREATE TABLE capability_relations (
+    capability_id         VARCHAR(255) NOT NULL,
+    relation              VARCHAR(32)  NOT NULL,
+    position              INT          NOT NULL,
+    related_capability_id VARCHAR(255) NOT NULL,
+    PRIMARY KEY (capability_id, relation, position),
+    FOREIGN KEY (capability_id) REFERENCES capabilities (id)
+);
+
+CREATE TABLE packages (
+    id          VARCHAR(255) NOT NULL,
+    name        VARCHAR(255) NOT NULL,
+    is_archived BOOLEAN      NOT NULL,
+    PRIMARY KEY (id)
+);
+
+-- capacity limit of the capability as defined by the package
+CREATE TABLE package_capabilities (
+    package_id          VARCHAR(255) NOT NULL,
+    position            INT          NOT NULL,
+    capability_id       VARCHAR(255) NOT NULL,
+    has_capacity_limit  BOOLEAN      NOT NULL,
+    capacity_limit      BIGINT       NOT NULL,
+    capacity_limit_unit VARCHAR(255) NOT NULL,
+    PRIMARY KEY (package_id, position),
+    FOREIGN KEY (package_id) REFERENCES packages (id),
+    FOREIGN KEY (capability_id) REFERENCES capabilities (id)
+);
+
+CREATE TABLE packaging_plans (
+    id            VARCHAR(255) NOT NULL,
+    major_version INT          NOT NULL,
+    revision      INT          NOT NULL,
+    created_at    BIGINT       NOT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE packaging_plan_packages (
+    plan_id    VARCHAR(255) NOT NULL,
+    position   INT          NOT NULL,
+    package_id VARCHAR(255) NOT NULL,
+    PRIMARY KEY (plan_id, position),
+    FOREIGN KEY (plan_id) REFERENCES packaging_plans (id),
+    FOREIGN KEY (package_id) REFERENCES packages (id)
+);
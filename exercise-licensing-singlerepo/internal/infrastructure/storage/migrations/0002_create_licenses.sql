@@ -0,0 +1,54 @@
+-- Licenses, their current assignments and their assignment history
+
+CREATE TABLE licenses (
+    id                    VARCHAR(64)  NOT NULL,
+    account_id            VARCHAR(255) NOT NULL,
+    subscription_id       VARCHAR(255) NOT NULL,
+    package_id            VARCHAR(255) NOT NULL,
+    is_trial              BOOLEAN      NOT NULL,
+    expires_at            BIGINT       NULL,
+    issued_at             BIGINT       NULL,
+    issuance_reason       VARCHAR(255) NULL,
+    expired_at            BIGINT       NULL,
+    cancelled_at          BIGINT       NULL,
+    renewed_to_license_id VARCHAR(64)  NULL,
+    renewed_at            BIGINT       NULL,
+    renewal_reason        VARCHAR(255) NULL,
+    version               BIGINT       NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (package_id) REFERENCES packages (id)
+);
+
+CREATE INDEX licenses_by_account_package ON licenses (account_id, package_id);
+
+-- at most one current assignment per license
+CREATE TABLE license_assignments (
+    license_id      VARCHAR(64)  NOT NULL,
+    licensee_id     VARCHAR(255) NOT NULL,
+    licensee_type   INT          NOT NULL,
+    instance_id     VARCHAR(255) NOT NULL,
+    user_id         VARCHAR(255) NOT NULL,
+    organization_id VARCHAR(255) NOT NULL,
+    email_address   VARCHAR(255) NOT NULL,
+    assigned_at     BIGINT       NOT NULL,
+    PRIMARY KEY (license_id),
+    FOREIGN KEY (license_id) REFERENCES licenses (id)
+);
+
+CREATE INDEX license_assignments_by_licensee ON license_assignments (licensee_id);
+
+-- previous assignments, append-only, in the order they were replaced
+CREATE TABLE license_assignment_history (
+    license_id      VARCHAR(64)  NOT NULL,
+    position        INT          NOT NULL,
+    licensee_id     VARCHAR(255) NOT NULL,
+    licensee_type   INT          NOT NULL,
+    instance_id     VARCHAR(255) NOT NULL,
+    user_id         VARCHAR(255) NOT NULL,
+    organization_id VARCHAR(255) NOT NULL,
+    email_address   VARCHAR(255) NOT NULL,
+    assigned_at     BIGINT       NOT NULL,
+    unassigned_at   BIGINT       NULL,
+    PRIMARY KEY (license_id, position),
+    FOREIGN KEY (license_id) REFERENCES licenses (id)
+);
@@ -0,0 +1,3 @@
+-- Listing the licenses of an account in the order they were issued, see LicenseRepoSql.ListLicenses
+
+CREATE INDEX licenses_by_account_issued ON licenses (account_id, issued_at, id);
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// Package and capability repository reading a declarative catalog file (YAML or JSON),
+// so that catalog changes are reviewed as data rather than Go code.
+//
+// The repository is read-only; the catalog is changed by editing the file.
+// When watching, the file is reloaded on change; a file that fails validation is reported
+// and ignored, so the repository keeps serving the last valid catalog.
+type PackageRepoFile struct {
+	path string
+
+	mu      sync.RWMutex
+	content *catalogContent
+	modTime time.Time
+	size    int64
+
+	stopWatch chan struct{}
+	watchDone chan struct{}
+}
+
+func NewPackageRepoFile(path string) (*PackageRepoFile, error) {
+	r := &PackageRepoFile{path: path}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Re-reads the catalog file. On failure, the previously loaded catalog is kept.
+func (r *PackageRepoFile) Reload() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	content, err := parseCatalogFile(r.path, data)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.content = content
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+	return nil
+}
+
+// Polls the catalog file at the given interval and reloads it on change.
+// The onReload callback, if not nil, is invoked after every reload attempt with its outcome.
+func (r *PackageRepoFile) Watch(interval time.Duration, onReload func(err error)) {
+	r.StopWatching()
+	stop, done := make(chan struct{}), make(chan struct{})
+	r.mu.Lock()
+	r.stopWatch, r.watchDone = stop, done
+	r.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if !r.hasChanged() {
+					continue
+				}
+				err := r.Reload()
+				if err != nil {
+					// remember the broken revision, so it is reported once rather than on every tick
+					r.rememberRevision()
+				}
+				if onReload != nil {
+					onReload(err)
+				}
+			}
+		}
+	}()
+}
+
+// Stops watching the catalog file, if watching
+func (r *PackageRepoFile) StopWatching() {
+	r.mu.Lock()
+	stop, done := r.stopWatch, r.watchDone
+	r.stopWatch, r.watchDone = nil, nil
+	r.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (r *PackageRepoFile) hasChanged() bool {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !info.ModTime().Equal(r.modTime) || info.Size() != r.size
+}
+
+func (r *PackageRepoFile) rememberRevision() {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+}
+
+func (r *PackageRepoFile) current() *catalogContent {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.content
+}
+
+func (r *PackageRepoFile) GetPackageById(ctx context.Context, pkgId string) (*licensing.Package, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for _, pkg := range r.current().packages {
+		if pkg.Id == pkgId {
+			return pkg, nil
+		}
+	}
+	return nil, fmt.Errorf("package not found for pkgId=%s", pkgId)
+}
+
+func (r *PackageRepoFile) GetPackagingPlanById(ctx context.Context, planId string) (*licensing.PackagingPlan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for _, plan := range r.current().plans {
+		if plan.Id == planId {
+			return plan, nil
+		}
+	}
+	return nil, fmt.Errorf("packaging plan not found for planId=%s", planId)
+}
+
+func (r *PackageRepoFile) ListPackages(ctx context.Context) ([]*licensing.Package, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	results := append([]*licensing.Package{}, r.current().packages...)
+	sort.Slice(results, func(i, j int) bool { return results[i].Id < results[j].Id })
+	return results, nil
+}
+
+func (r *PackageRepoFile) GetCapabilityCatalog(ctx context.Context) (*licensing.CapabilityCatalog, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.current().catalog, nil
+}
+
+func (r *PackageRepoFile) CreatePackage(ctx context.Context, pkg *licensing.Package) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.errReadOnly()
+}
+
+func (r *PackageRepoFile) UpdatePackage(ctx context.Context, pkg *licensing.Package) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.errReadOnly()
+}
+
+func (r *PackageRepoFile) GetCapabilityById(ctx context.Context, cpbId string) (licensing.Capability, error) {
+	if err := ctx.Err(); err != nil {
+		return licensing.Capability{}, err
+	}
+	if cpb, ok := r.current().catalog.GetCapability(cpbId); ok {
+		return cpb, nil
+	}
+	return licensing.Capability{}, fmt.Errorf("capability not found for cpbId=%s", cpbId)
+}
+
+func (r *PackageRepoFile) ListCapabilities(ctx context.Context) ([]licensing.Capability, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.current().catalog.Capabilities(), nil
+}
+
+func (r *PackageRepoFile) CreateCapability(ctx context.Context, cpb licensing.Capability) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.errReadOnly()
+}
+
+func (r *PackageRepoFile) UpdateCapability(ctx context.Context, cpb licensing.Capability) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.errReadOnly()
+}
+
+func (r *PackageRepoFile) errReadOnly() error {
+	return fmt.Errorf("catalog file %s is read-only, edit the file instead", r.path)
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestPackageRepoFile(t *testing.T) {
+
+	ctx := context.Background()
+	t.Run("2022 catalog fixture loads", func(t *testing.T) {
+		r, err := NewPackageRepoFile("catalog/catalog-2022.yaml")
+		assert.NilError(t, err)
+		pkgs, err := r.ListPackages(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, len(pkgs), 3)
+
+		optimize, err := r.GetPackageById(ctx, "pkg:base-optimize-2022")
+		assert.NilError(t, err)
+		crmSync, ok := optimize.GetIncludedCapability("cpb:crm-sync")
+		assert.Equal(t, ok, true)
+		assert.Equal(t, crmSync.CapacityLimit, 250000)
+		assert.Equal(t, crmSync.CapacityLimitUnit, "CallsPerDay")
+
+		plan, err := r.GetPackagingPlanById(ctx, "pkgplan:v1.0")
+		assert.NilError(t, err)
+		assert.Equal(t, len(plan.SupportedPackages), 3)
+	})
+
+	t.Run("json catalog loads", func(t *testing.T) {
+		path := writeCatalogFile(t, "catalog.json", `{
+  "formatVersion": 1,
+  "capabilities": [{"id": "cpb:sequence", "displayName": "Sequence"}],
+  "packages": [{"id": "pkg:seq", "name": "Sequence Only", "capabilities": [{"id": "cpb:sequence"}]}]
+}`)
+		r, err := NewPackageRepoFile(path)
+		assert.NilError(t, err)
+		_, err = r.GetPackageById(ctx, "pkg:seq")
+		assert.NilError(t, err)
+	})
+
+	t.Run("invalid catalog reports file, line and field", func(t *testing.T) {
+		path := writeCatalogFile(t, "catalog.yaml", `formatVersion: 1
+capabilities:
+  - id: cpb:sequence
+    displayName: Sequence
+    colour: blue
+  - id: cpb:crm-sync
+    displayName: CRM Sync
+    capacityLimit: lots
+packages:
+  - id: pkg:seq
+    name: Sequence Only
+    capabilities:
+      - id: cpb:unknown
+`)
+		_, err := NewPackageRepoFile(path)
+		assert.Error(t, err, path+":5: capabilities[0].colour: unknown field, expected one of: "+
+			"id, displayName, capacityLimit, capacityLimitUnit, capacityMergeRule, capacityScope, implies, requires, conflictsWith, archived\n"+
+			path+":8: capabilities[1].capacityLimit: expected an integer, got \"lots\"\n"+
+			path+":13: packages[0].capabilities[0].id: unknown capability id \"cpb:unknown\"")
+	})
+
+	t.Run("implication cycle is rejected", func(t *testing.T) {
+		path := writeCatalogFile(t, "catalog.yaml", `formatVersion: 1
+capabilities:
+  - {id: cpb:a, displayName: A, implies: [cpb:b]}
+  - {id: cpb:b, displayName: B, implies: [cpb:a]}
+packages: []
+`)
+		_, err := NewPackageRepoFile(path)
+		assert.Error(t, err, path+":2: capabilities: capability implies relationships form a cycle: cpb:a -> cpb:b -> cpb:a")
+	})
+
+	t.Run("changed file is hot-reloaded, broken revision is ignored", func(t *testing.T) {
+		path := writeCatalogFile(t, "catalog.yaml", `formatVersion: 1
+capabilities: [{id: cpb:sequence, displayName: Sequence}]
+packages: [{id: pkg:v1, name: V1, capabilities: [{id: cpb:sequence}]}]
+`)
+		r, err := NewPackageRepoFile(path)
+		assert.NilError(t, err)
+		reloads := make(chan error, 10)
+		r.Watch(10*time.Millisecond, func(err error) { reloads <- err })
+		defer r.StopWatching()
+
+		rewriteCatalogFile(t, path, `formatVersion: 1
+capabilities: [{id: cpb:sequence, displayName: Sequence}]
+packages: [{id: pkg:v2, name: V2, capabilities: [{id: cpb:sequence}]}]
+`)
+		assert.NilError(t, <-reloads)
+		_, err = r.GetPackageById(ctx, "pkg:v2")
+		assert.NilError(t, err)
+
+		rewriteCatalogFile(t, path, `formatVersion: 2`)
+		assert.ErrorContains(t, <-reloads, "unsupported format version 2")
+		_, err = r.GetPackageById(ctx, "pkg:v2")
+		assert.NilError(t, err)
+	})
+}
+
+func writeCatalogFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NilError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+// Rewrites the file with a distinct modification time, so that coarse file system clocks don't hide the change
+func rewriteCatalogFile(t *testing.T, path string, content string) {
+	info, err := os.Stat(path)
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(path, []byte(content), 0o644))
+	modTime := info.ModTime().Add(time.Second)
+	assert.NilError(t, os.Chtimes(path, modTime, modTime))
+}
@@ -1,90 +1,103 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"sort"
 
 	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
 )
 
 type PackageRepoInMem struct {
 	storage map[string]*licensing.Package
+
+	plans map[string]*licensing.PackagingPlan
+
+	// capabilities the packages are composed of
+	cpbRepo licensing.CapabilityRepository
 }
 
 func NewPackageRepoInMem() *PackageRepoInMem {
+	return NewPackageRepoInMemWithCapabilityRepo(NewCapabilityRepoInMem())
+}
+
+func NewPackageRepoInMemWithCapabilityRepo(cpbRepo licensing.CapabilityRepository) *PackageRepoInMem {
 	r := PackageRepoInMem{}
 	r.storage = make(map[string]*licensing.Package)
+	r.plans = make(map[string]*licensing.PackagingPlan)
+	r.cpbRepo = cpbRepo
 
-	pkg1 := newAccelerateVersion2022Package()
-	pkg2 := newOptimizeVersion2022Package()
-	pkg3 := newOchestrateVersion2022Package()
-	r.storage[pkg1.Id] = pkg1
-	r.storage[pkg2.Id] = pkg2
-	r.storage[pkg3.Id] = pkg3
+	catalog2022 := loadCatalog2022()
+	for _, pkg := range catalog2022.packages {
+		r.storage[pkg.Id] = pkg
+	}
+	for _, plan := range catalog2022.plans {
+		r.plans[plan.Id] = plan
+	}
 	return &r
 }
 
-func (r *PackageRepoInMem) GetPackageById(pkgId string) (*licensing.Package, error) {
+func (r *PackageRepoInMem) GetPackageById(ctx context.Context, pkgId string) (*licensing.Package, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if result, ok := r.storage[pkgId]; ok {
 		return result, nil
 	}
 	return nil, fmt.Errorf("package not found for pkgId=%s", pkgId)
 }
 
-var sequenceCpb = licensing.Capability{Id: "cpb:sequence", DisplayName: "Squence", HasCapacityLimit: false, CapacityLimit: 0, CapacityLimitUnit: "NotApplicable"}
-var calenderingCpb = licensing.Capability{Id: "cpb:calendaring", DisplayName: "Calendering", HasCapacityLimit: false, CapacityLimit: 0, CapacityLimitUnit: "NotApplicable"}
-var basicReportingCpb = licensing.Capability{Id: "cpb:basic-reporting", DisplayName: "Basic Reporting", HasCapacityLimit: false, CapacityLimit: 0, CapacityLimitUnit: "NotApplicable"}
-var basicOppViewCpb = licensing.Capability{Id: "cpb:basic-opportunity-view", DisplayName: "Basic Opportunity View", HasCapacityLimit: false, CapacityLimit: 0, CapacityLimitUnit: "NotApplicable"}
-
-var sentimentCpb = licensing.Capability{Id: "cpb:sentiment", DisplayName: "ML Driven Sentiment", HasCapacityLimit: false, CapacityLimit: 0, CapacityLimitUnit: "NotApplicable"}
-var advancedReportingCpb = licensing.Capability{Id: "cpb:advanced-reporting", DisplayName: "Advanced Reporting", HasCapacityLimit: false, CapacityLimit: 0, CapacityLimitUnit: "NotApplicable"}
+func (r *PackageRepoInMem) GetPackagingPlanById(ctx context.Context, planId string) (*licensing.PackagingPlan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if result, ok := r.plans[planId]; ok {
+		return result, nil
+	}
+	return nil, fmt.Errorf("packaging plan not found for planId=%s", planId)
+}
 
-var successPlanCpb = licensing.Capability{Id: "cpb:success-plan", DisplayName: "Success Plan", HasCapacityLimit: false, CapacityLimit: 0, CapacityLimitUnit: "NotApplicable"}
-var kaiaMeetingPlanCpb = licensing.Capability{Id: "cpb:kaia-meeting", DisplayName: "Kaia Meeting Assistant", HasCapacityLimit: false, CapacityLimit: 0, CapacityLimitUnit: "NotApplicable"}
+func (r *PackageRepoInMem) CreatePackage(ctx context.Context, pkg *licensing.Package) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, ok := r.storage[pkg.Id]; ok {
+		return fmt.Errorf("package already exists for pkgId=%s", pkg.Id)
+	}
+	r.storage[pkg.Id] = pkg
+	return nil
+}
 
-func newAccelerateVersion2022Package() *licensing.Package {
-	crmCpb := licensing.Capability{Id: "cpb:crm-sync", DisplayName: "CRM Sync", HasCapacityLimit: true, CapacityLimit: 10000, CapacityLimitUnit: "CallsPerDay"}
-	includedCapabilities := []licensing.Capability{
-		sequenceCpb,
-		calenderingCpb,
-		basicOppViewCpb,
-		crmCpb,
+func (r *PackageRepoInMem) UpdatePackage(ctx context.Context, pkg *licensing.Package) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, ok := r.storage[pkg.Id]; !ok {
+		return fmt.Errorf("package not found for pkgId=%s", pkg.Id)
 	}
-	return &licensing.Package{
-		Id:                   "pkg:base-accelerate-2022",
-		Name:                 "Accelerate",
-		IncludedCapabilities: includedCapabilities}
+	r.storage[pkg.Id] = pkg
+	return nil
 }
 
-func newOptimizeVersion2022Package() *licensing.Package {
-	crmCpb := licensing.Capability{Id: "cpb:crm-sync", DisplayName: "CRM Sync", HasCapacityLimit: true, CapacityLimit: 250000, CapacityLimitUnit: "CallsPerDay"}
-	includedCapabilities := []licensing.Capability{
-		sequenceCpb,
-		calenderingCpb,
-		basicOppViewCpb,
-		sentimentCpb,
-		advancedReportingCpb,
-		crmCpb,
+func (r *PackageRepoInMem) ListPackages(ctx context.Context) ([]*licensing.Package, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	return &licensing.Package{
-		Id:                   "pkg:base-optimize-2022",
-		Name:                 "Optimize",
-		IncludedCapabilities: includedCapabilities}
+	results := make([]*licensing.Package, 0, len(r.storage))
+	for _, pkg := range r.storage {
+		results = append(results, pkg)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Id < results[j].Id })
+	return results, nil
 }
 
-func newOchestrateVersion2022Package() *licensing.Package {
-	crmCpb := licensing.Capability{Id: "cpb:crm-sync", DisplayName: "CRM Sync", HasCapacityLimit: true, CapacityLimit: 1000000, CapacityLimitUnit: "CallsPerDay"}
-	includedCapabilities := []licensing.Capability{
-		sequenceCpb,
-		calenderingCpb,
-		basicOppViewCpb,
-		sentimentCpb,
-		advancedReportingCpb,
-		successPlanCpb,
-		kaiaMeetingPlanCpb,
-		crmCpb,
+func (r *PackageRepoInMem) GetCapabilityCatalog(ctx context.Context) (*licensing.CapabilityCatalog, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	capabilities, err := r.cpbRepo.ListCapabilities(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return &licensing.Package{
-		Id:                   "pkg:base-ochestrate-2022",
-		Name:                 "Ochestrate",
-		IncludedCapabilities: includedCapabilities}
+	return licensing.NewCapabilityCatalog(capabilities)
 }
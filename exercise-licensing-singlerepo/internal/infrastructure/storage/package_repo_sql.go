@@ -0,0 +1,417 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// Package and capability repository on a SQL database, see MigrateSqlSchema for its schema.
+//
+// A package stores the ids of its capabilities with the capacity limits the package defines for them;
+// the other attributes of the capabilities are read from the capabilities table.
+type PackageRepoSql struct {
+	db *sql.DB
+}
+
+// Creates the repository on a database whose schema is up to date, see MigrateSqlSchema
+func NewPackageRepoSql(db *sql.DB) *PackageRepoSql {
+	return &PackageRepoSql{db: db}
+}
+
+// Imports the capabilities, packages and packaging plans of a catalog file (see PackageRepoFile),
+// replacing the stored definitions of the same ids
+func (r *PackageRepoSql) ImportCatalogFile(ctx context.Context, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	content, err := parseCatalogFile(path, data)
+	if err != nil {
+		return err
+	}
+	return r.importCatalog(ctx, content)
+}
+
+func (r *PackageRepoSql) importCatalog(ctx context.Context, content *catalogContent) error {
+	return inSqlTx(ctx, r.db, func(tx *sql.Tx) error {
+		for _, cpb := range content.capabilities {
+			if err := saveSqlCapability(ctx, tx, cpb); err != nil {
+				return err
+			}
+		}
+		for _, pkg := range content.packages {
+			if err := saveSqlPackage(ctx, tx, pkg); err != nil {
+				return err
+			}
+		}
+		for _, plan := range content.plans {
+			if err := replaceSqlPackagingPlan(ctx, tx, plan); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *PackageRepoSql) GetPackageById(ctx context.Context, pkgId string) (*licensing.Package, error) {
+	capabilities, err := loadSqlCapabilities(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := loadSqlPackage(ctx, r.db, pkgId, capabilities)
+	if err != nil {
+		return nil, err
+	}
+	if pkg == nil {
+		return nil, fmt.Errorf("package not found for pkgId=%s", pkgId)
+	}
+	return pkg, nil
+}
+
+func (r *PackageRepoSql) GetPackagingPlanById(ctx context.Context, planId string) (*licensing.PackagingPlan, error) {
+	plan := &licensing.PackagingPlan{Id: planId}
+	var createdAt int64
+	err := r.db.QueryRowContext(ctx, `SELECT major_version, revision, created_at FROM packaging_plans WHERE id = ?`, planId).
+		Scan(&plan.MajorVersion, &plan.Revision, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("packaging plan not found for planId=%s", planId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	plan.CreatedAt = time.Unix(0, createdAt).UTC()
+
+	pkgIds, err := queryStrings(ctx, r.db, `SELECT package_id FROM packaging_plan_packages WHERE plan_id = ? ORDER BY position`, planId)
+	if err != nil {
+		return nil, err
+	}
+	capabilities, err := loadSqlCapabilities(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkgId := range pkgIds {
+		pkg, err := loadSqlPackage(ctx, r.db, pkgId, capabilities)
+		if err != nil {
+			return nil, err
+		}
+		plan.SupportedPackages = append(plan.SupportedPackages, pkg)
+	}
+	return plan, nil
+}
+
+func (r *PackageRepoSql) CreatePackage(ctx context.Context, pkg *licensing.Package) error {
+	return inSqlTx(ctx, r.db, func(tx *sql.Tx) error {
+		exists, err := sqlRowExists(ctx, tx, `SELECT 1 FROM packages WHERE id = ?`, pkg.Id)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("package already exists for pkgId=%s", pkg.Id)
+		}
+		return insertSqlPackage(ctx, tx, pkg)
+	})
+}
+
+func (r *PackageRepoSql) UpdatePackage(ctx context.Context, pkg *licensing.Package) error {
+	return inSqlTx(ctx, r.db, func(tx *sql.Tx) error {
+		exists, err := sqlRowExists(ctx, tx, `SELECT 1 FROM packages WHERE id = ?`, pkg.Id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("package not found for pkgId=%s", pkg.Id)
+		}
+		return saveSqlPackage(ctx, tx, pkg)
+	})
+}
+
+func (r *PackageRepoSql) ListPackages(ctx context.Context) ([]*licensing.Package, error) {
+	pkgIds, err := queryStrings(ctx, r.db, `SELECT id FROM packages ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	capabilities, err := loadSqlCapabilities(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*licensing.Package, 0, len(pkgIds))
+	for _, pkgId := range pkgIds {
+		pkg, err := loadSqlPackage(ctx, r.db, pkgId, capabilities)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, pkg)
+	}
+	return results, nil
+}
+
+func (r *PackageRepoSql) GetCapabilityCatalog(ctx context.Context) (*licensing.CapabilityCatalog, error) {
+	capabilities, err := r.ListCapabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return licensing.NewCapabilityCatalog(capabilities)
+}
+
+func (r *PackageRepoSql) CreateCapability(ctx context.Context, cpb licensing.Capability) error {
+	return inSqlTx(ctx, r.db, func(tx *sql.Tx) error {
+		exists, err := sqlRowExists(ctx, tx, `SELECT 1 FROM capabilities WHERE id = ?`, cpb.Id)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("capability already exists for cpbId=%s", cpb.Id)
+		}
+		return insertSqlCapability(ctx, tx, cpb)
+	})
+}
+
+func (r *PackageRepoSql) UpdateCapability(ctx context.Context, cpb licensing.Capability) error {
+	return inSqlTx(ctx, r.db, func(tx *sql.Tx) error {
+		exists, err := sqlRowExists(ctx, tx, `SELECT 1 FROM capabilities WHERE id = ?`, cpb.Id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("capability not found for cpbId=%s", cpb.Id)
+		}
+		return saveSqlCapability(ctx, tx, cpb)
+	})
+}
+
+func (r *PackageRepoSql) GetCapabilityById(ctx context.Context, cpbId string) (licensing.Capability, error) {
+	capabilities, err := loadSqlCapabilities(ctx, r.db)
+	if err != nil {
+		return licensing.Capability{}, err
+	}
+	if result, ok := capabilities[cpbId]; ok {
+		return result, nil
+	}
+	return licensing.Capability{}, fmt.Errorf("capability not found for cpbId=%s", cpbId)
+}
+
+func (r *PackageRepoSql) ListCapabilities(ctx context.Context) ([]licensing.Capability, error) {
+	capabilities, err := loadSqlCapabilities(ctx, r.db)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]licensing.Capability, 0, len(capabilities))
+	for _, cpb := range capabilities {
+		results = append(results, cpb)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Id < results[j].Id })
+	return results, nil
+}
+
+// Relations between capabilities, as stored in capability_relations
+const (
+	sqlRelationImplies       = "IMPLIES"
+	sqlRelationRequires      = "REQUIRES"
+	sqlRelationConflictsWith = "CONFLICTS_WITH"
+)
+
+// Loads all capabilities by id
+func loadSqlCapabilities(ctx context.Context, q sqlQuerier) (map[string]licensing.Capability, error) {
+	rows, err := q.QueryContext(ctx, `SELECT id, display_name, has_capacity_limit, capacity_limit, capacity_limit_unit,
+capacity_merge_rule, capacity_scope, is_archived FROM capabilities`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make(map[string]licensing.Capability)
+	for rows.Next() {
+		var cpb licensing.Capability
+		var mergeRule, scope int
+		err := rows.Scan(&cpb.Id, &cpb.DisplayName, &cpb.HasCapacityLimit, &cpb.CapacityLimit, &cpb.CapacityLimitUnit,
+			&mergeRule, &scope, &cpb.IsArchived)
+		if err != nil {
+			return nil, err
+		}
+		cpb.CapacityMergeRule = licensing.CapacityMergeRule(mergeRule)
+		cpb.CapacityScope = licensing.CapacityScope(scope)
+		results[cpb.Id] = cpb
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	relRows, err := q.QueryContext(ctx, `SELECT capability_id, relation, related_capability_id FROM capability_relations
+ORDER BY capability_id, relation, position`)
+	if err != nil {
+		return nil, err
+	}
+	defer relRows.Close()
+	for relRows.Next() {
+		var cpbId, relation, relatedId string
+		if err := relRows.Scan(&cpbId, &relation, &relatedId); err != nil {
+			return nil, err
+		}
+		cpb := results[cpbId]
+		switch relation {
+		case sqlRelationImplies:
+			cpb.ImpliedCapabilityIds = append(cpb.ImpliedCapabilityIds, relatedId)
+		case sqlRelationRequires:
+			cpb.RequiredCapabilityIds = append(cpb.RequiredCapabilityIds, relatedId)
+		case sqlRelationConflictsWith:
+			cpb.ConflictingCapabilityIds = append(cpb.ConflictingCapabilityIds, relatedId)
+		default:
+			return nil, fmt.Errorf("unknown relation %q of capability cpbId=%s", relation, cpbId)
+		}
+		results[cpbId] = cpb
+	}
+	return results, relRows.Err()
+}
+
+// Loads the package of the given id, composed of the given capabilities; nil if not found
+func loadSqlPackage(ctx context.Context, q sqlQuerier, pkgId string, capabilities map[string]licensing.Capability) (*licensing.Package, error) {
+	pkg := &licensing.Package{Id: pkgId}
+	err := q.QueryRowContext(ctx, `SELECT name, is_archived FROM packages WHERE id = ?`, pkgId).Scan(&pkg.Name, &pkg.IsArchived)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.QueryContext(ctx, `SELECT capability_id, has_capacity_limit, capacity_limit, capacity_limit_unit
+FROM package_capabilities WHERE package_id = ? ORDER BY position`, pkgId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cpbId string
+		var hasLimit bool
+		var limit int
+		var unit string
+		if err := rows.Scan(&cpbId, &hasLimit, &limit, &unit); err != nil {
+			return nil, err
+		}
+		cpb, ok := capabilities[cpbId]
+		if !ok {
+			return nil, fmt.Errorf("capability not found for cpbId=%s of package pkgId=%s", cpbId, pkgId)
+		}
+		cpb.HasCapacityLimit, cpb.CapacityLimit, cpb.CapacityLimitUnit = hasLimit, limit, unit
+		pkg.IncludedCapabilities = append(pkg.IncludedCapabilities, cpb)
+	}
+	return pkg, rows.Err()
+}
+
+func insertSqlCapability(ctx context.Context, tx *sql.Tx, cpb licensing.Capability) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO capabilities (id, display_name, has_capacity_limit, capacity_limit,
+capacity_limit_unit, capacity_merge_rule, capacity_scope, is_archived) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		cpb.Id, cpb.DisplayName, cpb.HasCapacityLimit, cpb.CapacityLimit, cpb.CapacityLimitUnit,
+		int(cpb.CapacityMergeRule), int(cpb.CapacityScope), cpb.IsArchived)
+	if err != nil {
+		return err
+	}
+	return insertSqlCapabilityRelations(ctx, tx, cpb)
+}
+
+// Inserts the capability, or updates it in place if it exists, as packages may reference it
+func saveSqlCapability(ctx context.Context, tx *sql.Tx, cpb licensing.Capability) error {
+	exists, err := sqlRowExists(ctx, tx, `SELECT 1 FROM capabilities WHERE id = ?`, cpb.Id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return insertSqlCapability(ctx, tx, cpb)
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE capabilities SET display_name = ?, has_capacity_limit = ?, capacity_limit = ?,
+capacity_limit_unit = ?, capacity_merge_rule = ?, capacity_scope = ?, is_archived = ? WHERE id = ?`,
+		cpb.DisplayName, cpb.HasCapacityLimit, cpb.CapacityLimit, cpb.CapacityLimitUnit,
+		int(cpb.CapacityMergeRule), int(cpb.CapacityScope), cpb.IsArchived, cpb.Id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM capability_relations WHERE capability_id = ?`, cpb.Id); err != nil {
+		return err
+	}
+	return insertSqlCapabilityRelations(ctx, tx, cpb)
+}
+
+func insertSqlCapabilityRelations(ctx context.Context, tx *sql.Tx, cpb licensing.Capability) error {
+	relations := []struct {
+		relation   string
+		relatedIds []string
+	}{
+		{sqlRelationImplies, cpb.ImpliedCapabilityIds},
+		{sqlRelationRequires, cpb.RequiredCapabilityIds},
+		{sqlRelationConflictsWith, cpb.ConflictingCapabilityIds},
+	}
+	for _, rel := range relations {
+		for i, relatedId := range rel.relatedIds {
+			_, err := tx.ExecContext(ctx, `INSERT INTO capability_relations (capability_id, relation, position, related_capability_id)
+VALUES (?, ?, ?, ?)`, cpb.Id, rel.relation, i, relatedId)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func insertSqlPackage(ctx context.Context, tx *sql.Tx, pkg *licensing.Package) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO packages (id, name, is_archived) VALUES (?, ?, ?)`, pkg.Id, pkg.Name, pkg.IsArchived)
+	if err != nil {
+		return err
+	}
+	return insertSqlPackageCapabilities(ctx, tx, pkg)
+}
+
+func insertSqlPackageCapabilities(ctx context.Context, tx *sql.Tx, pkg *licensing.Package) error {
+	for i, cpb := range pkg.IncludedCapabilities {
+		_, err := tx.ExecContext(ctx, `INSERT INTO package_capabilities (package_id, position, capability_id,
+has_capacity_limit, capacity_limit, capacity_limit_unit) VALUES (?, ?, ?, ?, ?, ?)`,
+			pkg.Id, i, cpb.Id, cpb.HasCapacityLimit, cpb.CapacityLimit, cpb.CapacityLimitUnit)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Inserts the package, or updates it in place if it exists, as licenses may reference it
+func saveSqlPackage(ctx context.Context, tx *sql.Tx, pkg *licensing.Package) error {
+	exists, err := sqlRowExists(ctx, tx, `SELECT 1 FROM packages WHERE id = ?`, pkg.Id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return insertSqlPackage(ctx, tx, pkg)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE packages SET name = ?, is_archived = ? WHERE id = ?`, pkg.Name, pkg.IsArchived, pkg.Id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM package_capabilities WHERE package_id = ?`, pkg.Id); err != nil {
+		return err
+	}
+	return insertSqlPackageCapabilities(ctx, tx, pkg)
+}
+
+func replaceSqlPackagingPlan(ctx context.Context, tx *sql.Tx, plan *licensing.PackagingPlan) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM packaging_plan_packages WHERE plan_id = ?`, plan.Id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM packaging_plans WHERE id = ?`, plan.Id); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO packaging_plans (id, major_version, revision, created_at) VALUES (?, ?, ?, ?)`,
+		plan.Id, plan.MajorVersion, plan.Revision, plan.CreatedAt.UnixNano())
+	if err != nil {
+		return err
+	}
+	for i, pkg := range plan.SupportedPackages {
+		_, err := tx.ExecContext(ctx, `INSERT INTO packaging_plan_packages (plan_id, position, package_id) VALUES (?, ?, ?)`,
+			plan.Id, i, pkg.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
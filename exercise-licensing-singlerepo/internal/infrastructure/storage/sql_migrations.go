@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Versioned schema migrations of the SQL repositories, named <version>_<description>.sql.
+//
+// The statements are plain SQL that runs unchanged on SQLite and MySQL: every key column is a VARCHAR,
+// foreign keys are declared per table, and timestamps are stored as Unix nanoseconds in BIGINT columns.
+//
+//go:embed migrations/*.sql
+var sqlMigrationFiles embed.FS
+
+type sqlMigration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// Brings the schema of the database up to date, applying the migrations not applied yet in version order.
+// Applied versions are recorded in the schema_migrations table.
+//
+// Run it at deployment, from a single process. On MySQL, DDL statements commit implicitly, so a failed
+// migration may be left partially applied and must be repaired by hand.
+func MigrateSqlSchema(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INT          NOT NULL,
+    name       VARCHAR(255) NOT NULL,
+    applied_at BIGINT       NOT NULL,
+    PRIMARY KEY (version)
+)`)
+	if err != nil {
+		return fmt.Errorf("cannot create schema_migrations: %w", err)
+	}
+	applied, err := appliedSqlMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	migrations, err := loadSqlMigrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if err := applySqlMigration(ctx, db, m); err != nil {
+			return fmt.Errorf("migration %s failed: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+func appliedSqlMigrations(ctx context.Context, db *sql.DB) (map[int]bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		results[version] = true
+	}
+	return results, rows.Err()
+}
+
+func applySqlMigration(ctx context.Context, db *sql.DB, m sqlMigration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range m.statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.version, m.name, time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func loadSqlMigrations() ([]sqlMigration, error) {
+	entries, err := sqlMigrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	results := make([]sqlMigration, 0, len(entries))
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s is not named <version>_<description>.sql", name)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version %d", other, name, version)
+		}
+		seen[version] = name
+		data, err := sqlMigrationFiles.ReadFile("migrations/" + name)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, sqlMigration{version: version, name: name, statements: splitSqlStatements(string(data))})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].version < results[j].version })
+	return results, nil
+}
+
+// Splits a migration into its statements, one by one, as MySQL drivers do not run several statements per call
+// by default. Statements end with a semicolon at the end of a line; lines starting with -- are comments.
+func splitSqlStatements(script string) []string {
+	results := make([]string, 0)
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			results = append(results, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		results = append(results, rest)
+	}
+	return results
+}
@@ -0,0 +1,594 @@
+// Package storagetest implements conformance suites for implementations of the licensing repositories,
+// so that every implementation is held to the same contract and they can stand in for each other.
+//
+// Run a suite from a test of the implementation, passing a factory of empty repositories:
+//
+//	func TestLicenseRepoInMem(t *testing.T) {
+//		storagetest.TestLicenseRepository(t, func(t *testing.T) (licensing.LicenseRepository, licensing.PackageRepository) {
+//			return storage.NewLicenseRepoInMem(), storage.NewPackageRepoInMem()
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"gotest.tools/v3/assert"
+)
+
+// Creates an empty license repository for the test, and the package repository resolving the packages of its
+// licenses. The package repository holds the 2022 catalog, see TestPackageRepository.
+type LicenseRepositoryFactory func(t *testing.T) (licensing.LicenseRepository, licensing.PackageRepository)
+
+// Checks the contract of licensing.LicenseRepository, each subtest on a new repository
+func TestLicenseRepository(t *testing.T, newRepo LicenseRepositoryFactory) {
+
+	ctx := context.Background()
+	alice := licensing.NewInstanceUser("ins-101", "usr-alice")
+	bob := licensing.NewInstanceUser("ins-101", "usr-bob")
+	carol := licensing.NewOrganizationUser("org-1", "usr-carol", "carol@example.com")
+
+	// returns a new repository, and the packages licenses are issued for
+	setUp := func(t *testing.T) (licensing.LicenseRepository, *licensing.Package, *licensing.Package) {
+		r, pkgRepo := newRepo(t)
+		optimize, err := pkgRepo.GetPackageById(ctx, "pkg:base-optimize-2022")
+		assert.NilError(t, err)
+		accelerate, err := pkgRepo.GetPackageById(ctx, "pkg:base-accelerate-2022")
+		assert.NilError(t, err)
+		return r, optimize, accelerate
+	}
+
+	t.Run("created license is stored at version 1", func(t *testing.T) {
+		r, optimize, _ := setUp(t)
+		expiresAt := time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC)
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", optimize, licensing.AsTrial(), licensing.ExpiringAt(expiresAt))
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+		assert.Equal(t, lic.Version(), int64(1))
+		assert.Error(t, r.CreateLicense(ctx, lic), "license id="+lic.Id()+" already exists")
+
+		stored, err := r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		assert.Equal(t, stored.Version(), int64(1))
+		assert.DeepEqual(t, stored.State(), lic.State())
+	})
+
+	t.Run("missing licenses are reported", func(t *testing.T) {
+		r, optimize, _ := setUp(t)
+		_, err := r.GetLicenseById(ctx, "lic-unknown")
+		assert.Error(t, err, "license not found for id=lic-unknown")
+		assert.Check(t, errors.Is(err, licensing.ErrLicenseNotFound))
+
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", optimize)
+		lic.SetPersistedVersion(1)
+		assert.Error(t, r.UpdateLicense(ctx, lic.Id(), lic), "license not found for id="+lic.Id())
+
+		_, err = r.FindLicensesByAssignedLicenseeId(ctx, alice.LicenseeId())
+		assert.Error(t, err, "license not found: none assigned to licenseeId="+alice.LicenseeId())
+		assert.Check(t, errors.Is(err, licensing.ErrLicenseNotFound))
+
+		// finding and counting nothing is not an error
+		licenses, err := r.FindLicensesOfAccount(ctx, "acc-unknown")
+		assert.NilError(t, err)
+		assert.Equal(t, len(licenses), 0)
+		licenses, err = r.FindUnassignedLicensesOfPackage(ctx, "acc-unknown", optimize.Id)
+		assert.NilError(t, err)
+		assert.Equal(t, len(licenses), 0)
+		count, err := r.CountTotalUnassignedLicensesOfPackage(ctx, "acc-unknown", optimize.Id)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 0)
+	})
+
+	t.Run("updates compare and swap versions", func(t *testing.T) {
+		r, optimize, _ := setUp(t)
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", optimize)
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+
+		first, err := r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		second, err := r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+
+		first.Assign(alice)
+		assert.NilError(t, r.UpdateLicense(ctx, first.Id(), first))
+		assert.Equal(t, first.Version(), int64(2))
+
+		second.Assign(bob)
+		err = r.UpdateLicense(ctx, second.Id(), second)
+		assert.Check(t, errors.Is(err, licensing.ErrLicenseVersionConflict))
+		assert.Error(t, err, "license version conflict: license id="+lic.Id()+" is at version 2, update is based on version 1")
+
+		stored, err := r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		assert.Equal(t, stored.Version(), int64(2))
+		assert.Equal(t, stored.AssignedToLicensee().LicenseeId(), alice.LicenseeId())
+	})
+
+	t.Run("licenses are returned as copies", func(t *testing.T) {
+		r, optimize, _ := setUp(t)
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", optimize)
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+		lic.Assign(alice)
+		assert.NilError(t, r.UpdateLicense(ctx, lic.Id(), lic))
+
+		// changing a created, updated or loaded license does not change the stored one
+		lic.Unassign()
+		loaded, err := r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		loaded.Unassign()
+		found, err := r.FindLicensesOfAccount(ctx, "acc-1")
+		assert.NilError(t, err)
+		found[0].Unassign()
+
+		reloaded, err := r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		assert.Equal(t, reloaded.IsAssigned(), true)
+	})
+
+	t.Run("counts follow assignment and unassignment", func(t *testing.T) {
+		r, optimize, _ := setUp(t)
+		for i := 0; i < 3; i++ {
+			assert.NilError(t, r.CreateLicense(ctx, licensing.NewIssuedLicense("acc-1", "sub-1", optimize)))
+		}
+		checkUnassigned := func(t *testing.T, expected int) {
+			t.Helper()
+			count, err := r.CountTotalUnassignedLicensesOfPackage(ctx, "acc-1", optimize.Id)
+			assert.NilError(t, err)
+			assert.Equal(t, count, expected)
+			unassigned, err := r.FindUnassignedLicensesOfPackage(ctx, "acc-1", optimize.Id)
+			assert.NilError(t, err)
+			assert.Equal(t, len(unassigned), expected)
+			for _, lic := range unassigned {
+				assert.Equal(t, lic.IsAssigned(), false)
+			}
+		}
+		checkUnassigned(t, 3)
+
+		unassigned, err := r.FindUnassignedLicensesOfPackage(ctx, "acc-1", optimize.Id)
+		assert.NilError(t, err)
+		first, second := unassigned[0], unassigned[1]
+		first.Assign(alice)
+		assert.NilError(t, r.UpdateLicense(ctx, first.Id(), first))
+		second.Assign(bob)
+		assert.NilError(t, r.UpdateLicense(ctx, second.Id(), second))
+		checkUnassigned(t, 1)
+
+		// reassigning keeps the count
+		first.Assign(carol)
+		assert.NilError(t, r.UpdateLicense(ctx, first.Id(), first))
+		checkUnassigned(t, 1)
+		_, err = r.FindLicensesByAssignedLicenseeId(ctx, alice.LicenseeId())
+		assert.Error(t, err, "license not found: none assigned to licenseeId="+alice.LicenseeId())
+
+		second.Unassign()
+		assert.NilError(t, r.UpdateLicense(ctx, second.Id(), second))
+		checkUnassigned(t, 2)
+		_, err = r.FindLicensesByAssignedLicenseeId(ctx, bob.LicenseeId())
+		assert.Error(t, err, "license not found: none assigned to licenseeId="+bob.LicenseeId())
+
+		licenses, err := r.FindLicensesOfAccount(ctx, "acc-1")
+		assert.NilError(t, err)
+		assert.Equal(t, len(licenses), 3)
+	})
+
+	t.Run("expired is not unassigned", func(t *testing.T) {
+		r, optimize, _ := setUp(t)
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", optimize)
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+		lic.Expire()
+		assert.NilError(t, r.UpdateLicense(ctx, lic.Id(), lic))
+
+		count, err := r.CountTotalUnassignedLicensesOfPackage(ctx, "acc-1", optimize.Id)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 0)
+		unassigned, err := r.FindUnassignedLicensesOfPackage(ctx, "acc-1", optimize.Id)
+		assert.NilError(t, err)
+		assert.Equal(t, len(unassigned), 0)
+	})
+
+	t.Run("past term is not unassigned", func(t *testing.T) {
+		r, optimize, _ := setUp(t)
+		pastTerm := licensing.NewIssuedLicense("acc-1", "sub-1", optimize, licensing.ExpiringAt(time.Now().Add(-time.Hour)))
+		assert.NilError(t, r.CreateLicense(ctx, pastTerm))
+		inTerm := licensing.NewIssuedLicense("acc-1", "sub-1", optimize, licensing.ExpiringAt(time.Now().Add(time.Hour)))
+		assert.NilError(t, r.CreateLicense(ctx, inTerm))
+
+		count, err := r.CountTotalUnassignedLicensesOfPackage(ctx, "acc-1", optimize.Id)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 1)
+		unassigned, err := r.FindUnassignedLicensesOfPackage(ctx, "acc-1", optimize.Id)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, sortedIds(unassigned), []string{inTerm.Id()})
+	})
+
+	t.Run("renewed is not unassigned", func(t *testing.T) {
+		r, optimize, _ := setUp(t)
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", optimize)
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+		renewed := licensing.NewIssuedLicense("acc-1", "sub-1", optimize)
+		assert.NilError(t, r.CreateLicense(ctx, renewed))
+		lic.RenewTo(renewed.Id(), "annual renewal")
+		assert.NilError(t, r.UpdateLicense(ctx, lic.Id(), lic))
+
+		count, err := r.CountTotalUnassignedLicensesOfPackage(ctx, "acc-1", optimize.Id)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 1)
+		unassigned, err := r.FindUnassignedLicensesOfPackage(ctx, "acc-1", optimize.Id)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, sortedIds(unassigned), []string{renewed.Id()})
+	})
+
+	t.Run("licenses to assign are found in the order of the strategy", func(t *testing.T) {
+		r, optimize, accelerate := setUp(t)
+		now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+		issue := func(subId string, daysAgo int, opts ...licensing.LicenseIssuanceOption) *licensing.License {
+			lic := issuedAt(licensing.NewIssuedLicense("acc-1", subId, optimize, opts...), now.AddDate(0, 0, -daysAgo))
+			assert.NilError(t, r.CreateLicense(ctx, lic))
+			return lic
+		}
+		expiringLate := issue("sub-1", 3, licensing.ExpiringAt(now.AddDate(0, 0, 30)))
+		perpetual := issue("sub-1", 5)
+		trial := issue("sub-2", 1, licensing.AsTrial(), licensing.ExpiringAt(now.AddDate(0, 0, 7)))
+		expiringSoon := issue("sub-2", 4, licensing.ExpiringAt(now.AddDate(0, 0, 14)))
+		// none of these is to assign
+		issue("sub-1", 10, licensing.ExpiringAt(now.AddDate(0, 0, -1)))
+		assigned := issue("sub-2", 2)
+		assigned.Assign(alice)
+		assert.NilError(t, r.UpdateLicense(ctx, assigned.Id(), assigned))
+		expired := issue("sub-1", 6)
+		expired.Expire()
+		assert.NilError(t, r.UpdateLicense(ctx, expired.Id(), expired))
+		otherPackage := licensing.NewIssuedLicense("acc-1", "sub-1", accelerate)
+		assert.NilError(t, r.CreateLicense(ctx, otherPackage))
+
+		cases := []struct {
+			strategy licensing.SeatAllocationStrategy
+			limit    int
+			expected []*licensing.License
+		}{
+			{licensing.SoonestExpiringFirst(), 10, []*licensing.License{trial, expiringSoon, expiringLate, perpetual}},
+			{licensing.OldestIssuedFirst(), 10, []*licensing.License{perpetual, expiringSoon, expiringLate, trial}},
+			{licensing.PaidBeforeTrial(), 10, []*licensing.License{expiringSoon, expiringLate, perpetual, trial}},
+			{licensing.PaidBeforeTrial(), 2, []*licensing.License{expiringSoon, expiringLate}},
+			{licensing.PreferredSubscription("sub-2"), 3, []*licensing.License{trial, expiringSoon, expiringLate}},
+		}
+		for _, c := range cases {
+			licenses, err := r.FindLicensesToAssign(ctx, "acc-1", optimize.Id, c.strategy, now, c.limit)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, idsInOrder(licenses), idsInOrder(c.expected))
+		}
+
+		// at the end of its term, a license is no longer to assign
+		licenses, err := r.FindLicensesToAssign(ctx, "acc-1", optimize.Id, licensing.SoonestExpiringFirst(), now.AddDate(0, 0, 7), 10)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, idsInOrder(licenses), idsInOrder([]*licensing.License{expiringSoon, expiringLate, perpetual}))
+	})
+
+	t.Run("every license of a licensee is returned", func(t *testing.T) {
+		r, optimize, accelerate := setUp(t)
+		assigned := make([]string, 0)
+		for _, pkg := range []*licensing.Package{optimize, accelerate, accelerate} {
+			lic := licensing.NewIssuedLicense("acc-1", "sub-1", pkg)
+			lic.Assign(carol)
+			assert.NilError(t, r.CreateLicense(ctx, lic))
+			assigned = append(assigned, lic.Id())
+		}
+		other := licensing.NewIssuedLicense("acc-1", "sub-1", optimize)
+		other.Assign(alice)
+		assert.NilError(t, r.CreateLicense(ctx, other))
+
+		licenses, err := r.FindLicensesByAssignedLicenseeId(ctx, carol.LicenseeId())
+		assert.NilError(t, err)
+		assert.DeepEqual(t, sortedIds(licenses), sortedStrings(assigned))
+		for _, lic := range licenses {
+			assert.DeepEqual(t, lic.AssignedToLicensee(), carol)
+		}
+	})
+
+	t.Run("assignment history is kept", func(t *testing.T) {
+		r, optimize, _ := setUp(t)
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", optimize)
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+		for _, licensee := range []licensing.Licensee{alice, carol, bob} {
+			lic.Assign(licensee)
+			assert.NilError(t, r.UpdateLicense(ctx, lic.Id(), lic))
+		}
+
+		stored, err := r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		assert.DeepEqual(t, stored.State(), lic.State())
+		previous := stored.State().PreviousAssignments
+		assert.Equal(t, len(previous), 2)
+		assert.DeepEqual(t, previous[0].Assignee, licensing.Licensee(alice))
+		assert.DeepEqual(t, previous[1].Assignee, licensing.Licensee(carol))
+	})
+
+	t.Run("accounts are isolated", func(t *testing.T) {
+		r, optimize, accelerate := setUp(t)
+		for _, accId := range []string{"acc-1", "acc-2", "acc-2"} {
+			assert.NilError(t, r.CreateLicense(ctx, licensing.NewIssuedLicense(accId, "sub-"+accId, optimize)))
+		}
+		assert.NilError(t, r.CreateLicense(ctx, licensing.NewIssuedLicense("acc-2", "sub-acc-2", accelerate)))
+
+		licenses, err := r.FindLicensesOfAccount(ctx, "acc-1")
+		assert.NilError(t, err)
+		assert.Equal(t, len(licenses), 1)
+		assert.Equal(t, licenses[0].PossessingCustomerAccountId(), "acc-1")
+
+		unassigned, err := r.FindUnassignedLicensesOfPackage(ctx, "acc-1", optimize.Id)
+		assert.NilError(t, err)
+		assert.Equal(t, len(unassigned), 1)
+		assert.Equal(t, unassigned[0].Id(), licenses[0].Id())
+		unassigned, err = r.FindUnassignedLicensesOfPackage(ctx, "acc-1", accelerate.Id)
+		assert.NilError(t, err)
+		assert.Equal(t, len(unassigned), 0)
+
+		lic := licenses[0]
+		lic.Assign(alice)
+		assert.NilError(t, r.UpdateLicense(ctx, lic.Id(), lic))
+		count, err := r.CountTotalUnassignedLicensesOfPackage(ctx, "acc-2", optimize.Id)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 2)
+		licenses, err = r.FindLicensesOfAccount(ctx, "acc-2")
+		assert.NilError(t, err)
+		assert.Equal(t, len(licenses), 3)
+		for _, lic := range licenses {
+			assert.Equal(t, lic.PossessingCustomerAccountId(), "acc-2")
+			assert.Equal(t, lic.IsAssigned(), false)
+		}
+	})
+
+	t.Run("licenses are listed by query", func(t *testing.T) {
+		r, optimize, accelerate := setUp(t)
+		day := func(d int) time.Time { return time.Date(2022, 3, d, 0, 0, 0, 0, time.UTC) }
+		create := func(subId string, pkg *licensing.Package, issuedOn int, opts ...licensing.LicenseIssuanceOption) *licensing.License {
+			lic := issuedAt(licensing.NewIssuedLicense("acc-1", subId, pkg, opts...), day(issuedOn))
+			assert.NilError(t, r.CreateLicense(ctx, lic))
+			return lic
+		}
+		trial := create("sub-1", optimize, 1, licensing.AsTrial(), licensing.ExpiringAt(day(31)))
+		assigned := create("sub-1", optimize, 2)
+		assigned.Assign(alice)
+		assert.NilError(t, r.UpdateLicense(ctx, assigned.Id(), assigned))
+		expired := create("sub-1", accelerate, 3, licensing.ExpiringAt(day(15)))
+		expired.Expire()
+		assert.NilError(t, r.UpdateLicense(ctx, expired.Id(), expired))
+		renewed := create("sub-2", accelerate, 4)
+		assert.NilError(t, r.CreateLicense(ctx, licensing.NewIssuedLicense("acc-2", "sub-1", optimize)))
+
+		list := func(t *testing.T, query licensing.LicenseQuery) []string {
+			query.AccountId = "acc-1"
+			if query.At.IsZero() {
+				query.At = day(10)
+			}
+			page, err := r.ListLicenses(ctx, query)
+			assert.NilError(t, err)
+			assert.Equal(t, page.NextCursor, "")
+			return idsInOrder(page.Licenses)
+		}
+		yes, no := true, false
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{}), []string{trial.Id(), assigned.Id(), expired.Id(), renewed.Id()})
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{SubscriptionId: "sub-2"}), []string{renewed.Id()})
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{PackageId: optimize.Id}), []string{trial.Id(), assigned.Id()})
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{IsAssigned: &yes}), []string{assigned.Id()})
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{IsAssigned: &no, IsTrial: &no}), []string{expired.Id(), renewed.Id()})
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{IsTrial: &yes}), []string{trial.Id()})
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{Statuses: []licensing.LicenseStatus{licensing.EXPIRED_LICENSE}}), []string{expired.Id()})
+		// past its term, an active license is expired
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{Statuses: []licensing.LicenseStatus{licensing.EXPIRED_LICENSE}, At: day(31)}),
+			[]string{trial.Id(), expired.Id()})
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{Statuses: []licensing.LicenseStatus{licensing.ACTIVE_LICENSE}, At: day(31)}),
+			[]string{assigned.Id(), renewed.Id()})
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{LicenseeId: alice.LicenseeId()}), []string{assigned.Id()})
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{LicenseeId: bob.LicenseeId()}), []string{})
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{IssuedFrom: day(2), IssuedBefore: day(4)}), []string{assigned.Id(), expired.Id()})
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{Descending: true}), []string{renewed.Id(), expired.Id(), assigned.Id(), trial.Id()})
+		// licenses without end of term come first, ordered by id
+		byExpiry := list(t, licensing.LicenseQuery{SortBy: licensing.SORT_LICENSES_BY_EXPIRES_AT})
+		assert.DeepEqual(t, byExpiry[:2], sortedStrings([]string{assigned.Id(), renewed.Id()}))
+		assert.DeepEqual(t, byExpiry[2:], []string{expired.Id(), trial.Id()})
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{SortBy: licensing.SORT_LICENSES_BY_ID}),
+			sortedStrings([]string{trial.Id(), assigned.Id(), expired.Id(), renewed.Id()}))
+
+		renewed.RenewTo(trial.Id(), "Renewed under subId=sub-1")
+		assert.NilError(t, r.UpdateLicense(ctx, renewed.Id(), renewed))
+		assert.DeepEqual(t, list(t, licensing.LicenseQuery{Statuses: []licensing.LicenseStatus{licensing.ACTIVE_LICENSE}}),
+			[]string{trial.Id(), assigned.Id()})
+	})
+
+	t.Run("licenses are listed page by page", func(t *testing.T) {
+		r, optimize, _ := setUp(t)
+		created := make([]string, 0)
+		for i := 0; i < 7; i++ {
+			// licenses issued at the same time are ordered by id
+			lic := issuedAt(licensing.NewIssuedLicense("acc-1", "sub-1", optimize), time.Date(2022, 3, 1+i/2, 0, 0, 0, 0, time.UTC))
+			assert.NilError(t, r.CreateLicense(ctx, lic))
+			created = append(created, lic.Id())
+		}
+
+		for _, descending := range []bool{false, true} {
+			query := licensing.LicenseQuery{AccountId: "acc-1", Descending: descending, PageSize: 3}
+			listed := make([]string, 0)
+			pages := 0
+			for {
+				page, err := r.ListLicenses(ctx, query)
+				assert.NilError(t, err)
+				assert.Assert(t, len(page.Licenses) <= 3)
+				listed = append(listed, idsInOrder(page.Licenses)...)
+				pages++
+				if page.NextCursor == "" {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+			assert.Equal(t, pages, 3)
+			assert.Equal(t, len(listed), len(created))
+			assert.DeepEqual(t, sortedStrings(listed), sortedStrings(created))
+			for i := 1; i < len(listed); i++ {
+				previous, err := r.GetLicenseById(ctx, listed[i-1])
+				assert.NilError(t, err)
+				next, err := r.GetLicenseById(ctx, listed[i])
+				assert.NilError(t, err)
+				assert.Check(t, query.Precedes(previous, next), "%s listed before %s", listed[i-1], listed[i])
+			}
+		}
+
+		page, err := r.ListLicenses(ctx, licensing.LicenseQuery{AccountId: "acc-1", PageSize: 3})
+		assert.NilError(t, err)
+		_, err = r.ListLicenses(ctx, licensing.LicenseQuery{AccountId: "acc-1", PageSize: 3, Descending: true, Cursor: page.NextCursor})
+		assert.Check(t, errors.Is(err, licensing.ErrInvalidLicenseCursor), err)
+		_, err = r.ListLicenses(ctx, licensing.LicenseQuery{AccountId: "acc-1", Cursor: "not a cursor"})
+		assert.Check(t, errors.Is(err, licensing.ErrInvalidLicenseCursor), err)
+		_, err = r.ListLicenses(ctx, licensing.LicenseQuery{AccountId: "acc-1", PageSize: -1})
+		assert.Error(t, err, "invalid page size -1")
+	})
+
+	t.Run("canceled context is honored", func(t *testing.T) {
+		r, optimize, _ := setUp(t)
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		err := r.CreateLicense(canceled, licensing.NewIssuedLicense("acc-1", "sub-1", optimize))
+		assert.Check(t, errors.Is(err, context.Canceled), err)
+		_, err = r.FindLicensesOfAccount(canceled, "acc-1")
+		assert.Check(t, errors.Is(err, context.Canceled), err)
+	})
+}
+
+// Checks the contract of licensing.LicenseUnitOfWork, for license repositories that implement it;
+// newRepo is as for TestLicenseRepository
+func TestLicenseUnitOfWork(t *testing.T, newRepo LicenseRepositoryFactory) {
+
+	ctx := context.Background()
+	alice := licensing.NewInstanceUser("ins-101", "usr-alice")
+	errFailed := errors.New("failed")
+
+	// returns a new repository holding one unassigned license, and that license
+	setUp := func(t *testing.T) (licensing.LicenseRepository, licensing.LicenseUnitOfWork, *licensing.License) {
+		r, pkgRepo := newRepo(t)
+		uow, ok := r.(licensing.LicenseUnitOfWork)
+		assert.Assert(t, ok, "%T does not implement licensing.LicenseUnitOfWork", r)
+		optimize, err := pkgRepo.GetPackageById(ctx, "pkg:base-optimize-2022")
+		assert.NilError(t, err)
+		lic := licensing.NewIssuedLicense("acc-1", "sub-1", optimize)
+		assert.NilError(t, r.CreateLicense(ctx, lic))
+		return r, uow, lic
+	}
+
+	t.Run("changes are committed together", func(t *testing.T) {
+		r, uow, lic := setUp(t)
+		created := licensing.NewIssuedLicense("acc-1", "sub-1", lic.LicensedPackage())
+		err := uow.RunAtomically(ctx, func(ctx context.Context, licRepo licensing.LicenseRepository) error {
+			if err := licRepo.CreateLicense(ctx, created); err != nil {
+				return err
+			}
+			lic.Assign(alice)
+			return licRepo.UpdateLicense(ctx, lic.Id(), lic)
+		})
+		assert.NilError(t, err)
+
+		stored, err := r.GetLicenseById(ctx, created.Id())
+		assert.NilError(t, err)
+		assert.Equal(t, stored.Version(), int64(1))
+		stored, err = r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		assert.Equal(t, stored.Version(), int64(2))
+		assert.DeepEqual(t, stored.State(), lic.State())
+		count, err := r.CountTotalUnassignedLicensesOfPackage(ctx, "acc-1", lic.LicensedPackage().Id)
+		assert.NilError(t, err)
+		assert.Equal(t, count, 1)
+	})
+
+	t.Run("changes are discarded on error", func(t *testing.T) {
+		r, uow, lic := setUp(t)
+		created := licensing.NewIssuedLicense("acc-1", "sub-1", lic.LicensedPackage())
+		err := uow.RunAtomically(ctx, func(ctx context.Context, licRepo licensing.LicenseRepository) error {
+			if err := licRepo.CreateLicense(ctx, created); err != nil {
+				return err
+			}
+			assigned := lic.Clone()
+			assigned.Assign(alice)
+			if err := licRepo.UpdateLicense(ctx, assigned.Id(), assigned); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		assert.Assert(t, errors.Is(err, errFailed), err)
+
+		_, err = r.GetLicenseById(ctx, created.Id())
+		assert.Error(t, err, "license not found for id="+created.Id())
+		stored, err := r.GetLicenseById(ctx, lic.Id())
+		assert.NilError(t, err)
+		assert.DeepEqual(t, stored.State(), lic.State())
+		_, err = r.FindLicensesByAssignedLicenseeId(ctx, alice.LicenseeId())
+		assert.Error(t, err, "license not found: none assigned to licenseeId="+alice.LicenseeId())
+	})
+
+	t.Run("uncommitted changes are read back", func(t *testing.T) {
+		_, uow, lic := setUp(t)
+		created := licensing.NewIssuedLicense("acc-1", "sub-1", lic.LicensedPackage())
+		err := uow.RunAtomically(ctx, func(ctx context.Context, licRepo licensing.LicenseRepository) error {
+			assert.NilError(t, licRepo.CreateLicense(ctx, created))
+			assert.Error(t, licRepo.CreateLicense(ctx, created), "license id="+created.Id()+" already exists")
+			lic.Assign(alice)
+			assert.NilError(t, licRepo.UpdateLicense(ctx, lic.Id(), lic))
+
+			stored, err := licRepo.GetLicenseById(ctx, created.Id())
+			assert.NilError(t, err)
+			assert.DeepEqual(t, stored.State(), created.State())
+			assigned, err := licRepo.FindLicensesByAssignedLicenseeId(ctx, alice.LicenseeId())
+			assert.NilError(t, err)
+			assert.DeepEqual(t, sortedIds(assigned), []string{lic.Id()})
+			unassigned, err := licRepo.FindUnassignedLicensesOfPackage(ctx, "acc-1", lic.LicensedPackage().Id)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, sortedIds(unassigned), []string{created.Id()})
+			all, err := licRepo.FindLicensesOfAccount(ctx, "acc-1")
+			assert.NilError(t, err)
+			assert.DeepEqual(t, sortedIds(all), sortedStrings([]string{lic.Id(), created.Id()}))
+			count, err := licRepo.CountTotalUnassignedLicensesOfPackage(ctx, "acc-1", lic.LicensedPackage().Id)
+			assert.NilError(t, err)
+			assert.Equal(t, count, 1)
+			toAssign, err := licRepo.FindLicensesToAssign(ctx, "acc-1", lic.LicensedPackage().Id, licensing.OldestIssuedFirst(), time.Now(), 10)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, idsInOrder(toAssign), []string{created.Id()})
+			yes := true
+			page, err := licRepo.ListLicenses(ctx, licensing.LicenseQuery{AccountId: "acc-1", IsAssigned: &yes})
+			assert.NilError(t, err)
+			assert.DeepEqual(t, idsInOrder(page.Licenses), []string{lic.Id()})
+			return nil
+		})
+		assert.NilError(t, err)
+	})
+}
+
+// Returns the license as issued at the given time, for listing licenses in a known order
+func issuedAt(lic *licensing.License, at time.Time) *licensing.License {
+	state := lic.State()
+	state.IssuanceDetail.IssuedAt = at
+	return licensing.RestoreLicense(state)
+}
+
+func idsInOrder(licenses []*licensing.License) []string {
+	ids := make([]string, len(licenses))
+	for i, lic := range licenses {
+		ids[i] = lic.Id()
+	}
+	return ids
+}
+
+func sortedIds(licenses []*licensing.License) []string {
+	ids := make([]string, len(licenses))
+	for i, lic := range licenses {
+		ids[i] = lic.Id()
+	}
+	return sortedStrings(ids)
+}
+
+func sortedStrings(values []string) []string {
+	results := append([]string{}, values...)
+	sort.Strings(results)
+	return results
+}
@@ -0,0 +1,117 @@
+package storagetest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"gotest.tools/v3/assert"
+)
+
+// Creates a package repository for the test, holding the 2022 catalog (storage/catalog/catalog-2022.yaml)
+type PackageRepositoryFactory func(t *testing.T) licensing.PackageRepository
+
+// Checks the read contract of licensing.PackageRepository against the 2022 catalog, each subtest on a new repository.
+// See TestPackageRepositoryWrites for repositories that also store changes.
+func TestPackageRepository(t *testing.T, newRepo PackageRepositoryFactory) {
+
+	ctx := context.Background()
+
+	t.Run("packages are found by id", func(t *testing.T) {
+		r := newRepo(t)
+		optimize, err := r.GetPackageById(ctx, "pkg:base-optimize-2022")
+		assert.NilError(t, err)
+		assert.Equal(t, optimize.Name, "Optimize")
+		assert.Equal(t, optimize.IsArchived, false)
+		assert.DeepEqual(t, optimize.IncludedCapabilityIds(), []string{
+			"cpb:sequence", "cpb:calendaring", "cpb:basic-opportunity-view", "cpb:sentiment", "cpb:advanced-reporting", "cpb:crm-sync",
+		})
+		crmSync, ok := optimize.GetIncludedCapability("cpb:crm-sync")
+		assert.Equal(t, ok, true)
+		assert.Equal(t, crmSync.CapacityLimit, 250000)
+		assert.Equal(t, crmSync.CapacityLimitUnit, "CallsPerDay")
+	})
+
+	t.Run("packages are listed by id", func(t *testing.T) {
+		r := newRepo(t)
+		pkgs, err := r.ListPackages(ctx)
+		assert.NilError(t, err)
+		ids := make([]string, len(pkgs))
+		for i, pkg := range pkgs {
+			ids[i] = pkg.Id
+			found, err := r.GetPackageById(ctx, pkg.Id)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, found, pkg)
+		}
+		assert.DeepEqual(t, ids, []string{"pkg:base-accelerate-2022", "pkg:base-ochestrate-2022", "pkg:base-optimize-2022"})
+	})
+
+	t.Run("packaging plans are found by id", func(t *testing.T) {
+		r := newRepo(t)
+		plan, err := r.GetPackagingPlanById(ctx, "pkgplan:v1.0")
+		assert.NilError(t, err)
+		assert.Equal(t, plan.MajorVersion, 1)
+		assert.Equal(t, plan.Revision, 0)
+		ids := make([]string, len(plan.SupportedPackages))
+		for i, pkg := range plan.SupportedPackages {
+			ids[i] = pkg.Id
+		}
+		assert.DeepEqual(t, ids, []string{"pkg:base-accelerate-2022", "pkg:base-optimize-2022", "pkg:base-ochestrate-2022"})
+	})
+
+	t.Run("capability catalog covers every package", func(t *testing.T) {
+		r := newRepo(t)
+		catalog, err := r.GetCapabilityCatalog(ctx)
+		assert.NilError(t, err)
+		pkgs, err := r.ListPackages(ctx)
+		assert.NilError(t, err)
+		for _, pkg := range pkgs {
+			for _, cpbId := range pkg.IncludedCapabilityIds() {
+				_, ok := catalog.GetCapability(cpbId)
+				assert.Check(t, ok, "%s of %s is missing from the catalog", cpbId, pkg.Id)
+			}
+		}
+	})
+
+	t.Run("missing packages and plans are reported", func(t *testing.T) {
+		r := newRepo(t)
+		_, err := r.GetPackageById(ctx, "pkg:unknown")
+		assert.Error(t, err, "package not found for pkgId=pkg:unknown")
+		_, err = r.GetPackagingPlanById(ctx, "pkgplan:unknown")
+		assert.Error(t, err, "packaging plan not found for planId=pkgplan:unknown")
+	})
+}
+
+// Checks the write contract of licensing.PackageRepository, for repositories that store changes;
+// newRepo is as for TestPackageRepository
+func TestPackageRepositoryWrites(t *testing.T, newRepo PackageRepositoryFactory) {
+
+	ctx := context.Background()
+
+	t.Run("created and updated packages are stored", func(t *testing.T) {
+		r := newRepo(t)
+		optimize, err := r.GetPackageById(ctx, "pkg:base-optimize-2022")
+		assert.NilError(t, err)
+		pkg := &licensing.Package{Id: "pkg:seq", Name: "Sequence Only"}
+		assert.NilError(t, r.CreatePackage(ctx, pkg))
+		assert.Error(t, r.CreatePackage(ctx, pkg), "package already exists for pkgId=pkg:seq")
+
+		sequence, ok := optimize.GetIncludedCapability("cpb:sequence")
+		assert.Equal(t, ok, true)
+		updated := &licensing.Package{Id: "pkg:seq", Name: "Sequence Only", IncludedCapabilities: []licensing.Capability{sequence}, IsArchived: true}
+		assert.NilError(t, r.UpdatePackage(ctx, updated))
+		stored, err := r.GetPackageById(ctx, "pkg:seq")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, stored, updated)
+
+		pkgs, err := r.ListPackages(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, len(pkgs), 4)
+	})
+
+	t.Run("updating a missing package is reported", func(t *testing.T) {
+		r := newRepo(t)
+		err := r.UpdatePackage(ctx, &licensing.Package{Id: "pkg:unknown"})
+		assert.Error(t, err, "package not found for pkgId=pkg:unknown")
+	})
+}
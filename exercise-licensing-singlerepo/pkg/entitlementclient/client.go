@@ -0,0 +1,219 @@
+// Package entitlementclient is the stable way for product code to check entitlements.
+//
+// The client keeps a local snapshot of each user's entitlements, refreshed in the background, so that
+// checks are answered without a round trip to the licensing service. It talks to the licensing service
+// either in-process or over its HTTP adapter, see NewInProcessTransport and NewHttpTransport. Entitlements are
+// answered as the client's own Entitlement type, so that product code depends on this package only.
+//
+// Snapshots of pooled capacities are only as current as the last refresh; product code that meters usage
+// should record it through the licensing service, which answers with the up-to-date entitlement.
+package entitlementclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+)
+
+// A user of a product instance, whose entitlements are checked
+type User struct {
+	AccountId      string
+	InstanceId     string
+	InstanceUserId string
+}
+
+// Fetches the entitlements of a user from the licensing service
+type Transport interface {
+	FetchEntitlements(ctx context.Context, user User) ([]Entitlement, error)
+}
+
+const (
+	DefaultRefreshInterval = 30 * time.Second
+	DefaultMaxStaleness    = 5 * time.Minute
+)
+
+type Client struct {
+	transport Transport
+
+	// How often snapshots are refreshed in the background
+	refreshInterval time.Duration
+
+	// How old a snapshot may get, when refreshing fails, before it is no longer used
+	maxStaleness time.Duration
+
+	// Whether checks are allowed when no usable snapshot exists and the licensing service cannot be reached
+	failOpen bool
+
+	now func() time.Time
+
+	mu        sync.Mutex
+	snapshots map[User]*snapshot
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Entitlements of a user as of the time they were fetched
+type snapshot struct {
+	entitlements map[string]Entitlement
+	fetchedAt    time.Time
+	lastUsedAt   time.Time
+}
+
+type ClientOption func(*Client)
+
+// Refresh snapshots at the given interval, DefaultRefreshInterval by default
+func WithRefreshInterval(interval time.Duration) ClientOption {
+	return func(c *Client) {
+		c.refreshInterval = interval
+	}
+}
+
+// Keep using a snapshot up to the given age while refreshing it fails, DefaultMaxStaleness by default
+func WithMaxStaleness(maxStaleness time.Duration) ClientOption {
+	return func(c *Client) {
+		c.maxStaleness = maxStaleness
+	}
+}
+
+// Allow checks when no usable snapshot exists and the licensing service cannot be reached.
+// By default such checks are denied (fail-closed).
+func WithFailOpen() ClientOption {
+	return func(c *Client) {
+		c.failOpen = true
+	}
+}
+
+// Creates a client and starts its background refresh; Close stops it
+func NewClient(transport Transport, opts ...ClientOption) *Client {
+	c := &Client{
+		transport:       transport,
+		refreshInterval: DefaultRefreshInterval,
+		maxStaleness:    DefaultMaxStaleness,
+		now:             time.Now,
+		snapshots:       make(map[User]*snapshot),
+		stop:            make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	go c.refreshLoop()
+	return c
+}
+
+// Checks whether the user is entitled to the capability.
+//
+// When the entitlement cannot be determined, the error is returned along with the configured
+// failure mode: true if the client fails open, false if it fails closed.
+func (c *Client) Can(ctx context.Context, user User, cpbId string) (bool, error) {
+	ent, err := c.Entitlement(ctx, user, cpbId)
+	if err != nil {
+		return c.failOpen, err
+	}
+	return ent.IsEntitled, nil
+}
+
+// Gets the entitlement of the user to the capability, e.g. to read its capacity limit
+func (c *Client) Entitlement(ctx context.Context, user User, cpbId string) (Entitlement, error) {
+	snap, err := c.snapshotOf(ctx, user)
+	if err != nil {
+		return Entitlement{}, err
+	}
+	if ent, ok := snap.entitlements[cpbId]; ok {
+		return ent, nil
+	}
+	return Entitlement{
+		IsEntitled:            false,
+		EvaluatedUserId:       licensing.NewInstanceUser(user.InstanceId, user.InstanceUserId).LicenseeId(),
+		EvaluatedCapabilityId: cpbId,
+	}, nil
+}
+
+// Drops the snapshot of the user, so that the next check fetches its entitlements,
+// e.g. after the product assigned a license to the user
+func (c *Client) Invalidate(user User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.snapshots, user)
+}
+
+// Stops the background refresh
+func (c *Client) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+// Gets a usable snapshot of the user, fetching one if there is none
+func (c *Client) snapshotOf(ctx context.Context, user User) (*snapshot, error) {
+	c.mu.Lock()
+	snap, ok := c.snapshots[user]
+	if ok && c.now().Sub(snap.fetchedAt) <= c.maxStaleness {
+		snap.lastUsedAt = c.now()
+		c.mu.Unlock()
+		return snap, nil
+	}
+	c.mu.Unlock()
+	return c.fetch(ctx, user)
+}
+
+func (c *Client) fetch(ctx context.Context, user User) (*snapshot, error) {
+	entitlements, err := c.transport.FetchEntitlements(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	now := c.now()
+	snap := &snapshot{
+		entitlements: make(map[string]Entitlement),
+		fetchedAt:    now,
+		lastUsedAt:   now,
+	}
+	for _, ent := range entitlements {
+		snap.entitlements[ent.EvaluatedCapabilityId] = ent
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if previous, ok := c.snapshots[user]; ok {
+		snap.lastUsedAt = previous.lastUsedAt
+	}
+	c.snapshots[user] = snap
+	return snap, nil
+}
+
+func (c *Client) refreshLoop() {
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.refresh()
+		}
+	}
+}
+
+// Refreshes the snapshots due for refresh, and drops those of users not checked within the max staleness.
+// A failed refresh leaves the previous snapshot in place.
+func (c *Client) refresh() {
+	due := make([]User, 0)
+	c.mu.Lock()
+	now := c.now()
+	for user, snap := range c.snapshots {
+		if now.Sub(snap.lastUsedAt) > c.maxStaleness {
+			delete(c.snapshots, user)
+		} else if now.Sub(snap.fetchedAt) >= c.refreshInterval {
+			due = append(due, user)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, user := range due {
+		ctx, cancel := context.WithTimeout(context.Background(), c.refreshInterval)
+		c.fetch(ctx, user)
+		cancel()
+	}
+}
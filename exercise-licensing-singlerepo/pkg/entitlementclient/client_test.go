@@ -0,0 +1,210 @@
+package entitlementclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	app "github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/application/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/driving/httpapi"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/infrastructure/storage"
+	"gotest.tools/v3/assert"
+)
+
+var alice = User{AccountId: "acc-1", InstanceId: "ins-101", InstanceUserId: "usr-alice"}
+
+func TestClientTransports(t *testing.T) {
+
+	ctx := context.Background()
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := app.NewLicensingService(&licRepo, &pkgRepo)
+	_, err := ls.IssueLicenses(ctx, app.NewLicenseAdmin("license-admin"), alice.AccountId, "sub-1", "pkg:base-optimize-2022", 1)
+	assert.NilError(t, err)
+	_, err = ls.AssignAvailableLicenseOfPackage(ctx, app.NewCustomerAdmin("customer-admin", alice.AccountId), "pkg:base-optimize-2022", alice.AccountId, alice.InstanceId, alice.InstanceUserId)
+	assert.NilError(t, err)
+
+	server := httptest.NewServer(httpapi.NewEntitlementHandler(ls, func(r *http.Request) (app.Principal, error) {
+		if r.Header.Get("Authorization") != "Bearer app-secret" {
+			return app.Principal{}, errors.New("invalid credentials")
+		}
+		return app.NewApplicationPrincipal("outreach-app"), nil
+	}))
+	defer server.Close()
+	httpClient := server.Client()
+	httpClient.Transport = bearerTokenRoundTripper{token: "app-secret", next: httpClient.Transport}
+
+	transports := map[string]Transport{
+		"in-process": NewInProcessTransport(ls, Principal{Id: "outreach-app"}),
+		"http":       NewHttpTransport(server.URL, httpClient),
+	}
+	for name, transport := range transports {
+		transport := transport
+		t.Run(name, func(t *testing.T) {
+			c := NewClient(transport)
+			defer c.Close()
+
+			can, err := c.Can(context.Background(), alice, "cpb:sequence")
+			assert.NilError(t, err)
+			assert.Equal(t, can, true)
+
+			ent, err := c.Entitlement(context.Background(), alice, "cpb:crm-sync")
+			assert.NilError(t, err)
+			assert.Equal(t, ent.IsEntitled, true)
+			assert.Equal(t, ent.EffectiveCapacityLimit, 250000)
+			assert.Equal(t, ent.CapacityLimitUnit, "CallsPerDay")
+
+			can, err = c.Can(context.Background(), alice, "cpb:kaia-meeting")
+			assert.NilError(t, err)
+			assert.Equal(t, can, false)
+
+			bob := User{AccountId: "acc-1", InstanceId: "ins-101", InstanceUserId: "usr-bob"}
+			can, err = c.Can(context.Background(), bob, "cpb:sequence")
+			assert.NilError(t, err)
+			assert.Equal(t, can, false)
+		})
+	}
+}
+
+func TestClientHttpTransportForbidden(t *testing.T) {
+
+	var licRepo licensing.LicenseRepository = storage.NewLicenseRepoInMem()
+	var pkgRepo licensing.PackageRepository = storage.NewPackageRepoInMem()
+	ls := app.NewLicensingService(&licRepo, &pkgRepo)
+	server := httptest.NewServer(httpapi.NewEntitlementHandler(ls, func(r *http.Request) (app.Principal, error) {
+		return app.NewCustomerAdmin("customer-admin", alice.AccountId), nil
+	}))
+	defer server.Close()
+
+	c := NewClient(NewHttpTransport(server.URL, server.Client()))
+	defer c.Close()
+	can, err := c.Can(context.Background(), alice, "cpb:sequence")
+	assert.ErrorContains(t, err, "fetching entitlements failed with status 403: permission denied")
+	assert.Equal(t, can, false)
+}
+
+func TestClientCaching(t *testing.T) {
+
+	t.Run("checks are answered from the snapshot", func(t *testing.T) {
+		transport := &fakeTransport{entitlements: []Entitlement{{IsEntitled: true, EvaluatedCapabilityId: "cpb:sequence"}}}
+		c := NewClient(transport)
+		defer c.Close()
+
+		for i := 0; i < 3; i++ {
+			can, err := c.Can(context.Background(), alice, "cpb:sequence")
+			assert.NilError(t, err)
+			assert.Equal(t, can, true)
+		}
+		assert.Equal(t, transport.fetchCount(), 1)
+	})
+
+	t.Run("unreachable service fails closed by default", func(t *testing.T) {
+		transport := &fakeTransport{err: errors.New("connection refused")}
+		c := NewClient(transport)
+		defer c.Close()
+
+		can, err := c.Can(context.Background(), alice, "cpb:sequence")
+		assert.Error(t, err, "connection refused")
+		assert.Equal(t, can, false)
+	})
+
+	t.Run("unreachable service fails open if configured", func(t *testing.T) {
+		transport := &fakeTransport{err: errors.New("connection refused")}
+		c := NewClient(transport, WithFailOpen())
+		defer c.Close()
+
+		can, err := c.Can(context.Background(), alice, "cpb:sequence")
+		assert.Error(t, err, "connection refused")
+		assert.Equal(t, can, true)
+	})
+
+	t.Run("stale snapshot is used until max staleness", func(t *testing.T) {
+		transport := &fakeTransport{entitlements: []Entitlement{{IsEntitled: true, EvaluatedCapabilityId: "cpb:sequence"}}}
+		c := NewClient(transport, WithRefreshInterval(time.Hour), WithMaxStaleness(time.Minute))
+		defer c.Close()
+		now := time.Now()
+		c.now = func() time.Time { return now }
+
+		_, err := c.Can(context.Background(), alice, "cpb:sequence")
+		assert.NilError(t, err)
+		transport.fail(errors.New("connection refused"))
+
+		now = now.Add(time.Minute)
+		can, err := c.Can(context.Background(), alice, "cpb:sequence")
+		assert.NilError(t, err)
+		assert.Equal(t, can, true)
+
+		now = now.Add(time.Second)
+		can, err = c.Can(context.Background(), alice, "cpb:sequence")
+		assert.Error(t, err, "connection refused")
+		assert.Equal(t, can, false)
+	})
+
+	t.Run("snapshot is refreshed in the background", func(t *testing.T) {
+		transport := &fakeTransport{entitlements: []Entitlement{{IsEntitled: true, EvaluatedCapabilityId: "cpb:sequence"}}}
+		c := NewClient(transport, WithRefreshInterval(10*time.Millisecond))
+		defer c.Close()
+
+		can, err := c.Can(context.Background(), alice, "cpb:sequence")
+		assert.NilError(t, err)
+		assert.Equal(t, can, true)
+
+		transport.set([]Entitlement{{IsEntitled: false, EvaluatedCapabilityId: "cpb:sequence"}})
+		deadline := time.Now().Add(5 * time.Second)
+		for can && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+			can, err = c.Can(context.Background(), alice, "cpb:sequence")
+			assert.NilError(t, err)
+		}
+		assert.Equal(t, can, false)
+	})
+}
+
+// Adds the bearer token to every request
+type bearerTokenRoundTripper struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (rt bearerTokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+rt.token)
+	return rt.next.RoundTrip(req)
+}
+
+type fakeTransport struct {
+	mu           sync.Mutex
+	entitlements []Entitlement
+	err          error
+	fetches      int
+}
+
+func (t *fakeTransport) FetchEntitlements(ctx context.Context, user User) ([]Entitlement, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fetches++
+	return t.entitlements, t.err
+}
+
+func (t *fakeTransport) set(entitlements []Entitlement) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entitlements = entitlements
+}
+
+func (t *fakeTransport) fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.err = err
+}
+
+func (t *fakeTransport) fetchCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.fetches
+}
@@ -0,0 +1,86 @@
+package entitlementclient
+
+import (
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/domain/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/driving/httpapi"
+)
+
+// Entitlement of a user to a capability, as answered by the licensing service
+type Entitlement struct {
+
+	// True if the user is entitled to the capability
+	IsEntitled bool
+
+	// True if the user is entitled to the feature part of the capability, but not to the capability
+	// due to exceeding its capacity
+	IsEntitledToFeatureButExceedCapability bool
+
+	// Licensee id of the user the entitlement was evaluated for
+	EvaluatedUserId string
+
+	// Capability id the entitlement was evaluated for
+	EvaluatedCapabilityId string
+
+	// Required capability ids the user is not entitled to, which prevent the entitlement
+	MissingRequiredCapabilityIds []string
+
+	// Whether the entitled capability is capped by a capacity limit
+	HasCapacityLimit bool
+
+	// Capacity limit in effect for the user
+	EffectiveCapacityLimit int
+
+	// Unit of the effective capacity limit
+	CapacityLimitUnit string
+
+	// True if the capacity is an account-level pool shared by all users of the account
+	IsPooledCapacity bool
+
+	// True if the user has an allocation of the pool, personally or through its instance
+	HasPersonalAllocation bool
+
+	// Capacity left for the user in the current usage period
+	RemainingCapacity int
+
+	// True if the account's pool is used up
+	IsPoolExhausted bool
+
+	// True if the user's personal allocation of the pool is used up
+	IsPersonalAllocationExhausted bool
+}
+
+func fromEntitlement(ent licensing.Entitlement) Entitlement {
+	return Entitlement{
+		IsEntitled:                             ent.IsEntitled,
+		IsEntitledToFeatureButExceedCapability: ent.IsEntitledToFeatureButExceedCapability,
+		EvaluatedUserId:                        ent.EvaluatedUserId,
+		EvaluatedCapabilityId:                  ent.EvaluatedCapabilityId,
+		MissingRequiredCapabilityIds:           ent.MissingRequiredCapabilityIds,
+		HasCapacityLimit:                       ent.HasCapacityLimit,
+		EffectiveCapacityLimit:                 ent.EffectiveCapacityLimit,
+		CapacityLimitUnit:                      ent.CapacityLimitUnit,
+		IsPooledCapacity:                       ent.IsPooledCapacity,
+		HasPersonalAllocation:                  ent.HasPersonalAllocation,
+		RemainingCapacity:                      ent.RemainingCapacity,
+		IsPoolExhausted:                        ent.IsPoolExhausted,
+		IsPersonalAllocationExhausted:          ent.IsPersonalAllocationExhausted,
+	}
+}
+
+func fromEntitlementJson(j httpapi.EntitlementJson) Entitlement {
+	return Entitlement{
+		IsEntitled:                             j.IsEntitled,
+		IsEntitledToFeatureButExceedCapability: j.IsEntitledToFeatureButExceedCapability,
+		EvaluatedUserId:                        j.EvaluatedUserId,
+		EvaluatedCapabilityId:                  j.EvaluatedCapabilityId,
+		MissingRequiredCapabilityIds:           j.MissingRequiredCapabilityIds,
+		HasCapacityLimit:                       j.HasCapacityLimit,
+		EffectiveCapacityLimit:                 j.EffectiveCapacityLimit,
+		CapacityLimitUnit:                      j.CapacityLimitUnit,
+		IsPooledCapacity:                       j.IsPooledCapacity,
+		HasPersonalAllocation:                  j.HasPersonalAllocation,
+		RemainingCapacity:                      j.RemainingCapacity,
+		IsPoolExhausted:                        j.IsPoolExhausted,
+		IsPersonalAllocationExhausted:          j.IsPersonalAllocationExhausted,
+	}
+}
@@ -0,0 +1,95 @@
+package entitlementclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	app "github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/application/licensing"
+	"github.com/jyangorch/hello-go/exercise-licensing-singlerepo/internal/driving/httpapi"
+)
+
+// Application the product code calls the licensing service as, in the same process
+type Principal struct {
+	Id string
+
+	// Customer account the application acts for; all accounts if empty
+	AccountId string
+}
+
+// Calls the licensing service in the same process
+type inProcessTransport struct {
+	ls app.LicensingService
+
+	// Principal the product code calls the licensing service as
+	principal app.Principal
+}
+
+// Creates a transport to the licensing service running in the same process, called as the given application.
+// Only code built with the licensing service holds one; product code running elsewhere uses NewHttpTransport.
+func NewInProcessTransport(ls app.LicensingService, principal Principal) Transport {
+	return &inProcessTransport{ls: ls, principal: app.Principal{Id: principal.Id, Roles: []app.Role{app.APPLICATION}, AccountId: principal.AccountId}}
+}
+
+func (t *inProcessTransport) FetchEntitlements(ctx context.Context, user User) ([]Entitlement, error) {
+	found, err := t.ls.ListEntitlements(ctx, t.principal, user.AccountId, user.InstanceId, user.InstanceUserId)
+	if err != nil {
+		return nil, err
+	}
+	entitlements := make([]Entitlement, 0, len(found))
+	for _, ent := range found {
+		entitlements = append(entitlements, fromEntitlement(ent))
+	}
+	return entitlements, nil
+}
+
+// Calls the licensing service over its HTTP adapter
+type httpTransport struct {
+	baseUrl    string
+	httpClient *http.Client
+}
+
+// Creates a transport to the HTTP adapter at the base url; http.DefaultClient is used if the client is nil.
+// The HTTP client is responsible for authenticating the requests, e.g. with a round tripper adding credentials.
+func NewHttpTransport(baseUrl string, httpClient *http.Client) Transport {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &httpTransport{baseUrl: strings.TrimSuffix(baseUrl, "/"), httpClient: httpClient}
+}
+
+func (t *httpTransport) FetchEntitlements(ctx context.Context, user User) ([]Entitlement, error) {
+	query := url.Values{}
+	query.Set("accId", user.AccountId)
+	query.Set("insId", user.InstanceId)
+	query.Set("insUsrId", user.InstanceUserId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.baseUrl+httpapi.EntitlementsPath+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errJson httpapi.ErrorJson
+		if json.NewDecoder(resp.Body).Decode(&errJson) == nil && errJson.Error != "" {
+			return nil, fmt.Errorf("fetching entitlements failed with status %d: %s", resp.StatusCode, errJson.Error)
+		}
+		return nil, fmt.Errorf("fetching entitlements failed with status %d", resp.StatusCode)
+	}
+	var result httpapi.EntitlementsJson
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding entitlements failed: %w", err)
+	}
+	entitlements := make([]Entitlement, 0, len(result.Entitlements))
+	for _, entJson := range result.Entitlements {
+		entitlements = append(entitlements, fromEntitlementJson(entJson))
+	}
+	return entitlements, nil
+}
@@ -0,0 +1,146 @@
+// Package entitlementtoken signs and verifies offline entitlement tokens.
+//
+// An entitlement token is a short-lived JWT, signed with Ed25519 ("EdDSA"), listing the capabilities
+// a licensee is entitled to and their capacity limits. Edge services verify it with the issuer's public
+// keys, without calling back to licensing. The key id ("kid") in the token header selects the verifying
+// key, so that signing keys can be rotated while tokens signed with the previous key are still valid.
+package entitlementtoken
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrMalformedToken   = errors.New("malformed entitlement token")
+	ErrUnknownKeyId     = errors.New("unknown key id")
+	ErrInvalidSignature = errors.New("invalid entitlement token signature")
+	ErrTokenExpired     = errors.New("entitlement token expired")
+	ErrTokenNotYetValid = errors.New("entitlement token not yet valid")
+	ErrWrongIssuer      = errors.New("entitlement token from unexpected issuer")
+)
+
+const signingAlgorithm = "EdDSA"
+
+// JWT header of an entitlement token
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyId     string `json:"kid"`
+}
+
+// Claims of an entitlement token; registered JWT claims plus the licensee's entitlements
+type Claims struct {
+
+	// Issuer of the token
+	Issuer string `json:"iss"`
+
+	// Licensee id the token was issued for
+	Subject string `json:"sub"`
+
+	// Customer account of the licensee
+	AccountId string `json:"acc"`
+
+	// Unique id of the token
+	TokenId string `json:"jti,omitempty"`
+
+	// Issuance time, in seconds since the epoch
+	IssuedAt int64 `json:"iat"`
+
+	// Time the token becomes valid, in seconds since the epoch
+	NotBefore int64 `json:"nbf"`
+
+	// Time the token expires, in seconds since the epoch
+	ExpiresAt int64 `json:"exp"`
+
+	// Capabilities the licensee is entitled to, sorted by capability id
+	Entitlements []EntitlementClaim `json:"ent"`
+}
+
+// Entitlement of the licensee to a capability
+type EntitlementClaim struct {
+	CapabilityId string `json:"cpb"`
+
+	// Capacity limit in effect for the licensee; absent if the capacity is unlimited
+	CapacityLimit *int `json:"limit,omitempty"`
+
+	// Unit of the capacity limit
+	CapacityLimitUnit string `json:"unit,omitempty"`
+
+	// True if the capacity is an account-level pool, whose usage the token cannot reflect
+	IsPooledCapacity bool `json:"pooled,omitempty"`
+}
+
+// Whether the token entitles its subject to the capability
+func (c *Claims) Can(cpbId string) bool {
+	_, ok := c.Entitlement(cpbId)
+	return ok
+}
+
+// Gets the entitlement claim of the capability, false if the subject is not entitled to it
+func (c *Claims) Entitlement(cpbId string) (EntitlementClaim, bool) {
+	for _, ent := range c.Entitlements {
+		if ent.CapabilityId == cpbId {
+			return ent, true
+		}
+	}
+	return EntitlementClaim{}, false
+}
+
+// Signs the claims with the private key, identified by the key id in the token header
+func Sign(claims Claims, keyId string, key ed25519.PrivateKey) (string, error) {
+	if keyId == "" {
+		return "", errors.New("key id is required")
+	}
+	if len(key) != ed25519.PrivateKeySize {
+		return "", fmt.Errorf("invalid Ed25519 private key size %d", len(key))
+	}
+	headerJson, err := json.Marshal(header{Algorithm: signingAlgorithm, Type: "JWT", KeyId: keyId})
+	if err != nil {
+		return "", err
+	}
+	claimsJson, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := encodeSegment(headerJson) + "." + encodeSegment(claimsJson)
+	signature := ed25519.Sign(key, []byte(signingInput))
+	return signingInput + "." + encodeSegment(signature), nil
+}
+
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Splits the token into its header, the signed input, its claims and its signature, without verifying it
+func parse(token string) (header, string, Claims, []byte, error) {
+	var hdr header
+	var claims Claims
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return hdr, "", claims, nil, fmt.Errorf("%w: expected 3 segments, got %d", ErrMalformedToken, len(segments))
+	}
+	headerJson, err := base64.RawURLEncoding.DecodeString(segments[0])
+	if err != nil {
+		return hdr, "", claims, nil, fmt.Errorf("%w: header: %v", ErrMalformedToken, err)
+	}
+	if err := json.Unmarshal(headerJson, &hdr); err != nil {
+		return hdr, "", claims, nil, fmt.Errorf("%w: header: %v", ErrMalformedToken, err)
+	}
+	claimsJson, err := base64.RawURLEncoding.DecodeString(segments[1])
+	if err != nil {
+		return hdr, "", claims, nil, fmt.Errorf("%w: claims: %v", ErrMalformedToken, err)
+	}
+	if err := json.Unmarshal(claimsJson, &claims); err != nil {
+		return hdr, "", claims, nil, fmt.Errorf("%w: claims: %v", ErrMalformedToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return hdr, "", claims, nil, fmt.Errorf("%w: signature: %v", ErrMalformedToken, err)
+	}
+	return hdr, segments[0] + "." + segments[1], claims, signature, nil
+}
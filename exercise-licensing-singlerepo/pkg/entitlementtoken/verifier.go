@@ -0,0 +1,102 @@
+package entitlementtoken
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Verifies entitlement tokens against a set of public keys by key id.
+//
+// To rotate the signing key, add the new public key before the issuer signs with it, and remove
+// the previous key once the tokens it signed have expired.
+type Verifier struct {
+	mu   sync.RWMutex
+	keys map[string]ed25519.PublicKey
+
+	// Issuer the tokens must come from; any issuer if empty
+	expectedIssuer string
+
+	// Tolerated clock skew between issuer and verifier
+	leeway time.Duration
+
+	now func() time.Time
+}
+
+type VerifierOption func(*Verifier)
+
+// Accept only tokens from the given issuer
+func WithExpectedIssuer(issuer string) VerifierOption {
+	return func(v *Verifier) {
+		v.expectedIssuer = issuer
+	}
+}
+
+// Tolerate the given clock skew when checking the validity period of tokens
+func WithLeeway(leeway time.Duration) VerifierOption {
+	return func(v *Verifier) {
+		v.leeway = leeway
+	}
+}
+
+func NewVerifier(keys map[string]ed25519.PublicKey, opts ...VerifierOption) *Verifier {
+	v := &Verifier{
+		keys: make(map[string]ed25519.PublicKey),
+		now:  time.Now,
+	}
+	for keyId, key := range keys {
+		v.keys[keyId] = key
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// Adds or replaces the public key of the key id
+func (v *Verifier) AddKey(keyId string, key ed25519.PublicKey) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys[keyId] = key
+}
+
+// Removes the public key of the key id; tokens signed with it no longer verify
+func (v *Verifier) RemoveKey(keyId string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.keys, keyId)
+}
+
+// Verifies the signature and validity period of the token, and returns its claims
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	hdr, signingInput, claims, signature, err := parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Algorithm != signingAlgorithm {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrMalformedToken, hdr.Algorithm)
+	}
+
+	v.mu.RLock()
+	key, ok := v.keys[hdr.KeyId]
+	v.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKeyId, hdr.KeyId)
+	}
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, []byte(signingInput), signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	now := v.now()
+	if now.Add(-v.leeway).Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("%w at %s", ErrTokenExpired, time.Unix(claims.ExpiresAt, 0).UTC().Format(time.RFC3339))
+	}
+	if now.Add(v.leeway).Unix() < claims.NotBefore {
+		return nil, fmt.Errorf("%w before %s", ErrTokenNotYetValid, time.Unix(claims.NotBefore, 0).UTC().Format(time.RFC3339))
+	}
+	if v.expectedIssuer != "" && claims.Issuer != v.expectedIssuer {
+		return nil, fmt.Errorf("%w %q", ErrWrongIssuer, claims.Issuer)
+	}
+	return &claims, nil
+}
@@ -0,0 +1,107 @@
+package entitlementtoken
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestVerifier(t *testing.T) {
+
+	pubKey1, privKey1, err := ed25519.GenerateKey(nil)
+	assert.NilError(t, err)
+	pubKey2, privKey2, err := ed25519.GenerateKey(nil)
+	assert.NilError(t, err)
+
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	limit := 250000
+	claims := Claims{
+		Issuer:    "licensing",
+		Subject:   "INSTANCE_USER:ins-101:usr-alice",
+		AccountId: "acc-1",
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(5 * time.Minute).Unix(),
+		Entitlements: []EntitlementClaim{
+			{CapabilityId: "cpb:crm-sync", CapacityLimit: &limit, CapacityLimitUnit: "CallsPerDay"},
+			{CapabilityId: "cpb:sequence"},
+		},
+	}
+	token, err := Sign(claims, "key-1", privKey1)
+	assert.NilError(t, err)
+
+	newVerifier := func(opts ...VerifierOption) *Verifier {
+		v := NewVerifier(map[string]ed25519.PublicKey{"key-1": pubKey1}, opts...)
+		v.now = func() time.Time { return now.Add(time.Minute) }
+		return v
+	}
+
+	t.Run("valid token verifies", func(t *testing.T) {
+		verified, err := newVerifier(WithExpectedIssuer("licensing")).Verify(token)
+		assert.NilError(t, err)
+		assert.Equal(t, verified.Subject, claims.Subject)
+		assert.Equal(t, verified.Can("cpb:sequence"), true)
+		assert.Equal(t, verified.Can("cpb:kaia-meeting"), false)
+		crmSync, ok := verified.Entitlement("cpb:crm-sync")
+		assert.Equal(t, ok, true)
+		assert.Equal(t, *crmSync.CapacityLimit, 250000)
+	})
+
+	t.Run("tampered claims are rejected", func(t *testing.T) {
+		segments := strings.Split(token, ".")
+		tamperedClaims := claims
+		tamperedClaims.Entitlements = append(tamperedClaims.Entitlements, EntitlementClaim{CapabilityId: "cpb:kaia-meeting"})
+		forged, err := Sign(tamperedClaims, "key-1", privKey2)
+		assert.NilError(t, err)
+		tampered := segments[0] + "." + strings.Split(forged, ".")[1] + "." + segments[2]
+
+		_, err = newVerifier().Verify(tampered)
+		assert.Check(t, errors.Is(err, ErrInvalidSignature), err)
+		_, err = newVerifier().Verify(forged)
+		assert.Check(t, errors.Is(err, ErrInvalidSignature), err)
+	})
+
+	t.Run("malformed token is rejected", func(t *testing.T) {
+		_, err := newVerifier().Verify("not-a-token")
+		assert.Check(t, errors.Is(err, ErrMalformedToken), err)
+	})
+
+	t.Run("expired token is rejected", func(t *testing.T) {
+		v := newVerifier()
+		v.now = func() time.Time { return now.Add(5 * time.Minute) }
+		_, err := v.Verify(token)
+		assert.Check(t, errors.Is(err, ErrTokenExpired), err)
+
+		v = newVerifier(WithLeeway(30 * time.Second))
+		v.now = func() time.Time { return now.Add(5 * time.Minute) }
+		_, err = v.Verify(token)
+		assert.NilError(t, err)
+	})
+
+	t.Run("token from unexpected issuer is rejected", func(t *testing.T) {
+		_, err := newVerifier(WithExpectedIssuer("someone-else")).Verify(token)
+		assert.Check(t, errors.Is(err, ErrWrongIssuer), err)
+	})
+
+	t.Run("keys rotate by key id", func(t *testing.T) {
+		v := newVerifier()
+		rotated, err := Sign(claims, "key-2", privKey2)
+		assert.NilError(t, err)
+		_, err = v.Verify(rotated)
+		assert.Check(t, errors.Is(err, ErrUnknownKeyId), err)
+
+		v.AddKey("key-2", pubKey2)
+		_, err = v.Verify(rotated)
+		assert.NilError(t, err)
+		_, err = v.Verify(token)
+		assert.NilError(t, err)
+
+		v.RemoveKey("key-1")
+		_, err = v.Verify(token)
+		assert.Check(t, errors.Is(err, ErrUnknownKeyId), err)
+	})
+}
@@ -0,0 +1,138 @@
+// Package offlinelicense signs and verifies license files for instances deployed without connectivity.
+//
+// A license file grants an instance a number of seats of a package until it expires. It is signed with
+// Ed25519 by licensing, and verified offline by the instance with the published public keys, selected by
+// the key id in the file. The instance enforces the seat count locally, see SeatEnforcer.
+package offlinelicense
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const FormatVersion = 1
+
+var (
+	ErrMalformedLicenseFile = errors.New("malformed license file")
+	ErrUnknownKeyId         = errors.New("unknown key id")
+	ErrInvalidSignature     = errors.New("invalid license file signature")
+	ErrLicenseFileExpired   = errors.New("license file expired")
+	ErrWrongInstance        = errors.New("license file issued for another instance")
+)
+
+// Content of a license file
+type LicenseFile struct {
+
+	// Unique id of the license file
+	Id string `json:"id"`
+
+	// Customer account possessing the licenses
+	AccountId string `json:"accountId"`
+
+	// Instance the license file is issued for
+	InstanceId string `json:"instanceId"`
+
+	PackageId string `json:"packageId"`
+
+	PackageName string `json:"packageName"`
+
+	// Number of users of the instance who can be assigned a seat
+	Seats int `json:"seats"`
+
+	IssuedAt time.Time `json:"issuedAt"`
+
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	// Capabilities granted to every seat, including implied capabilities, sorted by id
+	Capabilities []CapabilityGrant `json:"capabilities"`
+}
+
+// A capability granted by the license file
+type CapabilityGrant struct {
+	Id string `json:"id"`
+
+	DisplayName string `json:"displayName"`
+
+	// Capacity limit per seat; absent if the capacity is unlimited
+	CapacityLimit *int `json:"capacityLimit,omitempty"`
+
+	CapacityLimitUnit string `json:"capacityLimitUnit,omitempty"`
+}
+
+// Gets the grant of the capability, false if the license file does not grant it
+func (f *LicenseFile) Capability(cpbId string) (CapabilityGrant, bool) {
+	for _, cpb := range f.Capabilities {
+		if cpb.Id == cpbId {
+			return cpb, true
+		}
+	}
+	return CapabilityGrant{}, false
+}
+
+// Signed envelope of the content, the on-disk format of a license file.
+// The signature covers the payload bytes exactly as stored, so the file can be re-indented without breaking it.
+type envelope struct {
+	FormatVersion int    `json:"formatVersion"`
+	KeyId         string `json:"keyId"`
+	Payload       []byte `json:"payload"`
+	Signature     []byte `json:"signature"`
+}
+
+// Signs the license file with the private key, identified by the key id, and encodes it for writing to disk
+func Sign(file LicenseFile, keyId string, key ed25519.PrivateKey) ([]byte, error) {
+	if keyId == "" {
+		return nil, errors.New("key id is required")
+	}
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 private key size %d", len(key))
+	}
+	if file.Seats <= 0 {
+		return nil, fmt.Errorf("seats must be positive, got %d", file.Seats)
+	}
+	if !file.ExpiresAt.After(file.IssuedAt) {
+		return nil, fmt.Errorf("expiry %s must be after issuance %s", file.ExpiresAt.Format(time.RFC3339), file.IssuedAt.Format(time.RFC3339))
+	}
+	payload, err := json.Marshal(file)
+	if err != nil {
+		return nil, err
+	}
+	env := envelope{
+		FormatVersion: FormatVersion,
+		KeyId:         keyId,
+		Payload:       payload,
+		Signature:     ed25519.Sign(key, signingInput(keyId, payload)),
+	}
+	return json.MarshalIndent(env, "", "  ")
+}
+
+// Decodes the license file without verifying it, for inspection; returns the key id it claims to be signed with
+func Decode(data []byte) (*LicenseFile, string, error) {
+	env, file, err := decode(data)
+	if err != nil {
+		return nil, "", err
+	}
+	return file, env.KeyId, nil
+}
+
+func decode(data []byte) (envelope, *LicenseFile, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return env, nil, fmt.Errorf("%w: %v", ErrMalformedLicenseFile, err)
+	}
+	if env.FormatVersion != FormatVersion {
+		return env, nil, fmt.Errorf("%w: unsupported format version %d", ErrMalformedLicenseFile, env.FormatVersion)
+	}
+	var file LicenseFile
+	if err := json.Unmarshal(env.Payload, &file); err != nil {
+		return env, nil, fmt.Errorf("%w: payload: %v", ErrMalformedLicenseFile, err)
+	}
+	return env, &file, nil
+}
+
+// The key id is signed along with the payload, so that a file cannot be re-attributed to another key
+func signingInput(keyId string, payload []byte) []byte {
+	return append([]byte(keyId+"."), payload...)
+}
@@ -0,0 +1,129 @@
+package offlinelicense
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestLicenseFile(t *testing.T) {
+
+	pubKey, privKey, err := ed25519.GenerateKey(nil)
+	assert.NilError(t, err)
+	otherPubKey, otherPrivKey, err := ed25519.GenerateKey(nil)
+	assert.NilError(t, err)
+
+	issuedAt := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	file := LicenseFile{
+		Id:           "file-1",
+		AccountId:    "acc-1",
+		InstanceId:   "ins-101",
+		PackageId:    "pkg:base-optimize-2022",
+		PackageName:  "Optimize",
+		Seats:        2,
+		IssuedAt:     issuedAt,
+		ExpiresAt:    issuedAt.AddDate(1, 0, 0),
+		Capabilities: []CapabilityGrant{{Id: "cpb:sequence", DisplayName: "Sequence"}},
+	}
+	data, err := Sign(file, "key-1", privKey)
+	assert.NilError(t, err)
+
+	newVerifier := func(now time.Time) *Verifier {
+		v := NewVerifier(map[string]ed25519.PublicKey{"key-1": pubKey, "key-2": otherPubKey})
+		v.now = func() time.Time { return now }
+		return v
+	}
+	verifier := newVerifier(issuedAt.AddDate(0, 6, 0))
+
+	// rewrites the envelope of the signed file
+	tamper := func(t *testing.T, change func(env *envelope)) []byte {
+		var env envelope
+		assert.NilError(t, json.Unmarshal(data, &env))
+		change(&env)
+		tampered, err := json.Marshal(env)
+		assert.NilError(t, err)
+		return tampered
+	}
+
+	t.Run("valid file verifies", func(t *testing.T) {
+		verified, err := verifier.Verify(data, "ins-101")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, *verified, file)
+	})
+
+	t.Run("tampered seat count is detected", func(t *testing.T) {
+		tampered := tamper(t, func(env *envelope) {
+			env.Payload = bytes.Replace(env.Payload, []byte(`"seats":2`), []byte(`"seats":200`), 1)
+		})
+		_, err := verifier.Verify(tampered, "ins-101")
+		assert.Check(t, errors.Is(err, ErrInvalidSignature), err)
+	})
+
+	t.Run("tampered expiry is detected", func(t *testing.T) {
+		tampered := tamper(t, func(env *envelope) {
+			env.Payload = bytes.Replace(env.Payload, []byte(`"expiresAt":"2027`), []byte(`"expiresAt":"2099`), 1)
+		})
+		_, err := verifier.Verify(tampered, "ins-101")
+		assert.Check(t, errors.Is(err, ErrInvalidSignature), err)
+	})
+
+	t.Run("tampered signature is detected", func(t *testing.T) {
+		tampered := tamper(t, func(env *envelope) {
+			env.Signature[0] ^= 0xff
+		})
+		_, err := verifier.Verify(tampered, "ins-101")
+		assert.Check(t, errors.Is(err, ErrInvalidSignature), err)
+	})
+
+	t.Run("re-attributing to another key is detected", func(t *testing.T) {
+		tampered := tamper(t, func(env *envelope) {
+			env.KeyId = "key-2"
+		})
+		_, err := verifier.Verify(tampered, "ins-101")
+		assert.Check(t, errors.Is(err, ErrInvalidSignature), err)
+	})
+
+	t.Run("file signed by an unknown key is rejected", func(t *testing.T) {
+		forged, err := Sign(file, "key-3", otherPrivKey)
+		assert.NilError(t, err)
+		_, err = verifier.Verify(forged, "ins-101")
+		assert.Check(t, errors.Is(err, ErrUnknownKeyId), err)
+	})
+
+	t.Run("expired file is rejected", func(t *testing.T) {
+		_, err := newVerifier(file.ExpiresAt).Verify(data, "ins-101")
+		assert.Check(t, errors.Is(err, ErrLicenseFileExpired), err)
+	})
+
+	t.Run("file for another instance is rejected", func(t *testing.T) {
+		_, err := verifier.Verify(data, "ins-202")
+		assert.Check(t, errors.Is(err, ErrWrongInstance), err)
+	})
+
+	t.Run("seat count is enforced", func(t *testing.T) {
+		verified, err := verifier.Verify(data, "ins-101")
+		assert.NilError(t, err)
+		enforcer, err := NewSeatEnforcer(verified, []string{"usr-alice"})
+		assert.NilError(t, err)
+
+		assert.NilError(t, enforcer.AssignSeat("usr-bob"))
+		assert.NilError(t, enforcer.AssignSeat("usr-bob"))
+		err = enforcer.AssignSeat("usr-charles")
+		assert.Check(t, errors.Is(err, ErrNoSeatAvailable), err)
+		assert.Equal(t, enforcer.IsEntitled("usr-bob", "cpb:sequence"), true)
+		assert.Equal(t, enforcer.IsEntitled("usr-bob", "cpb:kaia-meeting"), false)
+		assert.Equal(t, enforcer.IsEntitled("usr-charles", "cpb:sequence"), false)
+
+		enforcer.ReleaseSeat("usr-alice")
+		assert.NilError(t, enforcer.AssignSeat("usr-charles"))
+		assert.DeepEqual(t, enforcer.AssignedUserIds(), []string{"usr-bob", "usr-charles"})
+
+		_, err = NewSeatEnforcer(verified, []string{"usr-alice", "usr-bob", "usr-charles"})
+		assert.Check(t, errors.Is(err, ErrNoSeatAvailable), err)
+	})
+}
@@ -0,0 +1,75 @@
+package offlinelicense
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var ErrNoSeatAvailable = errors.New("no seat available")
+
+// Enforces the seat count of a verified license file within the instance.
+//
+// The enforcer keeps seat assignments in memory; the instance persists them itself and restores
+// them when it creates the enforcer.
+type SeatEnforcer struct {
+	mu    sync.Mutex
+	file  *LicenseFile
+	seats map[string]bool
+}
+
+// Creates an enforcer with the users previously assigned a seat; fails if they exceed the seat count
+func NewSeatEnforcer(file *LicenseFile, assignedUserIds []string) (*SeatEnforcer, error) {
+	e := &SeatEnforcer{file: file, seats: make(map[string]bool)}
+	for _, usrId := range assignedUserIds {
+		if err := e.AssignSeat(usrId); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Assigns a seat to the user; assigning to a user with a seat has no effect
+func (e *SeatEnforcer) AssignSeat(usrId string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.seats[usrId] {
+		return nil
+	}
+	if len(e.seats) >= e.file.Seats {
+		return fmt.Errorf("%w for user %s, all %d seats of license file %s are assigned", ErrNoSeatAvailable, usrId, e.file.Seats, e.file.Id)
+	}
+	e.seats[usrId] = true
+	return nil
+}
+
+// Releases the seat of the user, if any
+func (e *SeatEnforcer) ReleaseSeat(usrId string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.seats, usrId)
+}
+
+// Users with a seat, sorted
+func (e *SeatEnforcer) AssignedUserIds() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	results := make([]string, 0, len(e.seats))
+	for usrId := range e.seats {
+		results = append(results, usrId)
+	}
+	sort.Strings(results)
+	return results
+}
+
+// Whether the user has a seat granting the capability
+func (e *SeatEnforcer) IsEntitled(usrId string, cpbId string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.seats[usrId] {
+		return false
+	}
+	_, ok := e.file.Capability(cpbId)
+	return ok
+}
@@ -0,0 +1,43 @@
+package offlinelicense
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"time"
+)
+
+// Verifies license files offline against a set of public keys by key id
+type Verifier struct {
+	keys map[string]ed25519.PublicKey
+	now  func() time.Time
+}
+
+func NewVerifier(keys map[string]ed25519.PublicKey) *Verifier {
+	v := &Verifier{keys: make(map[string]ed25519.PublicKey), now: time.Now}
+	for keyId, key := range keys {
+		v.keys[keyId] = key
+	}
+	return v
+}
+
+// Verifies the signature and expiry of the license file, and that it is issued for the instance
+func (v *Verifier) Verify(data []byte, insId string) (*LicenseFile, error) {
+	env, file, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := v.keys[env.KeyId]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKeyId, env.KeyId)
+	}
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, signingInput(env.KeyId, env.Payload), env.Signature) {
+		return nil, ErrInvalidSignature
+	}
+	if !v.now().Before(file.ExpiresAt) {
+		return nil, fmt.Errorf("%w at %s", ErrLicenseFileExpired, file.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if file.InstanceId != insId {
+		return nil, fmt.Errorf("%w insId=%s", ErrWrongInstance, file.InstanceId)
+	}
+	return file, nil
+}
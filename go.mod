@@ -7,8 +7,12 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 )
 
-require gotest.tools/v3 v3.1.0
-
 require (
-	github.com/google/go-cmp v0.5.7 // indirect
+	github.com/go-sql-driver/mysql v1.7.1
+	github.com/mattn/go-sqlite3 v1.14.17
+	golang.org/x/sys v0.13.0
+	gopkg.in/yaml.v3 v3.0.1
+	gotest.tools/v3 v3.1.0
 )
+
+require github.com/google/go-cmp v0.5.7 // indirect